@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	version = "1.0.0"
+)
+
+var (
+	serverURL   = flag.String("server", "http://localhost:7092", "HomeGuard server URL")
+	deviceName  = flag.String("device", "", "Device name as configured on the server (default: hostname)")
+	token       = flag.String("token", os.Getenv("HOMEGUARD_AGENT_TOKEN"), "Agent token (default: $HOMEGUARD_AGENT_TOKEN)")
+	listenAddr  = flag.String("listen", ":7093", "Address to accept power commands on (empty to disable)")
+	interval    = flag.Duration("interval", 30*time.Second, "Heartbeat interval")
+	idleSuspend = flag.Duration("idle-suspend", 0, "Suspend after the machine has been idle this long (0 to disable)")
+	idleLoad    = flag.Float64("idle-load", 0.2, "load below which the machine counts as idle: the 1-minute load average, or busy CPUs on Windows")
+	logLevel    = flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+	showVer     = flag.Bool("version", false, "Show version information")
+)
+
+// Power commands accepted by the agent.
+const (
+	actionShutdown = "shutdown"
+	actionReboot   = "reboot"
+	actionSleep    = "sleep"
+)
+
+// HeartbeatPayload is the liveness report sent to the HomeGuard server.
+type HeartbeatPayload struct {
+	Device          string `json:"device"`
+	Hostname        string `json:"hostname,omitempty"`
+	IP              string `json:"ip,omitempty"`
+	Mac             string `json:"mac,omitempty"`
+	UptimeSeconds   int64  `json:"uptime_seconds,omitempty"`
+	IntervalSeconds int64  `json:"interval_seconds,omitempty"`
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "homeguard-agent - HomeGuard agent for target machines\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  homeguard-agent -server <url> -token <token> [-device <name>]\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  homeguard-agent -server http://192.168.1.100:7092 -device desktop -token secret\n")
+		fmt.Fprintf(os.Stderr, "  homeguard-agent -server http://192.168.1.100:7092 -idle-suspend 30m\n")
+	}
+
+	flag.Parse()
+
+	if *showVer {
+		fmt.Printf("homeguard-agent version %s\n", version)
+		os.Exit(0)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		level = slog.LevelInfo
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level})))
+
+	if *token == "" {
+		fmt.Fprintf(os.Stderr, "Error: -token or $HOMEGUARD_AGENT_TOKEN is required\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *deviceName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: -device not set and hostname unavailable: %v\n", err)
+			os.Exit(1)
+		}
+		*deviceName = hostname
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("Starting HomeGuard agent", "device", *deviceName, "server", *serverURL)
+
+	if *listenAddr != "" {
+		go serveCommands(ctx, *listenAddr)
+	}
+	if *idleSuspend > 0 {
+		go watchIdle(ctx, *idleSuspend, *idleLoad)
+	}
+
+	runHeartbeats(ctx)
+	slog.Info("HomeGuard agent stopped")
+}
+
+func runHeartbeats(ctx context.Context) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		if err := sendHeartbeat(ctx, client); err != nil {
+			slog.Warn("Failed to send heartbeat", "error", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func sendHeartbeat(ctx context.Context, client *http.Client) error {
+	payload := HeartbeatPayload{
+		Device:          *deviceName,
+		IntervalSeconds: int64(*interval / time.Second),
+	}
+	payload.Hostname, _ = os.Hostname()
+	if up, err := uptime(); err == nil {
+		payload.UptimeSeconds = int64(up / time.Second)
+	}
+	if ip, mac, err := localAddress(*serverURL); err == nil {
+		payload.IP = ip
+		payload.Mac = mac
+	} else {
+		slog.Debug("Failed to determine local address", "error", err)
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal heartbeat: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *serverURL+"/api/v1/agents/heartbeat", bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+*token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned error (status %d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	slog.Debug("Sent heartbeat", "ip", payload.IP, "mac", payload.Mac)
+	return nil
+}
+
+// localAddress returns the IP and MAC of the interface used to reach the server.
+func localAddress(server string) (string, string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", "", err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+
+	// Dialing UDP sends no packets but selects the outbound interface
+	conn, err := net.Dial("udp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return "", "", err
+	}
+	defer func() { _ = conn.Close() }()
+	ip := conn.LocalAddr().(*net.UDPAddr).IP
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ip.String(), "", err
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return ip.String(), iface.HardwareAddr.String(), nil
+			}
+		}
+	}
+
+	return ip.String(), "", nil
+}
+
+func serveCommands(ctx context.Context, addr string) {
+	server := &http.Server{Addr: addr, Handler: commandHandler(runPowerCommand)}
+	go func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.Background())
+	}()
+
+	slog.Info("Accepting power commands", "addr", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		slog.Error("Command listener error", "error", err)
+	}
+}
+
+// commandDelay gives the response to a power command a chance to reach the
+// caller before the command runs.
+var commandDelay = time.Second
+
+// commandHandler accepts the power commands that carry the agent token and
+// passes them to run.
+func commandHandler(run func(action string)) http.Handler {
+	mux := http.NewServeMux()
+	for _, action := range []string{actionShutdown, actionReboot, actionSleep} {
+		mux.HandleFunc("POST /"+action, func(w http.ResponseWriter, r *http.Request) {
+			provided, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(*token)) != 1 {
+				slog.Warn("Rejected command with invalid token", "action", action, "remote", r.RemoteAddr)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			slog.Info("Received power command", "action", action, "remote", r.RemoteAddr)
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(action + " scheduled"))
+
+			time.AfterFunc(commandDelay, func() { run(action) })
+		})
+	}
+	return mux
+}
+
+func runPowerCommand(action string) {
+	args, err := powerCommand(action)
+	if err != nil {
+		slog.Error("Unsupported power command", "action", action, "error", err)
+		return
+	}
+
+	output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		slog.Error("Power command failed", "action", action, "error", err, "output", string(output))
+	}
+}
+
+func watchIdle(ctx context.Context, after time.Duration, threshold float64) {
+	if _, err := loadAverage(); err != nil {
+		slog.Warn("Idle auto-suspend is not supported on this platform", "error", err)
+		return
+	}
+
+	slog.Info("Idle auto-suspend enabled", "after", after, "load_threshold", threshold)
+
+	const checkInterval = time.Minute
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	var idleSince time.Time
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		load, err := loadAverage()
+		if err != nil {
+			slog.Warn("Failed to read load average", "error", err)
+			continue
+		}
+		if load >= threshold {
+			idleSince = time.Time{}
+			continue
+		}
+		if idleSince.IsZero() {
+			idleSince = time.Now()
+		}
+		if time.Since(idleSince) >= after {
+			slog.Info("Machine idle, suspending", "idle", time.Since(idleSince).Round(time.Second), "load", load)
+			idleSince = time.Time{}
+			runPowerCommand(actionSleep)
+		}
+	}
+}
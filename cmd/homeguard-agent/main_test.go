@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setFlags sets the agent's flags for a test and restores them afterwards.
+func setFlags(t *testing.T, server, device, agentToken string) {
+	t.Helper()
+	savedServer, savedDevice, savedToken, savedInterval := *serverURL, *deviceName, *token, *interval
+	t.Cleanup(func() {
+		*serverURL, *deviceName, *token, *interval = savedServer, savedDevice, savedToken, savedInterval
+	})
+	*serverURL, *deviceName, *token, *interval = server, device, agentToken, 45*time.Second
+}
+
+func TestSendHeartbeat(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{name: "accepted", status: http.StatusNoContent},
+		{name: "rejected", status: http.StatusUnauthorized, body: `{"error": {"code": "unauthorized"}}` + "\n", wantErr: `server returned error (status 401): {"error": {"code": "unauthorized"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got HeartbeatPayload
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/api/v1/agents/heartbeat" {
+					t.Errorf("request = %s %s", r.Method, r.URL.Path)
+				}
+				if auth := r.Header.Get("Authorization"); auth != "Bearer agent-secret" {
+					t.Errorf("Authorization = %q", auth)
+				}
+				if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+					t.Errorf("Content-Type = %q", ct)
+				}
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Error(err)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+			setFlags(t, server.URL, "desktop", "agent-secret")
+
+			err := sendHeartbeat(t.Context(), server.Client())
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if got.Device != "desktop" || got.IntervalSeconds != 45 {
+				t.Errorf("payload = %+v, want device desktop every 45 seconds", got)
+			}
+			if got.IP != "127.0.0.1" {
+				t.Errorf("IP = %q, want the address the server is reached from", got.IP)
+			}
+		})
+	}
+}
+
+func TestSendHeartbeatUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	setFlags(t, server.URL, "desktop", "agent-secret")
+
+	if err := sendHeartbeat(t.Context(), server.Client()); err == nil || !strings.Contains(err.Error(), "failed to send request") {
+		t.Errorf("error = %v, want a failed request", err)
+	}
+}
+
+func TestCommandHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		auth       string
+		wantStatus int
+		wantRun    string
+	}{
+		{name: "shutdown", method: http.MethodPost, path: "/shutdown", auth: "Bearer agent-secret", wantStatus: http.StatusAccepted, wantRun: actionShutdown},
+		{name: "reboot", method: http.MethodPost, path: "/reboot", auth: "Bearer agent-secret", wantStatus: http.StatusAccepted, wantRun: actionReboot},
+		{name: "sleep", method: http.MethodPost, path: "/sleep", auth: "Bearer agent-secret", wantStatus: http.StatusAccepted, wantRun: actionSleep},
+		{name: "no token", method: http.MethodPost, path: "/shutdown", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodPost, path: "/shutdown", auth: "Bearer guess", wantStatus: http.StatusUnauthorized},
+		{name: "GET", method: http.MethodGet, path: "/shutdown", auth: "Bearer agent-secret", wantStatus: http.StatusMethodNotAllowed},
+		{name: "unknown command", method: http.MethodPost, path: "/hibernate", auth: "Bearer agent-secret", wantStatus: http.StatusNotFound},
+	}
+	saved := commandDelay
+	t.Cleanup(func() { commandDelay = saved })
+	commandDelay = 0
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, "", "desktop", "agent-secret")
+			ran := make(chan string, 1)
+			handler := commandHandler(func(action string) { ran <- action })
+
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if tt.wantRun == "" {
+				select {
+				case action := <-ran:
+					t.Errorf("ran %s after a rejected request", action)
+				case <-time.After(50 * time.Millisecond):
+				}
+				return
+			}
+			if body := w.Body.String(); body != tt.wantRun+" scheduled" {
+				t.Errorf("body = %q", body)
+			}
+			select {
+			case action := <-ran:
+				if action != tt.wantRun {
+					t.Errorf("ran %s, want %s", action, tt.wantRun)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("%s did not run", tt.wantRun)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func uptime() (time.Duration, error) {
+	tv, err := unix.SysctlTimeval("kern.boottime")
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(time.Unix(tv.Unix())), nil
+}
+
+// loadAverage reads the 1-minute load average from vm.loadavg, a struct
+// loadavg of three fixed-point uint32 values and their int64 scale.
+func loadAverage() (float64, error) {
+	raw, err := unix.SysctlRaw("vm.loadavg")
+	if err != nil {
+		return 0, err
+	}
+	if len(raw) < 24 {
+		return 0, fmt.Errorf("unexpected vm.loadavg size: %d", len(raw))
+	}
+	load := binary.LittleEndian.Uint32(raw[0:4])
+	scale := binary.LittleEndian.Uint64(raw[16:24])
+	if scale == 0 {
+		return 0, fmt.Errorf("unexpected vm.loadavg scale: 0")
+	}
+	return float64(load) / float64(scale), nil
+}
+
+func powerCommand(action string) ([]string, error) {
+	switch action {
+	case actionShutdown:
+		return []string{"shutdown", "-h", "now"}, nil
+	case actionReboot:
+		return []string{"shutdown", "-r", "now"}, nil
+	case actionSleep:
+		return []string{"pmset", "sleepnow"}, nil
+	}
+	return nil, fmt.Errorf("unknown action: %s", action)
+}
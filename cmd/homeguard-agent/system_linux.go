@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func uptime() (time.Duration, error) {
+	data, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected /proc/uptime format")
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func loadAverage() (float64, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected /proc/loadavg format")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+func powerCommand(action string) ([]string, error) {
+	switch action {
+	case actionShutdown:
+		return []string{"systemctl", "poweroff"}, nil
+	case actionReboot:
+		return []string{"systemctl", "reboot"}, nil
+	case actionSleep:
+		return []string{"systemctl", "suspend"}, nil
+	}
+	return nil, fmt.Errorf("unknown action: %s", action)
+}
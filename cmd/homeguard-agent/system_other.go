@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+func uptime() (time.Duration, error) {
+	return 0, fmt.Errorf("uptime not available on %s", runtime.GOOS)
+}
+
+func loadAverage() (float64, error) {
+	return 0, fmt.Errorf("load average not available on %s", runtime.GOOS)
+}
+
+func powerCommand(action string) ([]string, error) {
+	return nil, fmt.Errorf("power commands not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	kernel32            = windows.NewLazySystemDLL("kernel32.dll")
+	procGetTickCount64  = kernel32.NewProc("GetTickCount64")
+	procGetSystemTimes  = kernel32.NewProc("GetSystemTimes")
+	cpuTimesMu          sync.Mutex
+	lastIdle, lastTotal uint64
+)
+
+func uptime() (time.Duration, error) {
+	if err := procGetTickCount64.Find(); err != nil {
+		return 0, err
+	}
+	ms, _, _ := procGetTickCount64.Call()
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// loadAverage approximates the load average, which Windows does not have,
+// by the number of busy CPUs since the previous call. The first call only
+// takes the initial sample and returns 0.
+func loadAverage() (float64, error) {
+	if err := procGetSystemTimes.Find(); err != nil {
+		return 0, err
+	}
+	var idle, kernel, user windows.Filetime
+	ok, _, err := procGetSystemTimes.Call(
+		uintptr(unsafe.Pointer(&idle)),
+		uintptr(unsafe.Pointer(&kernel)),
+		uintptr(unsafe.Pointer(&user)))
+	if ok == 0 {
+		return 0, fmt.Errorf("GetSystemTimes failed: %w", err)
+	}
+
+	// Kernel time includes the idle time
+	idleTicks := filetimeTicks(idle)
+	totalTicks := filetimeTicks(kernel) + filetimeTicks(user)
+
+	cpuTimesMu.Lock()
+	defer cpuTimesMu.Unlock()
+	deltaIdle, deltaTotal := idleTicks-lastIdle, totalTicks-lastTotal
+	first := lastTotal == 0
+	lastIdle, lastTotal = idleTicks, totalTicks
+	if first || deltaTotal == 0 {
+		return 0, nil
+	}
+	busy := float64(deltaTotal-deltaIdle) / float64(deltaTotal)
+	return busy * float64(runtime.NumCPU()), nil
+}
+
+func filetimeTicks(ft windows.Filetime) uint64 {
+	return uint64(ft.HighDateTime)<<32 | uint64(ft.LowDateTime)
+}
+
+func powerCommand(action string) ([]string, error) {
+	switch action {
+	case actionShutdown:
+		return []string{"shutdown", "/s", "/t", "0"}, nil
+	case actionReboot:
+		return []string{"shutdown", "/r", "/t", "0"}, nil
+	case actionSleep:
+		return []string{"rundll32.exe", "powrprof.dll,SetSuspendState", "0,1,0"}, nil
+	}
+	return nil, fmt.Errorf("unknown action: %s", action)
+}
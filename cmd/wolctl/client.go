@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Exit codes. Errors returned by the server map onto them by error code.
+const (
+	exitOK           = 0
+	exitFailure      = 1 // Any other error
+	exitUsage        = 2 // Invalid command line
+	exitUnavailable  = 3 // Server unreachable, timed out or unavailable
+	exitUnauthorized = 4 // Missing or invalid token
+	exitNotFound     = 5 // Unknown device, group or endpoint
+	exitRejected     = 6 // Invalid request or configuration
+)
+
+// apiError is an error response of the server.
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server returned error (status %d)", e.Status)
+	}
+	return fmt.Sprintf("server returned error (status %d): %s", e.Status, e.Message)
+}
+
+// usageError is an invalid command line.
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+// exitError is an error with a specific exit code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e exitError) Unwrap() error {
+	return e.err
+}
+
+func usagef(format string, args ...any) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
+
+// exitCode returns the process exit code for err.
+func exitCode(err error) int {
+	var apiErr *apiError
+	var urlErr *url.Error
+	var codeErr exitError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usageError{}):
+		return exitUsage
+	case errors.As(err, &codeErr):
+		return codeErr.code
+	case errors.As(err, &apiErr):
+		switch apiErr.Code {
+		case "unauthorized":
+			return exitUnauthorized
+		case "not_found":
+			return exitNotFound
+		case "invalid_request", "invalid_config", "shutdown_not_configured", "conflict",
+			"not_acceptable", "unsupported_media_type":
+			return exitRejected
+		case "unavailable":
+			return exitUnavailable
+		}
+		if apiErr.Status == http.StatusUnauthorized {
+			return exitUnauthorized
+		}
+		if apiErr.Status == http.StatusNotFound {
+			return exitNotFound
+		}
+		return exitFailure
+	case errors.As(err, &urlErr):
+		// Connection failures and timeouts of the HTTP client
+		return exitUnavailable
+	}
+	return exitFailure
+}
+
+// client calls the HomeGuard API.
+type client struct {
+	http    *http.Client
+	server  string // As given on the command line
+	baseURL string
+	token   string
+}
+
+// newClient returns a client for serverURL. A unix:// URL connects to the
+// Unix socket at its path. Requests fail after timeout. tlsConfig may be
+// nil for the defaults.
+func newClient(serverURL, token string, timeout time.Duration, tlsConfig *tls.Config) *client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	c := &client{
+		http:    &http.Client{Transport: transport, Timeout: timeout},
+		server:  serverURL,
+		baseURL: strings.TrimRight(serverURL, "/"),
+		token:   token,
+	}
+
+	socket, ok := strings.CutPrefix(serverURL, "unix://")
+	if !ok {
+		return c
+	}
+
+	var dialer net.Dialer
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", socket)
+	}
+	c.baseURL = "http://unix"
+	return c
+}
+
+// do sends a request and returns the response body. Error responses are
+// returned as *apiError.
+func (c *client) do(method, path, contentType string, body io.Reader) (json.RawMessage, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &apiError{Status: resp.StatusCode}
+		var envelope struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &envelope) == nil {
+			apiErr.Code = envelope.Error.Code
+			apiErr.Message = envelope.Error.Message
+		} else {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return nil, apiErr
+	}
+	return data, nil
+}
+
+func (c *client) get(path string) (json.RawMessage, error) {
+	return c.do(http.MethodGet, path, "", nil)
+}
+
+func (c *client) post(path string, body any) (json.RawMessage, error) {
+	if body == nil {
+		return c.do(http.MethodPost, path, "", nil)
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	return c.do(http.MethodPost, path, "application/json", bytes.NewReader(data))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "success", want: exitOK},
+		{name: "usage", err: usagef("wake requires a device"), want: exitUsage},
+		{name: "wrapped usage", err: fmt.Errorf("profile: %w", usagef("bad")), want: exitUsage},
+		{name: "explicit code", err: exitError{code: exitNotFound, err: errors.New("no config")}, want: exitNotFound},
+		{name: "unauthorized", err: &apiError{Status: 401, Code: "unauthorized"}, want: exitUnauthorized},
+		{name: "not found", err: &apiError{Status: 404, Code: "not_found"}, want: exitNotFound},
+		{name: "invalid request", err: &apiError{Status: 400, Code: "invalid_request"}, want: exitRejected},
+		{name: "invalid config", err: &apiError{Status: 422, Code: "invalid_config"}, want: exitRejected},
+		{name: "shutdown not configured", err: &apiError{Status: 409, Code: "shutdown_not_configured"}, want: exitRejected},
+		{name: "not acceptable", err: &apiError{Status: 406, Code: "not_acceptable"}, want: exitRejected},
+		{name: "unsupported media type", err: &apiError{Status: 415, Code: "unsupported_media_type"}, want: exitRejected},
+		{name: "unavailable", err: &apiError{Status: 503, Code: "unavailable"}, want: exitUnavailable},
+		{name: "401 without a code", err: &apiError{Status: 401}, want: exitUnauthorized},
+		{name: "404 without a code", err: &apiError{Status: 404}, want: exitNotFound},
+		{name: "unknown code", err: &apiError{Status: 500, Code: "internal"}, want: exitFailure},
+		{name: "wrapped API error", err: fmt.Errorf("wake: %w", &apiError{Status: 404, Code: "not_found"}), want: exitNotFound},
+		{name: "other error", err: errors.New("failed to parse response"), want: exitFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientDo(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		want        string
+		wantErr     *apiError
+		contentType string
+	}{
+		{name: "success", status: http.StatusOK, body: `{"ok": true}`, want: `{"ok": true}`},
+		{name: "accepted", status: http.StatusAccepted, body: `{}`, want: `{}`, contentType: "application/json"},
+		{
+			name:    "error envelope",
+			status:  http.StatusNotFound,
+			body:    `{"error": {"code": "not_found", "message": "device not found: nas"}}`,
+			wantErr: &apiError{Status: http.StatusNotFound, Code: "not_found", Message: "device not found: nas"},
+		},
+		{
+			name:    "plain text error",
+			status:  http.StatusBadGateway,
+			body:    "Bad Gateway\n",
+			wantErr: &apiError{Status: http.StatusBadGateway, Message: "Bad Gateway"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+					t.Errorf("Authorization = %q", got)
+				}
+				if got := r.Header.Get("Accept"); got != "application/json" {
+					t.Errorf("Accept = %q", got)
+				}
+				if got := r.Header.Get("Content-Type"); got != tt.contentType {
+					t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+
+			c := newClient(server.URL+"/", "secret", time.Second, nil)
+			var body io.Reader
+			if tt.contentType != "" {
+				body = strings.NewReader("{}")
+			}
+			got, err := c.do(http.MethodPost, "/api/v1/test", tt.contentType, body)
+			if tt.wantErr != nil {
+				var apiErr *apiError
+				if !errors.As(err, &apiErr) || *apiErr != *tt.wantErr {
+					t.Fatalf("error = %#v, want %#v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	start := time.Now()
+	_, err := newClient(server.URL, "", 50*time.Millisecond, nil).get("/api/v1/devices")
+	if err == nil {
+		t.Fatal("request to a hanging server succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("request took %s despite the timeout", elapsed)
+	}
+	if code := exitCode(err); code != exitUnavailable {
+		t.Errorf("exit code = %d, want %d for %v", code, exitUnavailable, err)
+	}
+
+	// A server that is not running
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	_, err = newClient(closed.URL, "", time.Second, nil).get("/api/v1/devices")
+	if code := exitCode(err); code != exitUnavailable {
+		t.Errorf("exit code = %d, want %d for %v", code, exitUnavailable, err)
+	}
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/p3ddd/HomeGuard/configfile"
+)
+
+// command is a wolctl subcommand. run registers the command's own flags on
+// fs, which already has the connection and output flags, and parses args.
+type command struct {
+	name string
+	args string // Argument synopsis
+	help string
+	run  func(fs *flag.FlagSet, args []string) error
+}
+
+var commands = []command{
+	{
+		name: "wake",
+		args: "[-group <name>] [-mac <MAC> -broadcast <addr>] [<device>...]",
+		help: "Wake devices by name, a group, or a MAC address",
+		run:  runWake,
+	},
+	{
+		name: "shutdown",
+		args: "<device>...",
+		help: "Shut down devices",
+		run:  runShutdown,
+	},
+	{
+		name: "list",
+		help: "List devices",
+		run:  runList,
+	},
+	{
+		name: "status",
+		args: "<device>",
+		help: "Show the online status reported by a device's agent",
+		run:  runStatus,
+	},
+	{
+		name: "history",
+		help: "Show the outcome of recent requests",
+		run:  runHistory,
+	},
+	{
+		name: "queue",
+		help: "Show the request queue of the server",
+		run:  runQueue,
+	},
+	{
+		name: "groups",
+		help: "List device groups",
+		run:  runGroups,
+	},
+	{
+		name: "schedule",
+		help: "List schedules and their next run",
+		run:  runSchedule,
+	},
+	{
+		name: "discover",
+		args: "[-subnet <cidr>,...] [-mdns] [-add <MAC|IP> [-name <name>] [-description <text>] [-groups <name>,...]]",
+		help: "Find machines on the network and add them as devices",
+		run:  runDiscover,
+	},
+	{
+		name: "profile",
+		args: "add <name> -server <url> [-token <token>] [-device <name>] [TLS options] | list | use <name> | remove <name>",
+		help: "Manage connection profiles",
+		run:  runProfile,
+	},
+	{
+		name: "config",
+		args: "validate <file>",
+		help: "Check a configuration file with the server",
+		run:  runConfig,
+	},
+	{
+		name: "completion",
+		args: "bash|zsh|fish",
+		help: "Print a shell completion script",
+		run:  runCompletion,
+	},
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// Response types of the API, limited to the fields wolctl shows.
+type (
+	acceptedPayload struct {
+		Action  string   `json:"action"`
+		Device  string   `json:"device,omitempty"`
+		Mac     string   `json:"mac,omitempty"`
+		Group   string   `json:"group,omitempty"`
+		Devices []string `json:"devices,omitempty"`
+	}
+	devicePayload struct {
+		Name        string    `json:"name"`
+		Description string    `json:"description"`
+		Mac         string    `json:"mac"`
+		Broadcast   string    `json:"broadcast"`
+		Groups      []string  `json:"groups"`
+		HasAgent    bool      `json:"has_agent"`
+		Online      bool      `json:"online"`
+		LastSeen    time.Time `json:"last_seen"`
+		CanShutdown bool      `json:"can_shutdown"`
+	}
+	statusPayload struct {
+		Device        string    `json:"device"`
+		Online        bool      `json:"online"`
+		LastSeen      time.Time `json:"last_seen"`
+		Hostname      string    `json:"hostname"`
+		IP            string    `json:"ip"`
+		UptimeSeconds int64     `json:"uptime_seconds"`
+		Mac           string    `json:"mac"`
+		AddressSeen   time.Time `json:"address_seen"`
+
+		AddressChanges []struct {
+			Time        time.Time `json:"time"`
+			IP          string    `json:"ip"`
+			PreviousIP  string    `json:"previous_ip"`
+			Mac         string    `json:"mac"`
+			PreviousMac string    `json:"previous_mac"`
+		} `json:"address_changes"`
+	}
+	groupPayload struct {
+		Name    string   `json:"name"`
+		Devices []string `json:"devices"`
+	}
+	schedulePayload struct {
+		Name    string    `json:"name"`
+		Device  string    `json:"device"`
+		Group   string    `json:"group"`
+		Action  string    `json:"action"`
+		At      string    `json:"at"`
+		Days    []string  `json:"days"`
+		NextRun time.Time `json:"next_run"`
+	}
+	queuePayload struct {
+		Workers       int     `json:"workers"`
+		Size          int     `json:"size"`
+		Queued        int     `json:"queued"`
+		Scheduled     int     `json:"scheduled"`
+		Active        int     `json:"active"`
+		OldestSeconds float64 `json:"oldest_seconds"`
+		Enqueued      uint64  `json:"enqueued"`
+		Processed     uint64  `json:"processed"`
+		Dropped       uint64  `json:"dropped"`
+		Waited        uint64  `json:"waited"`
+	}
+	eventPayload struct {
+		Type   string    `json:"type"`
+		Time   time.Time `json:"time"`
+		Device string    `json:"device"`
+		Mac    string    `json:"mac"`
+		Source string    `json:"source"`
+		Error  string    `json:"error"`
+	}
+)
+
+func runWake(fs *flag.FlagSet, args []string) error {
+	group := fs.String("group", "", "Wake every device of the group")
+	mac := fs.String("mac", "", "MAC address to wake up")
+	broadcast := fs.String("broadcast", "", "Broadcast address for -mac")
+	fs.BoolVar(local, "local", *local, "Send the magic packet from this machine instead of the server")
+	fs.StringVar(configFile, "config", *configFile, "HomeGuard config file for -local (default: search $HOMEGUARD_CONFIG and XDG locations)")
+	args = parseFlags(fs, args)
+	if *group == "" && *mac == "" {
+		args = withDefaultDevice(args)
+	}
+	if *local {
+		return wakeLocal(*group, *mac, *broadcast, args)
+	}
+	return wakeDevices(connect(), *group, *mac, *broadcast, args)
+}
+
+// checkWakeArgs checks that exactly one of a group, a MAC address or device
+// names is given.
+func checkWakeArgs(group, mac, broadcast string, args []string) error {
+	switch {
+	case group != "" && (mac != "" || len(args) > 0):
+		return usagef("-group cannot be combined with devices or -mac")
+	case mac != "" && len(args) > 0:
+		return usagef("-mac cannot be combined with devices")
+	case mac != "" && broadcast == "":
+		return usagef("-mac requires -broadcast")
+	case group == "" && mac == "" && len(args) == 0:
+		return usagef("wake requires a device, -group or -mac")
+	}
+	return nil
+}
+
+// wakeDevices wakes a group, a MAC address or the named devices.
+func wakeDevices(c *client, group, mac, broadcast string, args []string) error {
+	if err := checkWakeArgs(group, mac, broadcast, args); err != nil {
+		return err
+	}
+
+	var responses []json.RawMessage
+	switch {
+	case group != "":
+		raw, err := c.post("/api/v1/groups/"+url.PathEscape(group)+"/wake", nil)
+		if err != nil {
+			return err
+		}
+		responses = append(responses, raw)
+	case mac != "":
+		raw, err := c.post("/api/v1/wake", map[string]string{"mac": mac, "broadcast": broadcast})
+		if err != nil {
+			return err
+		}
+		responses = append(responses, raw)
+	default:
+		for _, name := range args {
+			raw, err := c.post("/api/v1/devices/"+url.PathEscape(name)+"/wake", nil)
+			if err != nil {
+				return err
+			}
+			responses = append(responses, raw)
+		}
+	}
+	return printAccepted(responses)
+}
+
+func runShutdown(fs *flag.FlagSet, args []string) error {
+	return shutdownDevices(connect(), withDefaultDevice(parseFlags(fs, args)))
+}
+
+// shutdownDevices shuts down the named devices.
+func shutdownDevices(c *client, args []string) error {
+	if len(args) == 0 {
+		return usagef("shutdown requires a device")
+	}
+
+	var responses []json.RawMessage
+	for _, name := range args {
+		raw, err := c.post("/api/v1/devices/"+url.PathEscape(name)+"/shutdown", nil)
+		if err != nil {
+			return err
+		}
+		responses = append(responses, raw)
+	}
+	return printAccepted(responses)
+}
+
+// printAccepted prints the responses of queued requests.
+func printAccepted(responses []json.RawMessage) error {
+	if outputJSON() {
+		if len(responses) == 1 {
+			return printJSON(responses[0])
+		}
+		return printJSON(responses)
+	}
+
+	for _, raw := range responses {
+		var accepted acceptedPayload
+		if err := json.Unmarshal(raw, &accepted); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+		verb := "wake-up"
+		if accepted.Action == "shutdown" {
+			verb = "shutdown"
+		}
+		switch {
+		case accepted.Group != "":
+			fmt.Printf("✓ Successfully sent %s request for group %s: %s\n", verb, accepted.Group, strings.Join(accepted.Devices, ", "))
+		case accepted.Device != "":
+			fmt.Printf("✓ Successfully sent %s request for device: %s\n", verb, accepted.Device)
+		default:
+			fmt.Printf("✓ Successfully sent %s request for MAC: %s\n", verb, accepted.Mac)
+		}
+	}
+	return nil
+}
+
+func runList(fs *flag.FlagSet, args []string) error {
+	args = parseFlags(fs, args)
+	if len(args) > 0 {
+		return usagef("list takes no arguments")
+	}
+	raw, err := connect().get("/api/v1/devices")
+	if err != nil {
+		return err
+	}
+
+	var devices []devicePayload
+	return show(raw, &devices, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tMAC\tBROADCAST\tSTATUS\tGROUPS\tDESCRIPTION")
+		for _, dev := range devices {
+			status := "-"
+			if dev.HasAgent {
+				status = onlineText(dev.Online)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", dev.Name, dev.Mac, dev.Broadcast, status,
+				dash(strings.Join(dev.Groups, ",")), dash(dev.Description))
+		}
+	})
+}
+
+func runStatus(fs *flag.FlagSet, args []string) error {
+	args = withDefaultDevice(parseFlags(fs, args))
+	if len(args) != 1 {
+		return usagef("status requires exactly one device")
+	}
+	raw, err := connect().get("/api/v1/devices/" + url.PathEscape(args[0]) + "/status")
+	if err != nil {
+		return err
+	}
+
+	var status statusPayload
+	return show(raw, &status, func(w io.Writer) {
+		fmt.Fprintf(w, "Device:\t%s\n", status.Device)
+		fmt.Fprintf(w, "Status:\t%s\n", onlineText(status.Online))
+		fmt.Fprintf(w, "Last seen:\t%s\n", timeText(status.LastSeen))
+		fmt.Fprintf(w, "Hostname:\t%s\n", dash(status.Hostname))
+		fmt.Fprintf(w, "IP:\t%s\n", dash(status.IP))
+		uptime := "-"
+		if status.UptimeSeconds > 0 {
+			uptime = (time.Duration(status.UptimeSeconds) * time.Second).String()
+		}
+		fmt.Fprintf(w, "Uptime:\t%s\n", uptime)
+		if !status.AddressSeen.IsZero() {
+			fmt.Fprintf(w, "MAC:\t%s\n", status.Mac)
+			fmt.Fprintf(w, "Address seen:\t%s\n", timeText(status.AddressSeen))
+		}
+		for _, c := range status.AddressChanges {
+			fmt.Fprintf(w, "Address changed:\t%s  %s %s (was %s %s)\n", timeText(c.Time), c.IP, c.Mac,
+				dash(c.PreviousIP), dash(c.PreviousMac))
+		}
+	})
+}
+
+func runHistory(fs *flag.FlagSet, args []string) error {
+	limit := fs.Int("n", 20, "Number of entries to show (0 for all)")
+	if len(parseFlags(fs, args)) > 0 {
+		return usagef("history takes no arguments")
+	}
+
+	raw, err := connect().get("/api/v1/history")
+	if err != nil {
+		return err
+	}
+
+	var history []eventPayload
+	if err := json.Unmarshal(raw, &history); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	if *limit > 0 && len(history) > *limit {
+		history = history[:*limit]
+	}
+	if outputJSON() {
+		return printJSON(history)
+	}
+
+	return table(func(w io.Writer) {
+		fmt.Fprintln(w, "TIME\tACTION\tTARGET\tSOURCE\tRESULT")
+		for _, e := range history {
+			action, result, _ := strings.Cut(e.Type, ".")
+			if e.Error != "" {
+				result += ": " + e.Error
+			}
+			target := e.Device
+			if target == "" {
+				target = e.Mac
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", timeText(e.Time), action, target, dash(e.Source), result)
+		}
+	})
+}
+
+func runQueue(fs *flag.FlagSet, args []string) error {
+	args = parseFlags(fs, args)
+	if len(args) > 0 {
+		return usagef("queue takes no arguments")
+	}
+	raw, err := connect().get("/api/v1/queue")
+	if err != nil {
+		return err
+	}
+
+	var queue queuePayload
+	return show(raw, &queue, func(w io.Writer) {
+		fmt.Fprintf(w, "Workers:\t%d busy of %d\n", queue.Active, queue.Workers)
+		fmt.Fprintf(w, "Queued:\t%d of %d (%d scheduled)\n", queue.Queued, queue.Size, queue.Scheduled)
+		if queue.Queued > 0 {
+			oldest := time.Duration(queue.OldestSeconds * float64(time.Second)).Round(time.Millisecond)
+			fmt.Fprintf(w, "Oldest:\t%s\n", oldest)
+		}
+		fmt.Fprintf(w, "Processed:\t%d of %d accepted\n", queue.Processed, queue.Enqueued)
+		fmt.Fprintf(w, "Dropped:\t%d\n", queue.Dropped)
+		fmt.Fprintf(w, "Waited:\t%d\n", queue.Waited)
+	})
+}
+
+func runGroups(fs *flag.FlagSet, args []string) error {
+	args = parseFlags(fs, args)
+	if len(args) > 0 {
+		return usagef("groups takes no arguments")
+	}
+	raw, err := connect().get("/api/v1/groups")
+	if err != nil {
+		return err
+	}
+
+	var groups []groupPayload
+	return show(raw, &groups, func(w io.Writer) {
+		fmt.Fprintln(w, "GROUP\tDEVICES")
+		for _, group := range groups {
+			fmt.Fprintf(w, "%s\t%s\n", group.Name, strings.Join(group.Devices, ", "))
+		}
+	})
+}
+
+func runSchedule(fs *flag.FlagSet, args []string) error {
+	args = parseFlags(fs, args)
+	if len(args) > 0 {
+		return usagef("schedule takes no arguments")
+	}
+	raw, err := connect().get("/api/v1/schedules")
+	if err != nil {
+		return err
+	}
+
+	var schedules []schedulePayload
+	return show(raw, &schedules, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tTARGET\tACTION\tAT\tDAYS\tNEXT RUN")
+		for _, s := range schedules {
+			target := s.Device
+			if s.Group != "" {
+				target = "group " + s.Group
+			}
+			days := "every day"
+			if len(s.Days) > 0 {
+				days = strings.Join(s.Days, ",")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Name, target, s.Action, s.At, days, timeText(s.NextRun))
+		}
+	})
+}
+
+func runConfig(fs *flag.FlagSet, args []string) error {
+	args = parseFlags(fs, args)
+	if len(args) != 2 || args[0] != "validate" {
+		return usagef("usage: wolctl config validate <file>")
+	}
+	file := args[1]
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	raw, err := connect().do(http.MethodPost, "/api/v1/config/validate", configMediaType(file), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	if outputJSON() {
+		return printJSON(raw)
+	}
+	fmt.Printf("✓ %s is valid\n", file)
+	return nil
+}
+
+// configMediaType returns the content type of the configuration file at
+// path, chosen by its extension like the server does for its own file.
+func configMediaType(path string) string {
+	switch configfile.FormatOf(path) {
+	case configfile.FormatJSON:
+		return "application/json"
+	case configfile.FormatTOML:
+		return "application/toml"
+	default:
+		return "application/yaml"
+	}
+}
+
+// show prints raw as JSON, or decodes it into v and prints the table written
+// by rows.
+func show(raw json.RawMessage, v any, rows func(w io.Writer)) error {
+	if outputJSON() {
+		return printJSON(raw)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return table(rows)
+}
+
+// table prints the rows written by rows as aligned columns.
+func table(rows func(w io.Writer)) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	rows(w)
+	return w.Flush()
+}
+
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func outputJSON() bool {
+	return *output == "json"
+}
+
+func onlineText(online bool) string {
+	if online {
+		return "online"
+	}
+	return "offline"
+}
+
+func timeText(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
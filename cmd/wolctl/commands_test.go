@@ -0,0 +1,286 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// isolate restores the global flags after a test and hides the user's
+// wolctl configuration and environment from it.
+func isolate(t *testing.T) {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOMEGUARD_TOKEN", "")
+	t.Setenv("WOLCTL_PROFILE", "")
+
+	server, apiToken, out, profile, config := *serverURL, *token, *output, *profileName, *configFile
+	requestTimeout, localMode := *timeout, *local
+	explicit = make(map[string]bool)
+	t.Cleanup(func() {
+		*serverURL, *token, *output, *profileName, *configFile = server, apiToken, out, profile, config
+		*timeout, *local = requestTimeout, localMode
+		explicit = make(map[string]bool)
+	})
+}
+
+// captureStdout returns what fn prints to stdout.
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	printed := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		printed <- string(data)
+	}()
+
+	err = fn()
+	os.Stdout = saved
+	_ = w.Close()
+	return <-printed, err
+}
+
+// apiStandIn answers API requests with canned JSON bodies, keyed by method
+// and path, and records the requests it gets.
+type apiStandIn struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string // Method, path and content type
+}
+
+func newAPIStandIn(t *testing.T, responses map[string]string) *apiStandIn {
+	t.Helper()
+	s := &apiStandIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.URL.Path
+		s.mu.Lock()
+		s.requests = append(s.requests, strings.TrimSpace(key+" "+r.Header.Get("Content-Type")))
+		s.mu.Unlock()
+
+		body, ok := responses[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"error": {"code": "not_found", "message": "not found: `+r.URL.Path+`"}}`)
+			return
+		}
+		if r.Method == http.MethodPost && !strings.HasSuffix(r.URL.Path, "/validate") {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *apiStandIn) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+func TestCheckWakeArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		group     string
+		mac       string
+		broadcast string
+		args      []string
+		wantErr   string
+	}{
+		{name: "devices", args: []string{"desktop", "nas"}},
+		{name: "group", group: "office"},
+		{name: "MAC", mac: "00:11:22:33:44:55", broadcast: "192.168.1.255"},
+		{name: "nothing", wantErr: "wake requires a device, -group or -mac"},
+		{name: "group and devices", group: "office", args: []string{"desktop"}, wantErr: "-group cannot be combined"},
+		{name: "group and MAC", group: "office", mac: "00:11:22:33:44:55", broadcast: "192.168.1.255", wantErr: "-group cannot be combined"},
+		{name: "MAC and devices", mac: "00:11:22:33:44:55", broadcast: "192.168.1.255", args: []string{"desktop"}, wantErr: "-mac cannot be combined"},
+		{name: "MAC without broadcast", mac: "00:11:22:33:44:55", wantErr: "-mac requires -broadcast"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkWakeArgs(tt.group, tt.mac, tt.broadcast, tt.args)
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if err != nil && exitCode(err) != exitUsage {
+				t.Errorf("exit code = %d, want %d", exitCode(err), exitUsage)
+			}
+		})
+	}
+}
+
+func TestCommands(t *testing.T) {
+	const (
+		devices = `[{"name": "desktop", "mac": "00:11:22:33:44:55", "broadcast": "192.168.1.255", "groups": ["office"], "has_agent": true, "online": true}]`
+		history = `[{"type": "wake.sent", "device": "desktop", "source": "HTTP"}, {"type": "shutdown.failed", "device": "nas", "source": "API", "error": "timeout"}]`
+	)
+	responses := map[string]string{
+		"POST /api/v1/devices/desktop/wake":     `{"action": "wake", "device": "desktop"}`,
+		"POST /api/v1/devices/nas/wake":         `{"action": "wake", "device": "nas"}`,
+		"POST /api/v1/devices/desktop/shutdown": `{"action": "shutdown", "device": "desktop"}`,
+		"POST /api/v1/groups/office/wake":       `{"action": "wake", "group": "office", "devices": ["desktop", "printer"]}`,
+		"POST /api/v1/wake":                     `{"action": "wake", "mac": "00:11:22:33:44:66"}`,
+		"POST /api/v1/config/validate":          `{"valid": true}`,
+		"GET /api/v1/devices":                   devices,
+		"GET /api/v1/history":                   history,
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"config.yaml", "config.json", "config.toml"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		args         []string
+		wantRequests []string
+		wantOutput   []string
+		wantCode     int
+	}{
+		{
+			name:         "wake devices",
+			args:         []string{"wake", "desktop", "nas"},
+			wantRequests: []string{"POST /api/v1/devices/desktop/wake", "POST /api/v1/devices/nas/wake"},
+			wantOutput:   []string{"✓ Successfully sent wake-up request for device: desktop\n", "for device: nas\n"},
+		},
+		{
+			name:         "wake group as JSON",
+			args:         []string{"wake", "-group", "office", "-o", "json"},
+			wantRequests: []string{"POST /api/v1/groups/office/wake"},
+			wantOutput:   []string{"{\n  \"action\": \"wake\",\n  \"group\": \"office\",\n  \"devices\": [\n    \"desktop\",\n    \"printer\"\n  ]\n}\n"},
+		},
+		{
+			name:         "wake several as JSON, flags between devices",
+			args:         []string{"wake", "desktop", "-o", "json", "nas"},
+			wantRequests: []string{"POST /api/v1/devices/desktop/wake", "POST /api/v1/devices/nas/wake"},
+			wantOutput:   []string{"[\n  {\n    \"action\": \"wake\",\n    \"device\": \"desktop\"\n  },\n"},
+		},
+		{
+			name:         "wake MAC",
+			args:         []string{"wake", "-mac", "00:11:22:33:44:66", "-broadcast", "192.168.1.255"},
+			wantRequests: []string{"POST /api/v1/wake application/json"},
+			wantOutput:   []string{"✓ Successfully sent wake-up request for MAC: 00:11:22:33:44:66\n"},
+		},
+		{
+			name:         "wake unknown device",
+			args:         []string{"wake", "laptop"},
+			wantRequests: []string{"POST /api/v1/devices/laptop/wake"},
+			wantCode:     exitNotFound,
+		},
+		{
+			name:     "wake without a device",
+			args:     []string{"wake"},
+			wantCode: exitUsage,
+		},
+		{
+			name:         "shutdown",
+			args:         []string{"shutdown", "desktop"},
+			wantRequests: []string{"POST /api/v1/devices/desktop/shutdown"},
+			wantOutput:   []string{"✓ Successfully sent shutdown request for device: desktop\n"},
+		},
+		{
+			name:         "list as a table",
+			args:         []string{"list"},
+			wantRequests: []string{"GET /api/v1/devices"},
+			wantOutput: []string{
+				"NAME     MAC                BROADCAST      STATUS  GROUPS  DESCRIPTION\n" +
+					"desktop  00:11:22:33:44:55  192.168.1.255  online  office  -\n",
+			},
+		},
+		{
+			name:         "list as JSON",
+			args:         []string{"list", "-o", "json"},
+			wantRequests: []string{"GET /api/v1/devices"},
+			wantOutput:   []string{"[\n  {\n    \"name\": \"desktop\",\n    \"mac\": \"00:11:22:33:44:55\",\n"},
+		},
+		{
+			name:     "list with arguments",
+			args:     []string{"list", "desktop"},
+			wantCode: exitUsage,
+		},
+		{
+			name:         "history limited",
+			args:         []string{"history", "-n", "1"},
+			wantRequests: []string{"GET /api/v1/history"},
+			wantOutput:   []string{"TIME  ACTION  TARGET   SOURCE  RESULT\n-     wake    desktop  HTTP    sent\n"},
+		},
+		{
+			name:         "history error",
+			args:         []string{"history", "-o", "json"},
+			wantRequests: []string{"GET /api/v1/history"},
+			wantOutput:   []string{`"error": "timeout"`},
+		},
+		{
+			name:         "validate YAML",
+			args:         []string{"config", "validate", filepath.Join(dir, "config.yaml")},
+			wantRequests: []string{"POST /api/v1/config/validate application/yaml"},
+			wantOutput:   []string{"config.yaml is valid\n"},
+		},
+		{
+			name:         "validate JSON",
+			args:         []string{"config", "validate", filepath.Join(dir, "config.json")},
+			wantRequests: []string{"POST /api/v1/config/validate application/json"},
+		},
+		{
+			name:         "validate TOML",
+			args:         []string{"config", "validate", filepath.Join(dir, "config.toml")},
+			wantRequests: []string{"POST /api/v1/config/validate application/toml"},
+		},
+		{
+			name:     "unknown command",
+			args:     []string{"reboot"},
+			wantCode: exitUsage,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolate(t)
+			server := newAPIStandIn(t, responses)
+			*serverURL = server.URL
+
+			out, err := captureStdout(t, func() error { return run(tt.args) })
+			if code := exitCode(err); code != tt.wantCode {
+				t.Fatalf("exit code = %d, want %d: %v", code, tt.wantCode, err)
+			}
+			if got := server.received(); !slices.Equal(got, tt.wantRequests) {
+				t.Errorf("requests = %q, want %q", got, tt.wantRequests)
+			}
+			for _, want := range tt.wantOutput {
+				if !strings.Contains(out, want) {
+					t.Errorf("output does not contain %q:\n%s", want, out)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigMediaType(t *testing.T) {
+	tests := map[string]string{
+		"config.yaml":     "application/yaml",
+		"config.yml":      "application/yaml",
+		"config":          "application/yaml",
+		"conf.d/lab.json": "application/json",
+		"CONFIG.TOML":     "application/toml",
+		"/etc/hg/x.toml":  "application/toml",
+	}
+	for path, want := range tests {
+		if got := configMediaType(path); got != want {
+			t.Errorf("configMediaType(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
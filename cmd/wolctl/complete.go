@@ -0,0 +1,367 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/p3ddd/HomeGuard/device"
+)
+
+// completeCommand is the hidden command the completion scripts call with the
+// words of the command line. It prints the candidates for the last word.
+const completeCommand = "__complete"
+
+const (
+	completionCacheTTL = time.Minute     // How long device names are reused
+	completionTimeout  = 2 * time.Second // Request timeout while completing
+)
+
+// sharedFlags and commandFlags list the flags of the commands, for
+// completion. Names ending in "=" take a value.
+var (
+	sharedFlags  = []string{"server=", "token=", "o=", "timeout=", "profile="}
+	commandFlags = map[string][]string{
+		"wake":     {"group=", "mac=", "broadcast=", "local", "config="},
+		"history":  {"n="},
+		"discover": {"subnet=", "mdns", "add=", "name=", "description=", "groups=", "local", "config="},
+		"profile":  {"device=", "ca-file=", "cert-file=", "key-file=", "insecure-skip-verify", "server-name="},
+	}
+)
+
+// globalFlags returns the flags accepted before the command.
+func globalFlags() []string {
+	var names []string
+	flag.VisitAll(func(f *flag.Flag) {
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			names = append(names, f.Name)
+		} else {
+			names = append(names, f.Name+"=")
+		}
+	})
+	return names
+}
+
+// completionCache holds the names completed from the server or the local
+// configuration.
+type completionCache struct {
+	Time    time.Time `json:"time"`
+	Devices []string  `json:"devices"`
+	Groups  []string  `json:"groups"`
+}
+
+func runComplete(words []string) error {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur := words[len(words)-1]
+
+	// Replay the words before the current one to find the command, its
+	// arguments and a flag waiting for its value
+	var cmd, pending string
+	var args []string
+	for _, word := range words[:len(words)-1] {
+		if pending != "" {
+			setCompletionFlag(pending, word)
+			pending = ""
+			continue
+		}
+		if strings.HasPrefix(word, "-") {
+			name, value, hasValue := strings.Cut(strings.TrimLeft(word, "-"), "=")
+			if slices.Contains(completionFlags(cmd), name+"=") && !hasValue {
+				pending = name
+			} else {
+				setCompletionFlag(name, value)
+			}
+			continue
+		}
+		if cmd == "" {
+			cmd = word
+		} else {
+			args = append(args, word)
+		}
+	}
+
+	var candidates []string
+	switch {
+	case pending != "":
+		candidates = flagValues(pending)
+	case strings.HasPrefix(cur, "-"):
+		for _, name := range completionFlags(cmd) {
+			candidates = append(candidates, "-"+strings.TrimSuffix(name, "="))
+		}
+	case cmd == "":
+		for _, c := range commands {
+			candidates = append(candidates, c.name)
+		}
+	default:
+		candidates = commandArgs(cmd, args)
+	}
+
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, cur) {
+			fmt.Println(candidate)
+		}
+	}
+	return nil
+}
+
+// completionFlags returns the flags accepted at the position after cmd.
+func completionFlags(cmd string) []string {
+	if cmd == "" {
+		return globalFlags()
+	}
+	return append(slices.Clone(sharedFlags), commandFlags[cmd]...)
+}
+
+// setCompletionFlag applies the flags that change where names are completed
+// from.
+func setCompletionFlag(name, value string) {
+	switch name {
+	case "server":
+		*serverURL = value
+	case "token":
+		*token = value
+	case "profile":
+		*profileName = value
+	case "config":
+		*configFile = value
+	case "local":
+		*local = value == "" || value == "true"
+	default:
+		return
+	}
+	explicit[name] = true
+}
+
+// flagValues returns the candidates for the value of a flag. File arguments
+// have none, so the shell completes file names.
+func flagValues(name string) []string {
+	switch name {
+	case "device":
+		return completionNames().Devices
+	case "group":
+		return completionNames().Groups
+	case "o":
+		return []string{"table", "json"}
+	case "profile":
+		return profileNames()
+	}
+	return nil
+}
+
+// commandArgs returns the candidates for the next argument of cmd.
+func commandArgs(cmd string, args []string) []string {
+	switch cmd {
+	case "wake", "shutdown":
+		return completionNames().Devices
+	case "status":
+		if len(args) == 0 {
+			return completionNames().Devices
+		}
+	case "profile":
+		if len(args) == 0 {
+			return []string{"add", "list", "use", "remove"}
+		}
+		if len(args) == 1 && (args[0] == "use" || args[0] == "remove") {
+			return profileNames()
+		}
+	case "config":
+		if len(args) == 0 {
+			return []string{"validate"}
+		}
+	case "completion":
+		if len(args) == 0 {
+			return []string{"bash", "zsh", "fish"}
+		}
+	}
+	return nil
+}
+
+func profileNames() []string {
+	config, _, err := loadClientConfig()
+	if err != nil {
+		return nil
+	}
+	return slices.Sorted(maps.Keys(config.Profiles))
+}
+
+// completionNames returns the device and group names from the server, or
+// from the local configuration with -local. Names are cached on disk for a
+// minute; a stale cache is used if they cannot be fetched.
+func completionNames() completionCache {
+	var key string
+	var fetch func() (completionCache, error)
+	if *local {
+		path, err := findConfig()
+		if err != nil {
+			return completionCache{}
+		}
+		key, fetch = "local:"+path, func() (completionCache, error) { return localNames(path) }
+	} else {
+		if !explicit["timeout"] {
+			*timeout = completionTimeout
+		}
+		c, err := dial()
+		if err != nil {
+			return completionCache{}
+		}
+		key, fetch = c.server, func() (completionCache, error) { return serverNames(c) }
+	}
+
+	path := completionCachePath(key)
+	cached, cacheErr := readCompletionCache(path)
+	if cacheErr == nil && time.Since(cached.Time) < completionCacheTTL {
+		return cached
+	}
+
+	names, err := fetch()
+	if err != nil {
+		return cached
+	}
+	names.Time = time.Now()
+	if path != "" {
+		if data, err := json.Marshal(names); err == nil && os.MkdirAll(filepath.Dir(path), 0o700) == nil {
+			_ = os.WriteFile(path, data, 0o600)
+		}
+	}
+	return names
+}
+
+func serverNames(c *client) (completionCache, error) {
+	var names completionCache
+
+	raw, err := c.get("/api/v1/devices")
+	if err != nil {
+		return names, err
+	}
+	var devices []devicePayload
+	if err := json.Unmarshal(raw, &devices); err != nil {
+		return names, err
+	}
+	for _, dev := range devices {
+		names.Devices = append(names.Devices, dev.Name)
+	}
+
+	raw, err = c.get("/api/v1/groups")
+	if err != nil {
+		return names, err
+	}
+	var groups []groupPayload
+	if err := json.Unmarshal(raw, &groups); err != nil {
+		return names, err
+	}
+	for _, group := range groups {
+		names.Groups = append(names.Groups, group.Name)
+	}
+	return names, nil
+}
+
+func localNames(path string) (completionCache, error) {
+	var names completionCache
+	devices, err := device.NewManager(path)
+	if err != nil {
+		return names, err
+	}
+	for _, dev := range devices.ListDevices() {
+		names.Devices = append(names.Devices, dev.Name)
+	}
+	slices.Sort(names.Devices)
+	names.Groups = slices.Sorted(maps.Keys(devices.ListGroups()))
+	return names, nil
+}
+
+// completionCachePath returns the cache file for names completed from key,
+// or "" if there is no cache directory.
+func completionCachePath(key string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dir, "wolctl", "completion-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+func readCompletionCache(path string) (completionCache, error) {
+	var cached completionCache
+	if path == "" {
+		return cached, os.ErrNotExist
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cached, err
+	}
+	err = json.Unmarshal(data, &cached)
+	return cached, err
+}
+
+func runCompletion(fs *flag.FlagSet, args []string) error {
+	args = parseFlags(fs, args)
+	if len(args) != 1 {
+		return usagef("usage: wolctl completion bash|zsh|fish")
+	}
+
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion)
+	case "zsh":
+		fmt.Print(zshCompletion)
+	case "fish":
+		fmt.Print(fishCompletion)
+	default:
+		return usagef("unsupported shell: %s", args[0])
+	}
+	return nil
+}
+
+// Completion scripts. Each passes the words typed so far to wolctl
+// __complete and falls back to file names when there are no candidates.
+const (
+	bashCompletion = `# bash completion for wolctl
+# Load with: source <(wolctl completion bash)
+_wolctl() {
+    local IFS=$'\n'
+    COMPREPLY=($(wolctl __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _wolctl wolctl
+`
+
+	zshCompletion = `#compdef wolctl
+# zsh completion for wolctl
+# Load with: source <(wolctl completion zsh), or save as _wolctl in $fpath
+_wolctl() {
+    local -a candidates
+    candidates=("${(@f)$(wolctl __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    if [[ -n ${candidates[1]} ]]; then
+        compadd -a candidates
+    else
+        _files
+    fi
+}
+if [[ ${funcstack[1]} == _wolctl ]]; then
+    _wolctl "$@"
+else
+    compdef _wolctl wolctl
+fi
+`
+
+	fishCompletion = `# fish completion for wolctl
+# Load with: wolctl completion fish | source
+function __wolctl_complete
+    set -l words (commandline -opc)
+    set -e words[1]
+    set -l cur (commandline -ct)
+    wolctl __complete $words "$cur" 2>/dev/null
+end
+complete -c wolctl -f -n 'count (__wolctl_complete) >/dev/null' -a '(__wolctl_complete)'
+complete -c wolctl -F -n 'not count (__wolctl_complete) >/dev/null'
+`
+)
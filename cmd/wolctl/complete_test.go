@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// completionResponses is what the stand-in server knows for completion.
+var completionResponses = map[string]string{
+	"GET /api/v1/devices": `[{"name": "desktop"}, {"name": "nas"}]`,
+	"GET /api/v1/groups":  `[{"name": "office", "devices": ["desktop"]}, {"name": "storage", "devices": ["nas"]}]`,
+}
+
+func TestRunComplete(t *testing.T) {
+	const (
+		profiles    = "profiles:\n  home:\n    server: http://home.lan:7092\n  work:\n    server: https://work.example.com\n"
+		localConfig = `devices:
+  - name: pi
+    mac: "00:11:22:33:44:77"
+    groups: ["lab"]
+  - name: laptop
+    mac: "00:11:22:33:44:88"
+`
+	)
+
+	tests := []struct {
+		name  string
+		words []string // {config} stands for a local config file
+		want  []string
+	}{
+		{name: "commands", words: []string{"s"}, want: []string{"shutdown", "status", "schedule"}},
+		{name: "global flags", words: []string{"-lo"}, want: []string{"-local"}},
+		{name: "wake devices", words: []string{"wake", ""}, want: []string{"desktop", "nas"}},
+		{name: "wake device prefix", words: []string{"wake", "desktop", "n"}, want: []string{"nas"}},
+		{name: "wake flags", words: []string{"wake", "-g"}, want: []string{"-group"}},
+		{name: "wake group", words: []string{"wake", "-group", ""}, want: []string{"office", "storage"}},
+		{name: "output format", words: []string{"list", "-o", ""}, want: []string{"table", "json"}},
+		{name: "output format prefix", words: []string{"history", "--o", "j"}, want: []string{"json"}},
+		{name: "status takes one device", words: []string{"status", "desktop", ""}},
+		{name: "profile actions", words: []string{"profile", ""}, want: []string{"add", "list", "use", "remove"}},
+		{name: "profile use", words: []string{"profile", "use", ""}, want: []string{"home", "work"}},
+		{name: "profile remove prefix", words: []string{"profile", "remove", "w"}, want: []string{"work"}},
+		{name: "profile flag", words: []string{"-profile", ""}, want: []string{"home", "work"}},
+		{name: "config actions", words: []string{"config", ""}, want: []string{"validate"}},
+		{name: "config file name", words: []string{"config", "validate", ""}},
+		{name: "completion shells", words: []string{"completion", ""}, want: []string{"bash", "zsh", "fish"}},
+		{name: "local devices", words: []string{"-local", "-config", "{config}", "wake", ""}, want: []string{"laptop", "pi"}},
+		{name: "local devices after the command", words: []string{"wake", "-local", "-config={config}", ""}, want: []string{"laptop", "pi"}},
+		{name: "local group", words: []string{"wake", "-local", "-config", "{config}", "-group", ""}, want: []string{"lab"}},
+		{name: "local without a config", words: []string{"wake", "-local", "-config", "{config}.missing", ""}},
+		{name: "unreachable server", words: []string{"-server", "http://127.0.0.1:1", "wake", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolate(t)
+			t.Setenv("XDG_CACHE_HOME", t.TempDir())
+			server := newAPIStandIn(t, completionResponses)
+			*serverURL = server.URL
+
+			configHome := os.Getenv("XDG_CONFIG_HOME")
+			writeFile(t, filepath.Join(configHome, "wolctl", "config.yaml"), profiles)
+			config := filepath.Join(t.TempDir(), "config.yaml")
+			writeFile(t, config, localConfig)
+
+			words := make([]string, len(tt.words))
+			for i, word := range tt.words {
+				words[i] = strings.ReplaceAll(word, "{config}", config)
+			}
+			out, err := captureStdout(t, func() error { return runComplete(words) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Fields(out); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("candidates = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompletionNamesCache(t *testing.T) {
+	isolate(t)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	server := newAPIStandIn(t, completionResponses)
+	*serverURL = server.URL
+
+	names := completionNames()
+	if strings.Join(names.Devices, " ") != "desktop nas" || strings.Join(names.Groups, " ") != "office storage" {
+		t.Fatalf("names = %+v", names)
+	}
+	if got := len(server.received()); got != 2 {
+		t.Fatalf("requests = %d, want 2", got)
+	}
+
+	// Fresh names come from the cache
+	completionNames()
+	if got := len(server.received()); got != 2 {
+		t.Errorf("requests with a fresh cache = %d, want 2", got)
+	}
+
+	// Stale names are fetched again
+	path := completionCachePath(server.URL)
+	writeCompletionCache(t, path, completionCache{Time: time.Now().Add(-2 * completionCacheTTL), Devices: []string{"old"}})
+	names = completionNames()
+	if got := len(server.received()); got != 4 {
+		t.Errorf("requests with a stale cache = %d, want 4", got)
+	}
+	if strings.Join(names.Devices, " ") != "desktop nas" {
+		t.Errorf("devices = %v, want those of the server", names.Devices)
+	}
+
+	// Stale names are better than none if the server is down
+	writeCompletionCache(t, path, completionCache{Time: time.Now().Add(-2 * completionCacheTTL), Devices: []string{"old"}})
+	server.Close()
+	names = completionNames()
+	if strings.Join(names.Devices, " ") != "old" {
+		t.Errorf("devices = %v, want the stale cache", names.Devices)
+	}
+}
+
+func writeCompletionCache(t *testing.T, path string, names completionCache) {
+	t.Helper()
+	data, err := json.Marshal(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path, string(data))
+}
+
+// writeFile writes data to path, creating its directory.
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/p3ddd/HomeGuard/configfile"
+	"github.com/p3ddd/HomeGuard/device"
+	"github.com/p3ddd/HomeGuard/discover"
+)
+
+const (
+	// discoverTimeout replaces the default request timeout for discovery,
+	// which sweeps whole subnets.
+	discoverTimeout = 2 * time.Minute
+
+	// discoverMDNSTime is how long local discovery listens for mDNS
+	// announcements, as long as the server does.
+	discoverMDNSTime = 3 * time.Second
+)
+
+// candidatePayload is a machine found by discovery.
+type candidatePayload struct {
+	IP        string   `json:"ip"`
+	Mac       string   `json:"mac"`
+	Hostname  string   `json:"hostname,omitempty"`
+	Vendor    string   `json:"vendor,omitempty"`
+	Broadcast string   `json:"broadcast"`
+	Sources   []string `json:"sources"`
+	Device    string   `json:"device,omitempty"` // Configured device with the same MAC address
+}
+
+func runDiscover(fs *flag.FlagSet, args []string) error {
+	subnets := fs.String("subnet", "", "Comma-separated subnets to scan (default: those of the server)")
+	mdns := fs.Bool("mdns", false, "Also listen for mDNS announcements, to find more hostnames")
+	add := fs.String("add", "", "Add the machine with this MAC or IP address to the configuration")
+	name := fs.String("name", "", "Device name for -add (default: its hostname)")
+	description := fs.String("description", "", "Device description for -add")
+	groups := fs.String("groups", "", "Comma-separated groups for -add")
+	fs.BoolVar(local, "local", *local, "Scan from this machine and edit the local config file")
+	fs.StringVar(configFile, "config", *configFile, "HomeGuard config file for -local (default: search $HOMEGUARD_CONFIG and XDG locations)")
+	if len(parseFlags(fs, args)) > 0 {
+		return usagef("discover takes no arguments")
+	}
+	if *add == "" && (*name != "" || *description != "" || *groups != "") {
+		return usagef("-name, -description and -groups require -add")
+	}
+	if *add != "" && !isAddress(*add) {
+		return usagef("-add takes a MAC or IP address: %s", *add)
+	}
+	if !explicit["timeout"] {
+		*timeout = discoverTimeout
+	}
+	checkOutput()
+
+	var candidates []candidatePayload
+	var c *client
+	var err error
+	if *local {
+		candidates, err = discoverLocal(*subnets, *mdns)
+	} else {
+		c = connect()
+		candidates, err = discoverRemote(c, *subnets, *mdns)
+	}
+	if err != nil {
+		return err
+	}
+	if *add == "" {
+		return printCandidates(candidates)
+	}
+
+	found, ok := findCandidate(candidates, *add)
+	if !ok {
+		return exitError{exitNotFound, fmt.Errorf("no machine with address %s was found", *add)}
+	}
+	if found.Device != "" {
+		return exitError{exitRejected, fmt.Errorf("%s is already configured as device %s", found.Mac, found.Device)}
+	}
+	dev := device.Device{
+		Name:        *name,
+		Mac:         found.Mac,
+		Broadcast:   found.Broadcast,
+		Description: *description,
+		Groups:      splitList(*groups),
+	}
+	if dev.Name == "" {
+		dev.Name = found.Hostname
+	}
+	if dev.Name == "" {
+		return usagef("%s has no known hostname, give a name with -name", found.Mac)
+	}
+
+	if *local {
+		return addLocalDevice(dev)
+	}
+	raw, err := c.post("/api/v1/devices", map[string]any{
+		"name":        dev.Name,
+		"mac":         dev.Mac,
+		"broadcast":   dev.Broadcast,
+		"description": dev.Description,
+		"groups":      dev.Groups,
+	})
+	if err != nil {
+		return err
+	}
+	if outputJSON() {
+		return printJSON(raw)
+	}
+	fmt.Printf("✓ Added device %s (%s, broadcast %s)\n", dev.Name, dev.Mac, dev.Broadcast)
+	return nil
+}
+
+// isAddress reports whether s is a MAC or IP address.
+func isAddress(s string) bool {
+	if _, err := net.ParseMAC(s); err == nil {
+		return true
+	}
+	_, err := netip.ParseAddr(s)
+	return err == nil
+}
+
+// findCandidate returns the candidate with the MAC or IP address addr.
+func findCandidate(candidates []candidatePayload, addr string) (candidatePayload, bool) {
+	mac, _ := net.ParseMAC(addr)
+	for _, c := range candidates {
+		if c.IP == addr || (mac != nil && c.Mac == mac.String()) {
+			return c, true
+		}
+	}
+	return candidatePayload{}, false
+}
+
+func discoverRemote(c *client, subnets string, mdns bool) ([]candidatePayload, error) {
+	body := map[string]any{"mdns": mdns}
+	if list := splitList(subnets); len(list) > 0 {
+		body["subnets"] = list
+	}
+	raw, err := c.post("/api/v1/discover", body)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []candidatePayload
+	if err := json.Unmarshal(raw, &candidates); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return candidates, nil
+}
+
+// discoverLocal scans from this machine. The lease and vendor files come
+// from the discovery section of the local config file, if there is one,
+// whose devices are marked as configured.
+func discoverLocal(subnets string, mdns bool) ([]candidatePayload, error) {
+	opts := discover.Options{}
+	for _, s := range splitList(subnets) {
+		prefix, err := discover.ParseSubnet(s)
+		if err != nil {
+			return nil, usagef("%v", err)
+		}
+		opts.Subnets = append(opts.Subnets, prefix)
+	}
+	if mdns {
+		opts.MDNS = discoverMDNSTime
+	}
+
+	configured := make(map[string]string)
+	if path, err := findConfig(); err == nil {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		var config struct {
+			Discovery discover.Config `yaml:"discovery"`
+		}
+		if err := configfile.Unmarshal(path, data, &config); err != nil {
+			return nil, exitError{exitRejected, fmt.Errorf("failed to parse %s: %w", path, err)}
+		}
+		opts.Config = config.Discovery
+		if devices, err := device.NewManager(path); err == nil {
+			for _, dev := range devices.ListDevices() {
+				if mac, err := net.ParseMAC(dev.Mac); err == nil {
+					configured[mac.String()] = dev.Name
+				}
+			}
+		}
+	}
+
+	found, err := discover.Run(context.Background(), opts)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]candidatePayload, 0, len(found))
+	for _, c := range found {
+		candidates = append(candidates, candidatePayload{
+			IP:        c.IP,
+			Mac:       c.Mac,
+			Hostname:  c.Hostname,
+			Vendor:    c.Vendor,
+			Broadcast: c.Broadcast,
+			Sources:   c.Sources,
+			Device:    configured[c.Mac],
+		})
+	}
+	return candidates, nil
+}
+
+// addLocalDevice adds dev to the local config file.
+func addLocalDevice(dev device.Device) error {
+	path, err := findConfig()
+	if err != nil {
+		return err
+	}
+	devices, err := device.NewManager(path)
+	if err != nil {
+		return exitError{exitRejected, fmt.Errorf("failed to load %s: %w", path, err)}
+	}
+	if err := devices.AddDevice(dev); err != nil {
+		if errors.Is(err, device.ErrExists) {
+			return exitError{exitRejected, err}
+		}
+		return err
+	}
+	if outputJSON() {
+		return printJSON(devicePayload{Name: dev.Name, Description: dev.Description, Mac: dev.Mac, Broadcast: dev.Broadcast, Groups: dev.Groups})
+	}
+	fmt.Printf("✓ Added device %s (%s, broadcast %s) to %s\n", dev.Name, dev.Mac, dev.Broadcast, path)
+	return nil
+}
+
+func printCandidates(candidates []candidatePayload) error {
+	if outputJSON() {
+		return printJSON(candidates)
+	}
+	return table(func(w io.Writer) {
+		fmt.Fprintln(w, "IP\tMAC\tHOSTNAME\tVENDOR\tDEVICE\tSOURCES")
+		for _, c := range candidates {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.IP, c.Mac, dash(c.Hostname), dash(c.Vendor),
+				dash(c.Device), strings.Join(c.Sources, ","))
+		}
+	})
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/p3ddd/HomeGuard/configfile"
+	"github.com/p3ddd/HomeGuard/device"
+	"github.com/p3ddd/HomeGuard/discover"
+	"github.com/p3ddd/HomeGuard/wol"
+)
+
+// configPaths returns the locations searched for the HomeGuard configuration
+// in local mode, in order: $HOMEGUARD_CONFIG, then homeguard/config.yaml in
+// $XDG_CONFIG_HOME and each of $XDG_CONFIG_DIRS. In each directory the
+// extensions are tried in the order of configfile.Extensions.
+func configPaths() []string {
+	var paths []string
+	if path := os.Getenv("HOMEGUARD_CONFIG"); path != "" {
+		paths = append(paths, path)
+	}
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			configHome = filepath.Join(home, ".config")
+		}
+	}
+	dirs := []string{configHome}
+
+	configDirs := os.Getenv("XDG_CONFIG_DIRS")
+	if configDirs == "" {
+		configDirs = "/etc/xdg"
+	}
+	for _, dir := range append(dirs, filepath.SplitList(configDirs)...) {
+		if dir == "" {
+			continue
+		}
+		for _, ext := range configfile.Extensions {
+			paths = append(paths, filepath.Join(dir, "homeguard", "config"+ext))
+		}
+	}
+	return paths
+}
+
+// findConfig returns the configuration file for local mode. -config wins
+// over the search path.
+func findConfig() (string, error) {
+	if *configFile != "" {
+		return *configFile, nil
+	}
+
+	paths := configPaths()
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("failed to read config file: %w", err)
+		}
+	}
+	return "", fmt.Errorf("no config file found, use -config or $HOMEGUARD_CONFIG (searched %s)", strings.Join(paths, ", "))
+}
+
+// wakeLocal sends the magic packets itself instead of asking the server. It
+// takes the same arguments as wakeDevices.
+func wakeLocal(group, mac, broadcast string, names []string) error {
+	checkOutput()
+	if err := checkWakeArgs(group, mac, broadcast, names); err != nil {
+		return err
+	}
+
+	if mac != "" {
+		if err := wol.WakeOnLan(mac, broadcast); err != nil {
+			return fmt.Errorf("failed to send magic packet to %s: %w", mac, err)
+		}
+		return printSent([]acceptedPayload{{Action: "wake", Mac: mac}})
+	}
+
+	path, err := findConfig()
+	if err != nil {
+		return err
+	}
+	devices, err := device.NewManager(path)
+	if err != nil {
+		return exitError{exitRejected, fmt.Errorf("failed to load %s: %w", path, err)}
+	}
+
+	// Devices configured by host get their MAC address from the neighbour
+	// table
+	if observations, err := discover.Observe(discover.Config{}); err == nil {
+		sightings := make([]device.Sighting, 0, len(observations))
+		for _, o := range observations {
+			sightings = append(sightings, device.Sighting{IP: o.IP, Mac: o.Mac, Broadcast: o.Broadcast})
+		}
+		devices.ObserveAddresses(context.Background(), sightings)
+	}
+
+	var targets []device.Device
+	if group != "" {
+		if targets, err = devices.GetGroup(group); err != nil {
+			return exitError{exitNotFound, err}
+		}
+	} else {
+		for _, name := range names {
+			dev, err := devices.GetDevice(name)
+			if err != nil {
+				return exitError{exitNotFound, err}
+			}
+			targets = append(targets, dev)
+		}
+	}
+
+	var sent []acceptedPayload
+	for _, dev := range targets {
+		if dev.Mac == "" {
+			return exitError{exitRejected, fmt.Errorf("MAC address of device %s is not known, it was not found at %s", dev.Name, dev.Host)}
+		}
+		if err := wol.WakeOnLan(dev.Mac, dev.Broadcast); err != nil {
+			return fmt.Errorf("failed to send magic packet to %s: %w", dev.Name, err)
+		}
+		sent = append(sent, acceptedPayload{Action: "wake", Device: dev.Name, Mac: dev.Mac})
+	}
+	return printSent(sent)
+}
+
+// printSent prints the packets sent in local mode.
+func printSent(sent []acceptedPayload) error {
+	if outputJSON() {
+		if len(sent) == 1 {
+			return printJSON(sent[0])
+		}
+		return printJSON(sent)
+	}
+
+	for _, s := range sent {
+		if s.Device != "" {
+			fmt.Printf("✓ Sent magic packet to device: %s (%s)\n", s.Device, s.Mac)
+		} else {
+			fmt.Printf("✓ Sent magic packet to MAC: %s\n", s.Mac)
+		}
+	}
+	return nil
+}
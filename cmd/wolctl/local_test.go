@@ -0,0 +1,195 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      []string          // Paths relative to the test directory
+		env        map[string]string // {dir} stands for the test directory
+		configFlag string
+		want       string
+		wantErr    string
+	}{
+		{
+			name:  "HOMEGUARD_CONFIG first",
+			files: []string{"custom.yaml", "home/homeguard/config.yaml"},
+			env:   map[string]string{"HOMEGUARD_CONFIG": "{dir}/custom.yaml", "XDG_CONFIG_HOME": "{dir}/home"},
+			want:  "custom.yaml",
+		},
+		{
+			name:  "missing HOMEGUARD_CONFIG falls through",
+			files: []string{"home/homeguard/config.yaml"},
+			env:   map[string]string{"HOMEGUARD_CONFIG": "{dir}/custom.yaml", "XDG_CONFIG_HOME": "{dir}/home"},
+			want:  "home/homeguard/config.yaml",
+		},
+		{
+			name:  "XDG_CONFIG_HOME before XDG_CONFIG_DIRS",
+			files: []string{"home/homeguard/config.json", "etc/homeguard/config.yaml"},
+			env:   map[string]string{"XDG_CONFIG_HOME": "{dir}/home", "XDG_CONFIG_DIRS": "{dir}/etc"},
+			want:  "home/homeguard/config.json",
+		},
+		{
+			name:  "extension order",
+			files: []string{"home/homeguard/config.toml", "home/homeguard/config.yml"},
+			env:   map[string]string{"XDG_CONFIG_HOME": "{dir}/home"},
+			want:  "home/homeguard/config.yml",
+		},
+		{
+			name:  "XDG_CONFIG_DIRS in order",
+			files: []string{"b/homeguard/config.yaml", "c/homeguard/config.toml"},
+			env:   map[string]string{"XDG_CONFIG_HOME": "{dir}/home", "XDG_CONFIG_DIRS": "{dir}/a:{dir}/c:{dir}/b"},
+			want:  "c/homeguard/config.toml",
+		},
+		{
+			name:       "-config wins",
+			files:      []string{"home/homeguard/config.yaml"},
+			env:        map[string]string{"HOMEGUARD_CONFIG": "{dir}/home/homeguard/config.yaml"},
+			configFlag: "{dir}/other.yaml",
+			want:       "other.yaml",
+		},
+		{
+			name:    "nothing found",
+			env:     map[string]string{"XDG_CONFIG_HOME": "{dir}/home", "XDG_CONFIG_DIRS": "{dir}/etc"},
+			wantErr: "no config file found, use -config or $HOMEGUARD_CONFIG (searched {dir}/home/homeguard/config.yaml, ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolate(t)
+			dir := t.TempDir()
+			expand := func(s string) string { return strings.ReplaceAll(s, "{dir}", dir) }
+			t.Setenv("HOMEGUARD_CONFIG", "")
+			t.Setenv("XDG_CONFIG_DIRS", expand("{dir}/none"))
+			for key, value := range tt.env {
+				t.Setenv(key, expand(value))
+			}
+			*configFile = expand(tt.configFlag)
+			for _, name := range tt.files {
+				writeFile(t, filepath.Join(dir, name), "")
+			}
+
+			got, err := findConfig()
+			wantErr := expand(tt.wantErr)
+			switch {
+			case err != nil && wantErr == "":
+				t.Fatal(err)
+			case wantErr != "" && (err == nil || !strings.Contains(err.Error(), wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, wantErr)
+			case err != nil:
+				return
+			}
+			if want := filepath.Join(dir, tt.want); got != want {
+				t.Errorf("findConfig() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestWakeLocal(t *testing.T) {
+	// The magic packets go to the loopback address, where nothing listens
+	// on the discard port.
+	const config = `devices:
+  - name: desktop
+    mac: "00:11:22:33:44:55"
+    broadcast: "127.0.0.1"
+    groups: ["office"]
+  - name: printer
+    mac: "00:11:22:33:44:66"
+    broadcast: "127.0.0.1"
+    groups: ["office"]
+  - name: nas
+    host: "192.0.2.10"
+    groups: ["storage"]
+`
+
+	tests := []struct {
+		name       string
+		group      string
+		mac        string
+		broadcast  string
+		devices    []string
+		json       bool
+		configFlag string // Default: a valid config file
+		wantOutput string
+		wantCode   int
+	}{
+		{
+			name:       "devices",
+			devices:    []string{"desktop", "printer"},
+			wantOutput: "✓ Sent magic packet to device: desktop (00:11:22:33:44:55)\n✓ Sent magic packet to device: printer (00:11:22:33:44:66)\n",
+		},
+		{
+			name:       "group",
+			group:      "office",
+			wantOutput: "✓ Sent magic packet to device: desktop (00:11:22:33:44:55)\n✓ Sent magic packet to device: printer (00:11:22:33:44:66)\n",
+		},
+		{
+			name:       "MAC without a config",
+			mac:        "00:11:22:33:44:77",
+			broadcast:  "127.0.0.1",
+			configFlag: "missing.yaml",
+			wantOutput: "✓ Sent magic packet to MAC: 00:11:22:33:44:77\n",
+		},
+		{
+			name:       "device as JSON",
+			devices:    []string{"desktop"},
+			json:       true,
+			wantOutput: "{\n  \"action\": \"wake\",\n  \"device\": \"desktop\",\n  \"mac\": \"00:11:22:33:44:55\"\n}\n",
+		},
+		{
+			name:     "unknown device",
+			devices:  []string{"desktop", "laptop"},
+			wantCode: exitNotFound,
+		},
+		{
+			name:     "unknown group",
+			group:    "lab",
+			wantCode: exitNotFound,
+		},
+		{
+			name:     "MAC address not seen yet",
+			group:    "storage",
+			wantCode: exitRejected,
+		},
+		{
+			name:       "invalid config",
+			devices:    []string{"desktop"},
+			configFlag: "invalid.yaml",
+			wantCode:   exitRejected,
+		},
+		{
+			name:     "no arguments",
+			wantCode: exitUsage,
+		},
+	}
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "config.yaml"), config)
+	writeFile(t, filepath.Join(dir, "invalid.yaml"), "devices: [\n")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolate(t)
+			*configFile = filepath.Join(dir, "config.yaml")
+			if tt.configFlag != "" {
+				*configFile = filepath.Join(dir, tt.configFlag)
+			}
+			if tt.json {
+				*output = "json"
+			}
+
+			out, err := captureStdout(t, func() error {
+				return wakeLocal(tt.group, tt.mac, tt.broadcast, tt.devices)
+			})
+			if code := exitCode(err); code != tt.wantCode {
+				t.Fatalf("exit code = %d, want %d: %v", code, tt.wantCode, err)
+			}
+			if out != tt.wantOutput {
+				t.Errorf("output = %q, want %q", out, tt.wantOutput)
+			}
+		})
+	}
+}
@@ -1,13 +1,11 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
+	"time"
 )
 
 const (
@@ -15,96 +13,220 @@ const (
 )
 
 var (
-	serverURL = flag.String("server", "http://localhost:7092", "HomeGuard server URL")
-	device    = flag.String("device", "", "Device name to wake up")
-	mac       = flag.String("mac", "", "MAC address to wake up")
-	broadcast = flag.String("broadcast", "", "Broadcast address")
+	serverURL = flag.String("server", "http://localhost:7092", "HomeGuard server URL, or unix:///path/to/socket")
+	token     = flag.String("token", os.Getenv("HOMEGUARD_TOKEN"), "API token (default: $HOMEGUARD_TOKEN)")
+	output    = flag.String("o", "table", "Output format: table or json")
+	timeout   = flag.Duration("timeout", 10*time.Second, "Request timeout")
 	showVer   = flag.Bool("version", false, "Show version information")
-)
 
-type WakeUpRequest struct {
-	Device    string `json:"device,omitempty"`
-	Mac       string `json:"mac,omitempty"`
-	Broadcast string `json:"broadcast,omitempty"`
-}
+	// profileName selects a profile of the wolctl config file
+	profileName = flag.String("profile", "", "Connection profile (default: $WOLCTL_PROFILE or the current profile)")
 
-func main() {
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "wolctl - HomeGuard Wake-on-LAN Client Tool\n\n")
-		fmt.Fprintf(os.Stderr, "Usage:\n")
-		fmt.Fprintf(os.Stderr, "  wolctl -device <name>                    Wake up device by name\n")
-		fmt.Fprintf(os.Stderr, "  wolctl -mac <MAC> -broadcast <addr>      Wake up device by MAC address\n\n")
-		fmt.Fprintf(os.Stderr, "Options:\n")
-		flag.PrintDefaults()
-		fmt.Fprintf(os.Stderr, "\nExamples:\n")
-		fmt.Fprintf(os.Stderr, "  wolctl -device desktop\n")
-		fmt.Fprintf(os.Stderr, "  wolctl -mac 00:11:22:33:44:55 -broadcast 192.168.1.255\n")
-		fmt.Fprintf(os.Stderr, "  wolctl -server http://192.168.1.100:7092 -device laptop\n")
-	}
+	// Local mode sends magic packets and scans the network without the server
+	local      = flag.Bool("local", false, "Work from this machine instead of the server (wake and discover only)")
+	configFile = flag.String("config", "", "HomeGuard config file for -local (default: search $HOMEGUARD_CONFIG and XDG locations)")
 
+	// Flags of the original single-command form, kept for compatibility
+	deviceName = flag.String("device", "", "Device name to wake up")
+	mac        = flag.String("mac", "", "MAC address to wake up")
+	broadcast  = flag.String("broadcast", "", "Broadcast address")
+	shutdown   = flag.Bool("shutdown", false, "Shut down the device instead of waking it (requires -device)")
+)
+
+func main() {
+	flag.Usage = usage
 	flag.Parse()
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
 
 	if *showVer {
 		fmt.Printf("wolctl version %s\n", version)
-		os.Exit(0)
+		os.Exit(exitOK)
 	}
 
-	// Validate input
-	if *device == "" && (*mac == "" || *broadcast == "") {
-		fmt.Fprintf(os.Stderr, "Error: Must specify either -device or both -mac and -broadcast\n\n")
+	if err := run(flag.Args()); err != nil {
+		fail(err)
+	}
+}
+
+// fail prints err and exits with its exit code.
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	if errors.As(err, &usageError{}) {
+		fmt.Fprintf(os.Stderr, "Run 'wolctl -h' for usage.\n")
+	}
+	os.Exit(exitCode(err))
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		// wolctl -device <name>, wolctl -mac <MAC> -broadcast <addr> and
+		// wolctl -shutdown -device <name>
+		switch {
+		case *local && *shutdown:
+			return usagef("-shutdown is not available with -local")
+		case *local && (*deviceName != "" || *mac != ""):
+			return wakeLocal("", *mac, *broadcast, nonEmpty(*deviceName))
+		case *shutdown:
+			if *deviceName == "" {
+				return usagef("-shutdown requires -device")
+			}
+			return shutdownDevices(connect(), []string{*deviceName})
+		case *deviceName != "":
+			return wakeDevices(connect(), "", "", "", []string{*deviceName})
+		case *mac != "":
+			return wakeDevices(connect(), "", *mac, *broadcast, nil)
+		}
 		flag.Usage()
-		os.Exit(1)
+		os.Exit(exitUsage)
+	}
+
+	if args[0] == completeCommand {
+		return runComplete(args[1:])
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		return usagef("unknown command: %s", args[0])
+	}
+	if *local && cmd.name != "wake" && cmd.name != "discover" {
+		return usagef("-local only works with wake and discover")
 	}
 
-	// Prepare request
-	req := WakeUpRequest{
-		Device:    *device,
-		Mac:       *mac,
-		Broadcast: *broadcast,
+	fs := flag.NewFlagSet("wolctl "+cmd.name, flag.ExitOnError)
+	fs.StringVar(serverURL, "server", *serverURL, "HomeGuard server URL, or unix:///path/to/socket")
+	fs.StringVar(token, "token", *token, "API token")
+	fs.StringVar(output, "o", *output, "Output format: table or json")
+	fs.DurationVar(timeout, "timeout", *timeout, "Request timeout")
+	fs.StringVar(profileName, "profile", *profileName, "Connection profile")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s\n\nUsage:\n  wolctl %s %s\n\nOptions:\n", cmd.help, cmd.name, cmd.args)
+		fs.PrintDefaults()
 	}
+	return cmd.run(fs, args[1:])
+}
 
-	// Send request
-	if err := sendWakeUpRequest(*serverURL, req); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+// nonEmpty returns s as a slice, or nil if it is empty.
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
 	}
+	return []string{s}
+}
 
-	if *device != "" {
-		fmt.Printf("✓ Successfully sent wake-up request for device: %s\n", *device)
-	} else {
-		fmt.Printf("✓ Successfully sent wake-up request for MAC: %s\n", *mac)
+// explicit records the flags given on the command line, which take
+// precedence over the active profile.
+var explicit = make(map[string]bool)
+
+// parseFlags parses the flags of a command, which may appear between its
+// arguments, and records the ones given. It returns the arguments.
+func parseFlags(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		_ = fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
 	}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	return positional
 }
 
-func sendWakeUpRequest(serverURL string, req WakeUpRequest) error {
-	// Marshal request to JSON
-	jsonData, err := json.Marshal(req)
+// connect returns a client for the server given on the command line or by
+// the active profile. It exits on error.
+func connect() *client {
+	checkOutput()
+	c, err := dial()
 	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
+		fail(err)
 	}
+	return c
+}
 
-	// Create HTTP request
-	url := serverURL + "/wakeup"
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+// dial returns a client for the server given on the command line or by the
+// active profile. $HOMEGUARD_TOKEN also wins over the profile's token.
+func dial() (*client, error) {
+	server, apiToken := *serverURL, *token
+	p, err := activeProfile()
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, err
+	}
+	if p == nil {
+		return newClient(server, apiToken, *timeout, nil), nil
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
+	if !explicit["server"] {
+		server = p.Server
+	}
+	if !explicit["token"] && os.Getenv("HOMEGUARD_TOKEN") == "" {
+		apiToken = p.Token
+	}
+	tlsConfig, err := p.TLS.load()
+	if err != nil {
+		return nil, err
+	}
+	return newClient(server, apiToken, *timeout, tlsConfig), nil
+}
 
-	// Send request
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
+// withDefaultDevice returns args, or the default device of the active
+// profile if args is empty.
+func withDefaultDevice(args []string) []string {
+	if len(args) > 0 {
+		return args
+	}
+	p, err := activeProfile()
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		fail(err)
 	}
-	defer func() { _ = resp.Body.Close() }()
+	if p != nil && p.Device != "" {
+		return []string{p.Device}
+	}
+	return nil
+}
 
-	// Check response
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("server returned error (status %d): %s", resp.StatusCode, string(body))
+// checkOutput exits on an invalid output format, which all commands share.
+func checkOutput() {
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "Error: unknown output format: %s\n", *output)
+		os.Exit(exitUsage)
 	}
+}
 
-	return nil
+func usage() {
+	fmt.Fprintf(os.Stderr, "wolctl - HomeGuard Wake-on-LAN Client Tool\n\n")
+	fmt.Fprintf(os.Stderr, "Usage:\n")
+	fmt.Fprintf(os.Stderr, "  wolctl [options] <command> [arguments]\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.help)
+	}
+	fmt.Fprintf(os.Stderr, "\nOptions:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nExit codes:\n")
+	fmt.Fprintf(os.Stderr, "  0  Success\n")
+	fmt.Fprintf(os.Stderr, "  1  Other error\n")
+	fmt.Fprintf(os.Stderr, "  2  Invalid command line\n")
+	fmt.Fprintf(os.Stderr, "  3  Server unreachable, timed out or unavailable\n")
+	fmt.Fprintf(os.Stderr, "  4  Missing or invalid token\n")
+	fmt.Fprintf(os.Stderr, "  5  Device, group or endpoint not found\n")
+	fmt.Fprintf(os.Stderr, "  6  Request or configuration rejected\n")
+	fmt.Fprintf(os.Stderr, "\nExamples:\n")
+	fmt.Fprintf(os.Stderr, "  wolctl wake desktop\n")
+	fmt.Fprintf(os.Stderr, "  wolctl wake -group office\n")
+	fmt.Fprintf(os.Stderr, "  wolctl wake -mac 00:11:22:33:44:55 -broadcast 192.168.1.255\n")
+	fmt.Fprintf(os.Stderr, "  wolctl shutdown desktop\n")
+	fmt.Fprintf(os.Stderr, "  wolctl list -o json\n")
+	fmt.Fprintf(os.Stderr, "  wolctl status desktop\n")
+	fmt.Fprintf(os.Stderr, "  wolctl config validate config.yaml\n")
+	fmt.Fprintf(os.Stderr, "  wolctl -server http://192.168.1.100:7092 history\n")
+	fmt.Fprintf(os.Stderr, "  wolctl queue\n")
+	fmt.Fprintf(os.Stderr, "  wolctl -server unix:///run/homeguard.sock wake desktop\n")
+	fmt.Fprintf(os.Stderr, "  wolctl -local -device desktop\n")
+	fmt.Fprintf(os.Stderr, "  wolctl discover -subnet 192.168.1.0/24 -mdns\n")
+	fmt.Fprintf(os.Stderr, "  wolctl discover -add 00:11:22:33:44:55 -name nas\n")
+	fmt.Fprintf(os.Stderr, "  wolctl profile add home -server http://192.168.1.100:7092 -token <token> -device desktop\n")
+	fmt.Fprintf(os.Stderr, "  wolctl -profile office list\n")
+	fmt.Fprintf(os.Stderr, "\nThe original form 'wolctl -device <name>' (with -mac, -broadcast and\n")
+	fmt.Fprintf(os.Stderr, "-shutdown) still works.\n")
 }
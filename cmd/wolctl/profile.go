@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// clientConfig is the wolctl configuration file.
+type clientConfig struct {
+	Current  string              `yaml:"current,omitempty"` // Profile used when none is selected
+	Profiles map[string]*profile `yaml:"profiles"`
+}
+
+// profile holds the connection settings for one HomeGuard server.
+type profile struct {
+	Server string     `yaml:"server"`
+	Token  string     `yaml:"token,omitempty"`
+	Device string     `yaml:"device,omitempty"` // Default device for wake, shutdown and status
+	TLS    *tlsConfig `yaml:"tls,omitempty"`
+}
+
+// tlsConfig configures HTTPS connections to the server.
+type tlsConfig struct {
+	CAFile             string `yaml:"ca_file,omitempty"`              // PEM CA bundle used to verify the server (default: system roots)
+	CertFile           string `yaml:"cert_file,omitempty"`            // PEM client certificate
+	KeyFile            string `yaml:"key_file,omitempty"`             // PEM client private key
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"` // Do not verify the server certificate
+	ServerName         string `yaml:"server_name,omitempty"`          // Verification name (default: server host)
+}
+
+// load builds a tls.Config from c. It returns nil for a nil c.
+func (c *tlsConfig) load() (*tls.Config, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify, //nolint:gosec // Explicitly requested by configuration
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if c.CAFile != "" {
+		data, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in CA file: %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, fmt.Errorf("client certificate requires both cert_file and key_file")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// clientConfigPath returns the path of the wolctl configuration file,
+// wolctl/config.yaml in $XDG_CONFIG_HOME (default ~/.config).
+func clientConfigPath() (string, error) {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to find home directory: %w", err)
+		}
+		configHome = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configHome, "wolctl", "config.yaml"), nil
+}
+
+// loadClientConfig reads the wolctl configuration file. A missing file is
+// an empty configuration.
+func loadClientConfig() (*clientConfig, string, error) {
+	path, err := clientConfigPath()
+	if err != nil {
+		return nil, "", err
+	}
+
+	config := &clientConfig{Profiles: make(map[string]*profile)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, path, nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, "", fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]*profile)
+	}
+	return config, path, nil
+}
+
+// save writes the configuration to path. It holds tokens, so only the
+// owner may read it.
+func (c *clientConfig) save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
+
+// activeProfile returns the profile selected by -profile, $WOLCTL_PROFILE or
+// the configuration file, or nil if none is selected.
+func activeProfile() (*profile, error) {
+	name := *profileName
+	if name == "" {
+		name = os.Getenv("WOLCTL_PROFILE")
+	}
+
+	config, path, err := loadClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = config.Current
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	p, ok := config.Profiles[name]
+	if !ok {
+		return nil, usagef("unknown profile %q in %s", name, path)
+	}
+	return p, nil
+}
+
+func runProfile(fs *flag.FlagSet, args []string) error {
+	device := fs.String("device", "", "Default device (profile add)")
+	caFile := fs.String("ca-file", "", "CA bundle for verifying an HTTPS server (profile add)")
+	certFile := fs.String("cert-file", "", "Client certificate (profile add)")
+	keyFile := fs.String("key-file", "", "Client private key (profile add)")
+	insecure := fs.Bool("insecure-skip-verify", false, "Do not verify the server certificate (profile add)")
+	serverName := fs.String("server-name", "", "TLS server name of the server (profile add)")
+	args = parseFlags(fs, args)
+
+	var action string
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+
+	config, path, err := loadClientConfig()
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "add":
+		if len(args) != 1 {
+			return usagef("usage: wolctl profile add <name> -server <url> [options]")
+		}
+		if !explicit["server"] {
+			return usagef("profile add requires -server")
+		}
+		p := &profile{Server: *serverURL, Token: *token, Device: *device}
+		if !explicit["token"] {
+			p.Token = ""
+		}
+		tlsOpts := &tlsConfig{
+			CAFile:             *caFile,
+			CertFile:           *certFile,
+			KeyFile:            *keyFile,
+			InsecureSkipVerify: *insecure,
+			ServerName:         *serverName,
+		}
+		if *tlsOpts != (tlsConfig{}) {
+			if _, err := tlsOpts.load(); err != nil {
+				return err
+			}
+			p.TLS = tlsOpts
+		}
+
+		config.Profiles[args[0]] = p
+		if config.Current == "" {
+			config.Current = args[0]
+		}
+		if err := config.save(path); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Saved profile %s to %s\n", args[0], path)
+		return nil
+
+	case "use":
+		if len(args) != 1 {
+			return usagef("usage: wolctl profile use <name>")
+		}
+		if _, ok := config.Profiles[args[0]]; !ok {
+			return usagef("unknown profile: %s", args[0])
+		}
+		config.Current = args[0]
+		if err := config.save(path); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Using profile %s\n", args[0])
+		return nil
+
+	case "remove":
+		if len(args) != 1 {
+			return usagef("usage: wolctl profile remove <name>")
+		}
+		if _, ok := config.Profiles[args[0]]; !ok {
+			return usagef("unknown profile: %s", args[0])
+		}
+		delete(config.Profiles, args[0])
+		if config.Current == args[0] {
+			config.Current = ""
+		}
+		if err := config.save(path); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Removed profile %s\n", args[0])
+		return nil
+
+	case "list":
+		if len(args) > 0 {
+			return usagef("profile list takes no arguments")
+		}
+		checkOutput()
+		return printProfiles(config)
+	}
+	return usagef("usage: wolctl profile add|list|use|remove")
+}
+
+// printProfiles lists the profiles, marking the current one. Tokens are not
+// shown.
+func printProfiles(config *clientConfig) error {
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	if outputJSON() {
+		type profileInfo struct {
+			Name     string `json:"name"`
+			Server   string `json:"server"`
+			Device   string `json:"device,omitempty"`
+			HasToken bool   `json:"has_token"`
+			Current  bool   `json:"current"`
+		}
+		list := make([]profileInfo, 0, len(names))
+		for _, name := range names {
+			p := config.Profiles[name]
+			list = append(list, profileInfo{name, p.Server, p.Device, p.Token != "", name == config.Current})
+		}
+		return printJSON(list)
+	}
+
+	return table(func(w io.Writer) {
+		fmt.Fprintln(w, "CURRENT\tNAME\tSERVER\tDEVICE\tTOKEN")
+		for _, name := range names {
+			p := config.Profiles[name]
+			current, hasToken := "", "no"
+			if name == config.Current {
+				current = "*"
+			}
+			if p.Token != "" {
+				hasToken = "yes"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", current, name, p.Server, dash(p.Device), hasToken)
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestActiveProfile(t *testing.T) {
+	const config = `current: home
+profiles:
+  home:
+    server: http://home.lan:7092
+  work:
+    server: https://work.example.com
+    token: secret
+`
+
+	tests := []struct {
+		name     string
+		config   string
+		flag     string
+		env      string
+		want     string // Server of the active profile
+		wantCode int
+	}{
+		{name: "no config file"},
+		{name: "no current profile", config: "profiles:\n  home:\n    server: http://home.lan:7092\n"},
+		{name: "current", config: config, want: "http://home.lan:7092"},
+		{name: "WOLCTL_PROFILE over current", config: config, env: "work", want: "https://work.example.com"},
+		{name: "-profile over WOLCTL_PROFILE", config: config, flag: "home", env: "work", want: "http://home.lan:7092"},
+		{name: "-profile without a config file", flag: "home", wantCode: exitUsage},
+		{name: "unknown WOLCTL_PROFILE", config: config, env: "lab", wantCode: exitUsage},
+		{name: "invalid config file", config: "profiles: [\n", wantCode: exitFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolate(t)
+			configHome := t.TempDir()
+			t.Setenv("XDG_CONFIG_HOME", configHome)
+			t.Setenv("WOLCTL_PROFILE", tt.env)
+			*profileName = tt.flag
+			if tt.config != "" {
+				writeFile(t, filepath.Join(configHome, "wolctl", "config.yaml"), tt.config)
+			}
+
+			p, err := activeProfile()
+			if code := exitCode(err); code != tt.wantCode {
+				t.Fatalf("exit code = %d, want %d: %v", code, tt.wantCode, err)
+			}
+			var got string
+			if p != nil {
+				got = p.Server
+			}
+			if got != tt.want {
+				t.Errorf("server = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfileCommands(t *testing.T) {
+	isolate(t)
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	path := filepath.Join(configHome, "wolctl", "config.yaml")
+
+	steps := []struct {
+		args        []string
+		wantCode    int
+		wantOutput  string
+		wantCurrent string
+	}{
+		{args: []string{"profile", "add", "home"}, wantCode: exitUsage},
+		{
+			args:        []string{"profile", "add", "home", "-server", "http://home.lan:7092", "-token", "secret", "-device", "desktop"},
+			wantOutput:  "✓ Saved profile home to " + path + "\n",
+			wantCurrent: "home",
+		},
+		{
+			args:        []string{"profile", "add", "work", "-server", "https://work.example.com"},
+			wantCurrent: "home",
+		},
+		{
+			args:        []string{"profile", "list"},
+			wantOutput:  "CURRENT  NAME  SERVER                    DEVICE   TOKEN\n*        home  http://home.lan:7092      desktop  yes\n         work  https://work.example.com  -        no\n",
+			wantCurrent: "home",
+		},
+		{args: []string{"profile", "use", "lab"}, wantCode: exitUsage, wantCurrent: "home"},
+		{args: []string{"profile", "use", "work"}, wantOutput: "✓ Using profile work\n", wantCurrent: "work"},
+		{args: []string{"profile", "remove", "home"}, wantOutput: "✓ Removed profile home\n", wantCurrent: "work"},
+		{args: []string{"profile", "remove", "work"}, wantOutput: "✓ Removed profile work\n"},
+		{args: []string{"profile", "remove", "work"}, wantCode: exitUsage},
+		{args: []string{"profile", "rename", "work"}, wantCode: exitUsage},
+	}
+	for _, step := range steps {
+		name := strings.Join(step.args, " ")
+		// The flags of an earlier step must not leak into the next one
+		*serverURL, *token = "http://localhost:7092", ""
+		explicit = make(map[string]bool)
+
+		out, err := captureStdout(t, func() error { return run(step.args) })
+		if code := exitCode(err); code != step.wantCode {
+			t.Fatalf("%s: exit code = %d, want %d: %v", name, code, step.wantCode, err)
+		}
+		if step.wantOutput != "" && out != step.wantOutput {
+			t.Errorf("%s: output = %q, want %q", name, out, step.wantOutput)
+		}
+
+		config, _, err := loadClientConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Current != step.wantCurrent {
+			t.Errorf("%s: current = %q, want %q", name, config.Current, step.wantCurrent)
+		}
+	}
+
+	config, _, err := loadClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Profiles) != 0 {
+		t.Errorf("profiles after removing all = %v", config.Profiles)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("config file mode = %o, want 600", mode)
+	}
+	info, err = os.Stat(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o700 {
+		t.Errorf("config directory mode = %o, want 700", mode)
+	}
+}
+
+func TestProfileSavesToken(t *testing.T) {
+	isolate(t)
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+
+	args := []string{"profile", "add", "home", "-server", "http://home.lan:7092", "-token", "secret"}
+	if _, err := captureStdout(t, func() error { return run(args) }); err != nil {
+		t.Fatal(err)
+	}
+	p, err := activeProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p == nil || p.Server != "http://home.lan:7092" || p.Token != "secret" {
+		t.Errorf("profile = %+v, want the saved server and token", p)
+	}
+}
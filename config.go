@@ -0,0 +1,355 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/p3ddd/HomeGuard/configfile"
+	"github.com/p3ddd/HomeGuard/device"
+	"github.com/p3ddd/HomeGuard/discover"
+	"github.com/p3ddd/HomeGuard/listener"
+)
+
+// FileConfig represents the server, discovery, queue and log sections of the
+// configuration file. Devices are loaded separately by device.NewManager.
+type FileConfig struct {
+	Server struct {
+		HTTP struct {
+			Enabled bool                     `yaml:"enabled"`
+			Addr    string                   `yaml:"addr"`
+			Tokens  []string                 `yaml:"tokens"`
+			Hooks   []listener.WebhookConfig `yaml:"hooks"`
+		} `yaml:"http"`
+		Unix struct {
+			Enabled                   bool `yaml:"enabled"`
+			listener.UnixSocketConfig `yaml:",inline"`
+		} `yaml:"unix"`
+		MQTT   mqttBrokers `yaml:"mqtt"`
+		Gotify struct {
+			Enabled               bool `yaml:"enabled"`
+			listener.GotifyConfig `yaml:",inline"`
+		} `yaml:"gotify"`
+		Telegram struct {
+			Enabled                 bool `yaml:"enabled"`
+			listener.TelegramConfig `yaml:",inline"`
+		} `yaml:"telegram"`
+	} `yaml:"server"`
+	Discovery discover.Config `yaml:"discovery"`
+	Queue     struct {
+		Workers int `yaml:"workers"`
+		Size    int `yaml:"size"`
+	} `yaml:"queue"`
+	Log struct {
+		Level string `yaml:"level"`
+	} `yaml:"log"`
+}
+
+// mqttBrokerConfig is one entry of the server.mqtt section.
+type mqttBrokerConfig struct {
+	Enabled             bool `yaml:"enabled"`
+	listener.MQTTConfig `yaml:",inline"`
+}
+
+// mqttBrokers accepts either a single broker mapping or a list of brokers.
+type mqttBrokers []mqttBrokerConfig
+
+// UnmarshalYAML decodes each broker over its current value, so settings
+// missing from the file are kept. Brokers given as a list are enabled
+// unless they say otherwise.
+func (b *mqttBrokers) UnmarshalYAML(node *yaml.Node) error {
+	nodes := []*yaml.Node{node}
+	if node.Kind == yaml.SequenceNode {
+		nodes = node.Content
+	}
+
+	brokers := make(mqttBrokers, len(nodes))
+	for i, n := range nodes {
+		if i < len(*b) {
+			brokers[i] = (*b)[i]
+			if node.Kind == yaml.SequenceNode {
+				brokers[i].Enabled = true
+			}
+		} else {
+			brokers[i] = mqttBrokerConfig{Enabled: true, MQTTConfig: listener.MQTTConfig{QoS: 1}}
+		}
+		if err := n.Decode(&brokers[i]); err != nil {
+			return err
+		}
+	}
+	*b = brokers
+	return nil
+}
+
+// explicit records the flags given on the command line, which take
+// precedence over the configuration file.
+var explicit = make(map[string]bool)
+
+// fromConfig sets the flag value p to value from the configuration file
+// unless the flag was given on the command line.
+func fromConfig[T any](name string, p *T, value T) {
+	if !explicit[name] {
+		*p = value
+	}
+}
+
+// applyConfigFile sets the flags not given on the command line to the
+// settings found in the configuration file. Settings missing from the file
+// keep their current value. The first MQTT broker is mapped onto the -mqtt-*
+// flags; any further enabled brokers are returned. A missing file is not an
+// error.
+func applyConfigFile(path string) ([]listener.MQTTConfig, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var config FileConfig
+	config.Server.HTTP.Enabled = *httpAddr != ""
+	config.Server.HTTP.Addr = *httpAddr
+	config.Server.HTTP.Tokens = apiTokensFromFlags()
+	config.Server.Unix.Enabled = *unixSocket != ""
+	config.Server.Unix.UnixSocketConfig = unixSocketConfig
+	config.Server.Unix.Path = *unixSocket
+	config.Server.MQTT = mqttBrokers{{Enabled: *mqttBroker != "", MQTTConfig: mqttConfigFromFlags()}}
+	config.Server.Gotify.Enabled = *gotifyURL != ""
+	config.Server.Gotify.GotifyConfig = gotifyConfigFromFlags()
+	config.Server.Telegram.Enabled = *telegramToken != ""
+	config.Server.Telegram.TelegramConfig, _ = telegramConfigFromFlags()
+	config.Queue.Workers = *workers
+	config.Queue.Size = *queueSize
+	config.Log.Level = *logLevel
+
+	if err := configfile.Unmarshal(path, data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	addr := config.Server.HTTP.Addr
+	if !config.Server.HTTP.Enabled {
+		addr = ""
+	}
+	fromConfig("http", httpAddr, addr)
+	fromConfig("api-tokens", apiTokens, strings.Join(config.Server.HTTP.Tokens, ","))
+	webhooks = config.Server.HTTP.Hooks
+	unixSocketConfig = config.Server.Unix.UnixSocketConfig
+	discoveryConfig = config.Discovery
+	socket := unixSocketConfig.Path
+	if !config.Server.Unix.Enabled {
+		socket = ""
+	} else if socket == "" {
+		socket = listener.DefaultUnixSocketPath
+	}
+	fromConfig("unix-socket", unixSocket, socket)
+
+	if len(config.Server.MQTT) == 0 {
+		config.Server.MQTT = mqttBrokers{{}}
+	}
+
+	var extra []listener.MQTTConfig
+	for _, broker := range config.Server.MQTT[1:] {
+		if broker.Enabled && broker.Broker != "" {
+			extra = append(extra, broker.MQTTConfig)
+		}
+	}
+
+	mqtt := config.Server.MQTT[0].MQTTConfig
+	broker := mqtt.Broker
+	if !config.Server.MQTT[0].Enabled {
+		broker = ""
+	}
+	fromConfig("mqtt-broker", mqttBroker, broker)
+	mqttSubscriptions = mqtt.Subscriptions
+	fromConfig("mqtt-topic", mqttTopic, mqtt.Topic)
+	fromConfig("mqtt-client-id", mqttClientID, mqtt.ClientID)
+	fromConfig("mqtt-username", mqttUsername, mqtt.Username)
+	fromConfig("mqtt-password", mqttPassword, mqtt.Password)
+	fromConfig("mqtt-qos", mqttQoS, uint(mqtt.QoS))
+	fromConfig("mqtt-ca-file", mqttCAFile, mqtt.TLS.CAFile)
+	fromConfig("mqtt-cert-file", mqttCertFile, mqtt.TLS.CertFile)
+	fromConfig("mqtt-key-file", mqttKeyFile, mqtt.TLS.KeyFile)
+	fromConfig("mqtt-insecure-skip-verify", mqttInsecure, mqtt.TLS.InsecureSkipVerify)
+	fromConfig("mqtt-server-name", mqttSNI, mqtt.TLS.ServerName)
+	fromConfig("mqtt-response-topic", mqttResponse, mqtt.ResponseTopic)
+	fromConfig("mqtt-state", mqttState, mqtt.PublishState)
+	fromConfig("mqtt-discovery", mqttHA, mqtt.Discovery)
+	fromConfig("mqtt-discovery-prefix", mqttHAPrefix, mqtt.DiscoveryPrefix)
+	fromConfig("mqtt-base-topic", mqttBase, mqtt.BaseTopic)
+	gotify := config.Server.Gotify.GotifyConfig
+	url := gotify.URL
+	if !config.Server.Gotify.Enabled {
+		url = ""
+	}
+	fromConfig("gotify-url", gotifyURL, url)
+	fromConfig("gotify-token", gotifyToken, gotify.Token)
+	fromConfig("gotify-pattern", gotifyPattern, gotify.Pattern)
+	telegram := config.Server.Telegram.TelegramConfig
+	token := telegram.Token
+	if !config.Server.Telegram.Enabled {
+		token = ""
+	}
+	fromConfig("telegram-token", telegramToken, token)
+	fromConfig("telegram-api-url", telegramAPIURL, telegram.APIURL)
+	chats := make([]string, 0, len(telegram.AllowedChats))
+	for _, id := range telegram.AllowedChats {
+		chats = append(chats, strconv.FormatInt(id, 10))
+	}
+	fromConfig("telegram-allowed-chats", telegramChats, strings.Join(chats, ","))
+	fromConfig("workers", workers, config.Queue.Workers)
+	fromConfig("queue-size", queueSize, config.Queue.Size)
+	fromConfig("log-level", logLevel, config.Log.Level)
+
+	return extra, nil
+}
+
+// validateConfig checks a configuration file in the given configfile format
+// the way the server loads it, without applying it. An empty format is that
+// of the server's configuration file. Its includes are resolved relative to
+// the server's configuration file.
+func validateConfig(format string, data []byte) error {
+	path := *configPath
+	if format != "" && format != configfile.FormatOf(path) {
+		path = strings.TrimSuffix(path, filepath.Ext(path)) + "." + format
+	}
+
+	var config FileConfig
+	if err := configfile.Unmarshal(path, data, &config); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if err := device.Validate(path, data); err != nil {
+		return err
+	}
+
+	for _, hook := range config.Server.HTTP.Hooks {
+		if err := hook.Validate(); err != nil {
+			return fmt.Errorf("invalid webhook: %w", err)
+		}
+	}
+	for _, broker := range config.Server.MQTT {
+		if _, err := broker.TLS.Load(); err != nil {
+			return fmt.Errorf("invalid MQTT TLS config: %w", err)
+		}
+		for _, sub := range broker.Subscriptions {
+			if err := sub.Validate(); err != nil {
+				return fmt.Errorf("invalid MQTT subscription: %w", err)
+			}
+		}
+	}
+	if config.Server.Gotify.Enabled {
+		if _, err := listener.NewGotifyListener(config.Server.Gotify.GotifyConfig); err != nil {
+			return err
+		}
+	}
+	if mode := config.Server.Unix.Mode; mode != "" {
+		if _, err := strconv.ParseUint(mode, 8, 32); err != nil {
+			return fmt.Errorf("invalid socket mode: %s", mode)
+		}
+	}
+	if config.Queue.Workers < 0 || config.Queue.Size < 0 {
+		return errors.New("queue workers and size cannot be negative")
+	}
+	switch config.Log.Level {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("unknown log level: %q", config.Log.Level)
+	}
+	return nil
+}
+
+// configSchema is the whole configuration file, described by -print-schema.
+type configSchema struct {
+	FileConfig    `yaml:",inline"`
+	device.Config `yaml:",inline"`
+}
+
+// printSchema writes the JSON Schema of the configuration file to stdout.
+func printSchema() error {
+	schema, err := configfile.Schema("HomeGuard configuration", configSchema{})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Printf("%s\n", schema)
+	return err
+}
+
+// checkConfig validates the configuration file at path for -check-config.
+// Unlike the server, it treats a missing file as an error.
+func checkConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	return validateConfig(configfile.FormatOf(path), data)
+}
+
+func apiTokensFromFlags() []string {
+	var tokens []string
+	for _, token := range strings.Split(*apiTokens, ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+func mqttConfigFromFlags() listener.MQTTConfig {
+	return listener.MQTTConfig{
+		Broker:   *mqttBroker,
+		ClientID: *mqttClientID,
+		Topic:    *mqttTopic,
+		QoS:      byte(*mqttQoS),
+		Username: *mqttUsername,
+		Password: *mqttPassword,
+
+		Subscriptions: mqttSubscriptions,
+
+		TLS: listener.MQTTTLSConfig{
+			CAFile:             *mqttCAFile,
+			CertFile:           *mqttCertFile,
+			KeyFile:            *mqttKeyFile,
+			InsecureSkipVerify: *mqttInsecure,
+			ServerName:         *mqttSNI,
+		},
+
+		ResponseTopic: *mqttResponse,
+		PublishState:  *mqttState,
+
+		Discovery:       *mqttHA,
+		DiscoveryPrefix: *mqttHAPrefix,
+		BaseTopic:       *mqttBase,
+	}
+}
+
+func gotifyConfigFromFlags() listener.GotifyConfig {
+	return listener.GotifyConfig{
+		URL:     *gotifyURL,
+		Token:   *gotifyToken,
+		Pattern: *gotifyPattern,
+	}
+}
+
+func telegramConfigFromFlags() (listener.TelegramConfig, error) {
+	config := listener.TelegramConfig{
+		Token:  *telegramToken,
+		APIURL: *telegramAPIURL,
+	}
+	for _, field := range strings.Split(*telegramChats, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return config, fmt.Errorf("invalid telegram chat ID: %q", field)
+		}
+		config.AllowedChats = append(config.AllowedChats, id)
+	}
+	return config, nil
+}
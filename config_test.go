@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/p3ddd/HomeGuard/configfile"
+	"github.com/p3ddd/HomeGuard/listener"
+)
+
+func TestValidateConfigFormat(t *testing.T) {
+	const (
+		yamlConfig = "devices:\n  - name: desktop\n    mac: \"00:11:22:33:44:55\"\nlog:\n  level: info\n"
+		jsonConfig = `{"devices": [{"name": "desktop", "mac": "00:11:22:33:44:55"}], "log": {"level": "info"}}`
+		tomlConfig = "[[devices]]\nname = \"desktop\"\nmac = \"00:11:22:33:44:55\"\n\n[log]\nlevel = \"info\"\n"
+	)
+
+	tests := []struct {
+		name    string
+		server  string // Name of the server's configuration file
+		format  string
+		data    string
+		wantErr string
+	}{
+		{name: "YAML to a YAML server", server: "config.yaml", format: configfile.FormatYAML, data: yamlConfig},
+		{name: "JSON to a YAML server", server: "config.yaml", format: configfile.FormatJSON, data: jsonConfig},
+		{name: "TOML to a YAML server", server: "config.yaml", format: configfile.FormatTOML, data: tomlConfig},
+		{name: "YAML to a TOML server", server: "config.toml", format: configfile.FormatYAML, data: yamlConfig},
+		{name: "JSON to a TOML server", server: "config.toml", format: configfile.FormatJSON, data: jsonConfig},
+		{name: "server format", server: "config.toml", data: tomlConfig},
+		{name: "YAML as TOML", server: "config.yaml", format: configfile.FormatTOML, data: yamlConfig, wantErr: "failed to parse config file"},
+		{name: "TOML as the server's YAML", server: "config.yaml", data: tomlConfig, wantErr: "failed to parse config file"},
+		{name: "invalid in another format", server: "config.yaml", format: configfile.FormatJSON, data: `{"log": {"level": "loud"}}`, wantErr: "unknown log level"},
+	}
+	saved := *configPath
+	t.Cleanup(func() { *configPath = saved })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*configPath = filepath.Join(t.TempDir(), tt.server)
+			err := validateConfig(tt.format, []byte(tt.data))
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyConfigFile(t *testing.T) {
+	const config = "server:\n  http:\n    addr: \":9000\"\n  unix:\n    enabled: true\nqueue:\n  workers: 8\n  size: 50\nlog:\n  level: debug\n"
+
+	tests := []struct {
+		name       string
+		flags      map[string]string // Given on the command line
+		wantHTTP   string
+		wantSocket string
+		wantQueue  [2]int // Workers and size
+		wantLevel  string
+	}{
+		{
+			name:       "config file",
+			wantHTTP:   ":9000",
+			wantSocket: listener.DefaultUnixSocketPath,
+			wantQueue:  [2]int{8, 50},
+			wantLevel:  "debug",
+		},
+		{
+			name:       "flags win",
+			flags:      map[string]string{"http": ":8080", "workers": "2", "log-level": "warn"},
+			wantHTTP:   ":8080",
+			wantSocket: listener.DefaultUnixSocketPath,
+			wantQueue:  [2]int{2, 50},
+			wantLevel:  "warn",
+		},
+		{
+			name:      "flags set to their defaults win",
+			flags:     map[string]string{"http": ":7092", "unix-socket": "", "log-level": "info"},
+			wantHTTP:  ":7092",
+			wantQueue: [2]int{8, 50},
+			wantLevel: "info",
+		},
+	}
+	addr, socket, workerCount, size, level := *httpAddr, *unixSocket, *workers, *queueSize, *logLevel
+	t.Cleanup(func() {
+		*httpAddr, *unixSocket, *workers, *queueSize, *logLevel = addr, socket, workerCount, size, level
+		explicit = make(map[string]bool)
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*httpAddr, *unixSocket, *workers, *queueSize, *logLevel = addr, socket, workerCount, size, level
+			explicit = make(map[string]bool)
+			for name, value := range tt.flags {
+				if err := flag.Set(name, value); err != nil {
+					t.Fatal(err)
+				}
+				explicit[name] = true
+			}
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := applyConfigFile(path); err != nil {
+				t.Fatal(err)
+			}
+			if *httpAddr != tt.wantHTTP {
+				t.Errorf("http = %q, want %q", *httpAddr, tt.wantHTTP)
+			}
+			if *unixSocket != tt.wantSocket {
+				t.Errorf("unix-socket = %q, want %q", *unixSocket, tt.wantSocket)
+			}
+			if got := [2]int{*workers, *queueSize}; got != tt.wantQueue {
+				t.Errorf("workers and queue size = %v, want %v", got, tt.wantQueue)
+			}
+			if *logLevel != tt.wantLevel {
+				t.Errorf("log-level = %q, want %q", *logLevel, tt.wantLevel)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Package configfile reads the HomeGuard configuration file in YAML, JSON or
+// TOML, expanding the references in its values:
+//
+//	${NAME}           the environment variable NAME, which must be set
+//	${NAME:-default}  NAME, or default if it is unset or empty
+//	${file:PATH}      the contents of the file at PATH, without trailing newlines
+//	$$                a literal $
+//
+// A $ followed by anything else is kept as it is. Keys and comments are not
+// expanded. Unquoted YAML values are typed after expansion, so
+// "port: ${PORT}" decodes into an integer; quoted values stay strings.
+package configfile
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// expandNode expands the scalar values below n in place. Aliases are not
+// followed, as the nodes they point to are expanded where they are
+// anchored.
+func expandNode(n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if !strings.Contains(n.Value, "$") {
+			return nil
+		}
+		value, err := Expand(n.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		n.Value = value
+		if n.Style == 0 {
+			n.Tag = "" // Resolve the type of the expanded value
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			if err := expandNode(n.Content[i]); err != nil {
+				return err
+			}
+		}
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, c := range n.Content {
+			if err := expandNode(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Expand replaces the ${...} references and $$ escapes of s, as described
+// in the package documentation.
+func Expand(s string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 || i == len(s)-1 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			s = s[i+2:]
+		case '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated reference %q, write $$ for a literal $", s[i:])
+			}
+			value, err := lookup(s[i+2 : i+2+end])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			s = s[i+3+end:]
+		default:
+			b.WriteByte('$')
+			s = s[i+1:]
+		}
+	}
+}
+
+// lookup returns the value of the reference ref, the text between ${ and }.
+func lookup(ref string) (string, error) {
+	if path, ok := strings.CutPrefix(ref, "file:"); ok {
+		if path == "" {
+			return "", fmt.Errorf("missing path in ${%s}", ref)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	name, fallback, hasDefault := strings.Cut(ref, ":-")
+	if !validName(name) {
+		return "", fmt.Errorf("invalid variable name in ${%s}", ref)
+	}
+	value, ok := os.LookupEnv(name)
+	if hasDefault && value == "" {
+		return fallback, nil
+	}
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// validName reports whether name is a shell variable name.
+func validName(name string) bool {
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		return false
+	}
+	for _, c := range name {
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
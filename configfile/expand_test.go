@@ -0,0 +1,108 @@
+package configfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	t.Setenv("HG_USER", "homeguard")
+	t.Setenv("HG_EMPTY", "")
+	t.Setenv("HG_DOLLAR", "pa$$word")
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("s3cret\r\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr string
+	}{
+		{name: "no references", in: "plain text", want: "plain text"},
+		{name: "variable", in: "user=${HG_USER}", want: "user=homeguard"},
+		{name: "several", in: "${HG_USER}:${HG_USER}", want: "homeguard:homeguard"},
+		{name: "escaped dollar", in: "$$", want: "$"},
+		{name: "escaped reference", in: "$${HG_USER}", want: "${HG_USER}"},
+		{name: "lone dollar kept", in: "costs $5", want: "costs $5"},
+		{name: "trailing dollar kept", in: "price$", want: "price$"},
+		{name: "shell form kept", in: "$HG_USER", want: "$HG_USER"},
+		{name: "value not expanded again", in: "${HG_DOLLAR}", want: "pa$$word"},
+		{name: "default of unset variable", in: "${HG_UNSET:-b}", want: "b"},
+		{name: "default of empty variable", in: "${HG_EMPTY:-b}", want: "b"},
+		{name: "default not used", in: "${HG_USER:-b}", want: "homeguard"},
+		{name: "empty default", in: "[${HG_UNSET:-}]", want: "[]"},
+		{name: "default with colons", in: "${HG_UNSET:-tcp://broker:1883}", want: "tcp://broker:1883"},
+		{name: "empty variable", in: "[${HG_EMPTY}]", want: "[]"},
+		{name: "secret file", in: "${file:" + secret + "}", want: "s3cret"},
+		{name: "unset variable", in: "${HG_UNSET}", wantErr: "environment variable HG_UNSET is not set"},
+		{name: "unterminated reference", in: "a ${HG_USER", wantErr: `unterminated reference "${HG_USER"`},
+		{name: "empty reference", in: "${}", wantErr: "invalid variable name"},
+		{name: "invalid name", in: "${HG-USER}", wantErr: "invalid variable name"},
+		{name: "name starting with a digit", in: "${1ST}", wantErr: "invalid variable name"},
+		{name: "missing secret path", in: "${file:}", wantErr: "missing path"},
+		{name: "missing secret file", in: "${file:" + secret + ".missing}", wantErr: "failed to read secret file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Expand(tt.in)
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Expand(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalYAMLExpansion(t *testing.T) {
+	t.Setenv("HG_PORT", "1883")
+	t.Setenv("HG_KEY", "expanded")
+
+	var config struct {
+		Port    any               `yaml:"port"`
+		Quoted  any               `yaml:"quoted"`
+		Enabled any               `yaml:"enabled"`
+		Labels  map[string]string `yaml:"labels"`
+		Hosts   []string          `yaml:"hosts"`
+	}
+	data := `# ${HG_UNSET} in a comment is not expanded
+port: ${HG_PORT}
+quoted: "${HG_PORT}"
+enabled: ${HG_ENABLED:-true}
+labels:
+  ${HG_KEY}: ${HG_KEY}
+hosts:
+  - ${HG_KEY}
+`
+	if err := Unmarshal("config.yaml", []byte(data), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Port != 1883 {
+		t.Errorf("port = %#v, want the integer 1883", config.Port)
+	}
+	if config.Quoted != "1883" {
+		t.Errorf("quoted = %#v, want the string \"1883\"", config.Quoted)
+	}
+	if config.Enabled != true {
+		t.Errorf("enabled = %#v, want true", config.Enabled)
+	}
+	if config.Labels["${HG_KEY}"] != "expanded" {
+		t.Errorf("labels = %v, want the key kept and the value expanded", config.Labels)
+	}
+	if len(config.Hosts) != 1 || config.Hosts[0] != "expanded" {
+		t.Errorf("hosts = %v", config.Hosts)
+	}
+
+	err := Unmarshal("config.yaml", []byte("server:\n  token: ${HG_UNSET}\n"), &config)
+	if err == nil || !strings.Contains(err.Error(), "line 2: environment variable HG_UNSET is not set") {
+		t.Fatalf("error = %v, want one containing %q", err, "line 2: environment variable HG_UNSET is not set")
+	}
+}
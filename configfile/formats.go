@@ -0,0 +1,242 @@
+package configfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration file formats, chosen by file extension.
+const (
+	FormatYAML = "yaml" // .yaml, .yml and any other extension
+	FormatJSON = "json" // .json
+	FormatTOML = "toml" // .toml
+)
+
+// Extensions are the file extensions of configuration files.
+var Extensions = []string{".yaml", ".yml", ".json", ".toml"}
+
+// FormatOf returns the format of the configuration file at path.
+func FormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatYAML
+	}
+}
+
+// Parse parses a configuration in the format of path and expands the
+// references in its values. JSON and TOML documents are converted to YAML
+// nodes, so every format decodes into the yaml tags of the configuration
+// structs. Nodes of TOML documents have no line numbers. An empty document
+// has a zero Kind.
+//
+// JSON and TOML have no unquoted values, so there a string containing a
+// reference is typed after expansion, and any other string stays one.
+func Parse(path string, data []byte) (*yaml.Node, error) {
+	var doc *yaml.Node
+	var err error
+	switch FormatOf(path) {
+	case FormatJSON:
+		doc, err = parseJSON(data)
+	case FormatTOML:
+		doc, err = parseTOML(data)
+	default:
+		doc = &yaml.Node{}
+		err = yaml.Unmarshal(data, doc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := expandNode(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Unmarshal decodes a configuration in the format of path into out.
+func Unmarshal(path string, data []byte, out any) error {
+	doc, err := Parse(path, data)
+	if err != nil || doc.Kind == 0 {
+		return err // A nil error for an empty document
+	}
+	return doc.Decode(out)
+}
+
+// Node constructors for converted documents. Strings are plain scalars
+// tagged as strings, which expandNode retypes once it expanded them.
+func stringNode(s string, line int) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s, Line: line}
+}
+
+func scalarNode(tag, value string, line int) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value, Line: line}
+}
+
+func document(root *yaml.Node) *yaml.Node {
+	return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}
+}
+
+// parseJSON converts a JSON document to YAML nodes with line numbers. An
+// empty document has a zero Kind, as in YAML.
+func parseJSON(data []byte) (*yaml.Node, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return &yaml.Node{}, nil
+	}
+	p := jsonParser{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+	p.dec.UseNumber()
+	root, err := p.value()
+	if err == nil {
+		if _, err = p.dec.Token(); err == nil {
+			err = fmt.Errorf("line %d: unexpected data after the top-level value", p.line())
+		} else if errors.Is(err, io.EOF) {
+			return document(root), nil
+		}
+	}
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		return nil, fmt.Errorf("line %d: %w", lineAt(data, syntax.Offset), err)
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return nil, errors.New("unexpected end of JSON input")
+	}
+	return nil, err
+}
+
+// jsonParser builds YAML nodes from the tokens of a JSON decoder.
+type jsonParser struct {
+	data []byte
+	dec  *json.Decoder
+}
+
+// line returns the line of the next token.
+func (p *jsonParser) line() int {
+	offset := p.dec.InputOffset()
+	for offset < int64(len(p.data)) && strings.IndexByte(" \t\r\n,:", p.data[offset]) >= 0 {
+		offset++
+	}
+	return lineAt(p.data, offset)
+}
+
+func (p *jsonParser) value() (*yaml.Node, error) {
+	line := p.line()
+	tok, err := p.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: line}
+		if t == '{' {
+			n.Kind, n.Tag = yaml.MappingNode, "!!map"
+		}
+		for p.dec.More() {
+			if n.Kind == yaml.MappingNode {
+				keyLine := p.line()
+				key, err := p.dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				n.Content = append(n.Content, stringNode(key.(string), keyLine))
+			}
+			item, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, item)
+		}
+		if _, err := p.dec.Token(); err != nil { // Closing delimiter
+			return nil, err
+		}
+		return n, nil
+	case string:
+		return stringNode(t, line), nil
+	case json.Number:
+		if strings.ContainsAny(t.String(), ".eE") {
+			return scalarNode("!!float", t.String(), line), nil
+		}
+		return scalarNode("!!int", t.String(), line), nil
+	case bool:
+		return scalarNode("!!bool", strconv.FormatBool(t), line), nil
+	default:
+		return scalarNode("!!null", "null", line), nil
+	}
+}
+
+// lineAt returns the line of a byte offset of data.
+func lineAt(data []byte, offset int64) int {
+	offset = min(offset, int64(len(data)))
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// parseTOML converts a TOML document to YAML nodes. Keys of a table are
+// sorted by name.
+func parseTOML(data []byte) (*yaml.Node, error) {
+	var table map[string]any
+	if err := toml.Unmarshal(data, &table); err != nil {
+		return nil, err
+	}
+	if len(table) == 0 {
+		return &yaml.Node{}, nil
+	}
+	return document(tomlNode(table)), nil
+}
+
+func tomlNode(v any) *yaml.Node {
+	switch v := v.(type) {
+	case map[string]any:
+		n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			n.Content = append(n.Content, stringNode(key, 0), tomlNode(v[key]))
+		}
+		return n
+	case []map[string]any:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range v {
+			n.Content = append(n.Content, tomlNode(item))
+		}
+		return n
+	case []any:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range v {
+			n.Content = append(n.Content, tomlNode(item))
+		}
+		return n
+	case string:
+		return stringNode(v, 0)
+	case int64:
+		return scalarNode("!!int", strconv.FormatInt(v, 10), 0)
+	case float64:
+		switch {
+		case math.IsInf(v, 1):
+			return scalarNode("!!float", ".inf", 0)
+		case math.IsInf(v, -1):
+			return scalarNode("!!float", "-.inf", 0)
+		case math.IsNaN(v):
+			return scalarNode("!!float", ".nan", 0)
+		}
+		return scalarNode("!!float", strconv.FormatFloat(v, 'g', -1, 64), 0)
+	case bool:
+		return scalarNode("!!bool", strconv.FormatBool(v), 0)
+	case time.Time:
+		return scalarNode("!!timestamp", v.Format(time.RFC3339Nano), 0)
+	default:
+		// Local dates and times
+		return stringNode(fmt.Sprint(v), 0)
+	}
+}
@@ -0,0 +1,202 @@
+package configfile
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestFormatOf(t *testing.T) {
+	tests := map[string]string{
+		"config.yaml":         FormatYAML,
+		"config.yml":          FormatYAML,
+		"config":              FormatYAML,
+		"config.json":         FormatJSON,
+		"/etc/hg/CONFIG.JSON": FormatJSON,
+		"config.toml":         FormatTOML,
+	}
+	for path, want := range tests {
+		if got := FormatOf(path); got != want {
+			t.Errorf("FormatOf(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestUnmarshalFormats(t *testing.T) {
+	t.Setenv("HG_PORT", "1883")
+	t.Setenv("HG_PASSWORD", "secret")
+
+	tests := []struct {
+		name    string
+		path    string
+		in      string
+		want    map[string]any
+		wantErr string
+	}{
+		{
+			name: "JSON types",
+			path: "config.json",
+			in:   `{"name": "desktop", "port": 9, "ratio": 0.5, "big": 1e3, "enabled": false, "note": null, "tags": ["a", 1], "nested": {"key": "value"}}`,
+			want: map[string]any{
+				"name": "desktop", "port": 9, "ratio": 0.5, "big": 1000.0, "enabled": false, "note": nil,
+				"tags": []any{"a", 1}, "nested": map[string]any{"key": "value"},
+			},
+		},
+		{
+			name: "JSON strings stay strings",
+			path: "config.json",
+			in:   `{"port": "9", "enabled": "true", "note": "null", "literal": "$${HG_PORT}"}`,
+			want: map[string]any{"port": "9", "enabled": "true", "note": "null", "literal": "${HG_PORT}"},
+		},
+		{
+			name: "JSON references retyped",
+			path: "config.json",
+			in:   `{"port": "${HG_PORT}", "enabled": "${HG_ENABLED:-true}", "password": "${HG_PASSWORD}", "url": "tcp://host:${HG_PORT}"}`,
+			want: map[string]any{"port": 1883, "enabled": true, "password": "secret", "url": "tcp://host:1883"},
+		},
+		{
+			name: "JSON keys not expanded",
+			path: "config.json",
+			in:   `{"${HG_PORT}": 1}`,
+			want: map[string]any{"${HG_PORT}": 1},
+		},
+		{
+			name: "empty JSON",
+			path: "config.json",
+			in:   " \n",
+		},
+		{
+			name:    "JSON syntax error",
+			path:    "config.json",
+			in:      "{\n  \"port\": 9,\n  \"name\" \"desktop\"\n}",
+			wantErr: "line 3: invalid character",
+		},
+		{
+			name:    "JSON trailing data",
+			path:    "config.json",
+			in:      "{\"port\": 9}\n{}",
+			wantErr: "line 2: unexpected data after the top-level value",
+		},
+		{
+			name:    "truncated JSON",
+			path:    "config.json",
+			in:      `{"tags": ["a"`,
+			wantErr: "unexpected end of JSON input",
+		},
+		{
+			name:    "JSON reference error",
+			path:    "config.json",
+			in:      "{\n  \"password\": \"${HG_UNSET}\"\n}",
+			wantErr: "line 2: environment variable HG_UNSET is not set",
+		},
+		{
+			name: "TOML types",
+			path: "config.toml",
+			in: `name = "desktop"
+port = 9
+ratio = 0.5
+enabled = true
+tags = ["a", "b"]
+limit = inf
+
+[nested]
+key = "value"
+
+[[devices]]
+name = "nas"
+`,
+			want: map[string]any{
+				"name": "desktop", "port": 9, "ratio": 0.5, "enabled": true, "tags": []any{"a", "b"},
+				"limit": math.Inf(1), "nested": map[string]any{"key": "value"},
+				"devices": []any{map[string]any{"name": "nas"}},
+			},
+		},
+		{
+			name: "TOML strings stay strings",
+			path: "config.toml",
+			in:   "port = \"9\"\nenabled = \"true\"\n",
+			want: map[string]any{"port": "9", "enabled": "true"},
+		},
+		{
+			name: "TOML references retyped",
+			path: "config.toml",
+			in:   "port = \"${HG_PORT}\"\npassword = '${HG_PASSWORD}'\n",
+			want: map[string]any{"port": 1883, "password": "secret"},
+		},
+		{
+			name: "empty TOML",
+			path: "config.toml",
+			in:   "# Nothing configured\n",
+		},
+		{
+			name:    "TOML syntax error",
+			path:    "config.toml",
+			in:      "port = \n",
+			wantErr: "line 1",
+		},
+		{
+			name:    "TOML reference error",
+			path:    "config.toml",
+			in:      "password = \"${HG_UNSET}\"\n",
+			wantErr: "environment variable HG_UNSET is not set",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]any
+			err := Unmarshal(tt.path, []byte(tt.in), &got)
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("config = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJSONLines(t *testing.T) {
+	data := `{
+  "server": {
+    "port": 9
+  },
+  "devices": [
+    "desktop",
+
+    "nas"
+  ]
+}`
+	doc, err := Parse("config.json", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := doc.Content[0]
+	server, devices := root.Content[1], root.Content[3]
+
+	tests := []struct {
+		name string
+		node *yaml.Node
+		want int
+	}{
+		{name: "root", node: root, want: 1},
+		{name: "key", node: root.Content[0], want: 2},
+		{name: "nested key", node: server.Content[0], want: 3},
+		{name: "nested value", node: server.Content[1], want: 3},
+		{name: "sequence", node: devices, want: 5},
+		{name: "first item", node: devices.Content[0], want: 6},
+		{name: "item after a blank line", node: devices.Content[1], want: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.node.Line != tt.want {
+				t.Errorf("line = %d, want %d", tt.node.Line, tt.want)
+			}
+		})
+	}
+}
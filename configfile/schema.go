@@ -0,0 +1,133 @@
+package configfile
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// schemaDialect is the JSON Schema version of Schema.
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Definitions shared by every schema.
+var schemaDefs = map[string]any{
+	// A value given as a ${...} reference, checked once it is expanded
+	"reference": map[string]any{"type": "string", "pattern": `\$\{[^}]+\}`},
+	"duration": map[string]any{
+		"type":    "string",
+		"pattern": `^(0|(\d+(\.\d+)?(ns|us|µs|ms|s|m|h))+|.*\$\{[^}]+\}.*)$`,
+	},
+}
+
+var (
+	durationType    = reflect.TypeFor[time.Duration]()
+	unmarshalerType = reflect.TypeFor[yaml.Unmarshaler]()
+)
+
+// Schema returns a JSON Schema of the configuration decoded into v, derived
+// from the yaml tags of its fields. Numbers and booleans may also be given
+// as references. Unknown keys are rejected, as HomeGuard would ignore them.
+func Schema(title string, v any) ([]byte, error) {
+	g := schemaGenerator{defs: make(map[string]any)}
+	for name, def := range schemaDefs {
+		g.defs[name] = def
+	}
+	root := g.object(reflect.TypeOf(v))
+	root["$schema"] = schemaDialect
+	root["title"] = title
+	root["$defs"] = g.defs
+	return json.MarshalIndent(root, "", "  ")
+}
+
+// schemaGenerator collects the definitions of the named struct types it
+// meets.
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == durationType {
+		return ref("duration")
+	}
+	// A list type with its own decoding is taken to also accept a single
+	// item, like the MQTT brokers
+	if t.Kind() == reflect.Slice && reflect.PointerTo(t).Implements(unmarshalerType) {
+		item := g.schema(t.Elem())
+		return map[string]any{"anyOf": []any{item, map[string]any{"type": "array", "items": item}}}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return orReference(map[string]any{"type": "boolean"})
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return orReference(map[string]any{"type": "integer"})
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return orReference(map[string]any{"type": "integer", "minimum": 0})
+	case reflect.Float32, reflect.Float64:
+		return orReference(map[string]any{"type": "number"})
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := path.Base(t.PkgPath()) + "." + t.Name()
+		if _, ok := g.defs[name]; !ok {
+			g.defs[name] = nil // Placeholder for recursive types
+			g.defs[name] = g.object(t)
+		}
+		return ref(name)
+	default:
+		return map[string]any{}
+	}
+}
+
+// object returns the schema of a struct.
+func (g *schemaGenerator) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	g.fields(t, properties)
+	return map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+}
+
+// fields adds the fields of a struct to properties, as yaml.v3 decodes
+// them: by tag name or lowercased field name, with inline structs merged.
+func (g *schemaGenerator) fields(t reflect.Type, properties map[string]any) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if slices.Contains(strings.Split(options, ","), "inline") {
+			g.fields(f.Type, properties)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		properties[name] = g.schema(f.Type)
+	}
+}
+
+func ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/$defs/" + name}
+}
+
+func orReference(schema map[string]any) map[string]any {
+	return map[string]any{"anyOf": []any{schema, ref("reference")}}
+}
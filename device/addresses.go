@@ -0,0 +1,180 @@
+package device
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// limitedBroadcast is used for devices without a configured broadcast
+// address until their subnet is known.
+const limitedBroadcast = "255.255.255.255"
+
+// maxAddressChanges is how many address changes are kept per device.
+const maxAddressChanges = 10
+
+// resolveTimeout bounds the lookup of device hostnames.
+const resolveTimeout = 2 * time.Second
+
+// Sighting is an IPv4 address seen on the network with the MAC address that
+// answers for it.
+type Sighting struct {
+	IP        netip.Addr
+	Mac       string
+	Broadcast string // Broadcast address of the local network of IP, if known
+}
+
+// AddressChange records a new MAC or IP address of a device.
+type AddressChange struct {
+	Device      string
+	Time        time.Time
+	IP          string
+	PreviousIP  string
+	Mac         string
+	PreviousMac string
+}
+
+// learnedAddress is what sightings and agent heartbeats told about a
+// device.
+type learnedAddress struct {
+	ip        netip.Addr
+	mac       string
+	broadcast string
+	seen      time.Time
+	changes   []AddressChange
+	fromAgent bool // The addresses were reported by the agent
+}
+
+// withAddress fills in the learned MAC and broadcast addresses of a device.
+// A device with a host is identified by the address of its host, so a MAC
+// address seen there replaces the configured one. A MAC address reported by
+// the agent never does: it is the one of whichever interface reached the
+// server. The caller must hold m.mu.
+func (m *Manager) withAddress(device Device) Device {
+	learned, ok := m.learned[device.Name]
+	if ok && learned.mac != "" && (device.Mac == "" || (device.Host != "" && !learned.fromAgent)) {
+		device.Mac = learned.mac
+	}
+	if device.Broadcast == "" {
+		device.Broadcast = learned.broadcast
+	}
+	if device.Broadcast == "" {
+		device.Broadcast = limitedBroadcast
+	}
+	return device
+}
+
+// ObserveAddresses updates the addresses of the devices from sightings of
+// the neighbour table. A device with a host is matched by the addresses its
+// host resolves to, any other by its MAC address. Devices that are not
+// sighted keep their last known addresses. It returns the changes.
+func (m *Manager) ObserveAddresses(ctx context.Context, sightings []Sighting) []AddressChange {
+	byIP := make(map[netip.Addr]Sighting, len(sightings))
+	byMac := make(map[string]Sighting, len(sightings))
+	for _, s := range sightings {
+		byIP[s.IP] = s
+		byMac[s.Mac] = s
+	}
+
+	m.mu.RLock()
+	devices := make([]Device, 0, len(m.devices))
+	for _, device := range m.devices {
+		devices = append(devices, device)
+	}
+	m.mu.RUnlock()
+
+	// Hostnames are resolved without holding the lock
+	found := make(map[string]Sighting)
+	for _, device := range devices {
+		if device.Host != "" {
+			for _, ip := range resolveHost(ctx, device.Host) {
+				if s, ok := byIP[ip]; ok {
+					found[device.Name] = s
+					break
+				}
+			}
+			continue
+		}
+		if mac, err := net.ParseMAC(device.Mac); err == nil {
+			if s, ok := byMac[mac.String()]; ok {
+				found[device.Name] = s
+			}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var changes []AddressChange
+	for name, s := range found {
+		device, exists := m.devices[name]
+		if !exists {
+			continue // Removed by a reload meanwhile
+		}
+		if change, ok := m.learn(device, s.IP, s.Mac, s.Broadcast, now, false); ok {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// learn records the addresses a device was seen with, and returns the
+// change if they differ from the last known ones. mac must be normalized.
+// The caller must hold m.mu.
+func (m *Manager) learn(device Device, ip netip.Addr, mac, broadcast string, now time.Time, fromAgent bool) (AddressChange, bool) {
+	learned := m.learned[device.Name]
+	previousMac := learned.mac
+	if previousMac == "" {
+		if configured, err := net.ParseMAC(device.Mac); err == nil {
+			previousMac = configured.String()
+		}
+	}
+	var change AddressChange
+	changed := mac != previousMac || (learned.ip.IsValid() && ip.IsValid() && ip != learned.ip)
+	if changed {
+		change = AddressChange{
+			Device:      device.Name,
+			Time:        now,
+			Mac:         mac,
+			PreviousMac: previousMac,
+		}
+		if ip.IsValid() {
+			change.IP = ip.String()
+		}
+		if learned.ip.IsValid() {
+			change.PreviousIP = learned.ip.String()
+		}
+		learned.changes = append(learned.changes, change)
+		if len(learned.changes) > maxAddressChanges {
+			learned.changes = learned.changes[len(learned.changes)-maxAddressChanges:]
+		}
+	}
+	if ip.IsValid() {
+		learned.ip = ip
+	}
+	learned.mac, learned.seen, learned.fromAgent = mac, now, fromAgent
+	if broadcast != "" {
+		learned.broadcast = broadcast
+	}
+	m.learned[device.Name] = learned
+	return change, changed
+}
+
+// resolveHost returns the IPv4 addresses of a hostname or IP address.
+func resolveHost(ctx context.Context, host string) []netip.Addr {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{ip.Unmap()}
+	}
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip4", host)
+	if err != nil {
+		return nil
+	}
+	for i, ip := range ips {
+		ips[i] = ip.Unmap()
+	}
+	return ips
+}
@@ -0,0 +1,157 @@
+package device
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const addressesConfig = `
+devices:
+  - name: desktop
+    mac: "00:11:22:33:44:55"
+    broadcast: "192.168.1.255"
+    agent:
+      token: secret
+  - name: nas
+    host: 192.168.1.20
+    agent:
+      token: secret
+`
+
+func newTestManager(t *testing.T, config string) *Manager {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestRecordHeartbeat(t *testing.T) {
+	heartbeat := func(device, mac string) Heartbeat {
+		return Heartbeat{Device: device, IP: "192.168.1.50", Mac: mac, Interval: time.Minute}
+	}
+
+	tests := []struct {
+		name       string
+		sightings  []Sighting // Seen in the neighbour table before the heartbeats
+		heartbeats []Heartbeat
+		device     string
+		wantErr    bool
+		wantMac    string // Used for waking
+		wantStatus string // Shown in the status
+		wantMoves  int
+	}{
+		{
+			name:       "same MAC as configured",
+			heartbeats: []Heartbeat{heartbeat("desktop", "00-11-22-33-44-55")},
+			device:     "desktop",
+			wantMac:    "00:11:22:33:44:55",
+			wantStatus: "00:11:22:33:44:55",
+		},
+		{
+			name:       "configured MAC stays authoritative",
+			heartbeats: []Heartbeat{heartbeat("desktop", "AA:BB:CC:DD:EE:FF")},
+			device:     "desktop",
+			wantMac:    "00:11:22:33:44:55",
+			wantStatus: "aa:bb:cc:dd:ee:ff",
+			wantMoves:  1,
+		},
+		{
+			name:       "repeated report is one change",
+			heartbeats: []Heartbeat{heartbeat("desktop", "aa:bb:cc:dd:ee:ff"), heartbeat("desktop", "aa:bb:cc:dd:ee:ff")},
+			device:     "desktop",
+			wantMac:    "00:11:22:33:44:55",
+			wantStatus: "aa:bb:cc:dd:ee:ff",
+			wantMoves:  1,
+		},
+		{
+			name:       "fills in the MAC of a device configured by host",
+			heartbeats: []Heartbeat{heartbeat("nas", "aa:bb:cc:dd:ee:ff")},
+			device:     "nas",
+			wantMac:    "aa:bb:cc:dd:ee:ff",
+			wantStatus: "aa:bb:cc:dd:ee:ff",
+			wantMoves:  1,
+		},
+		{
+			name:       "neighbour table wins over the agent",
+			sightings:  []Sighting{{IP: netip.MustParseAddr("192.168.1.20"), Mac: "66:77:88:99:aa:bb"}},
+			heartbeats: []Heartbeat{heartbeat("nas", "aa:bb:cc:dd:ee:ff")},
+			device:     "nas",
+			wantMac:    "66:77:88:99:aa:bb",
+			wantStatus: "66:77:88:99:aa:bb",
+			wantMoves:  1, // The sighting
+		},
+		{
+			name:       "invalid MAC",
+			heartbeats: []Heartbeat{heartbeat("desktop", "not-a-mac")},
+			device:     "desktop",
+			wantErr:    true,
+			wantMac:    "00:11:22:33:44:55",
+		},
+		{
+			name:       "unknown device",
+			heartbeats: []Heartbeat{heartbeat("laptop", "aa:bb:cc:dd:ee:ff")},
+			device:     "desktop",
+			wantErr:    true,
+			wantMac:    "00:11:22:33:44:55",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, addressesConfig)
+			m.ObserveAddresses(t.Context(), tt.sightings)
+			var err error
+			for _, hb := range tt.heartbeats {
+				_, err = m.RecordHeartbeat(hb)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+
+			device, err := m.GetDevice(tt.device)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if device.Mac != tt.wantMac {
+				t.Errorf("device MAC = %q, want %q", device.Mac, tt.wantMac)
+			}
+			status, err := m.GetStatus(tt.device)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.Mac != tt.wantStatus {
+				t.Errorf("status MAC = %q, want %q", status.Mac, tt.wantStatus)
+			}
+			if len(status.AddressChanges) != tt.wantMoves {
+				t.Errorf("address changes = %+v, want %d", status.AddressChanges, tt.wantMoves)
+			}
+		})
+	}
+}
+
+func TestRecordHeartbeatChange(t *testing.T) {
+	m := newTestManager(t, addressesConfig)
+	changes, err := m.RecordHeartbeat(Heartbeat{Device: "desktop", IP: "192.168.1.50", Mac: "aa:bb:cc:dd:ee:ff", Interval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("changes = %+v, want one", changes)
+	}
+	c := changes[0]
+	if c.Device != "desktop" || c.IP != "192.168.1.50" || c.Mac != "aa:bb:cc:dd:ee:ff" || c.PreviousMac != "00:11:22:33:44:55" {
+		t.Errorf("change = %+v", c)
+	}
+	status, _ := m.GetStatus("desktop")
+	if !status.Online || status.IP != "192.168.1.50" {
+		t.Errorf("status = %+v, want online at 192.168.1.50", status)
+	}
+}
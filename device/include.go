@@ -0,0 +1,215 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/p3ddd/HomeGuard/configfile"
+)
+
+// ConfDir is the directory next to the configuration file whose YAML, JSON
+// and TOML files are merged into it, in name order.
+const ConfDir = "conf.d"
+
+// includedKeys are the sections a file other than the main configuration
+// file may have.
+var includedKeys = []string{"devices", "schedules", "device_sources", "include"}
+
+// configFile is one file of the configuration, with the line of each
+// device, schedule and device source.
+type configFile struct {
+	path string
+	Config
+
+	deviceLines   []int
+	scheduleLines []int
+	sourceLines   []int
+}
+
+// at returns the location of item i of a section for error messages.
+func (f *configFile) at(lines []int, i int) string {
+	if i >= len(lines) || lines[i] == 0 {
+		return f.path // Section given as an alias, or a TOML file
+	}
+	return fmt.Sprintf("%s:%d", f.path, lines[i])
+}
+
+// readConfigFiles returns the configuration file at path, whose contents are
+// data, followed by the files it includes and those of its conf.d
+// directory. Included files may include others; each file is read once.
+func readConfigFiles(path string, data []byte) ([]*configFile, error) {
+	seen := make(map[string]bool)
+	main, err := decodeConfigFile(path, data, true)
+	if err != nil {
+		return nil, err
+	}
+	files := []*configFile{main}
+	markSeen(seen, path)
+
+	var include func(f *configFile) error
+	include = func(f *configFile) error {
+		for _, pattern := range f.Include {
+			paths, err := expandInclude(filepath.Dir(f.path), pattern)
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.path, err)
+			}
+			for _, p := range paths {
+				if !markSeen(seen, p) {
+					continue
+				}
+				included, err := readIncluded(p)
+				if err != nil {
+					return err
+				}
+				files = append(files, included)
+				if err := include(included); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := include(main); err != nil {
+		return nil, err
+	}
+
+	// conf.d files come after the includes of the main file
+	confFiles, err := confDirFiles(filepath.Join(filepath.Dir(path), ConfDir))
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range confFiles {
+		if !markSeen(seen, p) {
+			continue
+		}
+		f, err := readIncluded(p)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		if err := include(f); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// markSeen records path and reports whether it was not seen before.
+func markSeen(seen map[string]bool, path string) bool {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if seen[path] {
+		return false
+	}
+	seen[path] = true
+	return true
+}
+
+func readIncluded(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read included config file: %w", err)
+	}
+	return decodeConfigFile(path, data, false)
+}
+
+// expandInclude returns the files an include entry names, relative to dir.
+// A pattern without matches is not an error, a missing plain path is.
+func expandInclude(dir, pattern string) ([]string, error) {
+	if pattern == "" {
+		return nil, errors.New("include path cannot be empty")
+	}
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+	}
+	if len(paths) == 0 && !hasMeta(pattern) {
+		return nil, fmt.Errorf("included config file not found: %s", pattern)
+	}
+	return paths, nil
+}
+
+func hasMeta(pattern string) bool {
+	return slices.ContainsFunc([]byte(pattern), func(c byte) bool {
+		return c == '*' || c == '?' || c == '[' || c == '\\'
+	})
+}
+
+// confDirFiles returns the configuration files of dir sorted by name. A
+// missing directory has none.
+func confDirFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	var paths []string
+	for _, e := range entries {
+		if !e.IsDir() && slices.Contains(configfile.Extensions, filepath.Ext(e.Name())) {
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// decodeConfigFile decodes the devices, schedules, device sources and
+// includes of a configuration file. Files other than the main one may only
+// have those sections.
+func decodeConfigFile(path string, data []byte, main bool) (*configFile, error) {
+	f := &configFile{path: path}
+	doc, err := configfile.Parse(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		return f, nil
+	}
+	if err := doc.Decode(&f.Config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return f, nil
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if !main && !slices.Contains(includedKeys, key.Value) {
+			at := path // TOML files have no line numbers
+			if key.Line > 0 {
+				at = fmt.Sprintf("%s:%d", path, key.Line)
+			}
+			return nil, fmt.Errorf("%s: %s can only be set in the main config file", at, key.Value)
+		}
+		switch key.Value {
+		case "devices":
+			f.deviceLines = itemLines(value)
+		case "schedules":
+			f.scheduleLines = itemLines(value)
+		case "device_sources":
+			f.sourceLines = itemLines(value)
+		}
+	}
+	return f, nil
+}
+
+// itemLines returns the line of each item of a sequence.
+func itemLines(n *yaml.Node) []int {
+	lines := make([]int, len(n.Content))
+	for i, item := range n.Content {
+		lines[i] = item.Line
+	}
+	return lines
+}
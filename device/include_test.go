@@ -0,0 +1,266 @@
+package device
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeConfigFiles writes files, keyed by their path relative to dir.
+func writeConfigFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadConfigFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		main    string
+		files   map[string]string
+		want    []string // Paths relative to the directory of config.yaml
+		wantErr string
+	}{
+		{
+			name: "no includes",
+			main: "devices: []\n",
+			want: []string{"config.yaml"},
+		},
+		{
+			name:  "glob include in name order",
+			main:  "include:\n  - devices/*.yaml\n",
+			files: map[string]string{"devices/b.yaml": "", "devices/a.yaml": "", "devices/c.json": ""},
+			want:  []string{"config.yaml", "devices/a.yaml", "devices/b.yaml"},
+		},
+		{
+			name: "glob without matches",
+			main: "include: [devices/*.yaml]\n",
+			want: []string{"config.yaml"},
+		},
+		{
+			name:  "plain path",
+			main:  "include: [lab.toml]\n",
+			files: map[string]string{"lab.toml": ""},
+			want:  []string{"config.yaml", "lab.toml"},
+		},
+		{
+			name:    "missing plain path",
+			main:    "include: [lab.yaml]\n",
+			wantErr: "included config file not found",
+		},
+		{
+			name:    "empty include",
+			main:    "include: ['']\n",
+			wantErr: "include path cannot be empty",
+		},
+		{
+			name:    "invalid pattern",
+			main:    "include: ['[a-']\n",
+			wantErr: "invalid include pattern",
+		},
+		{
+			name: "nested include relative to the including file",
+			main: "include: [lab/lab.yaml]\n",
+			files: map[string]string{
+				"lab/lab.yaml":  "include: [pi.yaml]\n",
+				"lab/pi.yaml":   "",
+				"pi.yaml":       "devices: [{name: wrong, mac: '00:11:22:33:44:55'}]\n",
+				"lab/other.yml": "",
+			},
+			want: []string{"config.yaml", "lab/lab.yaml", "lab/pi.yaml"},
+		},
+		{
+			name: "cycle",
+			main: "include: [a.yaml]\n",
+			files: map[string]string{
+				"a.yaml": "include: [b.yaml]\n",
+				"b.yaml": "include: [a.yaml, config.yaml]\n",
+			},
+			want: []string{"config.yaml", "a.yaml", "b.yaml"},
+		},
+		{
+			name:  "file included twice",
+			main:  "include: [b.yaml, ./b.yaml, '*.yaml']\n",
+			files: map[string]string{"a.yaml": "", "b.yaml": ""},
+			want:  []string{"config.yaml", "b.yaml", "a.yaml"},
+		},
+		{
+			name: "conf.d after the includes",
+			main: "include: [lab.yaml]\n",
+			files: map[string]string{
+				"lab.yaml":          "",
+				"conf.d/20-nas.yml": "",
+				"conf.d/10-pc.json": "{\"include\": [\"../extra.toml\"]}",
+				"conf.d/notes.txt":  "not a config file",
+				"conf.d/sub/x.yaml": "",
+				"extra.toml":        "",
+			},
+			want: []string{"config.yaml", "lab.yaml", "conf.d/10-pc.json", "extra.toml", "conf.d/20-nas.yml"},
+		},
+		{
+			name: "conf.d file already included",
+			main: "include: [conf.d/20-nas.yaml]\n",
+			files: map[string]string{
+				"conf.d/10-pc.yaml":  "",
+				"conf.d/20-nas.yaml": "",
+			},
+			want: []string{"config.yaml", "conf.d/20-nas.yaml", "conf.d/10-pc.yaml"},
+		},
+		{
+			name:    "main key in an included file",
+			main:    "include: [lab.yaml]\n",
+			files:   map[string]string{"lab.yaml": "devices: []\n\nlog:\n  level: debug\n"},
+			wantErr: "lab.yaml:3: log can only be set in the main config file",
+		},
+		{
+			name:    "main key in a conf.d file",
+			files:   map[string]string{"conf.d/server.toml": "[server.http]\naddr = ':80'\n"},
+			wantErr: "conf.d/server.toml: server can only be set in the main config file",
+		},
+		{
+			name:    "invalid included file",
+			main:    "include: [lab.yaml]\n",
+			files:   map[string]string{"lab.yaml": "devices: [\n"},
+			wantErr: "failed to parse ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeConfigFiles(t, dir, tt.files)
+			path := filepath.Join(dir, "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.main), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			files, err := readConfigFiles(path, []byte(tt.main))
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			case err != nil:
+				return
+			}
+			var got []string
+			for _, f := range files {
+				rel, err := filepath.Rel(dir, f.path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, filepath.ToSlash(rel))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigIncludes(t *testing.T) {
+	const desktop = "devices:\n  - name: desktop\n    mac: \"00:11:22:33:44:55\"\n"
+
+	tests := []struct {
+		name        string
+		main        string
+		files       map[string]string
+		wantDevices []string
+		wantErr     string // {dir} stands for the directory of config.yaml
+	}{
+		{
+			name: "devices of all files",
+			main: desktop + "include: [lab.yaml, lab.toml]\n",
+			files: map[string]string{
+				"lab.yaml":         "devices:\n  - name: nas\n    mac: \"00:11:22:33:44:66\"\n",
+				"lab.toml":         "[[devices]]\nname = \"pi\"\nmac = \"00:11:22:33:44:77\"\n",
+				"conf.d/pc.json":   `{"devices": [{"name": "laptop", "host": "laptop.lan"}]}`,
+				"conf.d/empty.yml": "",
+			},
+			wantDevices: []string{"desktop", "laptop", "nas", "pi"},
+		},
+		{
+			name: "schedule for a device of another file",
+			main: "include: [lab.yaml]\nschedules:\n  - name: morning\n    device: nas\n    at: \"07:00\"\n",
+			files: map[string]string{
+				"lab.yaml": "devices:\n  - name: nas\n    mac: \"00:11:22:33:44:66\"\n",
+			},
+			wantDevices: []string{"nas"},
+		},
+		{
+			name:    "duplicate device",
+			main:    desktop + "include: [lab.yaml]\n",
+			files:   map[string]string{"lab.yaml": "# Lab\n" + desktop},
+			wantErr: "{dir}/lab.yaml:3: device desktop is already defined at {dir}/config.yaml:2",
+		},
+		{
+			name:    "duplicate device in conf.d",
+			main:    desktop,
+			files:   map[string]string{"conf.d/pc.json": "{\n  \"devices\": [\n    {\"name\": \"desktop\", \"host\": \"pc\"}\n  ]\n}"},
+			wantErr: "{dir}/conf.d/pc.json:3: device desktop is already defined at {dir}/config.yaml:2",
+		},
+		{
+			name:    "duplicate device in TOML",
+			main:    desktop + "include: [lab.toml]\n",
+			files:   map[string]string{"lab.toml": "[[devices]]\nname = \"desktop\"\nmac = \"00:11:22:33:44:66\"\n"},
+			wantErr: "{dir}/lab.toml: device desktop is already defined at {dir}/config.yaml:2",
+		},
+		{
+			name:    "invalid device in TOML",
+			main:    "include: [lab.toml]\n",
+			files:   map[string]string{"lab.toml": "[[devices]]\nname = \"nas\"\n"},
+			wantErr: "{dir}/lab.toml: device MAC address or host must be set for device: nas",
+		},
+		{
+			name: "duplicate schedule",
+			main: desktop + "include: [schedules.yaml]\nschedules:\n  - name: morning\n    device: desktop\n    at: \"07:00\"\n",
+			files: map[string]string{
+				"schedules.yaml": "schedules:\n  - name: evening\n    device: desktop\n    at: \"22:00\"\n  - name: morning\n    device: desktop\n    at: \"08:00\"\n",
+			},
+			wantErr: "{dir}/schedules.yaml:5: schedule morning is already defined at {dir}/config.yaml:6",
+		},
+		{
+			name:    "schedule for an unknown device",
+			main:    "include: [schedules.yaml]\n",
+			files:   map[string]string{"schedules.yaml": "schedules:\n  - name: morning\n    device: nas\n    at: \"07:00\"\n"},
+			wantErr: "{dir}/schedules.yaml:2: schedule morning: device not found: nas",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeConfigFiles(t, dir, tt.files)
+			path := filepath.Join(dir, "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.main), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := parseConfig(path, []byte(tt.main))
+			wantErr := strings.ReplaceAll(tt.wantErr, "{dir}/", dir+string(filepath.Separator))
+			switch {
+			case err != nil && wantErr == "":
+				t.Fatal(err)
+			case wantErr != "" && (err == nil || !strings.Contains(err.Error(), wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, wantErr)
+			case err != nil:
+				return
+			}
+			var got []string
+			for name := range config.devices {
+				got = append(got, name)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.wantDevices) {
+				t.Errorf("devices = %v, want %v", got, tt.wantDevices)
+			}
+		})
+	}
+}
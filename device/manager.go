@@ -2,81 +2,397 @@ package device
 
 import (
 	"fmt"
+	"net"
+	"net/netip"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
-	"gopkg.in/yaml.v3"
+	"github.com/p3ddd/HomeGuard/power"
 )
 
 // Device represents a network device that can be woken up.
 type Device struct {
 	Name        string `yaml:"name"`
 	Mac         string `yaml:"mac"`
-	Broadcast   string `yaml:"broadcast"`
+	Broadcast   string `yaml:"broadcast"` // Default: that of the subnet the device is seen in
 	Description string `yaml:"description,omitempty"`
+
+	// Host is the hostname or IP address of the device. The MAC address
+	// seen at it replaces the configured one, which may then be omitted.
+	Host string `yaml:"host,omitempty"`
+
+	// Groups lists the groups the device belongs to, for waking several
+	// devices at once.
+	Groups []string `yaml:"groups,omitempty"`
+
+	// Shutdown configures the optional remote shutdown action.
+	Shutdown *power.Config `yaml:"shutdown,omitempty"`
+
+	// Agent configures the optional homeguard-agent running on the device.
+	Agent *AgentConfig `yaml:"agent,omitempty"`
+
+	// Source is the file an imported device comes from, empty for devices
+	// of the configuration file.
+	Source string `yaml:"-"`
+}
+
+// AgentConfig holds the settings shared with a device's homeguard-agent.
+type AgentConfig struct {
+	Token string `yaml:"token"` // Token the agent presents with its heartbeats
 }
 
 // Config represents the structure of the devices configuration file.
 type Config struct {
-	Devices []Device `yaml:"devices"`
+	Devices   []Device   `yaml:"devices"`
+	Schedules []Schedule `yaml:"schedules"`
+	Sources   []Source   `yaml:"device_sources"`
+
+	// Include lists further files of devices, schedules and device
+	// sources, as paths or glob patterns relative to the including file.
+	Include []string `yaml:"include"`
 }
 
 // Manager handles device configuration and lookup.
 type Manager struct {
-	devices map[string]Device
+	configPath string
+
+	mu        sync.RWMutex
+	devices   map[string]Device
+	schedules []Schedule
+	sources   []sourceFile
+	status    map[string]Status
+	learned   map[string]learnedAddress
+	onReload  []func()
+}
+
+// loadedConfig is the result of loading a configuration file.
+type loadedConfig struct {
+	devices   map[string]Device
+	schedules []Schedule
+	sources   []sourceFile // Files devices were imported from
 }
 
 // NewManager creates a new device manager from a configuration file.
 func NewManager(configPath string) (*Manager, error) {
+	config, err := loadDevices(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manager{
+		configPath: configPath,
+		devices:    config.devices,
+		schedules:  config.schedules,
+		sources:    config.sources,
+		status:     make(map[string]Status),
+		learned:    make(map[string]learnedAddress),
+	}, nil
+}
+
+// Reload re-reads the configuration file and the device sources. On error
+// the current devices are kept. Runtime status of devices that still exist
+// is preserved.
+func (m *Manager) Reload() error {
+	config, err := loadDevices(m.configPath)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.devices = config.devices
+	m.schedules = config.schedules
+	m.sources = config.sources
+	for name := range m.status {
+		if _, exists := config.devices[name]; !exists {
+			delete(m.status, name)
+		}
+	}
+	for name := range m.learned {
+		if _, exists := config.devices[name]; !exists {
+			delete(m.learned, name)
+		}
+	}
+	callbacks := append([]func(){}, m.onReload...)
+	m.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn()
+	}
+	return nil
+}
+
+// OnReload registers fn to be called after every successful Reload.
+func (m *Manager) OnReload(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onReload = append(m.onReload, fn)
+}
+
+func loadDevices(configPath string) (*loadedConfig, error) {
 	data, err := os.ReadFile(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
+	return parseConfig(configPath, data)
+}
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
-	}
+// Validate checks the devices, device sources and schedules of a
+// configuration file without loading it. data is taken as the contents of
+// the file at path, which locates its includes and conf.d directory.
+func Validate(path string, data []byte) error {
+	_, err := parseConfig(path, data)
+	return err
+}
 
-	manager := &Manager{
-		devices: make(map[string]Device),
+// parseConfig reads the configuration file at path, whose contents are
+// data, with the files it includes, and merges them. A device or schedule
+// name may only be defined once across the files; devices of the files
+// override imported ones.
+func parseConfig(path string, data []byte) (*loadedConfig, error) {
+	files, err := readConfigFiles(path, data)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, device := range config.Devices {
-		if device.Name == "" {
-			return nil, fmt.Errorf("device name cannot be empty")
+	devices := make(map[string]Device)
+	defined := make(map[string]string) // Location of each device
+	for _, f := range files {
+		for i, device := range f.Devices {
+			at := f.at(f.deviceLines, i)
+			if err := validateDevice(device); err != nil {
+				return nil, fmt.Errorf("%s: %w", at, err)
+			}
+			if first, exists := defined[device.Name]; exists {
+				return nil, fmt.Errorf("%s: device %s is already defined at %s", at, device.Name, first)
+			}
+			defined[device.Name] = at
+			devices[device.Name] = device
 		}
-		if device.Mac == "" {
-			return nil, fmt.Errorf("device MAC address cannot be empty for device: %s", device.Name)
+	}
+
+	var sources []sourceFile
+	for _, f := range files {
+		for i, source := range f.Sources {
+			imported, file, err := source.load()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.at(f.sourceLines, i), err)
+			}
+			sources = append(sources, file)
+			for _, device := range imported {
+				if _, exists := devices[device.Name]; !exists {
+					devices[device.Name] = device
+				}
+			}
 		}
-		if device.Broadcast == "" {
-			return nil, fmt.Errorf("device broadcast address cannot be empty for device: %s", device.Name)
+	}
+
+	var schedules []Schedule
+	names := make(map[string]string) // Location of each schedule
+	for _, f := range files {
+		for i, schedule := range f.Schedules {
+			at := f.at(f.scheduleLines, i)
+			if err := schedule.validate(devices); err != nil {
+				return nil, fmt.Errorf("%s: %w", at, err)
+			}
+			if first, exists := names[schedule.Name]; exists {
+				return nil, fmt.Errorf("%s: schedule %s is already defined at %s", at, schedule.Name, first)
+			}
+			names[schedule.Name] = at
+			schedules = append(schedules, schedule)
 		}
-		manager.devices[device.Name] = device
 	}
 
-	return manager, nil
+	return &loadedConfig{devices: devices, schedules: schedules, sources: sources}, nil
+}
+
+// validateDevice checks the settings of a device of the configuration.
+func validateDevice(device Device) error {
+	if device.Name == "" {
+		return fmt.Errorf("device name cannot be empty")
+	}
+	if device.Mac == "" && device.Host == "" {
+		return fmt.Errorf("device MAC address or host must be set for device: %s", device.Name)
+	}
+	if device.Shutdown != nil {
+		if err := device.Shutdown.Validate(); err != nil {
+			return fmt.Errorf("invalid shutdown config for device %s: %w", device.Name, err)
+		}
+	}
+	if device.Agent != nil && device.Agent.Token == "" {
+		return fmt.Errorf("agent token cannot be empty for device: %s", device.Name)
+	}
+	if slices.Contains(device.Groups, "") {
+		return fmt.Errorf("group name cannot be empty for device: %s", device.Name)
+	}
+	return nil
 }
 
 // GetDevice retrieves a device by its name.
 func (m *Manager) GetDevice(name string) (Device, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	device, exists := m.devices[name]
 	if !exists {
 		return Device{}, fmt.Errorf("device not found: %s", name)
 	}
-	return device, nil
+	return m.withAddress(device), nil
 }
 
 // ListDevices returns all registered devices.
 func (m *Manager) ListDevices() []Device {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	devices := make([]Device, 0, len(m.devices))
 	for _, device := range m.devices {
-		devices = append(devices, device)
+		devices = append(devices, m.withAddress(device))
 	}
 	return devices
 }
 
+// ListGroups returns the sorted member names of every group.
+func (m *Manager) ListGroups() map[string][]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	groups := make(map[string][]string)
+	for _, device := range m.devices {
+		for _, group := range device.Groups {
+			groups[group] = append(groups[group], device.Name)
+		}
+	}
+	for _, members := range groups {
+		slices.Sort(members)
+	}
+	return groups
+}
+
+// GetGroup returns the devices of a group sorted by name.
+func (m *Manager) GetGroup(name string) ([]Device, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var devices []Device
+	for _, device := range m.devices {
+		if slices.Contains(device.Groups, name) {
+			devices = append(devices, m.withAddress(device))
+		}
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("group not found: %s", name)
+	}
+	slices.SortFunc(devices, func(a, b Device) int { return strings.Compare(a.Name, b.Name) })
+	return devices, nil
+}
+
 // HasDevice checks if a device exists by name.
 func (m *Manager) HasDevice(name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	_, exists := m.devices[name]
 	return exists
 }
+
+// Heartbeat is a liveness report sent by a device's homeguard-agent.
+type Heartbeat struct {
+	Device   string
+	Hostname string
+	IP       string
+	Mac      string
+	Uptime   time.Duration
+	Interval time.Duration // How often the agent reports
+}
+
+// Status describes what is known about a device at runtime.
+type Status struct {
+	Online   bool
+	LastSeen time.Time
+	Hostname string
+	IP       string
+	Uptime   time.Duration
+
+	// Address tracking: when the device was last seen in the neighbour
+	// table, with the MAC address it answered with, and the latest changes
+	// of its addresses, oldest first.
+	Mac            string
+	AddressSeen    time.Time
+	AddressChanges []AddressChange
+
+	expires time.Time
+}
+
+// missedHeartbeats is how many agent intervals may pass before a device is
+// considered offline.
+const missedHeartbeats = 3
+
+// RecordHeartbeat updates the runtime status of a device from an agent
+// heartbeat. The MAC address reported by the agent is recorded like one seen
+// in the neighbour table, but does not replace the configured one, nor one
+// the neighbour table learned. It returns the address changes.
+func (m *Manager) RecordHeartbeat(hb Heartbeat) ([]AddressChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	device, exists := m.devices[hb.Device]
+	if !exists {
+		return nil, fmt.Errorf("device not found: %s", hb.Device)
+	}
+	var mac net.HardwareAddr
+	if hb.Mac != "" {
+		var err error
+		if mac, err = net.ParseMAC(hb.Mac); err != nil {
+			return nil, fmt.Errorf("invalid MAC address %q: %w", hb.Mac, err)
+		}
+	}
+
+	now := time.Now()
+	m.status[hb.Device] = Status{
+		LastSeen: now,
+		Hostname: hb.Hostname,
+		IP:       hb.IP,
+		Uptime:   hb.Uptime,
+		expires:  now.Add(missedHeartbeats * hb.Interval),
+	}
+
+	if mac == nil {
+		return nil, nil
+	}
+	if learned, ok := m.learned[hb.Device]; ok && learned.mac != "" && !learned.fromAgent {
+		return nil, nil
+	}
+	ip, _ := netip.ParseAddr(hb.IP)
+	if change, ok := m.learn(device, ip.Unmap(), mac.String(), "", now, true); ok {
+		return []AddressChange{change}, nil
+	}
+	return nil, nil
+}
+
+// GetStatus returns the runtime status of a device. Devices that have never
+// sent a heartbeat, or whose agent stopped reporting, are offline.
+func (m *Manager) GetStatus(name string) (Status, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, exists := m.devices[name]; !exists {
+		return Status{}, fmt.Errorf("device not found: %s", name)
+	}
+
+	status := m.status[name]
+	status.Online = !status.LastSeen.IsZero() && time.Now().Before(status.expires)
+	if learned, ok := m.learned[name]; ok {
+		// The most recent of the agent and the neighbour table wins
+		if learned.seen.After(status.LastSeen) {
+			status.IP = learned.ip.String()
+		}
+		status.Mac = learned.mac
+		status.AddressSeen = learned.seen
+		status.AddressChanges = slices.Clone(learned.changes)
+	}
+	return status, nil
+}
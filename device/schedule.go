@@ -0,0 +1,108 @@
+package device
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Schedule wakes or shuts down a device or group at a fixed time of day.
+type Schedule struct {
+	Name   string   `yaml:"name"`
+	Device string   `yaml:"device,omitempty"`
+	Group  string   `yaml:"group,omitempty"`
+	Action string   `yaml:"action,omitempty"` // "wake" (default) or "shutdown"
+	At     string   `yaml:"at"`               // Local time of day, HH:MM
+	Days   []string `yaml:"days,omitempty"`   // mon … sun; every day when empty
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// validate checks the schedule against the configured devices.
+func (s Schedule) validate(devices map[string]Device) error {
+	if s.Name == "" {
+		return fmt.Errorf("schedule name cannot be empty")
+	}
+	if (s.Device == "") == (s.Group == "") {
+		return fmt.Errorf("schedule %s must name either a device or a group", s.Name)
+	}
+	if s.Device != "" {
+		if _, exists := devices[s.Device]; !exists {
+			return fmt.Errorf("schedule %s: device not found: %s", s.Name, s.Device)
+		}
+	}
+	if s.Group != "" && !hasGroup(devices, s.Group) {
+		return fmt.Errorf("schedule %s: group not found: %s", s.Name, s.Group)
+	}
+	switch s.Action {
+	case "", "wake", "shutdown":
+	default:
+		return fmt.Errorf("schedule %s: unknown action: %q", s.Name, s.Action)
+	}
+	if _, err := time.Parse("15:04", s.At); err != nil {
+		return fmt.Errorf("schedule %s: invalid time %q, want HH:MM", s.Name, s.At)
+	}
+	for _, day := range s.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("schedule %s: unknown day: %q", s.Name, day)
+		}
+	}
+	return nil
+}
+
+func hasGroup(devices map[string]Device, group string) bool {
+	for _, device := range devices {
+		if slices.Contains(device.Groups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the first time after t at which the schedule runs, in t's
+// location. It returns the zero time for an invalid schedule.
+func (s Schedule) Next(t time.Time) time.Time {
+	at, err := time.Parse("15:04", s.At)
+	if err != nil {
+		return time.Time{}
+	}
+
+	for i := range 8 {
+		next := time.Date(t.Year(), t.Month(), t.Day()+i, at.Hour(), at.Minute(), 0, 0, t.Location())
+		if next.After(t) && s.runsOn(next.Weekday()) {
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+func (s Schedule) runsOn(day time.Weekday) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, d := range s.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// ListSchedules returns all schedules sorted by name.
+func (m *Manager) ListSchedules() []Schedule {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	schedules := slices.Clone(m.schedules)
+	slices.SortFunc(schedules, func(a, b Schedule) int { return strings.Compare(a.Name, b.Name) })
+	return schedules
+}
@@ -0,0 +1,126 @@
+package device
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // Europe/Berlin for the DST cases, on any host
+)
+
+func TestScheduleNext(t *testing.T) {
+	// 2026-03-03 is a Tuesday
+	tue := func(hour, min int) time.Time { return time.Date(2026, 3, 3, hour, min, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		from     time.Time
+		want     time.Time
+	}{
+		{
+			name:     "later today",
+			schedule: Schedule{At: "07:30"},
+			from:     tue(6, 0),
+			want:     tue(7, 30),
+		},
+		{
+			name:     "exactly at the time runs tomorrow",
+			schedule: Schedule{At: "07:30"},
+			from:     tue(7, 30),
+			want:     time.Date(2026, 3, 4, 7, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "past the time runs tomorrow",
+			schedule: Schedule{At: "07:30"},
+			from:     tue(8, 0),
+			want:     time.Date(2026, 3, 4, 7, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "skips days not listed",
+			schedule: Schedule{At: "07:30", Days: []string{"thu", "fri"}},
+			from:     tue(6, 0),
+			want:     time.Date(2026, 3, 5, 7, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "day names are case insensitive",
+			schedule: Schedule{At: "07:30", Days: []string{"Thu"}},
+			from:     tue(6, 0),
+			want:     time.Date(2026, 3, 5, 7, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "wraps past the end of the week",
+			schedule: Schedule{At: "07:30", Days: []string{"mon"}},
+			from:     time.Date(2026, 3, 7, 12, 0, 0, 0, time.UTC), // Saturday
+			want:     time.Date(2026, 3, 9, 7, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "same weekday after the time runs a week later",
+			schedule: Schedule{At: "07:30", Days: []string{"tue"}},
+			from:     tue(8, 0),
+			want:     time.Date(2026, 3, 10, 7, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "wraps past the end of the month and year",
+			schedule: Schedule{At: "00:15"},
+			from:     time.Date(2026, 12, 31, 23, 0, 0, 0, time.UTC),
+			want:     time.Date(2027, 1, 1, 0, 15, 0, 0, time.UTC),
+		},
+		{
+			name:     "invalid time",
+			schedule: Schedule{At: "7:30pm"},
+			from:     tue(6, 0),
+			want:     time.Time{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Schedule{At: "02:30"}
+
+	// On 2026-03-29 clocks go from 02:00 CET to 03:00 CEST, so 02:30 does not
+	// exist and time.Date normalizes it to 03:30 CEST
+	from := time.Date(2026, 3, 29, 1, 0, 0, 0, berlin)
+	got := s.Next(from)
+	want := time.Date(2026, 3, 29, 3, 30, 0, 0, berlin)
+	if !got.Equal(want) {
+		t.Fatalf("Next(%v) = %v, want %v", from, got, want)
+	}
+
+	// The normalized run happens once; the next one is the following day
+	got = s.Next(got)
+	want = time.Date(2026, 3, 30, 2, 30, 0, 0, berlin)
+	if !got.Equal(want) {
+		t.Errorf("Next after the skipped run = %v, want %v", got, want)
+	}
+
+	// A schedule checked a minute before and after the normalized time
+	// fires exactly once, as runSchedules does
+	before := time.Date(2026, 3, 29, 3, 29, 0, 0, berlin)
+	after := before.Add(time.Minute)
+	if next := s.Next(before); next.After(after) {
+		t.Errorf("schedule did not fire between %v and %v, next run %v", before, after, next)
+	}
+	if next := s.Next(after); !next.After(after.Add(time.Minute)) {
+		t.Errorf("schedule fired again after %v, next run %v", after, next)
+	}
+
+	// On 2026-10-25 02:30 happens twice; the schedule still runs once that day
+	from = time.Date(2026, 10, 25, 1, 0, 0, 0, berlin)
+	got = s.Next(from)
+	if got.Day() != 25 || got.Hour() != 2 || got.Minute() != 30 {
+		t.Fatalf("Next(%v) = %v, want 02:30 on the 25th", from, got)
+	}
+	if next := s.Next(got); next.Day() != 26 {
+		t.Errorf("Next after the fall-back run = %v, want the 26th", next)
+	}
+}
@@ -0,0 +1,583 @@
+package device
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Device source types.
+const (
+	SourceDnsmasq = "dnsmasq" // dhcp-host= lines of a dnsmasq configuration file
+	SourceISC     = "isc"     // host { } blocks of an ISC dhcpd.conf
+	SourceKea     = "kea"     // Reservations of a Kea DHCPv4 JSON configuration
+	SourceOpenWrt = "openwrt" // config host sections of OpenWrt's /etc/config/dhcp
+)
+
+// sourcePollInterval is how often WatchSources checks the source files.
+const sourcePollInterval = 10 * time.Second
+
+// Source imports the static leases of a DHCP server as devices. Only
+// reservations with a MAC address and a hostname are imported.
+type Source struct {
+	Type      string   `yaml:"type"`                // dnsmasq, isc, kea or openwrt
+	Path      string   `yaml:"path"`                // File to read
+	Broadcast string   `yaml:"broadcast,omitempty"` // Default: from the subnet, or the /24 of the reserved address
+	Groups    []string `yaml:"groups,omitempty"`    // Groups of every imported device
+}
+
+// sourceFile records the state of a source file when it was read.
+type sourceFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// reservation is a static lease read from a source.
+type reservation struct {
+	name      string
+	mac       net.HardwareAddr
+	ip        netip.Addr // May be invalid
+	broadcast string     // From the subnet declaration, if any
+}
+
+// load reads the devices of the source.
+func (s Source) load() ([]Device, sourceFile, error) {
+	file := sourceFile{path: s.Path}
+	if s.Path == "" {
+		return nil, file, fmt.Errorf("device source path cannot be empty")
+	}
+	if s.Broadcast != "" {
+		if _, err := netip.ParseAddr(s.Broadcast); err != nil {
+			return nil, file, fmt.Errorf("invalid broadcast address for device source %s: %s", s.Path, s.Broadcast)
+		}
+	}
+	if slices.Contains(s.Groups, "") {
+		return nil, file, fmt.Errorf("group name cannot be empty for device source: %s", s.Path)
+	}
+
+	var parse func([]byte) ([]reservation, error)
+	switch s.Type {
+	case SourceDnsmasq:
+		parse = parseDnsmasqHosts
+	case SourceISC:
+		parse = parseISCHosts
+	case SourceKea:
+		parse = parseKeaReservations
+	case SourceOpenWrt:
+		parse = parseOpenWrtHosts
+	default:
+		return nil, file, fmt.Errorf("unknown device source type %q for %s", s.Type, s.Path)
+	}
+
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return nil, file, fmt.Errorf("failed to read device source: %w", err)
+	}
+	file.modTime, file.size = info.ModTime(), info.Size()
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, file, fmt.Errorf("failed to read device source: %w", err)
+	}
+	reservations, err := parse(data)
+	if err != nil {
+		return nil, file, fmt.Errorf("failed to parse device source %s: %w", s.Path, err)
+	}
+
+	var devices []Device
+	seen := make(map[string]bool)
+	for _, r := range reservations {
+		// The first reservation of a name wins, as in the DHCP servers
+		if r.name == "" || seen[r.name] {
+			continue
+		}
+		seen[r.name] = true
+
+		broadcast := s.Broadcast
+		if broadcast == "" {
+			broadcast = r.broadcast
+		}
+		if broadcast == "" && r.ip.Is4() {
+			broadcast = broadcastOf(netip.PrefixFrom(r.ip, 24))
+		}
+		if broadcast == "" {
+			broadcast = "255.255.255.255"
+		}
+		devices = append(devices, Device{
+			Name:      r.name,
+			Mac:       r.mac.String(),
+			Broadcast: broadcast,
+			Groups:    slices.Clone(s.Groups),
+			Source:    s.Path,
+		})
+	}
+	return devices, file, nil
+}
+
+// stat returns the current state of the file. A missing file has a zero
+// modification time.
+func (f sourceFile) stat() sourceFile {
+	current := sourceFile{path: f.path}
+	if info, err := os.Stat(f.path); err == nil {
+		current.modTime, current.size = info.ModTime(), info.Size()
+	}
+	return current
+}
+
+// WatchSources reloads the configuration whenever a device source file
+// changes, until ctx is done. changed is called with the result of each
+// reload. A failed reload is not retried until a file changes again.
+func (m *Manager) WatchSources(ctx context.Context, changed func(error)) {
+	ticker := time.NewTicker(sourcePollInterval)
+	defer ticker.Stop()
+	seen := make(map[string]sourceFile)
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		m.mu.RLock()
+		sources := slices.Clone(m.sources)
+		m.mu.RUnlock()
+		modified := false
+		for _, f := range sources {
+			last, ok := seen[f.path]
+			if !ok {
+				last = f // As it was when loaded
+			}
+			current := f.stat()
+			seen[f.path] = current
+			if !current.modTime.Equal(last.modTime) || current.size != last.size {
+				modified = true
+			}
+		}
+		if modified {
+			changed(m.Reload())
+		}
+	}
+}
+
+// broadcastOf returns the last address of an IPv4 prefix.
+func broadcastOf(p netip.Prefix) string {
+	b := p.Masked().Addr().As4()
+	for i := p.Bits(); i < 32; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	return netip.AddrFrom4(b).String()
+}
+
+// parseDnsmasqHosts reads the dhcp-host options of a dnsmasq configuration
+// file:
+//
+//	dhcp-host=00:11:22:33:44:55,set:office,192.168.1.10,desktop,12h
+//
+// Fields are told apart by their form; the hostname is the one that is not
+// an address, tag, client ID or lease time.
+func parseDnsmasqHosts(data []byte) ([]reservation, error) {
+	var reservations []reservation
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		value, ok := strings.CutPrefix(line, "dhcp-host=")
+		if !ok {
+			continue
+		}
+
+		var r reservation
+		ignored := false
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if mac, err := net.ParseMAC(field); err == nil {
+				if r.mac == nil {
+					r.mac = mac
+				}
+				continue
+			}
+			if ip, err := netip.ParseAddr(strings.Trim(field, "[]")); err == nil {
+				if ip.Is4() {
+					r.ip = ip
+				}
+				continue
+			}
+			switch {
+			case field == "", field == "*", field == "infinite":
+			case field == "ignore":
+				ignored = true
+			case strings.HasPrefix(field, "id:"), strings.HasPrefix(field, "set:"),
+				strings.HasPrefix(field, "tag:"), strings.Contains(field, ":"):
+				// Client IDs, tags and MAC addresses with wildcards or a
+				// hardware type
+			case isLeaseTime(field):
+			default:
+				r.name = field
+			}
+		}
+		if !ignored && r.mac != nil && r.name != "" {
+			reservations = append(reservations, r)
+		}
+	}
+	return reservations, scanner.Err()
+}
+
+// isLeaseTime reports whether s is a dnsmasq lease time such as 3600, 45m,
+// 12h, 1d or 1w.
+func isLeaseTime(s string) bool {
+	s = strings.TrimRight(s, "smhdw")
+	_, err := strconv.ParseUint(s, 10, 32)
+	return err == nil
+}
+
+// iscToken is a word or punctuation of an ISC dhcpd.conf.
+type iscToken struct {
+	text   string
+	quoted bool
+}
+
+// tokenizeISC splits an ISC dhcpd.conf into words, quoted strings and the
+// punctuation "{", "}" and ";", dropping comments.
+func tokenizeISC(data []byte) ([]iscToken, error) {
+	var tokens []iscToken
+	s := string(data)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '#':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '{' || c == '}' || c == ';':
+			tokens = append(tokens, iscToken{text: string(c)})
+			i++
+		case c == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, iscToken{text: s[i+1 : i+1+end], quoted: true})
+			i += end + 2
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t\r\n{};#\"", rune(s[i])) {
+				i++
+			}
+			tokens = append(tokens, iscToken{text: s[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+// parseISCHosts reads the host declarations of an ISC dhcpd.conf:
+//
+//	subnet 192.168.1.0 netmask 255.255.255.0 {
+//	  option broadcast-address 192.168.1.255;
+//	}
+//	host desktop {
+//	  hardware ethernet 00:11:22:33:44:55;
+//	  fixed-address 192.168.1.10;
+//	}
+//
+// A host gets the broadcast address of the subnet declaration it is in, or
+// else of the subnet that contains its fixed address.
+func parseISCHosts(data []byte) ([]reservation, error) {
+	tokens, err := tokenizeISC(data)
+	if err != nil {
+		return nil, err
+	}
+
+	type subnet struct {
+		prefix    netip.Prefix
+		broadcast string
+	}
+	type block struct {
+		header []string
+		host   *reservation
+		subnet *subnet
+	}
+
+	var reservations []*reservation
+	var subnets []*subnet
+	var stack []*block
+	var statement []string
+
+	inner := func(kind string) *block {
+		for i := len(stack) - 1; i >= 0; i-- {
+			if len(stack[i].header) > 0 && stack[i].header[0] == kind {
+				return stack[i]
+			}
+		}
+		return nil
+	}
+
+	for _, t := range tokens {
+		if t.quoted {
+			statement = append(statement, t.text)
+			continue
+		}
+		switch t.text {
+		case "{":
+			b := &block{header: statement}
+			switch {
+			case len(statement) >= 2 && statement[0] == "host":
+				b.host = &reservation{name: statement[1]}
+				if s := inner("subnet"); s != nil {
+					b.host.broadcast = s.subnet.broadcast
+				}
+				reservations = append(reservations, b.host)
+			case len(statement) >= 4 && statement[0] == "subnet" && statement[2] == "netmask":
+				network, err1 := netip.ParseAddr(statement[1])
+				mask := net.ParseIP(statement[3]).To4()
+				if err1 == nil && network.Is4() && mask != nil {
+					bits, _ := net.IPMask(mask).Size()
+					prefix := netip.PrefixFrom(network, bits)
+					b.subnet = &subnet{prefix: prefix, broadcast: broadcastOf(prefix)}
+					subnets = append(subnets, b.subnet)
+				}
+			}
+			stack = append(stack, b)
+			statement = nil
+
+		case "}":
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("unexpected }")
+			}
+			stack = stack[:len(stack)-1]
+			statement = nil
+
+		case ";":
+			if len(stack) > 0 {
+				b := stack[len(stack)-1]
+				switch {
+				case b.host != nil && len(statement) == 3 && statement[0] == "hardware" && statement[1] == "ethernet":
+					if mac, err := net.ParseMAC(statement[2]); err == nil {
+						b.host.mac = mac
+					}
+				case b.host != nil && len(statement) >= 2 && statement[0] == "fixed-address":
+					if ip, err := netip.ParseAddr(strings.TrimSuffix(statement[1], ",")); err == nil && ip.Is4() {
+						b.host.ip = ip
+					}
+				case b.subnet != nil && len(statement) == 3 && statement[0] == "option" && statement[1] == "broadcast-address":
+					if ip, err := netip.ParseAddr(statement[2]); err == nil {
+						b.subnet.broadcast = ip.String()
+					}
+				}
+			}
+			statement = nil
+
+		default:
+			statement = append(statement, t.text)
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("missing }")
+	}
+
+	var result []reservation
+	for _, r := range reservations {
+		if r.mac == nil {
+			continue
+		}
+		if r.broadcast == "" && r.ip.IsValid() {
+			for _, s := range subnets {
+				if s.prefix.Contains(r.ip) {
+					r.broadcast = s.broadcast
+					break
+				}
+			}
+		}
+		result = append(result, *r)
+	}
+	return result, nil
+}
+
+// keaReservation is a host reservation of a Kea configuration.
+type keaReservation struct {
+	HWAddress string `json:"hw-address"`
+	IPAddress string `json:"ip-address"`
+	Hostname  string `json:"hostname"`
+}
+
+// keaSubnet is a subnet4 entry of a Kea configuration.
+type keaSubnet struct {
+	Subnet       string           `json:"subnet"`
+	Reservations []keaReservation `json:"reservations"`
+}
+
+// parseKeaReservations reads the host reservations of a Kea DHCPv4
+// configuration: global ones, and those of subnet4 entries at the top level
+// or in shared networks. Reservations of a subnet get its broadcast
+// address.
+func parseKeaReservations(data []byte) ([]reservation, error) {
+	var config struct {
+		Dhcp4 struct {
+			Reservations   []keaReservation `json:"reservations"`
+			Subnet4        []keaSubnet      `json:"subnet4"`
+			SharedNetworks []struct {
+				Subnet4 []keaSubnet `json:"subnet4"`
+			} `json:"shared-networks"`
+		} `json:"Dhcp4"`
+	}
+	if err := json.Unmarshal(stripJSONComments(data), &config); err != nil {
+		return nil, err
+	}
+
+	var reservations []reservation
+	add := func(list []keaReservation, broadcast string) {
+		for _, k := range list {
+			mac, err := net.ParseMAC(k.HWAddress)
+			if err != nil {
+				continue
+			}
+			r := reservation{name: k.Hostname, mac: mac, broadcast: broadcast}
+			if ip, err := netip.ParseAddr(k.IPAddress); err == nil && ip.Is4() {
+				r.ip = ip
+			}
+			reservations = append(reservations, r)
+		}
+	}
+	addSubnets := func(subnets []keaSubnet) {
+		for _, s := range subnets {
+			broadcast := ""
+			if prefix, err := netip.ParsePrefix(s.Subnet); err == nil && prefix.Addr().Is4() {
+				broadcast = broadcastOf(prefix)
+			}
+			add(s.Reservations, broadcast)
+		}
+	}
+
+	add(config.Dhcp4.Reservations, "")
+	addSubnets(config.Dhcp4.Subnet4)
+	for _, network := range config.Dhcp4.SharedNetworks {
+		addSubnets(network.Subnet4)
+	}
+	return reservations, nil
+}
+
+// stripJSONComments removes the //, /* */ and # comments Kea allows in its
+// configuration files.
+func stripJSONComments(data []byte) []byte {
+	var out bytes.Buffer
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString:
+			out.WriteByte(c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out.WriteByte(data[i])
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+			out.WriteByte(c)
+		case c == '#' || (c == '/' && i+1 < len(data) && data[i+1] == '/'):
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			out.WriteByte('\n')
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return out.Bytes()
+			}
+			i += end + 3
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.Bytes()
+}
+
+// parseOpenWrtHosts reads the static leases of OpenWrt's /etc/config/dhcp
+// in UCI format:
+//
+//	config host
+//		option name 'desktop'
+//		option mac '00:11:22:33:44:55'
+//		option ip '192.168.1.10'
+//
+// A host with several MAC addresses is imported with the first.
+func parseOpenWrtHosts(data []byte) ([]reservation, error) {
+	var reservations []reservation
+	var current *reservation
+	flush := func() {
+		if current != nil && current.mac != nil {
+			reservations = append(reservations, *current)
+		}
+		current = nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := uciFields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "config":
+			flush()
+			if len(fields) >= 2 && fields[1] == "host" {
+				current = &reservation{}
+			}
+		case "option", "list":
+			if current == nil || len(fields) < 3 {
+				continue
+			}
+			switch fields[1] {
+			case "name":
+				current.name = fields[2]
+			case "mac":
+				// Several MAC addresses may be given in one option
+				if macs := strings.Fields(fields[2]); current.mac == nil && len(macs) > 0 {
+					if mac, err := net.ParseMAC(macs[0]); err == nil {
+						current.mac = mac
+					}
+				}
+			case "ip":
+				if ip, err := netip.ParseAddr(fields[2]); err == nil && ip.Is4() {
+					current.ip = ip
+				}
+			}
+		}
+	}
+	flush()
+	return reservations, scanner.Err()
+}
+
+// uciFields splits a UCI line into words, removing the quotes of quoted
+// values and a trailing comment.
+func uciFields(line string) []string {
+	var fields []string
+	for line = strings.TrimSpace(line); line != "" && line[0] != '#'; line = strings.TrimSpace(line) {
+		if q := line[0]; q == '\'' || q == '"' {
+			end := strings.IndexByte(line[1:], q)
+			if end < 0 {
+				return append(fields, line[1:])
+			}
+			fields = append(fields, line[1:1+end])
+			line = line[end+2:]
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		fields = append(fields, line[:end])
+		line = line[end:]
+	}
+	return fields
+}
@@ -0,0 +1,289 @@
+package device
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// lease is a reservation in comparable form.
+type lease struct {
+	name, mac, ip, broadcast string
+}
+
+func leases(reservations []reservation) []lease {
+	var out []lease
+	for _, r := range reservations {
+		l := lease{name: r.name, mac: r.mac.String(), broadcast: r.broadcast}
+		if r.ip.IsValid() {
+			l.ip = r.ip.String()
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+type parserTest struct {
+	name    string
+	in      string
+	want    []lease
+	wantErr string
+}
+
+func runParserTests(t *testing.T, parse func([]byte) ([]reservation, error), tests []parserTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parse([]byte(tt.in))
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(leases(got), tt.want) {
+				t.Errorf("reservations = %+v, want %+v", leases(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDnsmasqHosts(t *testing.T) {
+	runParserTests(t, parseDnsmasqHosts, []parserTest{
+		{
+			name: "MAC, address and name",
+			in:   "dhcp-host=00:11:22:33:44:55,192.168.1.10,desktop\n",
+			want: []lease{{name: "desktop", mac: "00:11:22:33:44:55", ip: "192.168.1.10"}},
+		},
+		{
+			name: "fields in any order with tags and lease time",
+			in:   "dhcp-host=set:office,nas,12h,[fe80::1],AA-BB-CC-DD-EE-FF,192.168.1.20\n",
+			want: []lease{{name: "nas", mac: "aa:bb:cc:dd:ee:ff", ip: "192.168.1.20"}},
+		},
+		{
+			name: "first of several MAC addresses",
+			in:   "dhcp-host=00:11:22:33:44:55,00:11:22:33:44:66,laptop,infinite\n",
+			want: []lease{{name: "laptop", mac: "00:11:22:33:44:55"}},
+		},
+		{
+			name: "client ID and wildcard MAC",
+			in:   "dhcp-host=id:01:02:03,printer\ndhcp-host=00:11:22:*:*:*,tag:lab,phone\n",
+		},
+		{
+			name: "ignored host",
+			in:   "dhcp-host=00:11:22:33:44:55,desktop,ignore\n",
+		},
+		{
+			name: "without a name",
+			in:   "dhcp-host=00:11:22:33:44:55,192.168.1.10,1d\n",
+		},
+		{
+			name: "other options and comments",
+			in: `# dnsmasq.conf
+domain=lan
+dhcp-range=192.168.1.100,192.168.1.200,12h
+  dhcp-host=00:11:22:33:44:55,desktop
+#dhcp-host=00:11:22:33:44:66,old
+`,
+			want: []lease{{name: "desktop", mac: "00:11:22:33:44:55"}},
+		},
+	})
+}
+
+func TestParseISCHosts(t *testing.T) {
+	runParserTests(t, parseISCHosts, []parserTest{
+		{
+			name: "host in a subnet with a broadcast option",
+			in: `subnet 192.168.1.0 netmask 255.255.255.0 {
+  option broadcast-address 192.168.1.127;
+  host desktop {
+    hardware ethernet 00:11:22:33:44:55;
+    fixed-address 192.168.1.10;
+  }
+}
+`,
+			want: []lease{{name: "desktop", mac: "00:11:22:33:44:55", ip: "192.168.1.10", broadcast: "192.168.1.127"}},
+		},
+		{
+			name: "top-level host matched to its subnet",
+			in: `subnet 10.0.0.0 netmask 255.255.0.0 { }
+host "nas" { # Storage
+  hardware ethernet aa:bb:cc:dd:ee:ff; fixed-address 10.0.5.20, 10.0.5.21;
+}
+`,
+			want: []lease{{name: "nas", mac: "aa:bb:cc:dd:ee:ff", ip: "10.0.5.20", broadcast: "10.0.255.255"}},
+		},
+		{
+			name: "host outside any subnet",
+			in:   "host printer { hardware ethernet 00:11:22:33:44:66; fixed-address 172.16.0.9; }\n",
+			want: []lease{{name: "printer", mac: "00:11:22:33:44:66", ip: "172.16.0.9"}},
+		},
+		{
+			name: "host in a group without hardware",
+			in:   "group { host pxe { fixed-address 192.168.1.50; } }\n",
+		},
+		{
+			name:    "unbalanced braces",
+			in:      "host desktop { hardware ethernet 00:11:22:33:44:55;\n",
+			wantErr: "missing }",
+		},
+		{
+			name:    "extra brace",
+			in:      "}\n",
+			wantErr: "unexpected }",
+		},
+		{
+			name:    "unterminated string",
+			in:      "host \"desktop {\n",
+			wantErr: "unterminated string",
+		},
+	})
+}
+
+func TestParseKeaReservations(t *testing.T) {
+	runParserTests(t, parseKeaReservations, []parserTest{
+		{
+			name: "global, subnet and shared network reservations",
+			in: `{
+  // Kea allows comments
+  "Dhcp4": {
+    "reservations": [
+      {"hw-address": "00:11:22:33:44:55", "hostname": "desktop"}
+    ],
+    # Shell-style too
+    "subnet4": [{
+      "subnet": "192.168.1.0/24",
+      "reservations": [
+        {"hw-address": "00:11:22:33:44:66", "ip-address": "192.168.1.20", "hostname": "nas"},
+        {"client-id": "01:02:03", "hostname": "printer"}
+      ]
+    }],
+    /* Block
+       comments */
+    "shared-networks": [{
+      "name": "lab",
+      "subnet4": [{
+        "subnet": "10.1.0.0/16",
+        "reservations": [{"hw-address": "00:11:22:33:44:77", "hostname": "pi//lab"}]
+      }]
+    }]
+  }
+}
+`,
+			want: []lease{
+				{name: "desktop", mac: "00:11:22:33:44:55"},
+				{name: "nas", mac: "00:11:22:33:44:66", ip: "192.168.1.20", broadcast: "192.168.1.255"},
+				{name: "pi//lab", mac: "00:11:22:33:44:77", broadcast: "10.1.255.255"},
+			},
+		},
+		{
+			name: "reservation without a hostname",
+			in:   `{"Dhcp4": {"reservations": [{"hw-address": "00:11:22:33:44:55"}]}}`,
+			want: []lease{{mac: "00:11:22:33:44:55"}},
+		},
+		{
+			name:    "invalid JSON",
+			in:      `{"Dhcp4": {`,
+			wantErr: "unexpected end of JSON input",
+		},
+	})
+}
+
+func TestParseOpenWrtHosts(t *testing.T) {
+	runParserTests(t, parseOpenWrtHosts, []parserTest{
+		{
+			name: "hosts among other sections",
+			in: `config dnsmasq
+	option domain 'lan'
+
+config host
+	option name 'desktop'
+	option mac '00:11:22:33:44:55'
+	option ip '192.168.1.10'
+
+config host # Storage
+	option name "nas"
+	list mac 'AA:BB:CC:DD:EE:FF'
+	list mac '00:11:22:33:44:66'
+	option ip 192.168.1.20
+
+config dhcp 'lan'
+	option name 'ignored'
+`,
+			want: []lease{
+				{name: "desktop", mac: "00:11:22:33:44:55", ip: "192.168.1.10"},
+				{name: "nas", mac: "aa:bb:cc:dd:ee:ff", ip: "192.168.1.20"},
+			},
+		},
+		{
+			name: "several MAC addresses in one option",
+			in:   "config host\n\toption name 'laptop'\n\toption mac '00:11:22:33:44:55 00:11:22:33:44:66'\n",
+			want: []lease{{name: "laptop", mac: "00:11:22:33:44:55"}},
+		},
+		{
+			name: "empty MAC",
+			in:   "config host\n\toption name 'laptop'\n\toption mac ''\n",
+		},
+		{
+			name: "host without a MAC",
+			in:   "config host\n\toption name 'laptop'\n\toption ip '192.168.1.30'\n",
+		},
+	})
+}
+
+func TestSourceLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dnsmasq.conf")
+	data := `dhcp-host=00:11:22:33:44:55,192.168.1.10,desktop
+dhcp-host=00:11:22:33:44:66,desktop
+dhcp-host=00:11:22:33:44:77,nas
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		source  Source
+		want    []Device
+		wantErr string
+	}{
+		{
+			name:   "broadcast from the address, else limited",
+			source: Source{Type: SourceDnsmasq, Path: path},
+			want: []Device{
+				{Name: "desktop", Mac: "00:11:22:33:44:55", Broadcast: "192.168.1.255", Source: path},
+				{Name: "nas", Mac: "00:11:22:33:44:77", Broadcast: "255.255.255.255", Source: path},
+			},
+		},
+		{
+			name:   "configured broadcast and groups",
+			source: Source{Type: SourceDnsmasq, Path: path, Broadcast: "10.0.0.255", Groups: []string{"lan"}},
+			want: []Device{
+				{Name: "desktop", Mac: "00:11:22:33:44:55", Broadcast: "10.0.0.255", Groups: []string{"lan"}, Source: path},
+				{Name: "nas", Mac: "00:11:22:33:44:77", Broadcast: "10.0.0.255", Groups: []string{"lan"}, Source: path},
+			},
+		},
+		{name: "unknown type", source: Source{Type: "dhcpcd", Path: path}, wantErr: "unknown device source type"},
+		{name: "no path", source: Source{Type: SourceDnsmasq}, wantErr: "path cannot be empty"},
+		{name: "invalid broadcast", source: Source{Type: SourceDnsmasq, Path: path, Broadcast: "lan"}, wantErr: "invalid broadcast address"},
+		{name: "empty group", source: Source{Type: SourceDnsmasq, Path: path, Groups: []string{""}}, wantErr: "group name cannot be empty"},
+		{name: "missing file", source: Source{Type: SourceDnsmasq, Path: filepath.Join(dir, "missing")}, wantErr: "failed to read device source"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := tt.source.load()
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("devices = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
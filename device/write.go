@@ -0,0 +1,240 @@
+package device
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/p3ddd/HomeGuard/configfile"
+)
+
+// ErrExists is returned by AddDevice for a name or MAC address that is
+// already configured.
+var ErrExists = errors.New("device already exists")
+
+// AddDevice appends dev to the devices of the configuration file and reloads
+// it. The file is edited in place, so its comments and the order of its
+// entries are kept. Only YAML files can be edited.
+func (m *Manager) AddDevice(dev Device) error {
+	if dev.Name == "" {
+		return fmt.Errorf("device name cannot be empty")
+	}
+	mac, err := net.ParseMAC(dev.Mac)
+	if err != nil {
+		return fmt.Errorf("invalid MAC address for device %s: %w", dev.Name, err)
+	}
+	dev.Mac = mac.String()
+	if format := configfile.FormatOf(m.configPath); format != configfile.FormatYAML {
+		return fmt.Errorf("devices can only be added to a YAML config file, not %s", format)
+	}
+
+	// The file is the source of truth, so check it rather than the loaded
+	// devices, which may be out of date
+	data, err := os.ReadFile(m.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	config, err := parseConfig(m.configPath, data)
+	if err != nil {
+		return err
+	}
+	for _, existing := range config.devices {
+		// A static device may take over an imported one
+		if existing.Source != "" {
+			continue
+		}
+		if existing.Name == dev.Name {
+			return fmt.Errorf("%w: %s", ErrExists, dev.Name)
+		}
+		if strings.EqualFold(existing.Mac, dev.Mac) {
+			return fmt.Errorf("%w: MAC address %s belongs to %s", ErrExists, dev.Mac, existing.Name)
+		}
+	}
+
+	data, err = appendDevice(data, dev)
+	if err != nil {
+		return err
+	}
+	if err := Validate(m.configPath, data); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(m.configPath, data); err != nil {
+		return err
+	}
+	return m.Reload()
+}
+
+// appendDevice returns the configuration data with dev added to the end of
+// its devices list. The new entry is spliced into the text after the last
+// device, so the rest of the file is untouched. A missing, empty or
+// flow-style list is rewritten through the YAML encoder instead.
+func appendDevice(data []byte, dev Device) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	deviceNode, err := encodeDevice(dev)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := encodeYAML([]*yaml.Node{deviceNode})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode device: %w", err)
+	}
+
+	// A file with nothing but comments
+	if doc.Kind == 0 {
+		out := string(data)
+		if out != "" && !strings.HasSuffix(out, "\n") {
+			out += "\n"
+		}
+		return []byte(out + "devices:\n" + indentLines(string(entry), "  ")), nil
+	}
+	root := doc.Content[0]
+	if doc.Kind != yaml.DocumentNode || root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("failed to parse config file: top level is not a mapping")
+	}
+
+	var devices, next *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "devices" {
+			devices = root.Content[i+1]
+			if i+2 < len(root.Content) {
+				next = root.Content[i+2]
+			}
+			break
+		}
+	}
+
+	if devices != nil && devices.Kind == yaml.SequenceNode && devices.Style&yaml.FlowStyle == 0 && len(devices.Content) > 0 {
+		// Items start two columns after their dash
+		indent := strings.Repeat(" ", max(devices.Content[0].Column-3, 0))
+
+		lines := strings.SplitAfter(string(data), "\n")
+		end := len(lines)
+		if next != nil {
+			end = next.Line - 1
+		}
+		// Blank lines and unindented comments before the next key belong
+		// to it
+		for end > 0 {
+			line := strings.TrimRight(lines[end-1], "\r\n")
+			if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "#") {
+				break
+			}
+			end--
+		}
+
+		var out strings.Builder
+		for _, line := range lines[:end] {
+			out.WriteString(line)
+		}
+		if end > 0 && !strings.HasSuffix(lines[end-1], "\n") {
+			out.WriteString("\n")
+		}
+		out.WriteString(indentLines(string(entry), indent))
+		for _, line := range lines[end:] {
+			out.WriteString(line)
+		}
+		return []byte(out.String()), nil
+	}
+
+	if devices == nil {
+		devices = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "devices"}, devices)
+	}
+	if devices.Kind != yaml.SequenceNode {
+		if devices.Tag != "!!null" {
+			return nil, fmt.Errorf("failed to parse config file: devices is not a list")
+		}
+		*devices = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	}
+	devices.Style = 0
+	devices.Content = append(devices.Content, deviceNode)
+
+	out, err := encodeYAML(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config file: %w", err)
+	}
+	return out, nil
+}
+
+// indentLines prefixes each line of s with indent.
+func indentLines(s, indent string) string {
+	var out strings.Builder
+	for _, line := range strings.SplitAfter(strings.TrimSuffix(s, "\n"), "\n") {
+		out.WriteString(indent + line)
+	}
+	out.WriteString("\n")
+	return out.String()
+}
+
+// encodeDevice returns dev as a YAML node, with the addresses quoted and
+// the groups on one line as in the example configuration.
+func encodeDevice(dev Device) (*yaml.Node, error) {
+	var node yaml.Node
+	if err := node.Encode(dev); err != nil {
+		return nil, fmt.Errorf("failed to encode device: %w", err)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		switch node.Content[i].Value {
+		case "mac", "broadcast":
+			node.Content[i+1].Style = yaml.DoubleQuotedStyle
+		case "groups":
+			node.Content[i+1].Style = yaml.FlowStyle
+		}
+	}
+	return &node, nil
+}
+
+// encodeYAML encodes v with the two-space indentation of the example
+// configuration.
+func encodeYAML(v any) ([]byte, error) {
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// writeFileAtomic replaces path with data, keeping its permissions. A
+// reader never sees a partly written file.
+func writeFileAtomic(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
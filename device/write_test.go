@@ -0,0 +1,281 @@
+package device
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAppendDevice(t *testing.T) {
+	t.Setenv("MQTT_PASSWORD", "secret")
+	dev := Device{Name: "new", Mac: "aa:bb:cc:dd:ee:ff", Broadcast: "192.168.1.255"}
+
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "empty file",
+			in:   "",
+			want: `devices:
+  - name: new
+    mac: "aa:bb:cc:dd:ee:ff"
+    broadcast: "192.168.1.255"
+`,
+		},
+		{
+			name: "comments only",
+			in:   "# HomeGuard devices\n",
+			want: `# HomeGuard devices
+devices:
+  - name: new
+    mac: "aa:bb:cc:dd:ee:ff"
+    broadcast: "192.168.1.255"
+`,
+		},
+		{
+			name: "comments only without a final newline",
+			in:   "# HomeGuard devices",
+			want: `# HomeGuard devices
+devices:
+  - name: new
+    mac: "aa:bb:cc:dd:ee:ff"
+    broadcast: "192.168.1.255"
+`,
+		},
+		{
+			name: "block list followed by another key",
+			in: `devices:
+  - name: desktop
+    mac: "00:11:22:33:44:55" # Onboard NIC
+
+# Server settings
+server:
+  http:
+    addr: ":7092"
+`,
+			want: `devices:
+  - name: desktop
+    mac: "00:11:22:33:44:55" # Onboard NIC
+  - name: new
+    mac: "aa:bb:cc:dd:ee:ff"
+    broadcast: "192.168.1.255"
+
+# Server settings
+server:
+  http:
+    addr: ":7092"
+`,
+		},
+		{
+			name: "trailing comments",
+			in: `devices:
+  - name: desktop
+    mac: "00:11:22:33:44:55"
+    # Indented comments belong to the list
+  # - name: old
+  #   mac: "00:11:22:33:44:66"
+# An unindented comment belongs to what follows
+`,
+			want: `devices:
+  - name: desktop
+    mac: "00:11:22:33:44:55"
+    # Indented comments belong to the list
+  # - name: old
+  #   mac: "00:11:22:33:44:66"
+  - name: new
+    mac: "aa:bb:cc:dd:ee:ff"
+    broadcast: "192.168.1.255"
+# An unindented comment belongs to what follows
+`,
+		},
+		{
+			name: "list at the end without a final newline",
+			in: `devices:
+- name: desktop
+  mac: "00:11:22:33:44:55"`,
+			want: `devices:
+- name: desktop
+  mac: "00:11:22:33:44:55"
+- name: new
+  mac: "aa:bb:cc:dd:ee:ff"
+  broadcast: "192.168.1.255"
+`,
+		},
+		{
+			name: "environment variables",
+			in: `server:
+  mqtt:
+    broker: tcp://localhost:1883
+    password: ${MQTT_PASSWORD}
+devices:
+  - name: desktop
+    mac: "00:11:22:33:44:55"
+    broadcast: "${LAN_BROADCAST:-192.168.1.255}"
+log:
+  level: ${LOG_LEVEL:-info}
+`,
+			want: `server:
+  mqtt:
+    broker: tcp://localhost:1883
+    password: ${MQTT_PASSWORD}
+devices:
+  - name: desktop
+    mac: "00:11:22:33:44:55"
+    broadcast: "${LAN_BROADCAST:-192.168.1.255}"
+  - name: new
+    mac: "aa:bb:cc:dd:ee:ff"
+    broadcast: "192.168.1.255"
+log:
+  level: ${LOG_LEVEL:-info}
+`,
+		},
+		{
+			name: "missing list",
+			in: `# HomeGuard
+server:
+  http:
+    addr: ":7092" # All interfaces
+`,
+			want: `# HomeGuard
+server:
+  http:
+    addr: ":7092" # All interfaces
+devices:
+  - name: new
+    mac: "aa:bb:cc:dd:ee:ff"
+    broadcast: "192.168.1.255"
+`,
+		},
+		{
+			name: "null list",
+			in:   "devices:\n",
+			want: `devices:
+  - name: new
+    mac: "aa:bb:cc:dd:ee:ff"
+    broadcast: "192.168.1.255"
+`,
+		},
+		{
+			name: "empty flow list",
+			in:   "devices: []\n",
+			want: `devices:
+  - name: new
+    mac: "aa:bb:cc:dd:ee:ff"
+    broadcast: "192.168.1.255"
+`,
+		},
+		{
+			name: "flow list",
+			in: `devices: [{name: desktop, mac: "00:11:22:33:44:55"}]
+log:
+  level: info
+`,
+			want: `devices:
+  - {name: desktop, mac: "00:11:22:33:44:55"}
+  - name: new
+    mac: "aa:bb:cc:dd:ee:ff"
+    broadcast: "192.168.1.255"
+log:
+  level: info
+`,
+		},
+		{
+			name:    "devices is not a list",
+			in:      "devices:\n  desktop: 00:11:22:33:44:55\n",
+			wantErr: "devices is not a list",
+		},
+		{
+			name:    "top level is not a mapping",
+			in:      "- name: desktop\n",
+			wantErr: "top level is not a mapping",
+		},
+		{
+			name:    "invalid YAML",
+			in:      "devices: [\n",
+			wantErr: "failed to parse config file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := appendDevice([]byte(tt.in), dev)
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if string(out) != tt.want {
+				t.Errorf("output:\n%s\nwant:\n%s", out, tt.want)
+			}
+
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := Validate(path, out); err != nil {
+				t.Errorf("output does not validate: %v", err)
+			}
+			config, err := parseConfig(path, out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			added := config.devices[dev.Name]
+			if added.Mac != dev.Mac || added.Broadcast != dev.Broadcast {
+				t.Errorf("added device = %+v, want %+v", added, dev)
+			}
+		})
+	}
+}
+
+func TestAddDevice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := "devices:\n  - name: desktop\n    mac: \"00:11:22:33:44:55\"\n"
+	if err := os.WriteFile(path, []byte(config), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		dev     Device
+		wantErr string
+	}{
+		{name: "new device", dev: Device{Name: "nas", Mac: "AA-BB-CC-DD-EE-FF"}},
+		{name: "name taken", dev: Device{Name: "desktop", Mac: "aa:bb:cc:dd:ee:00"}, wantErr: "device already exists: desktop"},
+		{name: "MAC taken", dev: Device{Name: "laptop", Mac: "00:11:22:33:44:55"}, wantErr: "belongs to desktop"},
+		{name: "invalid MAC", dev: Device{Name: "laptop", Mac: "nope"}, wantErr: "invalid MAC address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.AddDevice(tt.dev)
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	dev, err := m.GetDevice("nas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.Mac != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("MAC = %q, want it normalized", dev.Mac)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("file mode = %o, want 640", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,71 @@
+package discover
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// atfComplete is the flag of a resolved entry in /proc/net/arp.
+const atfComplete = 0x2
+
+// neighbour is an entry of the neighbour table.
+type neighbour struct {
+	ip  netip.Addr
+	mac net.HardwareAddr
+}
+
+// readARP reads the resolved IPv4 entries of a Linux neighbour table in the
+// format of /proc/net/arp.
+func readARP(path string) ([]neighbour, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read neighbour table: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	// IP address  HW type  Flags  HW address  Mask  Device
+	var neighbours []neighbour
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[2], 0, 32)
+		if err != nil || flags&atfComplete == 0 {
+			continue // Header or unresolved entry
+		}
+		ip, err := netip.ParseAddr(fields[0])
+		if err != nil || !ip.Is4() {
+			continue
+		}
+		mac, err := net.ParseMAC(fields[3])
+		if err != nil || !usableMAC(mac) {
+			continue
+		}
+		neighbours = append(neighbours, neighbour{ip: ip, mac: mac})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read neighbour table: %w", err)
+	}
+	return neighbours, nil
+}
+
+// usableMAC reports whether mac is a unicast Ethernet address that is not
+// all zeros.
+func usableMAC(mac net.HardwareAddr) bool {
+	if len(mac) != 6 || mac[0]&0x01 != 0 {
+		return false
+	}
+	for _, b := range mac {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
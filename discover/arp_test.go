@@ -0,0 +1,57 @@
+package discover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// arpTable is a neighbour table in the format of /proc/net/arp.
+const arpTable = `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.10     0x1         0x2         00:11:22:33:44:55     *        eth0
+192.168.1.11     0x1         0x0         00:00:00:00:00:00     *        eth0
+192.168.1.12     0x1         0x6         00:11:22:33:44:66     *        eth0
+192.168.1.13     0x1         0x2         00:00:00:00:00:00     *        eth0
+192.168.1.14     0x1         0x2         01:00:5e:00:00:01     *        eth0
+192.168.1.15     0x1         0x2         ff:ff:ff:ff:ff:ff     *        eth0
+192.168.1.16     0x1         0x2         not-a-mac             *        eth0
+fe80::1          0x1         0x2         00:11:22:33:44:77     *        eth0
+10.0.0.5         0x1         0x2         02:42:ac:11:00:02     *        docker0
+short line
+`
+
+func writeARP(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "arp")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadARP(t *testing.T) {
+	neighbours, err := readARP(writeARP(t, arpTable))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"192.168.1.10 00:11:22:33:44:55",
+		"192.168.1.12 00:11:22:33:44:66",
+		"10.0.0.5 02:42:ac:11:00:02",
+	}
+	if len(neighbours) != len(want) {
+		t.Fatalf("neighbours = %v, want %v", neighbours, want)
+	}
+	for i, n := range neighbours {
+		if got := n.ip.String() + " " + n.mac.String(); got != want[i] {
+			t.Errorf("neighbour %d = %s, want %s", i, got, want[i])
+		}
+	}
+}
+
+func TestReadARPMissingFile(t *testing.T) {
+	if _, err := readARP(filepath.Join(t.TempDir(), "arp")); err == nil {
+		t.Error("reading a missing neighbour table succeeded")
+	}
+}
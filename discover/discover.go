@@ -0,0 +1,319 @@
+// Package discover finds the machines on the local network, so their MAC
+// addresses do not have to be looked up by hand.
+package discover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources a candidate can be found by.
+const (
+	SourceARP   = "arp"   // Neighbour table after the sweep
+	SourceLease = "lease" // DHCP lease file
+	SourceMDNS  = "mdns"  // mDNS announcement (hostname only)
+)
+
+const (
+	// DefaultTimeout is how long a single probe of the sweep waits.
+	DefaultTimeout = 500 * time.Millisecond
+
+	// maxSubnetBits is the largest subnet that can be scanned, a /20 of
+	// 4094 hosts.
+	maxSubnetBits = 20
+
+	// probeWorkers is how many hosts are probed at once.
+	probeWorkers = 64
+)
+
+// DefaultARPFile is the kernel neighbour table on Linux.
+const DefaultARPFile = "/proc/net/arp"
+
+// DefaultLeaseFiles are the usual locations of dnsmasq and ISC dhcpd lease
+// files. Missing files are skipped.
+var DefaultLeaseFiles = []string{
+	"/var/lib/misc/dnsmasq.leases",    // dnsmasq on Debian
+	"/var/lib/dnsmasq/dnsmasq.leases", // dnsmasq on Fedora
+	"/tmp/dhcp.leases",                // OpenWrt
+	"/var/lib/dhcp/dhcpd.leases",      // ISC dhcpd on Debian
+	"/var/lib/dhcpd/dhcpd.leases",     // ISC dhcpd on Fedora
+}
+
+// Config holds the discovery settings of the configuration file.
+type Config struct {
+	ARPFile    string   `yaml:"arp_file"`    // Neighbour table (default: /proc/net/arp)
+	LeaseFiles []string `yaml:"lease_files"` // dnsmasq or ISC dhcpd lease files (default: DefaultLeaseFiles)
+	OUIFile    string   `yaml:"oui_file"`    // Extra vendor prefixes, in IEEE oui.txt or Wireshark manuf format
+
+	// Address tracking of configured devices, see Track
+	TrackInterval  time.Duration `yaml:"track_interval"`  // How often the neighbour table is read (default: 30s, negative disables)
+	Sniff          bool          `yaml:"sniff"`           // Also watch ARP traffic (Linux, needs CAP_NET_RAW)
+	SniffInterface string        `yaml:"sniff_interface"` // Interface to watch (default: all)
+}
+
+// Options configures a discovery run.
+type Options struct {
+	Config
+
+	Subnets []netip.Prefix // IPv4 subnets to scan (default: those of the local interfaces)
+	Timeout time.Duration  // Per probe (default: DefaultTimeout)
+	MDNS    time.Duration  // How long to listen for mDNS announcements; 0 disables
+}
+
+// Candidate is a machine found on the network.
+type Candidate struct {
+	IP        string   `json:"ip"`
+	Mac       string   `json:"mac"`
+	Hostname  string   `json:"hostname,omitempty"`
+	Vendor    string   `json:"vendor,omitempty"`
+	Broadcast string   `json:"broadcast"` // Broadcast address of the subnet the machine was found in
+	Sources   []string `json:"sources"`
+}
+
+// subnet is a range to scan and the broadcast address of the network it
+// belongs to.
+type subnet struct {
+	prefix    netip.Prefix
+	network   netip.Prefix
+	broadcast netip.Addr
+}
+
+// Run sweeps the subnets to fill the neighbour table, then reads it and the
+// DHCP lease files. Machines are reported once per MAC address, sorted by IP
+// address. Only addresses inside the subnets are reported.
+func Run(ctx context.Context, opts Options) ([]Candidate, error) {
+	subnets, err := subnetsFor(opts.Subnets)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.ARPFile == "" {
+		opts.ARPFile = DefaultARPFile
+	}
+	leaseFiles := opts.LeaseFiles
+	if leaseFiles == nil {
+		leaseFiles = DefaultLeaseFiles
+	}
+	vendors, err := loadVendors(opts.OUIFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// mDNS answers come in while the sweep runs
+	var hostnames map[netip.Addr]string
+	var wg sync.WaitGroup
+	if opts.MDNS > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			if hostnames, err = listenMDNS(ctx, opts.MDNS); err != nil {
+				slog.Warn("mDNS discovery failed", "error", err)
+			}
+		}()
+	}
+	sweep(ctx, subnets, opts.Timeout)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]*Candidate)
+	add := func(ip netip.Addr, mac net.HardwareAddr, hostname, source string) {
+		sub, ok := subnetOf(subnets, ip)
+		if !ok {
+			return
+		}
+		c, exists := found[mac.String()]
+		if !exists {
+			c = &Candidate{Mac: mac.String(), Vendor: vendors.lookup(mac)}
+			found[c.Mac] = c
+		}
+		// The neighbour table is read first and is the most recent
+		if c.IP == "" {
+			c.IP = ip.String()
+			c.Broadcast = sub.broadcast.String()
+		}
+		if c.Hostname == "" {
+			c.Hostname = hostname
+		}
+		if !slices.Contains(c.Sources, source) {
+			c.Sources = append(c.Sources, source)
+		}
+	}
+
+	neighbours, err := readARP(opts.ARPFile)
+	if err != nil && !(errors.Is(err, fs.ErrNotExist) && opts.ARPFile == DefaultARPFile) {
+		return nil, err
+	}
+	for _, n := range neighbours {
+		add(n.ip, n.mac, "", SourceARP)
+	}
+
+	for _, path := range leaseFiles {
+		leases, err := readLeases(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range leases {
+			add(l.ip, l.mac, l.hostname, SourceLease)
+		}
+	}
+
+	candidates := make([]Candidate, 0, len(found))
+	for _, c := range found {
+		ip := netip.MustParseAddr(c.IP)
+		if name, ok := hostnames[ip]; ok {
+			if c.Hostname == "" {
+				c.Hostname = name
+			}
+			c.Sources = append(c.Sources, SourceMDNS)
+		}
+		candidates = append(candidates, *c)
+	}
+	slices.SortFunc(candidates, func(a, b Candidate) int {
+		return netip.MustParseAddr(a.IP).Compare(netip.MustParseAddr(b.IP))
+	})
+	return candidates, nil
+}
+
+// subnetsFor checks the requested subnets, or finds those of the local
+// interfaces if none are given.
+func subnetsFor(prefixes []netip.Prefix) ([]subnet, error) {
+	if len(prefixes) == 0 {
+		subnets, err := localSubnets()
+		if err != nil {
+			return nil, err
+		}
+		if len(subnets) == 0 {
+			return nil, fmt.Errorf("no IPv4 network interface found, give a subnet to scan")
+		}
+		return subnets, nil
+	}
+
+	// A subnet inside a local network is woken through the broadcast
+	// address of that network
+	local, _ := localSubnets()
+
+	subnets := make([]subnet, 0, len(prefixes))
+	for _, p := range prefixes {
+		if !p.Addr().Is4() {
+			return nil, fmt.Errorf("only IPv4 subnets can be scanned: %s", p)
+		}
+		if p.Bits() < maxSubnetBits {
+			return nil, fmt.Errorf("subnet %s is too large, the largest is /%d", p, maxSubnetBits)
+		}
+		p = p.Masked()
+		s := subnet{prefix: p, network: p, broadcast: broadcastOf(p)}
+		for _, l := range local {
+			if l.network.Contains(p.Addr()) && l.network.Bits() <= p.Bits() {
+				s.network, s.broadcast = l.network, l.broadcast
+				break
+			}
+		}
+		subnets = append(subnets, s)
+	}
+	return subnets, nil
+}
+
+// localSubnets returns the IPv4 subnets of the interfaces that are up and
+// can broadcast. Of networks larger than a /24, only the /24 around the
+// interface address is scanned, but the broadcast address is that of the
+// whole network.
+func localSubnets() ([]subnet, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network interfaces: %w", err)
+	}
+
+	var subnets []subnet
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagBroadcast == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil {
+				continue
+			}
+			ip, _ := netip.AddrFromSlice(ipNet.IP.To4())
+			bits, _ := ipNet.Mask.Size()
+			network := netip.PrefixFrom(ip, bits).Masked()
+			scan := network
+			if bits < 24 {
+				scan = netip.PrefixFrom(ip, 24).Masked()
+			}
+			subnets = append(subnets, subnet{prefix: scan, network: network, broadcast: broadcastOf(network)})
+		}
+	}
+	return subnets, nil
+}
+
+// broadcastOf returns the last address of an IPv4 prefix.
+func broadcastOf(p netip.Prefix) netip.Addr {
+	b := p.Masked().Addr().As4()
+	for i := p.Bits(); i < 32; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	return netip.AddrFrom4(b)
+}
+
+// subnetOf returns the subnet ip belongs to.
+func subnetOf(subnets []subnet, ip netip.Addr) (subnet, bool) {
+	for _, s := range subnets {
+		if s.prefix.Contains(ip) {
+			return s, true
+		}
+	}
+	return subnet{}, false
+}
+
+// hosts returns the host addresses of a subnet, without its network and
+// broadcast addresses.
+func (s subnet) hosts() []netip.Addr {
+	var addrs []netip.Addr
+	last := broadcastOf(s.prefix)
+	for ip := s.prefix.Addr(); s.prefix.Contains(ip); ip = ip.Next() {
+		if s.prefix.Bits() < 31 && (ip == s.prefix.Addr() || ip == last) {
+			continue
+		}
+		addrs = append(addrs, ip)
+	}
+	return addrs
+}
+
+// ParseSubnet parses a subnet in CIDR notation. A bare IPv4 address is
+// taken as its /24.
+func ParseSubnet(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid subnet: %s", s)
+		}
+		return netip.PrefixFrom(ip, 24).Masked(), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid subnet: %s", s)
+	}
+	return p.Masked(), nil
+}
@@ -0,0 +1,82 @@
+package discover
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestParseSubnet(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr string
+	}{
+		{in: "192.168.1.0/24", want: "192.168.1.0/24"},
+		{in: " 192.168.1.77/24 ", want: "192.168.1.0/24"},
+		{in: "192.168.1.77", want: "192.168.1.0/24"},
+		{in: "10.0.0.0/20", want: "10.0.0.0/20"},
+		{in: "10.0.0.0/8", want: "10.0.0.0/8"}, // Too large to scan, see subnetsFor
+		{in: "fd00::/64", want: "fd00::/64"},
+		{in: "192.168.1.0/33", wantErr: "invalid subnet: 192.168.1.0/33"},
+		{in: "lan", wantErr: "invalid subnet: lan"},
+		{in: "", wantErr: "invalid subnet"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSubnet(tt.in)
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			case err != nil:
+				return
+			}
+			if got.String() != tt.want {
+				t.Errorf("ParseSubnet(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubnetsFor(t *testing.T) {
+	// Documentation ranges, which no local interface is in
+	tests := []struct {
+		prefix        string
+		wantBroadcast string
+		wantHosts     int
+		wantErr       string
+	}{
+		{prefix: "198.51.100.0/24", wantBroadcast: "198.51.100.255", wantHosts: 254},
+		{prefix: "203.0.113.64/26", wantBroadcast: "203.0.113.127", wantHosts: 62},
+		{prefix: "198.51.96.0/20", wantBroadcast: "198.51.111.255", wantHosts: 4094},
+		{prefix: "203.0.113.8/31", wantBroadcast: "203.0.113.9", wantHosts: 2},
+		{prefix: "203.0.113.8/32", wantBroadcast: "203.0.113.8", wantHosts: 1},
+		{prefix: "198.51.0.0/19", wantErr: "subnet 198.51.0.0/19 is too large, the largest is /20"},
+		{prefix: "2001:db8::/120", wantErr: "only IPv4 subnets can be scanned"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			subnets, err := subnetsFor([]netip.Prefix{netip.MustParsePrefix(tt.prefix)})
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			case err != nil:
+				return
+			}
+			if len(subnets) != 1 {
+				t.Fatalf("subnets = %v, want one", subnets)
+			}
+			s := subnets[0]
+			if s.prefix.String() != tt.prefix || s.broadcast.String() != tt.wantBroadcast {
+				t.Errorf("subnet = %s broadcast %s, want %s broadcast %s", s.prefix, s.broadcast, tt.prefix, tt.wantBroadcast)
+			}
+			if got := len(s.hosts()); got != tt.wantHosts {
+				t.Errorf("hosts = %d, want %d", got, tt.wantHosts)
+			}
+		})
+	}
+}
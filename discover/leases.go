@@ -0,0 +1,123 @@
+package discover
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// lease is an address handed out by a DHCP server.
+type lease struct {
+	ip       netip.Addr
+	mac      net.HardwareAddr
+	hostname string
+}
+
+// readLeases reads a dnsmasq or ISC dhcpd lease file. The format is told
+// from the content.
+func readLeases(path string) ([]lease, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lease file: %w", err)
+	}
+
+	if isISCLeases(data) {
+		return parseISCLeases(data), nil
+	}
+	return parseDnsmasqLeases(data), nil
+}
+
+// isISCLeases reports whether data has an ISC dhcpd "lease <ip> {" block.
+func isISCLeases(data []byte) bool {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if bytes.HasPrefix(line, []byte("lease ")) && bytes.HasSuffix(line, []byte("{")) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDnsmasqLeases parses dnsmasq leases, one per line:
+//
+//	<expiry> <mac> <ip> <hostname or *> <client id or *>
+func parseDnsmasqLeases(data []byte) []lease {
+	var leases []lease
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue // Includes the "duid" line of DHCPv6 servers
+		}
+		mac, err := net.ParseMAC(fields[1])
+		if err != nil || !usableMAC(mac) {
+			continue
+		}
+		ip, err := netip.ParseAddr(fields[2])
+		if err != nil || !ip.Is4() {
+			continue
+		}
+		l := lease{ip: ip, mac: mac}
+		if fields[3] != "*" {
+			l.hostname = fields[3]
+		}
+		leases = append(leases, l)
+	}
+	return leases
+}
+
+// parseISCLeases parses ISC dhcpd leases. The file is appended to as leases
+// change, so a later block for an address replaces an earlier one:
+//
+//	lease 192.168.1.10 {
+//	  hardware ethernet 00:11:22:33:44:55;
+//	  client-hostname "desktop";
+//	}
+func parseISCLeases(data []byte) []lease {
+	var leases []lease
+	index := make(map[netip.Addr]int)
+
+	var current *lease
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "lease ") && strings.HasSuffix(line, "{"):
+			fields := strings.Fields(line)
+			ip, err := netip.ParseAddr(fields[1])
+			if err != nil || !ip.Is4() {
+				current = nil
+				continue
+			}
+			current = &lease{ip: ip}
+
+		case current == nil:
+
+		case line == "}":
+			if current.mac != nil {
+				if i, ok := index[current.ip]; ok {
+					leases[i] = *current
+				} else {
+					index[current.ip] = len(leases)
+					leases = append(leases, *current)
+				}
+			}
+			current = nil
+
+		case strings.HasPrefix(line, "hardware ethernet "):
+			value := strings.TrimSuffix(strings.TrimPrefix(line, "hardware ethernet "), ";")
+			if mac, err := net.ParseMAC(strings.TrimSpace(value)); err == nil && usableMAC(mac) {
+				current.mac = mac
+			}
+
+		case strings.HasPrefix(line, "client-hostname "):
+			value := strings.TrimSuffix(strings.TrimPrefix(line, "client-hostname "), ";")
+			current.hostname = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return leases
+}
@@ -0,0 +1,103 @@
+package discover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func leaseStrings(leases []lease) []string {
+	var s []string
+	for _, l := range leases {
+		s = append(s, l.ip.String()+" "+l.mac.String()+" "+l.hostname)
+	}
+	return s
+}
+
+func TestParseDnsmasqLeases(t *testing.T) {
+	const data = `1700000000 00:11:22:33:44:55 192.168.1.10 desktop 01:00:11:22:33:44:55
+1700000000 00:11:22:33:44:66 192.168.1.11 * *
+1700000000 01:00:5e:00:00:01 192.168.1.12 multicast *
+1700000000 00:11:22:33:44:77 fd00::12 v6host *
+1700000000 not-a-mac 192.168.1.13 broken *
+duid 00:01:00:01:2b:3c:4d:5e:00:11:22:33:44:55
+1700000000 00:11:22:33:44:88 192.168.1.14
+`
+	got := leaseStrings(parseDnsmasqLeases([]byte(data)))
+	want := []string{
+		"192.168.1.10 00:11:22:33:44:55 desktop",
+		"192.168.1.11 00:11:22:33:44:66 ",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("leases = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("lease %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseISCLeases(t *testing.T) {
+	const data = `# The format of this file is documented in the dhcpd.leases(5) manual page.
+authoring-byte-order little-endian;
+
+lease 192.168.1.10 {
+  starts 4 2024/01/04 10:00:00;
+  hardware ethernet 00:11:22:33:44:55;
+  client-hostname "desktop";
+}
+lease 192.168.1.11 {
+  hardware ethernet 00:11:22:33:44:66;
+}
+lease 192.168.1.12 {
+  binding state free;
+}
+lease fd00::13 {
+  hardware ethernet 00:11:22:33:44:77;
+}
+lease 192.168.1.10 {
+  hardware ethernet 00:11:22:33:44:99;
+  client-hostname "laptop";
+}
+`
+	got := leaseStrings(parseISCLeases([]byte(data)))
+	want := []string{
+		"192.168.1.10 00:11:22:33:44:99 laptop", // The later block replaces the earlier one
+		"192.168.1.11 00:11:22:33:44:66 ",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("leases = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("lease %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestReadLeases(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "dnsmasq", data: "1700000000 00:11:22:33:44:55 192.168.1.10 desktop *\n", want: "192.168.1.10 00:11:22:33:44:55 desktop"},
+		{name: "ISC", data: "lease 192.168.1.10 {\n  hardware ethernet 00:11:22:33:44:55;\n}\n", want: "192.168.1.10 00:11:22:33:44:55 "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "leases")
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			leases, err := readLeases(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := leaseStrings(leases); len(got) != 1 || got[0] != tt.want {
+				t.Errorf("leases = %q, want [%q]", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mdnsAddr is the IPv4 mDNS group.
+var mdnsAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// mdnsQueries are asked once at the start, so machines answer instead of
+// waiting for their next announcement.
+var mdnsQueries = []string{
+	"_services._dns-sd._udp.local.",
+	"_workstation._tcp.local.",
+	"_device-info._tcp.local.",
+}
+
+// listenMDNS collects the A records announced on the network for d. It
+// returns the hostname of each address, without ".local".
+func listenMDNS(ctx context.Context, d time.Duration) (map[netip.Addr]string, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join mDNS group: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	deadline := time.Now().Add(d)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	_ = conn.SetReadDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	if query, err := mdnsQuery(); err == nil {
+		_, _ = conn.WriteToUDP(query, mdnsAddr)
+	}
+
+	hostnames := make(map[netip.Addr]string)
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return hostnames, nil
+			}
+			return hostnames, fmt.Errorf("failed to read mDNS packet: %w", err)
+		}
+		for ip, name := range parseMDNS(buf[:n]) {
+			hostnames[ip] = name
+		}
+	}
+}
+
+// mdnsQuery builds a PTR query for mdnsQueries.
+func mdnsQuery() ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, q := range mdnsQueries {
+		name, err := dnsmessage.NewName(q)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// parseMDNS returns the A records of an mDNS response. Addresses are often
+// sent as additional records, so those are read too.
+func parseMDNS(packet []byte) map[netip.Addr]string {
+	var p dnsmessage.Parser
+	header, err := p.Start(packet)
+	if err != nil || !header.Response {
+		return nil
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil
+	}
+
+	records := make(map[netip.Addr]string)
+	for {
+		h, err := p.AnswerHeader()
+		if err != nil {
+			break
+		}
+		if !readA(&p, h, records) {
+			_ = p.SkipAnswer()
+		}
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		return records
+	}
+	for {
+		h, err := p.AdditionalHeader()
+		if err != nil {
+			break
+		}
+		if !readA(&p, h, records) {
+			_ = p.SkipAdditional()
+		}
+	}
+	return records
+}
+
+// readA adds the record with header h to records if it is an A record. It
+// reports whether the record was read.
+func readA(p *dnsmessage.Parser, h dnsmessage.ResourceHeader, records map[netip.Addr]string) bool {
+	if h.Type != dnsmessage.TypeA {
+		return false
+	}
+	r, err := p.AResource()
+	if err != nil {
+		return true
+	}
+	name := strings.TrimSuffix(strings.TrimSuffix(h.Name.String(), "."), ".local")
+	if name != "" {
+		records[netip.AddrFrom4(r.A)] = name
+	}
+	return true
+}
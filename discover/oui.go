@@ -0,0 +1,76 @@
+package discover
+
+import (
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+//go:embed oui.txt
+var embeddedOUI string
+
+// vendorTable maps the first three bytes of a MAC address to a vendor.
+type vendorTable map[[3]byte]string
+
+// loadVendors returns the embedded vendor table, extended by the prefixes
+// of path if it is not empty. Prefixes from path win.
+func loadVendors(path string) (vendorTable, error) {
+	vendors := make(vendorTable)
+	vendors.parse(embeddedOUI)
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OUI file: %w", err)
+		}
+		vendors.parse(string(data))
+	}
+	return vendors, nil
+}
+
+// parse adds the prefixes of an OUI list. It reads the IEEE oui.txt format
+// ("00-0C-29   (hex)  VMware, Inc.") and the Wireshark manuf format
+// ("00:0C:29<tab>VMware<tab>VMware, Inc."). Prefixes longer than three
+// bytes are skipped.
+func (v vendorTable) parse(data string) {
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		prefix, _, ok := strings.Cut(strings.ReplaceAll(line, "\t", " "), " ")
+		if !ok {
+			continue
+		}
+		b, err := hex.DecodeString(strings.NewReplacer(":", "", "-", "", ".", "").Replace(prefix))
+		if err != nil || len(b) != 3 {
+			continue
+		}
+
+		// Wireshark lists a short name before the full one
+		fields := strings.Split(strings.TrimSpace(line[len(prefix):]), "\t")
+		vendor := strings.TrimSpace(fields[len(fields)-1])
+		vendor = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(vendor, "(hex)"), "(base 16)"))
+		if vendor != "" {
+			v[[3]byte(b)] = vendor
+		}
+	}
+}
+
+// lookup returns the vendor of mac, or "" if it is unknown. Locally
+// administered addresses, as used by virtual machines and private Wi-Fi
+// addresses, are marked as such.
+func (v vendorTable) lookup(mac net.HardwareAddr) string {
+	if len(mac) < 3 {
+		return ""
+	}
+	if vendor, ok := v[[3]byte(mac[:3])]; ok {
+		return vendor
+	}
+	if mac[0]&0x02 != 0 {
+		return "(locally administered)"
+	}
+	return ""
+}
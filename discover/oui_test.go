@@ -0,0 +1,103 @@
+package discover
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVendorTableParse(t *testing.T) {
+	const (
+		ieee = `OUI/MA-L                                                    Organization
+company_id                                                  Organization
+                                                            Address
+
+00-0C-29   (hex)		VMware, Inc.
+000C29     (base 16)		VMware, Inc.
+				Palo Alto  CA  94304
+				US
+
+B8-27-EB   (hex)		Raspberry Pi Foundation
+`
+		wireshark = `# This file was generated by Wireshark
+00:00:0C	Cisco	Cisco Systems, Inc
+00:04:4B	Nvidia	NVIDIA
+00:1B:C5:00:00/36	Converge	Converging Systems Inc.
+DC:A6:32	RaspberryPiT	Raspberry Pi Trading Ltd
+`
+	)
+
+	tests := []struct {
+		name string
+		data string
+		want map[string]string
+	}{
+		{
+			name: "IEEE",
+			data: ieee,
+			want: map[string]string{
+				"00:0c:29:01:02:03": "VMware, Inc.",
+				"b8:27:eb:01:02:03": "Raspberry Pi Foundation",
+				"00:00:0c:01:02:03": "",
+			},
+		},
+		{
+			name: "Wireshark",
+			data: wireshark,
+			want: map[string]string{
+				"00:00:0c:01:02:03": "Cisco Systems, Inc",
+				"00:04:4b:01:02:03": "NVIDIA",
+				"dc:a6:32:01:02:03": "Raspberry Pi Trading Ltd",
+				"00:1b:c5:00:00:01": "", // Longer prefixes are skipped
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vendors := make(vendorTable)
+			vendors.parse(tt.data)
+			for addr, want := range tt.want {
+				mac, err := net.ParseMAC(addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := vendors.lookup(mac); got != want {
+					t.Errorf("lookup(%s) = %q, want %q", addr, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadVendors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manuf")
+	if err := os.WriteFile(path, []byte("00:0C:29\tVMware\tVMware, Inc.\n00:00:0C\tCisco\tCisco Systems, Inc\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	vendors, err := loadVendors(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"00:0c:29:01:02:03": "VMware, Inc.",           // The file wins over the embedded table
+		"00:00:0c:01:02:03": "Cisco Systems, Inc",     // Only in the file
+		"b8:27:eb:01:02:03": "Raspberry Pi",           // Only in the embedded table
+		"02:42:ac:11:00:02": "(locally administered)", // Docker
+		"00:00:5e:00:53:01": "",
+	}
+	for addr, want := range tests {
+		mac, err := net.ParseMAC(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := vendors.lookup(mac); got != want {
+			t.Errorf("lookup(%s) = %q, want %q", addr, got, want)
+		}
+	}
+
+	if _, err := loadVendors(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("loading a missing OUI file succeeded")
+	}
+}
@@ -0,0 +1,81 @@
+package discover
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// sniffer reads the ARP packets of a packet socket.
+type sniffer struct {
+	file *os.File
+	buf  []byte
+}
+
+// htons converts a 16-bit value to network byte order.
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// openSniffer opens a packet socket receiving the ARP packets of an
+// interface, or of all interfaces if name is empty.
+func openSniffer(name string) (*sniffer, error) {
+	ifindex := 0
+	if name != "" {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find interface for ARP sniffing: %w", err)
+		}
+		ifindex = iface.Index
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open packet socket for ARP sniffing: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: ifindex}); err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind packet socket for ARP sniffing: %w", err)
+	}
+	// A non-blocking descriptor uses the runtime poller, so close unblocks
+	// a pending read
+	return &sniffer{file: os.NewFile(uintptr(fd), "arp"), buf: make([]byte, 1500)}, nil
+}
+
+// next returns the sender of the next Ethernet ARP packet.
+func (s *sniffer) next() (netip.Addr, net.HardwareAddr, error) {
+	for {
+		n, err := s.file.Read(s.buf)
+		if err != nil {
+			return netip.Addr{}, nil, err
+		}
+		if ip, mac, ok := parseARP(s.buf[:n]); ok {
+			return ip, mac, nil
+		}
+	}
+}
+
+func (s *sniffer) close() {
+	_ = s.file.Close()
+}
+
+// parseARP returns the sender of an ARP packet for IPv4 over Ethernet.
+// Probes, which have no sender address yet, are skipped.
+func parseARP(packet []byte) (netip.Addr, net.HardwareAddr, bool) {
+	// Hardware type, protocol type, address lengths, operation, then the
+	// sender and target addresses
+	if len(packet) < 28 || binary.BigEndian.Uint16(packet[0:2]) != 1 ||
+		binary.BigEndian.Uint16(packet[2:4]) != unix.ETH_P_IP || packet[4] != 6 || packet[5] != 4 {
+		return netip.Addr{}, nil, false
+	}
+	mac := net.HardwareAddr(append([]byte(nil), packet[8:14]...))
+	ip := netip.AddrFrom4([4]byte(packet[14:18]))
+	if ip.IsUnspecified() || !usableMAC(mac) {
+		return netip.Addr{}, nil, false
+	}
+	return ip, mac, true
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package discover
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+)
+
+// sniffer is not available outside Linux.
+type sniffer struct{}
+
+func openSniffer(string) (*sniffer, error) {
+	return nil, errors.New("ARP sniffing is only supported on Linux")
+}
+
+func (s *sniffer) next() (netip.Addr, net.HardwareAddr, error) {
+	return netip.Addr{}, nil, errors.New("ARP sniffing is only supported on Linux")
+}
+
+func (s *sniffer) close() {}
@@ -0,0 +1,110 @@
+package discover
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// sweepPort is the TCP port probed on every host. Whether it is open does
+// not matter: the kernel has to resolve the MAC address of the host before
+// it can send the first packet, and the answer lands in the neighbour table.
+const sweepPort = 80
+
+// sweep probes every host of the subnets with a TCP connection attempt and,
+// if the system allows it, an ICMP echo request. Probes are not checked for
+// answers; the neighbour table is read afterwards.
+func sweep(ctx context.Context, subnets []subnet, timeout time.Duration) {
+	ping := newPinger()
+	if ping != nil {
+		defer ping.close()
+	}
+
+	hosts := make(chan netip.Addr)
+	var wg sync.WaitGroup
+	for range probeWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dialer := net.Dialer{Timeout: timeout}
+			for ip := range hosts {
+				if ping != nil {
+					ping.send(ip)
+				}
+				conn, err := dialer.DialContext(ctx, "tcp4", netip.AddrPortFrom(ip, sweepPort).String())
+				if err == nil {
+					_ = conn.Close()
+				}
+			}
+		}()
+	}
+
+	defer wg.Wait()
+	defer close(hosts)
+	for _, s := range subnets {
+		for _, ip := range s.hosts() {
+			select {
+			case hosts <- ip:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// pinger sends ICMP echo requests.
+type pinger struct {
+	conn *icmp.PacketConn
+	udp  bool // Unprivileged datagram socket, addressed by *net.UDPAddr
+
+	mu  sync.Mutex
+	seq int
+}
+
+// newPinger opens an unprivileged ICMP socket, or a raw one if that is not
+// permitted. It returns nil if neither can be opened, in which case the
+// sweep relies on TCP alone.
+func newPinger() *pinger {
+	if conn, err := icmp.ListenPacket("udp4", "0.0.0.0"); err == nil {
+		return &pinger{conn: conn, udp: true}
+	}
+	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		slog.Debug("ICMP sweep unavailable, using TCP only", "error", err)
+		return nil
+	}
+	return &pinger{conn: conn}
+}
+
+func (p *pinger) send(ip netip.Addr) {
+	p.mu.Lock()
+	p.seq++
+	seq := p.seq & 0xffff
+	p.mu.Unlock()
+
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: seq, Data: []byte("homeguard")},
+	}
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		return
+	}
+
+	var dst net.Addr = &net.IPAddr{IP: ip.AsSlice()}
+	if p.udp {
+		dst = &net.UDPAddr{IP: ip.AsSlice()}
+	}
+	_, _ = p.conn.WriteTo(data, dst)
+}
+
+func (p *pinger) close() {
+	_ = p.conn.Close()
+}
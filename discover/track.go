@@ -0,0 +1,161 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// DefaultTrackInterval is how often Track reads the neighbour table.
+const DefaultTrackInterval = 30 * time.Second
+
+// Observation is an IPv4 address seen on a local network with the MAC
+// address that answers for it.
+type Observation struct {
+	IP        netip.Addr
+	Mac       string
+	Broadcast string // Broadcast address of the local network of IP
+}
+
+// Track reports the neighbour table every TrackInterval until ctx is done.
+// With Sniff, the senders of ARP packets are reported in between as they
+// are seen, when they show a new MAC address for an IP address. Only
+// addresses in the networks of the local interfaces are reported. It
+// returns an error if the neighbour table or the packet socket cannot be
+// opened.
+func Track(ctx context.Context, config Config, report func([]Observation)) error {
+	interval := config.TrackInterval
+	if interval == 0 {
+		interval = DefaultTrackInterval
+	}
+	if interval < 0 {
+		return nil
+	}
+	path := arpFile(config)
+	if _, err := readARP(path); err != nil {
+		return err
+	}
+
+	sniffed := make(chan neighbour)
+	if config.Sniff {
+		s, err := openSniffer(config.SniffInterface)
+		if err != nil {
+			return err
+		}
+		defer s.close()
+		go func() {
+			for {
+				ip, mac, err := s.next()
+				if err != nil {
+					if !errors.Is(err, os.ErrClosed) {
+						slog.Warn("ARP sniffing stopped", "error", err)
+					}
+					return
+				}
+				select {
+				case sniffed <- neighbour{ip: ip, mac: mac}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	t := &tracker{path: path, subnets: localSubnets, report: report, known: make(map[netip.Addr]string)}
+	t.poll()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.poll()
+		case n := <-sniffed:
+			t.sniffed(n)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// tracker holds the state of Track between reads of the neighbour table.
+type tracker struct {
+	path    string
+	subnets func() ([]subnet, error) // Networks of the local interfaces
+	report  func([]Observation)
+
+	// MAC address last reported for each IP address, so sniffed packets
+	// are only reported when something changed
+	known    map[netip.Addr]string
+	networks []subnet
+}
+
+// poll reports the neighbour table.
+func (t *tracker) poll() {
+	t.networks, _ = t.subnets()
+	observations, err := observeTable(t.path, t.networks)
+	if err != nil {
+		slog.Warn("Failed to read neighbour table", "error", err)
+		return
+	}
+	for _, o := range observations {
+		t.known[o.IP] = o.Mac
+	}
+	t.report(observations)
+}
+
+// sniffed reports a sniffed neighbour if it is in a local network and shows
+// a new MAC address for its IP address.
+func (t *tracker) sniffed(n neighbour) {
+	if o, ok := observe(t.networks, n.ip, n.mac); ok && t.known[o.IP] != o.Mac {
+		t.known[o.IP] = o.Mac
+		t.report([]Observation{o})
+	}
+}
+
+// Observe reads the neighbour table once, returning the addresses in the
+// networks of the local interfaces.
+func Observe(config Config) ([]Observation, error) {
+	networks, err := localSubnets()
+	if err != nil {
+		return nil, err
+	}
+	return observeTable(arpFile(config), networks)
+}
+
+func arpFile(config Config) string {
+	if config.ARPFile == "" {
+		return DefaultARPFile
+	}
+	return config.ARPFile
+}
+
+// observeTable reads the neighbour table at path, returning the addresses
+// in the networks.
+func observeTable(path string, networks []subnet) ([]Observation, error) {
+	neighbours, err := readARP(path)
+	if err != nil {
+		return nil, err
+	}
+	var observations []Observation
+	for _, n := range neighbours {
+		if o, ok := observe(networks, n.ip, n.mac); ok {
+			observations = append(observations, o)
+		}
+	}
+	return observations, nil
+}
+
+// observe returns the observation of ip and mac if ip is in one of the
+// networks.
+func observe(networks []subnet, ip netip.Addr, mac net.HardwareAddr) (Observation, bool) {
+	for _, s := range networks {
+		if s.network.Contains(ip) {
+			return Observation{IP: ip, Mac: mac.String(), Broadcast: s.broadcast.String()}, true
+		}
+	}
+	return Observation{}, false
+}
@@ -0,0 +1,159 @@
+package discover
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// homeNetwork is the local network the fixtures are in.
+var homeNetwork = []subnet{{
+	prefix:    netip.MustParsePrefix("192.168.1.0/24"),
+	network:   netip.MustParsePrefix("192.168.1.0/24"),
+	broadcast: netip.MustParseAddr("192.168.1.255"),
+}}
+
+func TestObserve(t *testing.T) {
+	// A /24 scanned inside a larger network reports the network's broadcast
+	wide := []subnet{{
+		prefix:    netip.MustParsePrefix("10.0.0.0/24"),
+		network:   netip.MustParsePrefix("10.0.0.0/16"),
+		broadcast: netip.MustParseAddr("10.0.255.255"),
+	}}
+	networks := slices.Concat(homeNetwork, wide)
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+
+	tests := []struct {
+		ip            string
+		wantOK        bool
+		wantBroadcast string
+	}{
+		{ip: "192.168.1.10", wantOK: true, wantBroadcast: "192.168.1.255"},
+		{ip: "10.0.7.1", wantOK: true, wantBroadcast: "10.0.255.255"},
+		{ip: "192.168.2.10"},
+		{ip: "10.1.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			o, ok := observe(networks, netip.MustParseAddr(tt.ip), mac)
+			if ok != tt.wantOK {
+				t.Fatalf("observed = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && (o.IP.String() != tt.ip || o.Mac != "00:11:22:33:44:55" || o.Broadcast != tt.wantBroadcast) {
+				t.Errorf("observation = %+v, want %s at %s broadcast %s", o, mac, tt.ip, tt.wantBroadcast)
+			}
+		})
+	}
+	if _, ok := observe(nil, netip.MustParseAddr("192.168.1.10"), mac); ok {
+		t.Error("address observed without local networks")
+	}
+}
+
+func TestObserveTable(t *testing.T) {
+	observations, err := observeTable(writeARP(t, arpTable), homeNetwork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Observation{
+		{IP: netip.MustParseAddr("192.168.1.10"), Mac: "00:11:22:33:44:55", Broadcast: "192.168.1.255"},
+		{IP: netip.MustParseAddr("192.168.1.12"), Mac: "00:11:22:33:44:66", Broadcast: "192.168.1.255"},
+	}
+	if len(observations) != len(want) {
+		t.Fatalf("observations = %+v, want %+v", observations, want)
+	}
+	for i := range want {
+		if observations[i] != want[i] {
+			t.Errorf("observation %d = %+v, want %+v", i, observations[i], want[i])
+		}
+	}
+
+	if _, err := observeTable(filepath.Join(t.TempDir(), "arp"), homeNetwork); err == nil {
+		t.Error("reading a missing neighbour table succeeded")
+	}
+}
+
+func TestTrackerReportsChanges(t *testing.T) {
+	var reports [][]Observation
+	tr := &tracker{
+		path:    writeARP(t, arpTable),
+		subnets: func() ([]subnet, error) { return homeNetwork, nil },
+		report:  func(o []Observation) { reports = append(reports, o) },
+		known:   make(map[netip.Addr]string),
+	}
+	sniff := func(ip, mac string) {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.sniffed(neighbour{ip: netip.MustParseAddr(ip), mac: hw})
+	}
+
+	tr.poll()
+	if len(reports) != 1 || len(reports[0]) != 2 {
+		t.Fatalf("reports after polling = %+v, want the two neighbours", reports)
+	}
+
+	sniff("192.168.1.10", "00:11:22:33:44:55") // Already known
+	sniff("192.168.2.10", "00:11:22:33:44:99") // Not a local network
+	if len(reports) != 1 {
+		t.Fatalf("reports = %+v, want no new report for known or foreign addresses", reports[1:])
+	}
+
+	sniff("192.168.1.10", "00:11:22:33:44:aa") // New MAC address
+	sniff("192.168.1.20", "00:11:22:33:44:bb") // New IP address
+	sniff("192.168.1.20", "00:11:22:33:44:bb") // Reported just now
+	if len(reports) != 3 {
+		t.Fatalf("reports = %+v, want two new ones", reports[1:])
+	}
+	for i, want := range []string{"192.168.1.10 00:11:22:33:44:aa", "192.168.1.20 00:11:22:33:44:bb"} {
+		r := reports[i+1]
+		if len(r) != 1 || r[0].IP.String()+" "+r[0].Mac != want {
+			t.Errorf("report %d = %+v, want %s", i+1, r, want)
+		}
+	}
+
+	// The neighbour table wins again when it is read
+	tr.poll()
+	sniff("192.168.1.10", "00:11:22:33:44:aa")
+	if len(reports) != 5 {
+		t.Errorf("reports = %d, want the table and the changed MAC address again", len(reports))
+	}
+}
+
+func TestTrack(t *testing.T) {
+	t.Run("negative interval disables tracking", func(t *testing.T) {
+		config := Config{TrackInterval: -1, ARPFile: filepath.Join(t.TempDir(), "missing")}
+		err := Track(t.Context(), config, func([]Observation) { t.Error("reported while disabled") })
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("missing neighbour table", func(t *testing.T) {
+		config := Config{ARPFile: filepath.Join(t.TempDir(), "missing")}
+		if err := Track(t.Context(), config, func([]Observation) {}); err == nil {
+			t.Fatal("tracking without a neighbour table succeeded")
+		}
+	})
+
+	t.Run("reads the table until done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		config := Config{TrackInterval: 10 * time.Millisecond, ARPFile: writeARP(t, arpTable)}
+		polls := 0
+		err := Track(ctx, config, func([]Observation) {
+			if polls++; polls == 3 {
+				cancel()
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if polls < 3 {
+			t.Errorf("polls = %d, want at least 3", polls)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package event
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	WakeRequested     = "wake.requested"
+	WakeSent          = "wake.sent"
+	WakeFailed        = "wake.failed"
+	ShutdownRequested = "shutdown.requested"
+	ShutdownSent      = "shutdown.sent"
+	ShutdownFailed    = "shutdown.failed"
+	DeviceOnline      = "device.online"
+	DeviceOffline     = "device.offline"
+	DeviceMoved       = "device.moved"
+	ConfigReloaded    = "config.reloaded"
+	MQTTConnected     = "mqtt.connected"
+	MQTTDisconnected  = "mqtt.disconnected"
+)
+
+// DefaultReplaySize is the number of events kept for reconnecting clients.
+const DefaultReplaySize = 256
+
+// subscriberBuffer is the number of events a subscriber may fall behind
+// before it is dropped.
+const subscriberBuffer = 64
+
+// Event is a typed notification published on the bus.
+type Event struct {
+	ID     uint64            `json:"id"`
+	Type   string            `json:"type"`
+	Time   time.Time         `json:"time"`
+	Device string            `json:"device,omitempty"`
+	Mac    string            `json:"mac,omitempty"`
+	Source string            `json:"source,omitempty"` // Listener type that caused the event
+	Error  string            `json:"error,omitempty"`
+	Data   map[string]string `json:"data,omitempty"`
+}
+
+// Bus fans events out to subscribers and keeps the most recent ones for
+// replay. A nil *Bus discards all events.
+type Bus struct {
+	mu          sync.Mutex
+	nextID      uint64
+	replay      []Event // Ring buffer, oldest first once wrapped
+	start       int
+	subscribers map[chan Event]struct{}
+}
+
+// NewBus creates a bus that keeps the last size events for replay.
+func NewBus(size int) *Bus {
+	if size <= 0 {
+		size = DefaultReplaySize
+	}
+	return &Bus{
+		nextID:      1,
+		replay:      make([]Event, 0, size),
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish assigns the event an ID and time and delivers it to all
+// subscribers. Subscribers that cannot keep up are dropped; they are
+// expected to reconnect and resume from the replay buffer.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e.ID = b.nextID
+	b.nextID++
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if len(b.replay) < cap(b.replay) {
+		b.replay = append(b.replay, e)
+	} else {
+		b.replay[b.start] = e
+		b.start = (b.start + 1) % len(b.replay)
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Recent returns the events in the replay buffer, oldest first.
+func (b *Bus) Recent() []Event {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.recent()
+}
+
+// recent returns the replay buffer in order. b.mu must be held.
+func (b *Bus) recent() []Event {
+	events := make([]Event, 0, len(b.replay))
+	for i := range b.replay {
+		events = append(events, b.replay[(b.start+i)%len(b.replay)])
+	}
+	return events
+}
+
+// Subscribe returns the buffered events published after lastID, followed by
+// a channel of new events. The channel is closed when cancel is called or
+// the subscriber falls too far behind. A lastID of 0 replays nothing.
+func (b *Bus) Subscribe(lastID uint64) (replay []Event, events <-chan Event, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if lastID > 0 {
+		for _, e := range b.recent() {
+			if e.ID > lastID {
+				replay = append(replay, e)
+			}
+		}
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return replay, ch, cancel
+}
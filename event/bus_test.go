@@ -0,0 +1,101 @@
+package event
+
+import (
+	"slices"
+	"testing"
+)
+
+func ids(events []Event) []uint64 {
+	var ids []uint64
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestBusReplay(t *testing.T) {
+	b := NewBus(3)
+	for range 5 {
+		b.Publish(Event{Type: WakeSent, Device: "desktop"})
+	}
+
+	if got := ids(b.Recent()); !slices.Equal(got, []uint64{3, 4, 5}) {
+		t.Errorf("recent = %v, want the last three", got)
+	}
+	for _, e := range b.Recent() {
+		if e.Time.IsZero() {
+			t.Errorf("event %d has no time", e.ID)
+		}
+	}
+
+	tests := []struct {
+		lastID uint64
+		want   []uint64
+	}{
+		{lastID: 0, want: nil}, // A new client replays nothing
+		{lastID: 1, want: []uint64{3, 4, 5}},
+		{lastID: 3, want: []uint64{4, 5}},
+		{lastID: 5, want: nil},
+		{lastID: 9, want: nil},
+	}
+	for _, tt := range tests {
+		replay, _, cancel := b.Subscribe(tt.lastID)
+		cancel()
+		if got := ids(replay); !slices.Equal(got, tt.want) {
+			t.Errorf("replay after %d = %v, want %v", tt.lastID, got, tt.want)
+		}
+	}
+}
+
+func TestBusSubscribe(t *testing.T) {
+	b := NewBus(0)
+	_, events, cancel := b.Subscribe(0)
+	defer cancel()
+
+	b.Publish(Event{Type: DeviceOnline, Device: "nas"})
+	if e := <-events; e.ID != 1 || e.Type != DeviceOnline || e.Device != "nas" {
+		t.Errorf("event = %+v", e)
+	}
+
+	cancel()
+	cancel() // Cancelling twice is fine
+	if _, ok := <-events; ok {
+		t.Error("channel open after cancel")
+	}
+	b.Publish(Event{Type: DeviceOffline}) // Nobody to deliver to
+}
+
+func TestBusDropsSlowSubscribers(t *testing.T) {
+	b := NewBus(0)
+	_, slow, cancelSlow := b.Subscribe(0)
+	defer cancelSlow()
+	_, fast, cancelFast := b.Subscribe(0)
+	defer cancelFast()
+
+	for range subscriberBuffer + 1 {
+		b.Publish(Event{Type: WakeSent})
+		<-fast
+	}
+
+	// The buffered events are still delivered before the channel closes
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Errorf("slow subscriber received %d events, want %d", received, subscriberBuffer)
+	}
+
+	b.Publish(Event{Type: WakeSent})
+	if e, ok := <-fast; !ok || e.ID != subscriberBuffer+2 {
+		t.Errorf("fast subscriber got %+v, %v after the slow one was dropped", e, ok)
+	}
+}
+
+func TestNilBus(t *testing.T) {
+	var b *Bus
+	b.Publish(Event{Type: WakeSent})
+	if got := b.Recent(); got != nil {
+		t.Errorf("recent = %v, want nil", got)
+	}
+}
@@ -0,0 +1,634 @@
+package listener
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/p3ddd/HomeGuard/configfile"
+	"github.com/p3ddd/HomeGuard/device"
+	"github.com/p3ddd/HomeGuard/event"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// Error codes of the JSON API.
+const (
+	ErrCodeInvalidRequest        = "invalid_request"
+	ErrCodeUnauthorized          = "unauthorized"
+	ErrCodeNotFound              = "not_found"
+	ErrCodeNotAcceptable         = "not_acceptable"
+	ErrCodeUnsupportedMediaType  = "unsupported_media_type"
+	ErrCodeShutdownNotConfigured = "shutdown_not_configured"
+	ErrCodeInvalidConfig         = "invalid_config"
+	ErrCodeConflict              = "conflict"
+	ErrCodeUnavailable           = "unavailable"
+)
+
+// APIError is the error envelope returned by the JSON API.
+type APIError struct {
+	Error APIErrorDetail `json:"error"`
+}
+
+// APIErrorDetail describes an API error. Code is one of the ErrCode constants.
+type APIErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// AcceptedPayload is returned for requests queued for processing.
+type AcceptedPayload struct {
+	Action  string   `json:"action"`
+	Device  string   `json:"device,omitempty"`
+	Mac     string   `json:"mac,omitempty"`
+	Group   string   `json:"group,omitempty"`
+	Devices []string `json:"devices,omitempty"`
+}
+
+// DevicePayload represents a device in the JSON API.
+type DevicePayload struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Mac         string    `json:"mac"`
+	Broadcast   string    `json:"broadcast"`
+	Host        string    `json:"host,omitempty"`
+	Groups      []string  `json:"groups,omitempty"`
+	HasAgent    bool      `json:"has_agent"` // Online status is only known for devices with an agent
+	Online      bool      `json:"online"`
+	LastSeen    time.Time `json:"last_seen,omitzero"`
+	CanShutdown bool      `json:"can_shutdown"`
+	Source      string    `json:"source,omitempty"` // File the device was imported from
+}
+
+// GroupPayload represents a device group in the JSON API.
+type GroupPayload struct {
+	Name    string   `json:"name"`
+	Devices []string `json:"devices"`
+}
+
+// SchedulePayload represents a schedule in the JSON API.
+type SchedulePayload struct {
+	Name    string    `json:"name"`
+	Device  string    `json:"device,omitempty"`
+	Group   string    `json:"group,omitempty"`
+	Action  string    `json:"action"`
+	At      string    `json:"at"`
+	Days    []string  `json:"days,omitempty"`
+	NextRun time.Time `json:"next_run,omitzero"`
+}
+
+// QueuePayload reports the state of the request queue in the JSON API.
+type QueuePayload struct {
+	Workers       int     `json:"workers"`
+	Size          int     `json:"size"`
+	Queued        int     `json:"queued"`
+	Scheduled     int     `json:"scheduled"` // Queued requests of schedules
+	Active        int     `json:"active"`
+	OldestSeconds float64 `json:"oldest_seconds"` // Time the oldest queued request has waited
+	Enqueued      uint64  `json:"enqueued"`
+	Processed     uint64  `json:"processed"`
+	Dropped       uint64  `json:"dropped"` // Rejected by listeners that do not wait, like MQTT
+	Waited        uint64  `json:"waited"`  // Requests that waited for room, like HTTP
+}
+
+// ValidationPayload is returned for a configuration that passed validation.
+type ValidationPayload struct {
+	Valid bool `json:"valid"`
+}
+
+// maxConfigBody limits the size of configuration files sent for validation.
+const maxConfigBody = 1 << 20
+
+// yamlMediaTypes and tomlMediaTypes are accepted by /api/v1/config/validate
+// in addition to the usual request types. text/plain and a missing type mean
+// the format of the server's configuration file.
+var (
+	yamlMediaTypes = []string{"application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml"}
+	tomlMediaTypes = []string{"application/toml", "text/toml"}
+)
+
+// SetTokens sets the bearer tokens required by the API. Without tokens the
+// API is open. It must be called before Start.
+func (l *HTTPListener) SetTokens(tokens []string) {
+	l.tokens = slices.Clone(tokens)
+}
+
+// SetConfigValidator sets the function behind /api/v1/config/validate,
+// which checks a configuration file the way the server would load it. fn
+// gets the configfile format of the body, or an empty format for that of
+// the server's configuration file. It must be called before Start.
+func (l *HTTPListener) SetConfigValidator(fn func(format string, data []byte) error) {
+	l.validate = fn
+}
+
+// registerAPI registers the /api/v1 endpoints on mux.
+func (l *HTTPListener) registerAPI(ctx context.Context, mux *http.ServeMux, queue *Queue) {
+	api := func(next http.HandlerFunc) http.HandlerFunc {
+		return l.negotiate(l.requireToken(next))
+	}
+
+	mux.HandleFunc("GET /api/v1/openapi.json", l.negotiate(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(openAPISpec)
+	}))
+
+	// Wake by device name or MAC address
+	mux.HandleFunc("POST /api/v1/wake", api(func(w http.ResponseWriter, r *http.Request) {
+		payload, err := parseWakeUpPayload(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if payload.Device == "" && (payload.Mac == "" || payload.Broadcast == "") {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "must provide either device or both mac and broadcast")
+			return
+		}
+		if payload.Device != "" && !l.hasDevice(w, payload.Device) {
+			return
+		}
+
+		request := WakeUpRequest{
+			Type:       l.Name(),
+			DeviceName: payload.Device,
+			Mac:        payload.Mac,
+			Broadcast:  payload.Broadcast,
+			Action:     ActionWake,
+		}
+		if !l.enqueue(w, r, queue, request) {
+			return
+		}
+		l.logger().Info("Received wakeup request",
+			"device", request.DeviceName,
+			"mac", request.Mac,
+			"broadcast", request.Broadcast)
+		writeJSON(w, http.StatusAccepted, AcceptedPayload{Action: ActionWake, Device: payload.Device, Mac: payload.Mac})
+	}))
+
+	// Devices
+	mux.HandleFunc("GET /api/v1/devices", api(l.handleListDevices))
+	mux.HandleFunc("POST /api/v1/devices", api(l.handleAddDevice))
+	mux.HandleFunc("GET /api/v1/devices/{name}", api(func(w http.ResponseWriter, r *http.Request) {
+		if !l.hasDevice(w, r.PathValue("name")) {
+			return
+		}
+		dev, _ := l.devices.GetDevice(r.PathValue("name"))
+		writeJSON(w, http.StatusOK, l.devicePayload(dev))
+	}))
+	mux.HandleFunc("GET /api/v1/devices/{name}/status", api(l.handleDeviceStatus))
+
+	mux.HandleFunc("POST /api/v1/devices/{name}/wake", api(func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if !l.hasDevice(w, name) {
+			return
+		}
+		request := WakeUpRequest{Type: l.Name(), DeviceName: name, Action: ActionWake}
+		if !l.enqueue(w, r, queue, request) {
+			return
+		}
+		l.logger().Info("Received wakeup request", "device", name)
+		writeJSON(w, http.StatusAccepted, AcceptedPayload{Action: ActionWake, Device: name})
+	}))
+
+	mux.HandleFunc("POST /api/v1/devices/{name}/shutdown", api(func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if !l.hasDevice(w, name) {
+			return
+		}
+		if dev, _ := l.devices.GetDevice(name); dev.Shutdown == nil {
+			writeError(w, http.StatusConflict, ErrCodeShutdownNotConfigured, "device has no shutdown action configured: "+name)
+			return
+		}
+		request := WakeUpRequest{Type: l.Name(), DeviceName: name, Action: ActionShutdown}
+		if !l.enqueue(w, r, queue, request) {
+			return
+		}
+		l.logger().Info("Received shutdown request", "device", name)
+		writeJSON(w, http.StatusAccepted, AcceptedPayload{Action: ActionShutdown, Device: name})
+	}))
+
+	// Groups
+	mux.HandleFunc("GET /api/v1/groups", api(l.handleListGroups))
+	mux.HandleFunc("POST /api/v1/groups/{name}/wake", api(func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if !l.hasDevices(w) {
+			return
+		}
+		members, err := l.devices.GetGroup(name)
+		if err != nil {
+			writeError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+			return
+		}
+
+		accepted := AcceptedPayload{Action: ActionWake, Group: name}
+		requests := make([]WakeUpRequest, 0, len(members))
+		for _, dev := range members {
+			requests = append(requests, WakeUpRequest{Type: l.Name(), DeviceName: dev.Name, Action: ActionWake})
+			accepted.Devices = append(accepted.Devices, dev.Name)
+		}
+		if !l.enqueue(w, r, queue, requests...) {
+			return
+		}
+		l.logger().Info("Received group wakeup request", "group", name, "devices", len(members))
+		writeJSON(w, http.StatusAccepted, accepted)
+	}))
+
+	// Schedules
+	mux.HandleFunc("GET /api/v1/schedules", api(l.handleListSchedules))
+
+	// Request queue metrics
+	mux.HandleFunc("GET /api/v1/queue", api(func(w http.ResponseWriter, r *http.Request) {
+		stats := queue.Stats()
+		writeJSON(w, http.StatusOK, QueuePayload{
+			Workers:       stats.Workers,
+			Size:          stats.Size,
+			Queued:        stats.Queued,
+			Scheduled:     stats.Scheduled,
+			Active:        stats.Active,
+			OldestSeconds: stats.OldestAge.Seconds(),
+			Enqueued:      stats.Enqueued,
+			Processed:     stats.Processed,
+			Dropped:       stats.Dropped,
+			Waited:        stats.Waited,
+		})
+	}))
+
+	// Network discovery
+	mux.HandleFunc("POST /api/v1/discover", api(l.handleDiscover))
+
+	// Configuration validation, sent as the raw YAML file
+	mux.HandleFunc("POST /api/v1/config/validate", l.negotiate(l.requireToken(l.handleValidateConfig), slices.Concat(yamlMediaTypes, tomlMediaTypes, []string{"text/plain"})...))
+
+	// Recent history and live events. The event stream negotiates SSE or
+	// websocket itself.
+	mux.HandleFunc("GET /api/v1/history", api(l.handleHistory))
+	mux.HandleFunc("GET /api/v1/events", l.requireToken(func(w http.ResponseWriter, r *http.Request) {
+		l.handleEvents(ctx, w, r)
+	}))
+
+	// Agent heartbeats, authenticated with the device's agent token
+	mux.HandleFunc("POST /api/v1/agents/heartbeat", l.negotiate(l.handleHeartbeat))
+
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "no such endpoint: "+r.Method+" "+r.URL.Path)
+	})
+}
+
+// negotiate rejects requests that do not accept a JSON response, or whose
+// body is neither JSON, a form nor one of extraTypes.
+func (l *HTTPListener) negotiate(next http.HandlerFunc, extraTypes ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsJSON(r.Header.Get("Accept")) {
+			writeError(w, http.StatusNotAcceptable, ErrCodeNotAcceptable, "responses are only available as application/json")
+			return
+		}
+		if contentType := r.Header.Get("Content-Type"); contentType != "" {
+			mediaType, _, err := mime.ParseMediaType(contentType)
+			if err != nil || !(isJSON(mediaType) ||
+				mediaType == "application/x-www-form-urlencoded" ||
+				mediaType == "multipart/form-data" ||
+				slices.Contains(extraTypes, mediaType)) {
+				writeError(w, http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType,
+					"unsupported content type: "+contentType)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// acceptsJSON reports whether an Accept header allows a JSON response.
+func acceptsJSON(accept string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q <= 0 {
+			continue
+		}
+		if mediaRange == "*/*" || mediaRange == "application/*" || isJSON(mediaRange) {
+			return true
+		}
+	}
+	return false
+}
+
+// requireToken rejects requests without a valid bearer token. The token may
+// also be passed as the access_token query parameter, as browsers cannot set
+// headers on EventSource connections.
+func (l *HTTPListener) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(l.tokens) == 0 {
+			next(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("access_token")
+		}
+		for _, valid := range l.tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(valid)) == 1 {
+				next(w, r)
+				return
+			}
+		}
+
+		l.logger().Warn("Rejected API request with invalid token", "path", r.URL.Path, "remote", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="homeguard"`)
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "missing or invalid token")
+			return
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}
+}
+
+// enqueue adds requests to queue, waiting while it is full. It writes an
+// error and returns false if the server shuts down or the client goes away
+// first.
+func (l *HTTPListener) enqueue(w http.ResponseWriter, r *http.Request, queue *Queue, requests ...WakeUpRequest) bool {
+	for _, request := range requests {
+		if err := queue.Enqueue(r.Context(), request); err != nil {
+			l.logger().Warn("Failed to queue request", "error", err, "action", request.Action, "device", request.DeviceName)
+			writeError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "server shutting down")
+			return false
+		}
+	}
+	return true
+}
+
+// hasDevices writes an error and returns false if no device configuration
+// is loaded.
+func (l *HTTPListener) hasDevices(w http.ResponseWriter) bool {
+	if l.devices == nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "no device configuration loaded")
+		return false
+	}
+	return true
+}
+
+// hasDevice writes an error and returns false if the device does not exist.
+func (l *HTTPListener) hasDevice(w http.ResponseWriter, name string) bool {
+	if !l.hasDevices(w) {
+		return false
+	}
+	if !l.devices.HasDevice(name) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "device not found: "+name)
+		return false
+	}
+	return true
+}
+
+func (l *HTTPListener) devicePayload(dev device.Device) DevicePayload {
+	status, _ := l.devices.GetStatus(dev.Name)
+	return DevicePayload{
+		Name:        dev.Name,
+		Description: dev.Description,
+		Mac:         dev.Mac,
+		Broadcast:   dev.Broadcast,
+		Host:        dev.Host,
+		Groups:      dev.Groups,
+		HasAgent:    dev.Agent != nil,
+		Online:      status.Online,
+		LastSeen:    status.LastSeen,
+		CanShutdown: dev.Shutdown != nil,
+		Source:      dev.Source,
+	}
+}
+
+func (l *HTTPListener) handleListDevices(w http.ResponseWriter, r *http.Request) {
+	if !l.hasDevices(w) {
+		return
+	}
+
+	devices := l.devices.ListDevices()
+	slices.SortFunc(devices, func(a, b device.Device) int { return strings.Compare(a.Name, b.Name) })
+
+	payload := make([]DevicePayload, 0, len(devices))
+	for _, dev := range devices {
+		payload = append(payload, l.devicePayload(dev))
+	}
+	writeJSON(w, http.StatusOK, payload)
+}
+
+func (l *HTTPListener) handleDeviceStatus(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !l.hasDevice(w, name) {
+		return
+	}
+	status, err := l.devices.GetStatus(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	}
+	payload := StatusPayload{
+		Device:        name,
+		Online:        status.Online,
+		LastSeen:      status.LastSeen,
+		Hostname:      status.Hostname,
+		IP:            status.IP,
+		UptimeSeconds: int64(status.Uptime / time.Second),
+		Mac:           status.Mac,
+		AddressSeen:   status.AddressSeen,
+	}
+	for _, c := range status.AddressChanges {
+		payload.AddressChanges = append(payload.AddressChanges, AddressChangePayload{
+			Time:        c.Time,
+			IP:          c.IP,
+			PreviousIP:  c.PreviousIP,
+			Mac:         c.Mac,
+			PreviousMac: c.PreviousMac,
+		})
+	}
+	writeJSON(w, http.StatusOK, payload)
+}
+
+func (l *HTTPListener) handleListGroups(w http.ResponseWriter, r *http.Request) {
+	if !l.hasDevices(w) {
+		return
+	}
+
+	groups := l.devices.ListGroups()
+	payload := make([]GroupPayload, 0, len(groups))
+	for name, members := range groups {
+		payload = append(payload, GroupPayload{Name: name, Devices: members})
+	}
+	slices.SortFunc(payload, func(a, b GroupPayload) int { return strings.Compare(a.Name, b.Name) })
+	writeJSON(w, http.StatusOK, payload)
+}
+
+func (l *HTTPListener) handleListSchedules(w http.ResponseWriter, r *http.Request) {
+	if !l.hasDevices(w) {
+		return
+	}
+
+	now := time.Now()
+	schedules := l.devices.ListSchedules()
+	payload := make([]SchedulePayload, 0, len(schedules))
+	for _, s := range schedules {
+		action := s.Action
+		if action == "" {
+			action = ActionWake
+		}
+		payload = append(payload, SchedulePayload{
+			Name:    s.Name,
+			Device:  s.Device,
+			Group:   s.Group,
+			Action:  action,
+			At:      s.At,
+			Days:    s.Days,
+			NextRun: s.Next(now),
+		})
+	}
+	writeJSON(w, http.StatusOK, payload)
+}
+
+func (l *HTTPListener) handleValidateConfig(w http.ResponseWriter, r *http.Request) {
+	if l.validate == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "configuration validation is not available")
+		return
+	}
+
+	format, ok := configFormat(r.Header.Get("Content-Type"))
+	if !ok {
+		writeError(w, http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType,
+			"unsupported content type for a configuration file: "+r.Header.Get("Content-Type"))
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigBody))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "failed to read body: "+err.Error())
+		return
+	}
+	if err := l.validate(format, data); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, ErrCodeInvalidConfig, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ValidationPayload{Valid: true})
+}
+
+// configFormat returns the configfile format of a configuration file sent
+// with contentType, or an empty format if the type does not tell. It
+// reports false for types that are no configuration format.
+func configFormat(contentType string) (string, bool) {
+	if contentType == "" {
+		return "", true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	switch {
+	case err != nil:
+		return "", false
+	case isJSON(mediaType):
+		return configfile.FormatJSON, true
+	case slices.Contains(yamlMediaTypes, mediaType):
+		return configfile.FormatYAML, true
+	case slices.Contains(tomlMediaTypes, mediaType):
+		return configfile.FormatTOML, true
+	case mediaType == "text/plain":
+		return "", true
+	}
+	return "", false
+}
+
+// handleHistory returns the outcome of recent requests, newest first.
+func (l *HTTPListener) handleHistory(w http.ResponseWriter, r *http.Request) {
+	history := make([]event.Event, 0)
+	for _, e := range l.events.Recent() {
+		switch e.Type {
+		case event.WakeSent, event.WakeFailed, event.ShutdownSent, event.ShutdownFailed:
+			history = append(history, e)
+		}
+	}
+	slices.Reverse(history)
+	writeJSON(w, http.StatusOK, history)
+}
+
+func (l *HTTPListener) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if !l.hasDevices(w) {
+		return
+	}
+
+	var payload HeartbeatPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		l.logger().Error("Failed to parse heartbeat", "error", err)
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "invalid JSON: "+err.Error())
+		return
+	}
+
+	// An unknown device gets the same answer as a wrong token, so device
+	// names cannot be probed without one
+	dev, err := l.devices.GetDevice(payload.Device)
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if err != nil || dev.Agent == nil || subtle.ConstantTimeCompare([]byte(token), []byte(dev.Agent.Token)) != 1 {
+		l.logger().Warn("Rejected heartbeat with invalid token", "device", payload.Device, "remote", r.RemoteAddr)
+		writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "missing or invalid agent token")
+		return
+	}
+
+	interval := time.Duration(payload.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+
+	if payload.Mac != "" {
+		if _, err := net.ParseMAC(payload.Mac); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "invalid MAC address: "+err.Error())
+			return
+		}
+	}
+
+	changes, err := l.devices.RecordHeartbeat(device.Heartbeat{
+		Device:   payload.Device,
+		Hostname: payload.Hostname,
+		IP:       payload.IP,
+		Mac:      payload.Mac,
+		Uptime:   time.Duration(payload.UptimeSeconds) * time.Second,
+		Interval: interval,
+	})
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, err.Error())
+		return
+	}
+	for _, c := range changes {
+		l.logger().Info("Agent reported a new address",
+			"device", c.Device,
+			"ip", c.IP,
+			"mac", c.Mac,
+			"previous_mac", c.PreviousMac)
+		data := map[string]string{"ip": c.IP}
+		if c.PreviousIP != "" {
+			data["previous_ip"] = c.PreviousIP
+		}
+		if c.PreviousMac != "" {
+			data["previous_mac"] = c.PreviousMac
+		}
+		l.events.Publish(event.Event{Type: event.DeviceMoved, Device: c.Device, Mac: c.Mac, Source: l.Name(), Data: data})
+	}
+
+	l.logger().Debug("Received heartbeat", "device", payload.Device, "ip", payload.IP)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, APIError{Error: APIErrorDetail{Code: code, Message: message}})
+}
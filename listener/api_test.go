@@ -0,0 +1,373 @@
+package listener
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/p3ddd/HomeGuard/configfile"
+	"github.com/p3ddd/HomeGuard/device"
+)
+
+func TestValidateConfigFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		want        string
+		wantStatus  int
+	}{
+		{name: "no type", want: "", wantStatus: http.StatusOK},
+		{name: "plain text", contentType: "text/plain; charset=utf-8", want: "", wantStatus: http.StatusOK},
+		{name: "YAML", contentType: "application/yaml", want: configfile.FormatYAML, wantStatus: http.StatusOK},
+		{name: "legacy YAML", contentType: "application/x-yaml", want: configfile.FormatYAML, wantStatus: http.StatusOK},
+		{name: "JSON", contentType: "application/json", want: configfile.FormatJSON, wantStatus: http.StatusOK},
+		{name: "JSON suffix", contentType: "application/homeguard+json", want: configfile.FormatJSON, wantStatus: http.StatusOK},
+		{name: "TOML", contentType: "application/toml", want: configfile.FormatTOML, wantStatus: http.StatusOK},
+		{name: "form", contentType: "application/x-www-form-urlencoded", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "unknown", contentType: "application/xml", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "invalid config", contentType: "application/toml", want: configfile.FormatTOML, wantStatus: http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			l := NewHTTPListener(":0", nil)
+			l.SetConfigValidator(func(format string, data []byte) error {
+				called = true
+				if format != tt.want {
+					t.Errorf("format = %q, want %q", format, tt.want)
+				}
+				if string(data) != "body" {
+					t.Errorf("data = %q, want the request body", data)
+				}
+				if tt.wantStatus == http.StatusUnprocessableEntity {
+					return errors.New("invalid")
+				}
+				return nil
+			})
+
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/config/validate", strings.NewReader("body"))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			l.handler(t.Context(), NewQueue(0, 0, nil)).ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if wantCall := tt.wantStatus != http.StatusUnsupportedMediaType; called != wantCall {
+				t.Errorf("validator called = %v, want %v", called, wantCall)
+			}
+		})
+	}
+}
+
+// newAPIDevices returns devices for the API tests: desktop can be shut
+// down and runs an agent, and desktop and nas form the office group.
+func newAPIDevices(t *testing.T) *device.Manager {
+	t.Helper()
+	config := `devices:
+  - name: desktop
+    mac: "00:11:22:33:44:55"
+    groups: ["office"]
+    shutdown:
+      type: http
+      url: "http://127.0.0.1:1/shutdown"
+    agent:
+      token: "agent-secret"
+  - name: nas
+    mac: "00:11:22:33:44:66"
+    groups: ["office"]
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	devices, err := device.NewManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return devices
+}
+
+// queued closes queue and returns the requests it held as "action device"
+// or "action mac".
+func queued(t *testing.T, queue *Queue) []string {
+	t.Helper()
+	queue.Close()
+	var mu sync.Mutex
+	var got []string
+	queue.Run(t.Context(), func(req WakeUpRequest) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, strings.Join(strings.Fields(req.Action+" "+req.DeviceName+" "+req.Mac), " "))
+	})
+	slices.Sort(got)
+	return got
+}
+
+func TestAPIRoutes(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantCode    string // Error code of the envelope
+		wantQueued  []string
+	}{
+		{name: "wake device", method: http.MethodPost, path: "/api/v1/devices/desktop/wake", wantStatus: http.StatusAccepted, wantQueued: []string{"wake desktop"}},
+		{name: "wake unknown device", method: http.MethodPost, path: "/api/v1/devices/laptop/wake", wantStatus: http.StatusNotFound, wantCode: ErrCodeNotFound},
+		{name: "wake by JSON", method: http.MethodPost, path: "/api/v1/wake", contentType: "application/json", body: `{"device": "nas"}`, wantStatus: http.StatusAccepted, wantQueued: []string{"wake nas"}},
+		{name: "wake MAC by form", method: http.MethodPost, path: "/api/v1/wake", contentType: "application/x-www-form-urlencoded", body: "mac=00:11:22:33:44:77&broadcast=192.168.1.255", wantStatus: http.StatusAccepted, wantQueued: []string{"wake 00:11:22:33:44:77"}},
+		{name: "wake MAC without broadcast", method: http.MethodPost, path: "/api/v1/wake", contentType: "application/json", body: `{"mac": "00:11:22:33:44:77"}`, wantStatus: http.StatusBadRequest, wantCode: ErrCodeInvalidRequest},
+		{name: "wake invalid JSON", method: http.MethodPost, path: "/api/v1/wake", contentType: "application/json", body: `{"device":`, wantStatus: http.StatusBadRequest, wantCode: ErrCodeInvalidRequest},
+		{name: "wake unknown device by JSON", method: http.MethodPost, path: "/api/v1/wake", contentType: "application/json", body: `{"device": "laptop"}`, wantStatus: http.StatusNotFound, wantCode: ErrCodeNotFound},
+		{name: "shut down", method: http.MethodPost, path: "/api/v1/devices/desktop/shutdown", wantStatus: http.StatusAccepted, wantQueued: []string{"shutdown desktop"}},
+		{name: "shut down without an action", method: http.MethodPost, path: "/api/v1/devices/nas/shutdown", wantStatus: http.StatusConflict, wantCode: ErrCodeShutdownNotConfigured},
+		{name: "wake group", method: http.MethodPost, path: "/api/v1/groups/office/wake", wantStatus: http.StatusAccepted, wantQueued: []string{"wake desktop", "wake nas"}},
+		{name: "wake unknown group", method: http.MethodPost, path: "/api/v1/groups/lab/wake", wantStatus: http.StatusNotFound, wantCode: ErrCodeNotFound},
+		{name: "device", method: http.MethodGet, path: "/api/v1/devices/nas", wantStatus: http.StatusOK},
+		{name: "unknown device", method: http.MethodGet, path: "/api/v1/devices/laptop", wantStatus: http.StatusNotFound, wantCode: ErrCodeNotFound},
+		{name: "devices", method: http.MethodGet, path: "/api/v1/devices", wantStatus: http.StatusOK},
+		{name: "groups", method: http.MethodGet, path: "/api/v1/groups", wantStatus: http.StatusOK},
+		{name: "queue", method: http.MethodGet, path: "/api/v1/queue", wantStatus: http.StatusOK},
+		{name: "OpenAPI", method: http.MethodGet, path: "/api/v1/openapi.json", wantStatus: http.StatusOK},
+		{name: "unknown endpoint", method: http.MethodGet, path: "/api/v1/reboot", wantStatus: http.StatusNotFound, wantCode: ErrCodeNotFound},
+		{name: "unknown method", method: http.MethodDelete, path: "/api/v1/devices/desktop", wantStatus: http.StatusNotFound, wantCode: ErrCodeNotFound},
+		{name: "unknown version", method: http.MethodGet, path: "/api/v2/devices", wantStatus: http.StatusNotFound, wantCode: ErrCodeNotFound},
+	}
+	devices := newAPIDevices(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewHTTPListener(":0", devices)
+			queue := NewQueue(10, 1, devices)
+
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			l.handler(t.Context(), queue).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("content type = %q, want application/json", ct)
+			}
+			var envelope APIError
+			_ = json.Unmarshal(w.Body.Bytes(), &envelope)
+			if envelope.Error.Code != tt.wantCode {
+				t.Errorf("error code = %q, want %q: %s", envelope.Error.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode != "" && envelope.Error.Message == "" {
+				t.Error("error without a message")
+			}
+			if got := queued(t, queue); !slices.Equal(got, tt.wantQueued) {
+				t.Errorf("queued = %q, want %q", got, tt.wantQueued)
+			}
+		})
+	}
+}
+
+func TestAPIUnavailable(t *testing.T) {
+	devices := newAPIDevices(t)
+	l := NewHTTPListener(":0", devices)
+	queue := NewQueue(10, 1, devices)
+	queue.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/devices/desktop/wake", nil)
+	w := httptest.NewRecorder()
+	l.handler(t.Context(), queue).ServeHTTP(w, r)
+	var envelope APIError
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusServiceUnavailable || envelope.Error.Code != ErrCodeUnavailable {
+		t.Errorf("response = %d %+v, want %d %s", w.Code, envelope.Error, http.StatusServiceUnavailable, ErrCodeUnavailable)
+	}
+}
+
+func TestAPINegotiate(t *testing.T) {
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		wantStatus  int
+		wantCode    string
+	}{
+		{name: "no headers", wantStatus: http.StatusAccepted},
+		{name: "JSON", accept: "application/json", contentType: "application/json", wantStatus: http.StatusAccepted},
+		{name: "JSON suffix", accept: "application/problem+json", contentType: "application/merge-patch+json; charset=utf-8", wantStatus: http.StatusAccepted},
+		{name: "any type", accept: "text/html, */*;q=0.1", wantStatus: http.StatusAccepted},
+		{name: "any application type", accept: "application/*", wantStatus: http.StatusAccepted},
+		{name: "form", contentType: "application/x-www-form-urlencoded", wantStatus: http.StatusAccepted},
+		{name: "multipart form", contentType: "multipart/form-data; boundary=x", wantStatus: http.StatusBadRequest, wantCode: ErrCodeInvalidRequest},
+		{name: "HTML only", accept: "text/html", wantStatus: http.StatusNotAcceptable, wantCode: ErrCodeNotAcceptable},
+		{name: "JSON refused", accept: "application/json;q=0, text/html", wantStatus: http.StatusNotAcceptable, wantCode: ErrCodeNotAcceptable},
+		{name: "XML body", contentType: "application/xml", wantStatus: http.StatusUnsupportedMediaType, wantCode: ErrCodeUnsupportedMediaType},
+		{name: "YAML body", contentType: "application/yaml", wantStatus: http.StatusUnsupportedMediaType, wantCode: ErrCodeUnsupportedMediaType},
+		{name: "invalid type", contentType: "application/json; =", wantStatus: http.StatusUnsupportedMediaType, wantCode: ErrCodeUnsupportedMediaType},
+		{name: "Accept checked first", accept: "text/html", contentType: "application/xml", wantStatus: http.StatusNotAcceptable, wantCode: ErrCodeNotAcceptable},
+	}
+	devices := newAPIDevices(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewHTTPListener(":0", devices)
+			body := ""
+			if strings.Contains(tt.contentType, "json") {
+				body = `{"device": "desktop"}`
+			} else if tt.contentType == "application/x-www-form-urlencoded" {
+				body = "device=desktop"
+			}
+			path := "/api/v1/wake"
+			if body == "" && tt.contentType == "" {
+				path = "/api/v1/devices/desktop/wake"
+			}
+
+			r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			l.handler(t.Context(), NewQueue(10, 1, devices)).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			var envelope APIError
+			_ = json.Unmarshal(w.Body.Bytes(), &envelope)
+			if envelope.Error.Code != tt.wantCode {
+				t.Errorf("error code = %q, want %q", envelope.Error.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAPIRequireToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		header     string // Authorization header
+		wantStatus int
+	}{
+		{name: "no token", path: "/api/v1/devices", wantStatus: http.StatusUnauthorized},
+		{name: "bearer token", path: "/api/v1/devices", header: "Bearer secret", wantStatus: http.StatusOK},
+		{name: "second token", path: "/api/v1/devices", header: "Bearer other", wantStatus: http.StatusOK},
+		{name: "wrong token", path: "/api/v1/devices", header: "Bearer guess", wantStatus: http.StatusUnauthorized},
+		{name: "token prefix", path: "/api/v1/devices", header: "Bearer secre", wantStatus: http.StatusUnauthorized},
+		{name: "basic auth", path: "/api/v1/devices", header: "Basic c2VjcmV0", wantStatus: http.StatusUnauthorized},
+		{name: "query token", path: "/api/v1/devices?access_token=secret", wantStatus: http.StatusOK},
+		{name: "wrong query token", path: "/api/v1/devices?access_token=guess", wantStatus: http.StatusUnauthorized},
+		{name: "header wins over query", path: "/api/v1/devices?access_token=secret", header: "Bearer guess", wantStatus: http.StatusUnauthorized},
+		{name: "history", path: "/api/v1/history", wantStatus: http.StatusUnauthorized},
+		{name: "events", path: "/api/v1/events", wantStatus: http.StatusUnauthorized},
+		{name: "OpenAPI is public", path: "/api/v1/openapi.json", wantStatus: http.StatusOK},
+	}
+	devices := newAPIDevices(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewHTTPListener(":0", devices)
+			l.SetTokens([]string{"secret", "other"})
+
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			l.handler(t.Context(), NewQueue(10, 1, devices)).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusUnauthorized {
+				return
+			}
+			if got := w.Header().Get("WWW-Authenticate"); got != `Bearer realm="homeguard"` {
+				t.Errorf("WWW-Authenticate = %q", got)
+			}
+			var envelope APIError
+			if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil || envelope.Error.Code != ErrCodeUnauthorized {
+				t.Errorf("body = %s, want an %s error", w.Body, ErrCodeUnauthorized)
+			}
+		})
+	}
+}
+
+func TestAPIHeartbeat(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       string
+		body       string
+		wantStatus int
+		wantCode   string
+		wantOnline bool
+	}{
+		{name: "valid", auth: "Bearer agent-secret", body: `{"device": "desktop", "ip": "192.168.1.10", "mac": "00:11:22:33:44:55"}`, wantStatus: http.StatusNoContent, wantOnline: true},
+		{name: "wrong token", auth: "Bearer guess", body: `{"device": "desktop"}`, wantStatus: http.StatusUnauthorized, wantCode: ErrCodeUnauthorized},
+		{name: "no token", body: `{"device": "desktop"}`, wantStatus: http.StatusUnauthorized, wantCode: ErrCodeUnauthorized},
+		{name: "device without an agent", auth: "Bearer agent-secret", body: `{"device": "nas"}`, wantStatus: http.StatusUnauthorized, wantCode: ErrCodeUnauthorized},
+		{name: "unknown device", auth: "Bearer agent-secret", body: `{"device": "laptop"}`, wantStatus: http.StatusUnauthorized, wantCode: ErrCodeUnauthorized},
+		{name: "invalid MAC", auth: "Bearer agent-secret", body: `{"device": "desktop", "mac": "nope"}`, wantStatus: http.StatusBadRequest, wantCode: ErrCodeInvalidRequest},
+		{name: "invalid JSON", auth: "Bearer agent-secret", body: `{"device":`, wantStatus: http.StatusBadRequest, wantCode: ErrCodeInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devices := newAPIDevices(t)
+			l := NewHTTPListener(":0", devices)
+			// API tokens do not apply to agents
+			l.SetTokens([]string{"secret"})
+
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/agents/heartbeat", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			l.handler(t.Context(), NewQueue(10, 1, devices)).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			var envelope APIError
+			_ = json.Unmarshal(w.Body.Bytes(), &envelope)
+			if envelope.Error.Code != tt.wantCode {
+				t.Errorf("error code = %q, want %q", envelope.Error.Code, tt.wantCode)
+			}
+			if status, _ := devices.GetStatus("desktop"); status.Online != tt.wantOnline {
+				t.Errorf("desktop online = %v, want %v", status.Online, tt.wantOnline)
+			}
+		})
+	}
+}
+
+// TestAPIHeartbeatUnknownDevice checks that device names cannot be told
+// apart by the answer to a heartbeat without a valid token.
+func TestAPIHeartbeatUnknownDevice(t *testing.T) {
+	devices := newAPIDevices(t)
+	handler := NewHTTPListener(":0", devices).handler(t.Context(), NewQueue(10, 1, devices))
+
+	var bodies []string
+	for _, name := range []string{"desktop", "laptop"} {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/agents/heartbeat", strings.NewReader(`{"device": "`+name+`"}`))
+		r.Header.Set("Authorization", "Bearer guess")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		bodies = append(bodies, strconv.Itoa(w.Code)+" "+w.Body.String())
+	}
+	if bodies[0] != bodies[1] {
+		t.Errorf("known device: %s, unknown device: %s", bodies[0], bodies[1])
+	}
+}
@@ -0,0 +1,154 @@
+package listener
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/p3ddd/HomeGuard/device"
+	"github.com/p3ddd/HomeGuard/discover"
+	"github.com/p3ddd/HomeGuard/event"
+)
+
+// discoverMDNSTime is how long a discovery request listens for mDNS
+// announcements when asked to.
+const discoverMDNSTime = 3 * time.Second
+
+// DiscoverPayload is the body of a discovery request. All fields are
+// optional.
+type DiscoverPayload struct {
+	Subnets []string `json:"subnets,omitempty"` // CIDR subnets to scan (default: those of the server)
+	MDNS    bool     `json:"mdns,omitempty"`    // Also listen for mDNS announcements
+}
+
+// CandidatePayload is a machine found by discovery.
+type CandidatePayload struct {
+	discover.Candidate
+	Device string `json:"device,omitempty"` // Configured device with the same MAC address
+}
+
+// NewDevicePayload is the body of a request adding a device.
+type NewDevicePayload struct {
+	Name        string   `json:"name"`
+	Mac         string   `json:"mac"`
+	Broadcast   string   `json:"broadcast"`
+	Description string   `json:"description,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+}
+
+// SetDiscovery sets the lease and vendor files used by /api/v1/discover. It
+// must be called before Start.
+func (l *HTTPListener) SetDiscovery(config discover.Config) {
+	l.discovery = config
+}
+
+func (l *HTTPListener) handleDiscover(w http.ResponseWriter, r *http.Request) {
+	var payload DiscoverPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "invalid JSON: "+err.Error())
+		return
+	}
+
+	opts := discover.Options{Config: l.discovery}
+	for _, s := range payload.Subnets {
+		prefix, err := discover.ParseSubnet(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		opts.Subnets = append(opts.Subnets, prefix)
+	}
+	if payload.MDNS {
+		opts.MDNS = discoverMDNSTime
+	}
+
+	// A sweep sends a few hundred packets; one at a time is enough
+	if !l.discovering.TryLock() {
+		writeError(w, http.StatusConflict, ErrCodeConflict, "a discovery is already running")
+		return
+	}
+	defer l.discovering.Unlock()
+
+	l.logger().Info("Running network discovery", "subnets", strings.Join(payload.Subnets, ","), "mdns", payload.MDNS)
+	candidates, err := discover.Run(r.Context(), opts)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	// Mark the machines that are already configured
+	configured := make(map[string]string)
+	if l.devices != nil {
+		for _, dev := range l.devices.ListDevices() {
+			if mac, err := net.ParseMAC(dev.Mac); err == nil {
+				configured[mac.String()] = dev.Name
+			}
+		}
+	}
+
+	payloads := make([]CandidatePayload, 0, len(candidates))
+	for _, c := range candidates {
+		payloads = append(payloads, CandidatePayload{Candidate: c, Device: configured[c.Mac]})
+	}
+	l.logger().Info("Network discovery finished", "found", len(payloads))
+	writeJSON(w, http.StatusOK, payloads)
+}
+
+func (l *HTTPListener) handleAddDevice(w http.ResponseWriter, r *http.Request) {
+	if !l.hasDevices(w) {
+		return
+	}
+
+	var payload NewDevicePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if payload.Name == "" || payload.Mac == "" || payload.Broadcast == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "name, mac and broadcast are required")
+		return
+	}
+	if _, err := net.ParseMAC(payload.Mac); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "invalid MAC address: "+payload.Mac)
+		return
+	}
+	if _, err := netip.ParseAddr(payload.Broadcast); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "invalid broadcast address: "+payload.Broadcast)
+		return
+	}
+
+	err := l.devices.AddDevice(device.Device{
+		Name:        payload.Name,
+		Mac:         payload.Mac,
+		Broadcast:   payload.Broadcast,
+		Description: payload.Description,
+		Groups:      payload.Groups,
+	})
+	switch {
+	case errors.Is(err, device.ErrExists):
+		writeError(w, http.StatusConflict, ErrCodeConflict, err.Error())
+		return
+	case err != nil:
+		l.logger().Error("Failed to add device", "device", payload.Name, "error", err)
+		writeError(w, http.StatusUnprocessableEntity, ErrCodeInvalidConfig, err.Error())
+		return
+	}
+
+	count := len(l.devices.ListDevices())
+	l.logger().Info("Added device to configuration", "device", payload.Name, "mac", payload.Mac)
+	l.events.Publish(event.Event{
+		Type:   event.ConfigReloaded,
+		Device: payload.Name,
+		Source: l.Name(),
+		Data:   map[string]string{"devices": strconv.Itoa(count)},
+	})
+
+	dev, _ := l.devices.GetDevice(payload.Name)
+	writeJSON(w, http.StatusCreated, l.devicePayload(dev))
+}
@@ -0,0 +1,168 @@
+package listener
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/p3ddd/HomeGuard/event"
+)
+
+// eventKeepAlive is the interval of SSE comments and websocket pings that
+// keep idle connections open through proxies.
+const eventKeepAlive = 15 * time.Second
+
+var eventUpgrader = websocket.Upgrader{}
+
+// SetEvents sets the bus streamed at /api/v1/events. It must be called
+// before Start.
+func (l *HTTPListener) SetEvents(bus *event.Bus) {
+	l.events = bus
+}
+
+// handleEvents streams events as Server-Sent Events, or as JSON websocket
+// messages if the request asks for an upgrade. Clients resume with the
+// Last-Event-ID header or the last_event_id query parameter.
+func (l *HTTPListener) handleEvents(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if l.events == nil {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "event stream not available")
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var after uint64
+	if lastID != "" {
+		var err error
+		if after, err = strconv.ParseUint(lastID, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "invalid Last-Event-ID: "+lastID)
+			return
+		}
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		l.streamWebSocket(ctx, w, r, after)
+		return
+	}
+	l.streamSSE(ctx, w, r, after)
+}
+
+func (l *HTTPListener) streamSSE(ctx context.Context, w http.ResponseWriter, r *http.Request, after uint64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, ErrCodeUnavailable, "streaming not supported")
+		return
+	}
+
+	replay, events, cancel := l.events.Subscribe(after)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(e event.Event) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	for _, e := range replay {
+		if err := write(e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(eventKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := write(e); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (l *HTTPListener) streamWebSocket(ctx context.Context, w http.ResponseWriter, r *http.Request, after uint64) {
+	conn, err := eventUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		l.logger().Error("Failed to upgrade event stream", "error", err)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	replay, events, cancel := l.events.Subscribe(after)
+	defer cancel()
+
+	// Read until the client goes away; incoming messages are ignored
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, e := range replay {
+		if err := conn.WriteJSON(e); err != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(eventKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				_ = conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "subscriber too slow"))
+				return
+			}
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(5*time.Second)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-r.Context().Done():
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package listener
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/p3ddd/HomeGuard/event"
+)
+
+// newEventServer serves the API of a listener streaming bus.
+func newEventServer(t *testing.T, bus *event.Bus) *httptest.Server {
+	t.Helper()
+	l := NewHTTPListener(":0", nil)
+	if bus != nil {
+		l.SetEvents(bus)
+	}
+	server := httptest.NewServer(l.handler(t.Context(), NewQueue(0, 0, nil)))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// readSSE returns the next n events of an SSE stream, from their data:
+// lines.
+func readSSE(t *testing.T, scanner *bufio.Scanner, n int) []event.Event {
+	t.Helper()
+	var events []event.Event
+	for len(events) < n && scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var e event.Event
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			t.Fatalf("invalid data frame %q: %v", data, err)
+		}
+		events = append(events, e)
+	}
+	if len(events) < n {
+		t.Fatalf("stream ended after %d events, want %d: %v", len(events), n, scanner.Err())
+	}
+	return events
+}
+
+func TestEventsSSE(t *testing.T) {
+	bus := event.NewBus(0)
+	bus.Publish(event.Event{Type: event.WakeSent, Device: "desktop"})
+	bus.Publish(event.Event{Type: event.DeviceOnline, Device: "desktop"})
+	server := newEventServer(t, bus)
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, server.URL+"/api/v1/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, content type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	scanner := bufio.NewScanner(resp.Body)
+
+	// The event after Last-Event-ID is replayed, then new ones follow
+	replayed := readSSE(t, scanner, 1)
+	if e := replayed[0]; e.ID != 2 || e.Type != event.DeviceOnline {
+		t.Errorf("replayed = %+v, want event 2", e)
+	}
+	bus.Publish(event.Event{Type: event.WakeFailed, Device: "nas", Error: "timeout"})
+	live := readSSE(t, scanner, 1)
+	if e := live[0]; e.ID != 3 || e.Type != event.WakeFailed || e.Device != "nas" || e.Error != "timeout" {
+		t.Errorf("live = %+v, want event 3", e)
+	}
+}
+
+func TestEventsWebSocket(t *testing.T) {
+	bus := event.NewBus(0)
+	bus.Publish(event.Event{Type: event.WakeSent, Device: "desktop"})
+	server := newEventServer(t, bus)
+
+	// Resuming after event 1 gets event 2 whether it is published before
+	// or after the server subscribes
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/events?last_event_id=1"
+	conn, resp, err := websocket.DefaultDialer.DialContext(t.Context(), url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	defer func() { _ = conn.Close() }()
+
+	bus.Publish(event.Event{Type: event.ShutdownSent, Device: "nas"})
+	var e event.Event
+	if err := conn.ReadJSON(&e); err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != 2 || e.Type != event.ShutdownSent {
+		t.Errorf("event = %+v, want event 2", e)
+	}
+}
+
+func TestEventsErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		bus        *event.Bus
+		query      string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "no bus", query: "", wantStatus: http.StatusNotFound, wantCode: ErrCodeNotFound},
+		{name: "invalid ID", bus: event.NewBus(0), query: "?last_event_id=latest", wantStatus: http.StatusBadRequest, wantCode: ErrCodeInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newEventServer(t, tt.bus)
+			resp, err := http.Get(server.URL + "/api/v1/events" + tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = resp.Body.Close() }()
+
+			var body APIError
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus || body.Error.Code != tt.wantCode {
+				t.Errorf("response = %d %+v, want %d %s", resp.StatusCode, body.Error, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
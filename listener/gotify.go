@@ -0,0 +1,217 @@
+package listener
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var _ Listener = (*GotifyListener)(nil)
+
+// DefaultGotifyPattern matches messages such as "wake desktop".
+const DefaultGotifyPattern = `(?i)^\s*wake\s+(?P<device>\S+)\s*$`
+
+// Reconnect backoff for the Gotify stream.
+const (
+	gotifyMinBackoff = time.Second
+	gotifyMaxBackoff = time.Minute
+)
+
+// GotifyConfig holds the configuration for Gotify listener.
+type GotifyConfig struct {
+	URL   string `yaml:"url"`   // Server URL, e.g. https://gotify.example.com
+	Token string `yaml:"token"` // Client token (not an application token)
+
+	// Pattern is matched against the title and the body of each message. The
+	// "device" named group, or else the first group, is the device name.
+	Pattern string `yaml:"pattern"`
+}
+
+// GotifyMessage is a message received from the Gotify stream.
+type GotifyMessage struct {
+	ID       int64  `json:"id"`
+	AppID    int64  `json:"appid"`
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority"`
+}
+
+type GotifyListener struct {
+	config  GotifyConfig
+	pattern *regexp.Regexp
+	conn    *websocket.Conn
+	mu      sync.Mutex
+
+	// sleep waits for the reconnect backoff, or fails once ctx is done
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewGotifyListener creates a Gotify listener. It fails if the pattern does
+// not compile.
+func NewGotifyListener(config GotifyConfig) (*GotifyListener, error) {
+	if config.Pattern == "" {
+		config.Pattern = DefaultGotifyPattern
+	}
+	pattern, err := regexp.Compile(config.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gotify pattern: %w", err)
+	}
+	if pattern.NumSubexp() == 0 {
+		return nil, fmt.Errorf("gotify pattern must capture the device name: %s", config.Pattern)
+	}
+
+	return &GotifyListener{
+		config:  config,
+		pattern: pattern,
+		sleep:   sleepContext,
+	}, nil
+}
+
+func (l *GotifyListener) Name() string {
+	return "GOTIFY"
+}
+
+func (l *GotifyListener) logger() *slog.Logger {
+	return slog.With("type", l.Name())
+}
+
+func (l *GotifyListener) streamURL() (string, error) {
+	u, err := url.Parse(l.config.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid gotify URL: %w", err)
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/stream"
+	return u.String(), nil
+}
+
+func (l *GotifyListener) Start(ctx context.Context, queue *Queue) error {
+	streamURL, err := l.streamURL()
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("X-Gotify-Key", l.config.Token)
+
+	backoff := gotifyMinBackoff
+	for {
+		l.logger().Info("Connecting to Gotify stream", "url", streamURL)
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, streamURL, header)
+		if err == nil {
+			l.logger().Info("Connected to Gotify stream")
+			backoff = gotifyMinBackoff
+
+			l.mu.Lock()
+			l.conn = conn
+			l.mu.Unlock()
+
+			err = l.readMessages(ctx, conn, queue)
+			_ = conn.Close()
+		}
+
+		if ctx.Err() != nil {
+			l.logger().Info("Gotify listener context canceled")
+			return nil
+		}
+		l.logger().Error("Gotify stream error, reconnecting", "error", err, "backoff", backoff)
+
+		if err := l.sleep(ctx, backoff); err != nil {
+			l.logger().Info("Gotify listener context canceled")
+			return nil
+		}
+		backoff = min(backoff*2, gotifyMaxBackoff)
+	}
+}
+
+// sleepContext waits for d, or returns the error of ctx once it is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *GotifyListener) readMessages(ctx context.Context, conn *websocket.Conn, queue *Queue) error {
+	// Unblock ReadJSON when the listener is stopped
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	for {
+		var msg GotifyMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return err
+		}
+		l.handleMessage(ctx, msg, queue)
+	}
+}
+
+func (l *GotifyListener) handleMessage(ctx context.Context, msg GotifyMessage, queue *Queue) {
+	l.logger().Debug("Received Gotify message", "id", msg.ID, "title", msg.Title, "message", msg.Message)
+
+	device := l.matchDevice(msg.Title)
+	if device == "" {
+		device = l.matchDevice(msg.Message)
+	}
+	if device == "" {
+		return
+	}
+
+	request := WakeUpRequest{
+		Type:       l.Name(),
+		DeviceName: device,
+	}
+
+	if err := queue.TryEnqueue(request); err != nil {
+		l.logger().Warn("Dropping Gotify wakeup request", "error", err, "device", device, "id", msg.ID)
+		return
+	}
+	l.logger().Info("Processed Gotify wakeup request", "device", device, "id", msg.ID)
+}
+
+// matchDevice returns the device name captured by the pattern, or "" if text
+// does not match.
+func (l *GotifyListener) matchDevice(text string) string {
+	match := l.pattern.FindStringSubmatch(text)
+	if match == nil {
+		return ""
+	}
+	if i := l.pattern.SubexpIndex("device"); i > 0 {
+		return match[i]
+	}
+	return match[1]
+}
+
+// Stop implements Listener.
+func (l *GotifyListener) Stop() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil {
+		_ = l.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(time.Second))
+		_ = l.conn.Close()
+		l.conn = nil
+		l.logger().Info("Gotify listener stopped")
+	}
+
+	return nil
+}
@@ -0,0 +1,227 @@
+package listener
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestNewGotifyListener(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		wantErr string
+	}{
+		{name: "default pattern"},
+		{name: "named group", pattern: `^power on (?P<device>\w+)$`},
+		{name: "unnamed group", pattern: `^power on (\w+)$`},
+		{name: "invalid pattern", pattern: `^wake (`, wantErr: "invalid gotify pattern"},
+		{name: "no group", pattern: `^wake \w+$`, wantErr: "must capture the device name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewGotifyListener(GotifyConfig{URL: "http://gotify.local", Pattern: tt.pattern})
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGotifyHandleMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		msg     GotifyMessage
+		want    string // Device queued, if any
+	}{
+		{
+			name: "title",
+			msg:  GotifyMessage{Title: "wake desktop"},
+			want: "desktop",
+		},
+		{
+			name: "message",
+			msg:  GotifyMessage{Title: "Reminder", Message: "  WAKE   nas "},
+			want: "nas",
+		},
+		{
+			name: "title before message",
+			msg:  GotifyMessage{Title: "wake desktop", Message: "wake nas"},
+			want: "desktop",
+		},
+		{
+			name: "text around the command",
+			msg:  GotifyMessage{Message: "please wake desktop now"},
+		},
+		{
+			name: "no device",
+			msg:  GotifyMessage{Message: "wake"},
+		},
+		{
+			name:    "named group",
+			pattern: `^(on|power on) (?P<device>\w+)$`,
+			msg:     GotifyMessage{Message: "power on desktop"},
+			want:    "desktop",
+		},
+		{
+			name:    "first group",
+			pattern: `^boot (\w+)$`,
+			msg:     GotifyMessage{Message: "boot nas"},
+			want:    "nas",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := NewGotifyListener(GotifyConfig{URL: "http://gotify.local", Pattern: tt.pattern})
+			if err != nil {
+				t.Fatal(err)
+			}
+			queue := NewQueue(0, 0, nil)
+			l.handleMessage(context.Background(), tt.msg, queue)
+
+			var got string
+			if len(queue.pending) > 0 {
+				got = queue.pending[0].DeviceName
+			}
+			if got != tt.want {
+				t.Errorf("queued device = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// gotifyServer is a stand-in Gotify server. Connections to /stream fail
+// while reject returns true; accepted ones are sent messages and closed.
+type gotifyServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	dials    int
+	keys     []string // X-Gotify-Key of every request
+	paths    []string
+	reject   func(dial int) bool
+	messages []GotifyMessage
+}
+
+func startGotifyServer(t *testing.T, reject func(dial int) bool, messages ...GotifyMessage) *gotifyServer {
+	t.Helper()
+	s := &gotifyServer{reject: reject, messages: messages}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.dials++
+		dial := s.dials
+		s.keys = append(s.keys, r.Header.Get("X-Gotify-Key"))
+		s.paths = append(s.paths, r.URL.Path)
+		s.mu.Unlock()
+
+		if r.URL.Path != "/stream" || (s.reject != nil && s.reject(dial)) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		for _, msg := range s.messages {
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestGotifyListenerStream(t *testing.T) {
+	server := startGotifyServer(t, nil,
+		GotifyMessage{ID: 1, Title: "wake desktop"},
+		GotifyMessage{ID: 2, Message: "backup finished"},
+		GotifyMessage{ID: 3, Message: "wake nas"},
+	)
+	l, err := NewGotifyListener(GotifyConfig{URL: server.URL + "/", Token: "client-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server closes the stream after the messages; stop at the first
+	// reconnect
+	ctx, cancel := context.WithCancel(context.Background())
+	l.sleep = func(ctx context.Context, d time.Duration) error {
+		cancel()
+		return ctx.Err()
+	}
+	queue := NewQueue(0, 0, nil)
+	if err := l.Start(ctx, queue); err != nil {
+		t.Fatal(err)
+	}
+
+	var devices []string
+	for _, r := range queue.pending {
+		devices = append(devices, r.DeviceName)
+	}
+	if want := []string{"desktop", "nas"}; !slices.Equal(devices, want) {
+		t.Errorf("queued devices = %v, want %v", devices, want)
+	}
+	if want := []string{"client-token"}; !slices.Equal(server.keys, want) {
+		t.Errorf("X-Gotify-Key = %v, want %v", server.keys, want)
+	}
+	if want := []string{"/stream"}; !slices.Equal(server.paths, want) {
+		t.Errorf("paths = %v, want %v", server.paths, want)
+	}
+}
+
+func TestGotifyReconnectBackoff(t *testing.T) {
+	// Eight failed dials, one that connects and is closed by the server, then
+	// failures again
+	server := startGotifyServer(t, func(dial int) bool { return dial != 9 })
+	l, err := NewGotifyListener(GotifyConfig{URL: server.URL, Token: "client-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var waits []time.Duration
+	l.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		if len(waits) == 10 {
+			cancel()
+			return ctx.Err()
+		}
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- l.Start(ctx, NewQueue(0, 0, nil)) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return")
+	}
+
+	s := time.Second
+	want := []time.Duration{1 * s, 2 * s, 4 * s, 8 * s, 16 * s, 32 * s, time.Minute, time.Minute, 1 * s, 2 * s}
+	if !slices.Equal(waits, want) {
+		t.Errorf("backoff = %v, want %v", waits, want)
+	}
+	for i, key := range server.keys {
+		if key != "client-token" {
+			t.Errorf("dial %d sent X-Gotify-Key %q", i+1, key)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package listener
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Payloads used on the Home Assistant topics.
+const (
+	haPayloadOnline  = "online"
+	haPayloadOffline = "offline"
+	haPayloadPress   = "PRESS"
+	haStateOn        = "ON"
+	haStateOff       = "OFF"
+)
+
+// haStatusInterval is how often device online status is republished.
+const haStatusInterval = 10 * time.Second
+
+var haObjectIDInvalid = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// haDevice groups all entities of a HomeGuard device in Home Assistant.
+type haDevice struct {
+	Identifiers  []string    `json:"identifiers"`
+	Name         string      `json:"name"`
+	Manufacturer string      `json:"manufacturer"`
+	Model        string      `json:"model,omitempty"`
+	Connections  [][2]string `json:"connections,omitempty"`
+}
+
+// haEntity is the discovery config for a button or binary_sensor entity.
+type haEntity struct {
+	Name              string   `json:"name"`
+	UniqueID          string   `json:"unique_id"`
+	ObjectID          string   `json:"object_id"`
+	Icon              string   `json:"icon,omitempty"`
+	CommandTopic      string   `json:"command_topic,omitempty"`
+	PayloadPress      string   `json:"payload_press,omitempty"`
+	StateTopic        string   `json:"state_topic,omitempty"`
+	PayloadOn         string   `json:"payload_on,omitempty"`
+	PayloadOff        string   `json:"payload_off,omitempty"`
+	DeviceClass       string   `json:"device_class,omitempty"`
+	AvailabilityTopic string   `json:"availability_topic"`
+	Device            haDevice `json:"device"`
+}
+
+func haObjectID(name string) string {
+	return "homeguard_" + strings.ToLower(haObjectIDInvalid.ReplaceAllString(name, "_"))
+}
+
+// topicSegment returns a device name as a topic level. Characters other
+// than letters, digits, "_" and "-" are replaced as in object IDs, so a "/"
+// or a wildcard in a name cannot change the topic structure.
+func topicSegment(name string) string {
+	return haObjectIDInvalid.ReplaceAllString(name, "_")
+}
+
+func (l *MQTTListener) availabilityTopic() string {
+	return l.config.BaseTopic + "/status"
+}
+
+// deviceTopic returns the topic of a device below the base topic.
+func (l *MQTTListener) deviceTopic(name, suffix string) string {
+	return l.config.BaseTopic + "/" + topicSegment(name) + "/" + suffix
+}
+
+// deviceOfSegment returns the device whose topic level is segment.
+func (l *MQTTListener) deviceOfSegment(segment string) (string, bool) {
+	if l.devices == nil {
+		return "", false
+	}
+	if l.devices.HasDevice(segment) && topicSegment(segment) == segment {
+		return segment, true
+	}
+	for _, dev := range l.devices.ListDevices() {
+		if topicSegment(dev.Name) == segment {
+			return dev.Name, true
+		}
+	}
+	return "", false
+}
+
+// subscribeCommands subscribes to the per-device command topics used by the
+// discovered button entities.
+func (l *MQTTListener) subscribeCommands(ctx context.Context, client mqtt.Client, queue *Queue) {
+	for _, action := range []string{ActionWake, ActionShutdown} {
+		topic := l.config.BaseTopic + "/+/" + action
+		token := client.Subscribe(topic, l.config.QoS, func(client mqtt.Client, msg mqtt.Message) {
+			l.handleCommand(ctx, msg, action, queue)
+		})
+		if token.Wait() && token.Error() != nil {
+			l.logger().Error("Failed to subscribe to command topic", "topic", topic, "error", token.Error())
+		} else {
+			l.logger().Info("Subscribed to command topic", "topic", topic, "qos", l.config.QoS)
+		}
+	}
+}
+
+func (l *MQTTListener) handleCommand(ctx context.Context, msg mqtt.Message, action string, queue *Queue) {
+	segments := strings.Split(msg.Topic(), "/")
+	if len(segments) < 2 {
+		return
+	}
+	name, ok := l.deviceOfSegment(segments[len(segments)-2])
+	if !ok {
+		l.logger().Warn("Command for unknown device", "topic", msg.Topic())
+		return
+	}
+
+	request := WakeUpRequest{
+		Type:       l.Name(),
+		DeviceName: name,
+		Action:     action,
+	}
+
+	if err := queue.TryEnqueue(request); err != nil {
+		l.logger().Warn("Dropping MQTT command", "error", err, "action", action, "device", name)
+		return
+	}
+	l.logger().Info("Processed MQTT command", "action", action, "device", name)
+}
+
+// publishDiscovery publishes retained discovery configs for every configured
+// device and clears the configs of devices that no longer exist.
+func (l *MQTTListener) publishDiscovery() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.client == nil || !l.client.IsConnected() || l.devices == nil {
+		return
+	}
+
+	l.publish(l.availabilityTopic(), haPayloadOnline, true)
+
+	published := make(map[string]bool)
+	for _, dev := range l.devices.ListDevices() {
+		objectID := haObjectID(dev.Name)
+		haDev := haDevice{
+			Identifiers:  []string{objectID},
+			Name:         dev.Name,
+			Manufacturer: "HomeGuard",
+			Model:        dev.Description,
+		}
+		if dev.Mac != "" {
+			haDev.Connections = [][2]string{{"mac", strings.ToLower(dev.Mac)}}
+		}
+
+		wake := haEntity{
+			Name:              "Wake",
+			UniqueID:          objectID + "_wake",
+			ObjectID:          objectID + "_wake",
+			Icon:              "mdi:power",
+			CommandTopic:      l.deviceTopic(dev.Name, ActionWake),
+			PayloadPress:      haPayloadPress,
+			AvailabilityTopic: l.availabilityTopic(),
+			Device:            haDev,
+		}
+		l.publishConfig("button", objectID, wake)
+		published["button/"+objectID] = true
+
+		if dev.Shutdown != nil {
+			shutdown := wake
+			shutdown.Name = "Shutdown"
+			shutdown.UniqueID = objectID + "_shutdown"
+			shutdown.ObjectID = objectID + "_shutdown"
+			shutdown.Icon = "mdi:power-off"
+			shutdown.CommandTopic = l.deviceTopic(dev.Name, ActionShutdown)
+			l.publishConfig("button", objectID+"_shutdown", shutdown)
+			published["button/"+objectID+"_shutdown"] = true
+		}
+
+		if dev.Agent != nil {
+			online := haEntity{
+				Name:              "Online",
+				UniqueID:          objectID + "_online",
+				ObjectID:          objectID + "_online",
+				StateTopic:        l.deviceTopic(dev.Name, "online"),
+				PayloadOn:         haStateOn,
+				PayloadOff:        haStateOff,
+				DeviceClass:       "connectivity",
+				AvailabilityTopic: l.availabilityTopic(),
+				Device:            haDev,
+			}
+			l.publishConfig("binary_sensor", objectID, online)
+			published["binary_sensor/"+objectID] = true
+		}
+	}
+
+	// An empty retained config removes the entity from Home Assistant
+	for key := range l.discovered {
+		if !published[key] {
+			l.publish(l.config.DiscoveryPrefix+"/"+key+"/config", "", true)
+		}
+	}
+	l.discovered = published
+	l.onlineState = make(map[string]string)
+
+	l.logger().Info("Published Home Assistant discovery", "entities", len(published))
+}
+
+func (l *MQTTListener) publishConfig(component, objectID string, entity haEntity) {
+	payload, err := json.Marshal(entity)
+	if err != nil {
+		l.logger().Error("Failed to marshal discovery config", "error", err)
+		return
+	}
+	l.publish(l.config.DiscoveryPrefix+"/"+component+"/"+objectID+"/config", string(payload), true)
+}
+
+// publish sends a message without waiting for delivery. The caller must hold l.mu.
+func (l *MQTTListener) publish(topic, payload string, retained bool) {
+	token := l.client.Publish(topic, l.config.QoS, retained, payload)
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			l.logger().Error("Failed to publish", "topic", topic, "error", token.Error())
+		}
+	}()
+}
+
+// publishOnlineStatus periodically publishes the online state of devices
+// that report heartbeats, sending only changes.
+func (l *MQTTListener) publishOnlineStatus(ctx context.Context) {
+	ticker := time.NewTicker(haStatusInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		l.mu.Lock()
+		if l.client != nil && l.client.IsConnected() {
+			for _, dev := range l.devices.ListDevices() {
+				if dev.Agent == nil {
+					continue
+				}
+				status, err := l.devices.GetStatus(dev.Name)
+				if err != nil {
+					continue
+				}
+				state := haStateOff
+				if status.Online {
+					state = haStateOn
+				}
+				if l.onlineState[dev.Name] != state {
+					l.publish(l.deviceTopic(dev.Name, "online"), state, true)
+					l.onlineState[dev.Name] = state
+				}
+			}
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,197 @@
+package listener
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/p3ddd/HomeGuard/device"
+)
+
+// newHADevices returns desktop, with a shutdown action and an agent, and a
+// device whose name is not a valid topic level.
+func newHADevices(t *testing.T) *device.Manager {
+	t.Helper()
+	config := `devices:
+  - name: desktop
+    mac: "00:11:22:33:44:AA"
+    description: "Gaming PC"
+    shutdown:
+      type: http
+      url: "http://127.0.0.1:1/shutdown"
+    agent:
+      token: "agent-secret"
+  - name: "Living Room/PC"
+    mac: "00:11:22:33:44:66"
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	devices, err := device.NewManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return devices
+}
+
+func TestTopicSegment(t *testing.T) {
+	tests := map[string]string{
+		"desktop":        "desktop",
+		"Desk-Top_2":     "Desk-Top_2",
+		"Living Room/PC": "Living_Room_PC",
+		"a+b#c":          "a_b_c",
+		"nas/+/#":        "nas_",
+		"büro":           "b_ro",
+	}
+	for name, want := range tests {
+		if got := topicSegment(name); got != want {
+			t.Errorf("topicSegment(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestPublishDiscovery(t *testing.T) {
+	l, client := newFakeMQTTListener(MQTTConfig{Discovery: true}, newHADevices(t))
+	l.discovered["button/homeguard_removed"] = true
+	l.publishDiscovery()
+
+	if got := client.messages("homeguard/status"); len(got) != 1 || got[0].payload != haPayloadOnline || !got[0].retained {
+		t.Errorf("availability = %+v, want retained %s", got, haPayloadOnline)
+	}
+
+	tests := []struct {
+		topic string
+		want  haEntity
+	}{
+		{
+			topic: "homeassistant/button/homeguard_desktop/config",
+			want: haEntity{
+				Name: "Wake", UniqueID: "homeguard_desktop_wake", ObjectID: "homeguard_desktop_wake", Icon: "mdi:power",
+				CommandTopic: "homeguard/desktop/wake", PayloadPress: haPayloadPress, AvailabilityTopic: "homeguard/status",
+			},
+		},
+		{
+			topic: "homeassistant/button/homeguard_desktop_shutdown/config",
+			want: haEntity{
+				Name: "Shutdown", UniqueID: "homeguard_desktop_shutdown", ObjectID: "homeguard_desktop_shutdown", Icon: "mdi:power-off",
+				CommandTopic: "homeguard/desktop/shutdown", PayloadPress: haPayloadPress, AvailabilityTopic: "homeguard/status",
+			},
+		},
+		{
+			topic: "homeassistant/binary_sensor/homeguard_desktop/config",
+			want: haEntity{
+				Name: "Online", UniqueID: "homeguard_desktop_online", ObjectID: "homeguard_desktop_online",
+				StateTopic: "homeguard/desktop/online", PayloadOn: haStateOn, PayloadOff: haStateOff,
+				DeviceClass: "connectivity", AvailabilityTopic: "homeguard/status",
+			},
+		},
+		{
+			topic: "homeassistant/button/homeguard_living_room_pc/config",
+			want: haEntity{
+				Name: "Wake", UniqueID: "homeguard_living_room_pc_wake", ObjectID: "homeguard_living_room_pc_wake", Icon: "mdi:power",
+				CommandTopic: "homeguard/Living_Room_PC/wake", PayloadPress: haPayloadPress, AvailabilityTopic: "homeguard/status",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			messages := client.messages(tt.topic)
+			if len(messages) != 1 || !messages[0].retained {
+				t.Fatalf("messages = %+v, want one retained config", messages)
+			}
+			var got haEntity
+			if err := json.Unmarshal([]byte(messages[0].payload), &got); err != nil {
+				t.Fatal(err)
+			}
+			device := got.Device
+			got.Device = haDevice{}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("entity = %+v, want %+v", got, tt.want)
+			}
+			if device.Manufacturer != "HomeGuard" || len(device.Identifiers) != 1 || len(device.Connections) != 1 {
+				t.Errorf("device = %+v", device)
+			}
+		})
+	}
+
+	var desktop haEntity
+	_ = json.Unmarshal([]byte(client.messages("homeassistant/button/homeguard_desktop/config")[0].payload), &desktop)
+	if d := desktop.Device; d.Name != "desktop" || d.Model != "Gaming PC" || d.Connections[0] != [2]string{"mac", "00:11:22:33:44:aa"} {
+		t.Errorf("desktop device = %+v", d)
+	}
+
+	// No shutdown button or online sensor without shutdown and agent
+	for _, topic := range []string{"homeassistant/button/homeguard_living_room_pc_shutdown/config", "homeassistant/binary_sensor/homeguard_living_room_pc/config"} {
+		if got := client.messages(topic); len(got) != 0 {
+			t.Errorf("%s: %+v, want nothing", topic, got)
+		}
+	}
+
+	// Entities of removed devices are cleared
+	if got := client.messages("homeassistant/button/homeguard_removed/config"); len(got) != 1 || got[0].payload != "" || !got[0].retained {
+		t.Errorf("removed entity = %+v, want an empty retained config", got)
+	}
+	if len(l.discovered) != 4 {
+		t.Errorf("discovered = %v, want the four published entities", l.discovered)
+	}
+}
+
+func TestDiscoveryPrefix(t *testing.T) {
+	l, client := newFakeMQTTListener(MQTTConfig{Discovery: true, DiscoveryPrefix: "ha", BaseTopic: "lan/wol"}, newHADevices(t))
+	l.publishDiscovery()
+
+	messages := client.messages("ha/button/homeguard_desktop/config")
+	if len(messages) != 1 {
+		t.Fatalf("messages = %+v, want one config", client.published)
+	}
+	var got haEntity
+	if err := json.Unmarshal([]byte(messages[0].payload), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.CommandTopic != "lan/wol/desktop/wake" || got.AvailabilityTopic != "lan/wol/status" {
+		t.Errorf("topics = %s, %s", got.CommandTopic, got.AvailabilityTopic)
+	}
+}
+
+func TestHandleCommand(t *testing.T) {
+	tests := []struct {
+		topic  string
+		action string
+		want   []string
+	}{
+		{topic: "homeguard/desktop/wake", action: ActionWake, want: []string{"wake desktop"}},
+		{topic: "homeguard/desktop/shutdown", action: ActionShutdown, want: []string{"shutdown desktop"}},
+		{topic: "homeguard/Living_Room_PC/wake", action: ActionWake, want: []string{"wake Living Room/PC"}},
+		{topic: "homeguard/laptop/wake", action: ActionWake},
+		{topic: "wake", action: ActionWake},
+	}
+	devices := newHADevices(t)
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			l, _ := newFakeMQTTListener(MQTTConfig{Discovery: true}, devices)
+			queue := NewQueue(10, 1, devices)
+			l.handleCommand(t.Context(), mqttMessage{topic: tt.topic, payload: haPayloadPress}, tt.action, queue)
+			if got := queued(t, queue); !slices.Equal(got, tt.want) {
+				t.Errorf("queued = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscribeCommands(t *testing.T) {
+	l, client := newFakeMQTTListener(MQTTConfig{Discovery: true}, newHADevices(t))
+	l.subscribeCommands(t.Context(), client, NewQueue(10, 1, nil))
+
+	var topics []string
+	for topic := range client.subscribed {
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+	if want := []string{"homeguard/+/shutdown", "homeguard/+/wake"}; !slices.Equal(topics, want) {
+		t.Errorf("subscribed = %q, want %q", topics, want)
+	}
+}
@@ -3,17 +3,35 @@ package listener
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
+	"mime"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/p3ddd/HomeGuard/device"
+	"github.com/p3ddd/HomeGuard/discover"
+	"github.com/p3ddd/HomeGuard/event"
 )
 
 var _ Listener = (*HTTPListener)(nil)
 
 type HTTPListener struct {
-	addr   string
-	server *http.Server
-	mu     sync.Mutex
+	name    string
+	addr    string
+	devices *device.Manager
+	server  *http.Server
+	mu      sync.Mutex
+
+	webhooks map[string]*webhook
+	events   *event.Bus
+	tokens   []string
+	validate func(format string, data []byte) error
+
+	discovery   discover.Config
+	discovering sync.Mutex // Held while a discovery runs
 }
 
 // WakeUpPayload represents the JSON payload for wakeup requests.
@@ -23,55 +41,112 @@ type WakeUpPayload struct {
 	Broadcast string `json:"broadcast,omitempty"`
 }
 
-func NewHTTPListener(addr string) *HTTPListener {
+// HeartbeatPayload represents the JSON payload sent by homeguard-agent.
+type HeartbeatPayload struct {
+	Device          string `json:"device"`
+	Hostname        string `json:"hostname,omitempty"`
+	IP              string `json:"ip,omitempty"`
+	Mac             string `json:"mac,omitempty"`
+	UptimeSeconds   int64  `json:"uptime_seconds,omitempty"`
+	IntervalSeconds int64  `json:"interval_seconds,omitempty"`
+}
+
+// StatusPayload represents the JSON response for device status requests.
+type StatusPayload struct {
+	Device        string    `json:"device"`
+	Online        bool      `json:"online"`
+	LastSeen      time.Time `json:"last_seen,omitzero"`
+	Hostname      string    `json:"hostname,omitempty"`
+	IP            string    `json:"ip,omitempty"`
+	UptimeSeconds int64     `json:"uptime_seconds,omitempty"`
+
+	// Address tracking from the neighbour table
+	Mac            string                 `json:"mac,omitempty"`
+	AddressSeen    time.Time              `json:"address_seen,omitzero"`
+	AddressChanges []AddressChangePayload `json:"address_changes,omitempty"`
+}
+
+// AddressChangePayload is a change of the addresses of a device, as seen in
+// the neighbour table.
+type AddressChangePayload struct {
+	Time        time.Time `json:"time"`
+	IP          string    `json:"ip"`
+	PreviousIP  string    `json:"previous_ip,omitempty"`
+	Mac         string    `json:"mac"`
+	PreviousMac string    `json:"previous_mac,omitempty"`
+}
+
+// defaultHeartbeatInterval is assumed when an agent does not report its interval.
+const defaultHeartbeatInterval = 30 * time.Second
+
+// NewHTTPListener creates an HTTP listener. devices may be nil, in which case
+// device-specific endpoints report that no configuration is loaded.
+func NewHTTPListener(addr string, devices *device.Manager) *HTTPListener {
 	return &HTTPListener{
-		addr: addr,
+		name:    "HTTP",
+		addr:    addr,
+		devices: devices,
 	}
 }
 
 func (l *HTTPListener) Name() string {
-	return "HTTP"
+	return l.name
 }
 
 func (l *HTTPListener) logger() *slog.Logger {
 	return slog.With("type", l.Name())
 }
 
-func (l *HTTPListener) Start(ctx context.Context, wakeUpChan chan<- WakeUpRequest) error {
+func (l *HTTPListener) Start(ctx context.Context, queue *Queue) error {
+	l.mu.Lock()
+	l.server = &http.Server{
+		Addr:    l.addr,
+		Handler: l.handler(ctx, queue),
+	}
+	l.mu.Unlock()
+
+	l.logger().Info("Starting HTTP listener", "addr", l.addr)
+
+	// Handle graceful shutdown
+	go func() {
+		<-ctx.Done()
+		l.logger().Info("Shutting down HTTP listener")
+		shutdownCtx := context.Background()
+		if err := l.server.Shutdown(shutdownCtx); err != nil {
+			l.logger().Error("Failed to shutdown HTTP server", "error", err)
+		}
+	}()
+
+	if err := l.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// handler returns the HTTP API. Requests are added to queue.
+func (l *HTTPListener) handler(ctx context.Context, queue *Queue) http.Handler {
 	mux := http.NewServeMux()
 
-	// Handle wakeup requests
-	mux.HandleFunc("/wakeup", func(w http.ResponseWriter, r *http.Request) {
+	// Handle wakeup requests (legacy endpoint, kept for compatibility)
+	mux.HandleFunc("/wakeup", l.requireToken(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost && r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		var request WakeUpRequest
-		request.Type = l.Name()
-
-		// Try to parse JSON body first
-		if r.Header.Get("Content-Type") == "application/json" {
-			var payload WakeUpPayload
-			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-				l.logger().Error("Failed to parse JSON body", "error", err)
-				http.Error(w, "Invalid JSON", http.StatusBadRequest)
-				return
-			}
-			request.DeviceName = payload.Device
-			request.Mac = payload.Mac
-			request.Broadcast = payload.Broadcast
-		} else {
-			// Parse form data (query parameters or form body)
-			if err := r.ParseForm(); err != nil {
-				l.logger().Error("Failed to parse form", "error", err)
-				http.Error(w, "Failed to parse form", http.StatusBadRequest)
-				return
-			}
-
-			request.DeviceName = r.FormValue("device")
-			request.Mac = r.FormValue("mac")
-			request.Broadcast = r.FormValue("broadcast")
+		// Parse JSON body, form body or query parameters
+		payload, err := parseWakeUpPayload(r)
+		if err != nil {
+			l.logger().Error("Failed to parse request", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		request := WakeUpRequest{
+			Type:       l.Name(),
+			DeviceName: payload.Device,
+			Mac:        payload.Mac,
+			Broadcast:  payload.Broadcast,
 		}
 
 		// Validate request: must have either device name or both mac and broadcast
@@ -81,19 +156,26 @@ func (l *HTTPListener) Start(ctx context.Context, wakeUpChan chan<- WakeUpReques
 			return
 		}
 
-		// Send request to channel
-		select {
-		case wakeUpChan <- request:
-			l.logger().Info("Received wakeup request",
-				"device", request.DeviceName,
-				"mac", request.Mac,
-				"broadcast", request.Broadcast)
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write([]byte("Wakeup request received"))
-		case <-ctx.Done():
-			l.logger().Info("Context canceled")
+		// Queue the request, waiting while the queue is full
+		if err := queue.Enqueue(r.Context(), request); err != nil {
+			l.logger().Warn("Failed to queue wakeup request", "error", err, "device", request.DeviceName)
 			http.Error(w, "Server shutting down", http.StatusServiceUnavailable)
+			return
 		}
+		l.logger().Info("Received wakeup request",
+			"device", request.DeviceName,
+			"mac", request.Mac,
+			"broadcast", request.Broadcast)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("Wakeup request received"))
+	}))
+
+	// Versioned JSON API
+	l.registerAPI(ctx, mux, queue)
+
+	// Configurable webhooks
+	mux.HandleFunc("POST /hooks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		l.handleWebhook(w, r, queue)
 	})
 
 	// Health check endpoint
@@ -102,30 +184,36 @@ func (l *HTTPListener) Start(ctx context.Context, wakeUpChan chan<- WakeUpReques
 		_, _ = w.Write([]byte("OK"))
 	})
 
-	l.mu.Lock()
-	l.server = &http.Server{
-		Addr:    l.addr,
-		Handler: mux,
-	}
-	l.mu.Unlock()
+	// Web UI
+	mux.Handle("/", webHandler())
 
-	l.logger().Info("Starting HTTP listener", "addr", l.addr)
+	return mux
+}
 
-	// Handle graceful shutdown
-	go func() {
-		<-ctx.Done()
-		l.logger().Info("Shutting down HTTP listener")
-		shutdownCtx := context.Background()
-		if err := l.server.Shutdown(shutdownCtx); err != nil {
-			l.logger().Error("Failed to shutdown HTTP server", "error", err)
+// parseWakeUpPayload reads a wake-up request from a JSON body, or else from
+// a form body and the query parameters.
+func parseWakeUpPayload(r *http.Request) (WakeUpPayload, error) {
+	var payload WakeUpPayload
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if isJSON(mediaType) {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			return payload, fmt.Errorf("invalid JSON: %w", err)
 		}
-	}()
+		return payload, nil
+	}
 
-	if err := l.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		return err
+	if err := r.ParseForm(); err != nil {
+		return payload, fmt.Errorf("failed to parse form: %w", err)
 	}
+	payload.Device = r.FormValue("device")
+	payload.Mac = r.FormValue("mac")
+	payload.Broadcast = r.FormValue("broadcast")
+	return payload, nil
+}
 
-	return nil
+// isJSON reports whether mediaType is application/json or a +json type.
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
 }
 
 // Stop implements Listener.
@@ -17,16 +17,33 @@ import (
 //	0200.5e10.0000.0001
 //	0000.0000.fe80.0000.0000.0000.0200.5e10.0000.0001
 
+// Request actions. An empty Action is treated as ActionWake.
+const (
+	ActionWake     = "wake"
+	ActionShutdown = "shutdown"
+)
+
 type WakeUpRequest struct {
 	// HardwareAddr net.HardwareAddr
 	DeviceName string // Device name for lookup (optional)
 	Mac        string // MAC address (required if DeviceName is empty)
 	Broadcast  string // Broadcast address (required if DeviceName is empty)
 	Type       string // Listener type (HTTP, MQTT, etc.)
+	Action     string // ActionWake (default) or ActionShutdown
+	Priority   int    // PriorityNormal (default) or PriorityLow
+
+	// Done, if set, is called with the outcome once the request is processed.
+	Done func(err error)
 }
 
 type Listener interface {
 	Name() string
-	Start(ctx context.Context, wakeUpChan chan<- WakeUpRequest) error
+	Start(ctx context.Context, queue *Queue) error
 	Stop() error
 }
+
+// Reporter is implemented by listeners that publish the outcome of every
+// processed request, regardless of which listener received it.
+type Reporter interface {
+	Report(req WakeUpRequest, err error)
+}
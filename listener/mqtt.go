@@ -2,31 +2,55 @@ package listener
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/p3ddd/HomeGuard/device"
+	"github.com/p3ddd/HomeGuard/event"
 )
 
 var _ Listener = (*MQTTListener)(nil)
 
 // MQTTConfig holds the configuration for MQTT listener.
 type MQTTConfig struct {
-	Broker   string
-	ClientID string
-	Topic    string
-	QoS      byte
-	Username string
-	Password string
+	Broker   string `yaml:"broker"` // tcp://, ssl://, ws:// or wss:// URL
+	ClientID string `yaml:"client_id"`
+	Topic    string `yaml:"topic"` // JSON topic, used when Subscriptions is empty
+	QoS      byte   `yaml:"qos"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	Subscriptions []MQTTSubscription `yaml:"subscriptions"`
+
+	TLS MQTTTLSConfig `yaml:"tls"`
+
+	// ResponseTopic receives a JSON acknowledgement for every message. A
+	// "response_topic" in the message payload takes precedence. MQTT 5
+	// response properties are not available as the client speaks MQTT 3.1.1.
+	ResponseTopic string `yaml:"response_topic"`
+	// PublishState publishes the outcome of every attempt retained to <base>/<device>/state
+	PublishState bool `yaml:"publish_state"`
+
+	// Home Assistant MQTT discovery
+	Discovery       bool   `yaml:"discovery"`        // Publish discovery configs and subscribe to command topics
+	DiscoveryPrefix string `yaml:"discovery_prefix"` // Default: "homeassistant"
+	BaseTopic       string `yaml:"base_topic"`       // Prefix for command, state and availability topics. Default: "homeguard"
 }
 
 type MQTTListener struct {
-	config MQTTConfig
-	client mqtt.Client
-	mu     sync.Mutex
+	config  MQTTConfig
+	devices *device.Manager
+	client  mqtt.Client
+	events  *event.Bus
+	mu      sync.Mutex
+
+	discovered  map[string]bool   // Discovery configs currently published
+	onlineState map[string]string // Last published online state per device
 }
 
 // MQTTPayload represents the MQTT message payload structure.
@@ -34,29 +58,72 @@ type MQTTPayload struct {
 	Device    string `json:"device,omitempty"`
 	Mac       string `json:"mac,omitempty"`
 	Broadcast string `json:"broadcast,omitempty"`
+	Action    string `json:"action,omitempty"` // "wake" (default) or "shutdown"
+
+	// Request/response correlation (MQTT 3.1.1 equivalent of the MQTT 5 properties)
+	CorrelationID string `json:"correlation_id,omitempty"`
+	ResponseTopic string `json:"response_topic,omitempty"`
 }
 
-func NewMQTTListener(config MQTTConfig) *MQTTListener {
+// NewMQTTListener creates an MQTT listener. devices may be nil, in which case
+// Home Assistant discovery is disabled.
+func NewMQTTListener(config MQTTConfig, devices *device.Manager) *MQTTListener {
 	if config.ClientID == "" {
 		config.ClientID = fmt.Sprintf("wol-mqtt-%d", time.Now().Unix())
 	}
 	if config.QoS > 2 {
 		config.QoS = 1 // Default to QoS 1
 	}
+	if len(config.Subscriptions) == 0 {
+		if config.Topic == "" {
+			config.Topic = "homeguard/wakeup"
+		}
+		config.Subscriptions = []MQTTSubscription{{Topic: config.Topic}}
+	}
+	config.Subscriptions = slices.Clone(config.Subscriptions)
+	for i := range config.Subscriptions {
+		if config.Subscriptions[i].Format == "" {
+			config.Subscriptions[i].Format = FormatJSON
+		}
+	}
+	if config.DiscoveryPrefix == "" {
+		config.DiscoveryPrefix = "homeassistant"
+	}
+	if config.BaseTopic == "" {
+		config.BaseTopic = "homeguard"
+	}
+	if devices == nil {
+		config.Discovery = false
+	}
 	return &MQTTListener{
-		config: config,
+		config:      config,
+		devices:     devices,
+		discovered:  make(map[string]bool),
+		onlineState: make(map[string]string),
 	}
 }
 
+// SetEvents sets the bus that connection changes are published to. It must
+// be called before Start.
+func (l *MQTTListener) SetEvents(bus *event.Bus) {
+	l.events = bus
+}
+
 func (l *MQTTListener) Name() string {
 	return "MQTT"
 }
 
 func (l *MQTTListener) logger() *slog.Logger {
-	return slog.With("type", l.Name())
+	return slog.With("type", l.Name(), "broker", l.config.Broker)
 }
 
-func (l *MQTTListener) Start(ctx context.Context, wakeUpChan chan<- WakeUpRequest) error {
+func (l *MQTTListener) Start(ctx context.Context, queue *Queue) error {
+	for _, sub := range l.config.Subscriptions {
+		if err := sub.Validate(); err != nil {
+			return err
+		}
+	}
+
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(l.config.Broker)
 	opts.SetClientID(l.config.ClientID)
@@ -66,6 +133,14 @@ func (l *MQTTListener) Start(ctx context.Context, wakeUpChan chan<- WakeUpReques
 	opts.SetConnectRetryInterval(5 * time.Second)
 	opts.SetMaxReconnectInterval(1 * time.Minute)
 
+	tlsConfig, err := l.config.TLS.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load MQTT TLS config: %w", err)
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+
 	if l.config.Username != "" {
 		opts.SetUsername(l.config.Username)
 	}
@@ -73,37 +148,72 @@ func (l *MQTTListener) Start(ctx context.Context, wakeUpChan chan<- WakeUpReques
 		opts.SetPassword(l.config.Password)
 	}
 
+	if l.config.Discovery {
+		opts.SetWill(l.availabilityTopic(), haPayloadOffline, l.config.QoS, true)
+	}
+
 	// Connection lost handler
 	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
 		l.logger().Error("MQTT connection lost", "error", err)
+		l.events.Publish(event.Event{
+			Type:   event.MQTTDisconnected,
+			Source: l.Name(),
+			Error:  err.Error(),
+			Data:   map[string]string{"broker": l.config.Broker},
+		})
 	})
 
 	// On connect handler
 	opts.SetOnConnectHandler(func(client mqtt.Client) {
-		l.logger().Info("Connected to MQTT broker", "broker", l.config.Broker)
-
-		// Subscribe to topic
-		token := client.Subscribe(l.config.Topic, l.config.QoS, func(client mqtt.Client, msg mqtt.Message) {
-			l.handleMessage(ctx, msg, wakeUpChan)
+		l.logger().Info("Connected to MQTT broker")
+		l.events.Publish(event.Event{
+			Type:   event.MQTTConnected,
+			Source: l.Name(),
+			Data:   map[string]string{"broker": l.config.Broker},
 		})
 
-		if token.Wait() && token.Error() != nil {
-			l.logger().Error("Failed to subscribe to topic", "topic", l.config.Topic, "error", token.Error())
-		} else {
-			l.logger().Info("Subscribed to topic", "topic", l.config.Topic, "qos", l.config.QoS)
+		// Subscribe to topics
+		for _, sub := range l.config.Subscriptions {
+			qos := l.config.QoS
+			if sub.QoS != nil {
+				qos = *sub.QoS
+			}
+			token := client.Subscribe(sub.Topic, qos, func(client mqtt.Client, msg mqtt.Message) {
+				l.handleMessage(ctx, sub, msg, queue)
+			})
+
+			if token.Wait() && token.Error() != nil {
+				l.logger().Error("Failed to subscribe to topic", "topic", sub.Topic, "error", token.Error())
+			} else {
+				l.logger().Info("Subscribed to topic", "topic", sub.Topic, "qos", qos, "format", sub.Format)
+			}
+		}
+
+		if l.config.Discovery {
+			l.subscribeCommands(ctx, client, queue)
+			l.publishDiscovery()
 		}
 	})
 
+	if l.config.Discovery {
+		l.devices.OnReload(l.publishDiscovery)
+		go l.publishOnlineStatus(ctx)
+	}
+
 	// Create client
 	l.mu.Lock()
 	l.client = mqtt.NewClient(opts)
 	l.mu.Unlock()
 
 	// Connect to broker
-	l.logger().Info("Connecting to MQTT broker", "broker", l.config.Broker)
+	l.logger().Info("Connecting to MQTT broker")
 	token := l.client.Connect()
-	if token.Wait() && token.Error() != nil {
-		return fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	select {
+	case <-token.Done():
+		if token.Error() != nil {
+			return fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+		}
+	case <-ctx.Done(): // Still retrying to connect
 	}
 
 	// Wait for context cancellation
@@ -112,41 +222,105 @@ func (l *MQTTListener) Start(ctx context.Context, wakeUpChan chan<- WakeUpReques
 	return l.Stop()
 }
 
-func (l *MQTTListener) handleMessage(ctx context.Context, msg mqtt.Message, wakeUpChan chan<- WakeUpRequest) {
+func (l *MQTTListener) handleMessage(ctx context.Context, sub MQTTSubscription, msg mqtt.Message, queue *Queue) {
 	l.logger().Debug("Received MQTT message", "topic", msg.Topic(), "payload", string(msg.Payload()))
 
-	var payload MQTTPayload
-	if err := json.Unmarshal(msg.Payload(), &payload); err != nil {
-		l.logger().Error("Failed to parse MQTT payload", "error", err, "payload", string(msg.Payload()))
+	payload, err := sub.parse(msg.Topic(), msg.Payload())
+	if err != nil {
+		l.logger().Error("Failed to parse MQTT payload", "error", err, "topic", msg.Topic(), "payload", string(msg.Payload()))
+		l.acknowledge(l.config.ResponseTopic, MQTTAck{Outcome: OutcomeInvalid, Error: err.Error()})
 		return
 	}
 
+	responseTopic := l.config.ResponseTopic
+	if payload.ResponseTopic != "" {
+		responseTopic = payload.ResponseTopic
+	}
+	ack := MQTTAck{
+		CorrelationID: payload.CorrelationID,
+		Device:        payload.Device,
+		Mac:           payload.Mac,
+		Action:        payload.Action,
+	}
+	if ack.Action == "" {
+		ack.Action = ActionWake
+	}
+	reject := func(outcome, message string) {
+		ack.Outcome = outcome
+		ack.Error = message
+		l.acknowledge(responseTopic, ack)
+	}
+
 	request := WakeUpRequest{
 		Type:       l.Name(),
 		DeviceName: payload.Device,
 		Mac:        payload.Mac,
 		Broadcast:  payload.Broadcast,
+		Action:     payload.Action,
+	}
+
+	switch request.Action {
+	case "", ActionWake:
+	case ActionShutdown:
+		if request.DeviceName == "" {
+			l.logger().Error("Invalid MQTT message: shutdown requires a device name",
+				"payload", string(msg.Payload()))
+			reject(OutcomeInvalid, "shutdown requires a device name")
+			return
+		}
+	default:
+		l.logger().Error("Invalid MQTT message: unknown action",
+			"action", request.Action,
+			"payload", string(msg.Payload()))
+		reject(OutcomeInvalid, "unknown action: "+request.Action)
+		return
 	}
 
 	// Validate request: must have either device name or both mac and broadcast
 	if request.DeviceName == "" && (request.Mac == "" || request.Broadcast == "") {
 		l.logger().Error("Invalid MQTT message: must provide either device name or both mac and broadcast",
 			"payload", string(msg.Payload()))
+		reject(OutcomeInvalid, "must provide either device name or both mac and broadcast")
 		return
 	}
 
-	// Send request to channel
-	select {
-	case wakeUpChan <- request:
-		l.logger().Info("Processed MQTT wakeup request",
-			"device", request.DeviceName,
-			"mac", request.Mac,
-			"broadcast", request.Broadcast)
-	case <-ctx.Done():
-		l.logger().Info("Context canceled while processing message")
-	default:
-		l.logger().Warn("Channel full, dropping message")
+	stateTopic := sub.stateTopic(msg.Topic())
+
+	// "off" from a Bemfa switch only shuts down when explicitly enabled
+	if sub.Format == FormatBemfa && request.Action == ActionShutdown && !sub.ShutdownOnOff {
+		l.logger().Info("Ignoring off command (shutdown_on_off disabled)", "topic", msg.Topic(), "device", request.DeviceName)
+		l.publishSwitchState(stateTopic, false)
+		ack.Outcome = OutcomeOK
+		l.acknowledge(responseTopic, ack)
+		return
+	}
+
+	if responseTopic != "" || stateTopic != "" {
+		request.Done = func(err error) {
+			ack.Outcome, ack.Error = outcomeOf(err)
+			l.acknowledge(responseTopic, ack)
+
+			// Report the resulting power state; a failed action leaves it unchanged
+			on := request.Action != ActionShutdown
+			if err != nil {
+				on = !on
+			}
+			l.publishSwitchState(stateTopic, on)
+		}
+	}
+
+	// Drop the request rather than hold up the MQTT client while the queue
+	// is full
+	if err := queue.TryEnqueue(request); err != nil {
+		l.logger().Warn("Dropping MQTT request", "error", err, "device", request.DeviceName, "mac", request.Mac)
+		reject(OutcomeDropped, err.Error())
+		return
 	}
+	l.logger().Info("Processed MQTT request",
+		"action", request.Action,
+		"device", request.DeviceName,
+		"mac", request.Mac,
+		"broadcast", request.Broadcast)
 }
 
 // Stop implements Listener.
@@ -155,8 +329,19 @@ func (l *MQTTListener) Stop() error {
 	defer l.mu.Unlock()
 
 	if l.client != nil && l.client.IsConnected() {
-		// Unsubscribe from topic
-		token := l.client.Unsubscribe(l.config.Topic)
+		if l.config.Discovery {
+			token := l.client.Publish(l.availabilityTopic(), l.config.QoS, true, haPayloadOffline)
+			if token.WaitTimeout(time.Second) && token.Error() != nil {
+				l.logger().Error("Failed to publish availability", "error", token.Error())
+			}
+		}
+
+		// Unsubscribe from topics
+		topics := make([]string, 0, len(l.config.Subscriptions))
+		for _, sub := range l.config.Subscriptions {
+			topics = append(topics, sub.Topic)
+		}
+		token := l.client.Unsubscribe(topics...)
 		if token.Wait() && token.Error() != nil {
 			l.logger().Error("Failed to unsubscribe from topic", "error", token.Error())
 		}
@@ -0,0 +1,108 @@
+package listener
+
+import (
+	"encoding/json"
+	"time"
+)
+
+var _ Reporter = (*MQTTListener)(nil)
+
+// Request outcomes reported in acknowledgements and state topics.
+const (
+	OutcomeOK      = "ok"      // Request processed successfully
+	OutcomeFailed  = "failed"  // Request processed but the action failed
+	OutcomeInvalid = "invalid" // Message could not be parsed or validated
+	OutcomeDropped = "dropped" // Request queue was full
+)
+
+// MQTTAck is published to the response topic for every received message.
+type MQTTAck struct {
+	CorrelationID string `json:"correlation_id,omitempty"`
+	Device        string `json:"device,omitempty"`
+	Mac           string `json:"mac,omitempty"`
+	Action        string `json:"action,omitempty"`
+	Outcome       string `json:"outcome"`
+	Error         string `json:"error,omitempty"`
+}
+
+// MQTTState is published retained to <base>/<device>/state after every attempt.
+type MQTTState struct {
+	Action  string    `json:"action"`
+	Outcome string    `json:"outcome"`
+	Error   string    `json:"error,omitempty"`
+	Source  string    `json:"source"`
+	Time    time.Time `json:"time"`
+}
+
+func outcomeOf(err error) (string, string) {
+	if err != nil {
+		return OutcomeFailed, err.Error()
+	}
+	return OutcomeOK, ""
+}
+
+// acknowledge publishes ack to topic. Nothing is sent if topic is empty.
+func (l *MQTTListener) acknowledge(topic string, ack MQTTAck) {
+	if topic == "" {
+		return
+	}
+
+	payload, err := json.Marshal(ack)
+	if err != nil {
+		l.logger().Error("Failed to marshal acknowledgement", "error", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.client != nil && l.client.IsConnected() {
+		l.publish(topic, string(payload), false)
+	}
+}
+
+// Report implements Reporter by publishing the outcome to the device's state topic.
+func (l *MQTTListener) Report(req WakeUpRequest, err error) {
+	if !l.config.PublishState || l.devices == nil || !l.devices.HasDevice(req.DeviceName) {
+		return
+	}
+
+	state := MQTTState{
+		Action: req.Action,
+		Source: req.Type,
+		Time:   time.Now(),
+	}
+	if state.Action == "" {
+		state.Action = ActionWake
+	}
+	state.Outcome, state.Error = outcomeOf(err)
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		l.logger().Error("Failed to marshal device state", "error", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.client != nil && l.client.IsConnected() {
+		l.publish(l.deviceTopic(req.DeviceName, "state"), string(payload), true)
+	}
+}
+
+// publishSwitchState publishes "on" or "off" to topic. Nothing is sent if
+// topic is empty.
+func (l *MQTTListener) publishSwitchState(topic string, on bool) {
+	if topic == "" {
+		return
+	}
+	state := "off"
+	if on {
+		state = "on"
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.client != nil && l.client.IsConnected() {
+		l.publish(topic, state, false)
+	}
+}
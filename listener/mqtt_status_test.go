@@ -0,0 +1,205 @@
+package listener
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// processQueued closes queue and processes its requests, finishing each
+// with err. It returns the processed requests.
+func processQueued(t *testing.T, queue *Queue, err error) []WakeUpRequest {
+	t.Helper()
+	queue.Close()
+	var mu sync.Mutex
+	var processed []WakeUpRequest
+	queue.Run(t.Context(), func(req WakeUpRequest) {
+		mu.Lock()
+		processed = append(processed, req)
+		mu.Unlock()
+		if req.Done != nil {
+			req.Done(err)
+		}
+	})
+	return processed
+}
+
+func TestMQTTAcknowledgements(t *testing.T) {
+	tests := []struct {
+		name          string
+		responseTopic string // Of the listener
+		payload       string
+		fullQueue     bool
+		err           error // Outcome of processing
+		wantTopic     string
+		wantAck       string
+		wantQueued    int
+	}{
+		{
+			name:          "processed",
+			responseTopic: "homeguard/ack",
+			payload:       `{"device": "desktop", "correlation_id": "42"}`,
+			wantTopic:     "homeguard/ack",
+			wantAck:       `{"correlation_id":"42","device":"desktop","action":"wake","outcome":"ok"}`,
+			wantQueued:    1,
+		},
+		{
+			name:          "failed",
+			responseTopic: "homeguard/ack",
+			payload:       `{"device": "desktop", "action": "shutdown"}`,
+			err:           errors.New("connection refused"),
+			wantTopic:     "homeguard/ack",
+			wantAck:       `{"device":"desktop","action":"shutdown","outcome":"failed","error":"connection refused"}`,
+			wantQueued:    1,
+		},
+		{
+			name:          "response topic of the message",
+			responseTopic: "homeguard/ack",
+			payload:       `{"mac": "00:11:22:33:44:77", "broadcast": "192.168.1.255", "response_topic": "app/replies", "correlation_id": "7"}`,
+			wantTopic:     "app/replies",
+			wantAck:       `{"correlation_id":"7","mac":"00:11:22:33:44:77","action":"wake","outcome":"ok"}`,
+			wantQueued:    1,
+		},
+		{
+			name:       "response topic without a configured one",
+			payload:    `{"device": "desktop", "response_topic": "app/replies"}`,
+			wantTopic:  "app/replies",
+			wantAck:    `{"device":"desktop","action":"wake","outcome":"ok"}`,
+			wantQueued: 1,
+		},
+		{
+			name:          "invalid JSON",
+			responseTopic: "homeguard/ack",
+			payload:       `{"device":`,
+			wantTopic:     "homeguard/ack",
+			wantAck:       `{"outcome":"invalid","error":"invalid JSON: unexpected end of JSON input"}`,
+		},
+		{
+			name:          "unknown action",
+			responseTopic: "homeguard/ack",
+			payload:       `{"device": "desktop", "action": "reboot", "correlation_id": "8"}`,
+			wantTopic:     "homeguard/ack",
+			wantAck:       `{"correlation_id":"8","device":"desktop","action":"reboot","outcome":"invalid","error":"unknown action: reboot"}`,
+		},
+		{
+			name:          "shutdown by MAC",
+			responseTopic: "homeguard/ack",
+			payload:       `{"mac": "00:11:22:33:44:77", "broadcast": "192.168.1.255", "action": "shutdown"}`,
+			wantTopic:     "homeguard/ack",
+			wantAck:       `{"mac":"00:11:22:33:44:77","action":"shutdown","outcome":"invalid","error":"shutdown requires a device name"}`,
+		},
+		{
+			name:          "MAC without broadcast",
+			responseTopic: "homeguard/ack",
+			payload:       `{"mac": "00:11:22:33:44:77"}`,
+			wantTopic:     "homeguard/ack",
+			wantAck:       `{"mac":"00:11:22:33:44:77","action":"wake","outcome":"invalid","error":"must provide either device name or both mac and broadcast"}`,
+		},
+		{
+			name:          "queue full",
+			responseTopic: "homeguard/ack",
+			payload:       `{"device": "desktop"}`,
+			fullQueue:     true,
+			wantTopic:     "homeguard/ack",
+			wantAck:       `{"device":"desktop","action":"wake","outcome":"dropped","error":"request queue full"}`,
+		},
+		{
+			name:       "no response topic",
+			payload:    `{"device": "desktop"}`,
+			wantQueued: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, client := newFakeMQTTListener(MQTTConfig{Topic: "homeguard/wakeup", ResponseTopic: tt.responseTopic}, nil)
+			queue := NewQueue(1, 1, nil)
+			if tt.fullQueue {
+				if err := queue.TryEnqueue(WakeUpRequest{DeviceName: "nas"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			l.handleMessage(t.Context(), l.config.Subscriptions[0], mqttMessage{topic: "homeguard/wakeup", payload: tt.payload}, queue)
+			processed := processQueued(t, queue, tt.err)
+			if tt.fullQueue {
+				processed = processed[1:]
+			}
+			if len(processed) != tt.wantQueued {
+				t.Errorf("processed %d requests, want %d", len(processed), tt.wantQueued)
+			}
+
+			if tt.wantTopic == "" {
+				if len(client.published) != 0 {
+					t.Errorf("published %+v, want nothing", client.published)
+				}
+				return
+			}
+			acks := client.messages(tt.wantTopic)
+			if len(acks) != 1 || len(client.published) != 1 {
+				t.Fatalf("published %+v, want one acknowledgement on %s", client.published, tt.wantTopic)
+			}
+			if acks[0].payload != tt.wantAck || acks[0].retained {
+				t.Errorf("ack = %s (retained %v), want %s", acks[0].payload, acks[0].retained, tt.wantAck)
+			}
+		})
+	}
+}
+
+func TestMQTTReport(t *testing.T) {
+	tests := []struct {
+		name         string
+		publishState bool
+		req          WakeUpRequest
+		err          error
+		wantTopic    string
+		want         MQTTState
+	}{
+		{
+			name:         "wake",
+			publishState: true,
+			req:          WakeUpRequest{Type: "HTTP", DeviceName: "desktop"},
+			wantTopic:    "homeguard/desktop/state",
+			want:         MQTTState{Action: ActionWake, Outcome: OutcomeOK, Source: "HTTP"},
+		},
+		{
+			name:         "failed shutdown",
+			publishState: true,
+			req:          WakeUpRequest{Type: "Telegram", DeviceName: "Living Room/PC", Action: ActionShutdown},
+			err:          errors.New("no route to host"),
+			wantTopic:    "homeguard/Living_Room_PC/state",
+			want:         MQTTState{Action: ActionShutdown, Outcome: OutcomeFailed, Error: "no route to host", Source: "Telegram"},
+		},
+		{name: "unknown device", publishState: true, req: WakeUpRequest{Type: "HTTP", DeviceName: "laptop"}},
+		{name: "MAC", publishState: true, req: WakeUpRequest{Type: "HTTP", Mac: "00:11:22:33:44:66"}},
+		{name: "disabled", req: WakeUpRequest{Type: "HTTP", DeviceName: "desktop"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, client := newFakeMQTTListener(MQTTConfig{PublishState: tt.publishState}, newHADevices(t))
+			l.Report(tt.req, tt.err)
+
+			if tt.wantTopic == "" {
+				if len(client.published) != 0 {
+					t.Errorf("published %+v, want nothing", client.published)
+				}
+				return
+			}
+			states := client.messages(tt.wantTopic)
+			if len(states) != 1 || !states[0].retained {
+				t.Fatalf("published %+v, want one retained state on %s", client.published, tt.wantTopic)
+			}
+			var got MQTTState
+			if err := json.Unmarshal([]byte(states[0].payload), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Time.IsZero() {
+				t.Error("state without a time")
+			}
+			got.Time = tt.want.Time
+			if got != tt.want {
+				t.Errorf("state = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
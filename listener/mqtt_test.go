@@ -0,0 +1,113 @@
+package listener
+
+import (
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/p3ddd/HomeGuard/device"
+)
+
+// doneToken is a completed MQTT token.
+type doneToken struct{ err error }
+
+func (t doneToken) Wait() bool                     { return true }
+func (t doneToken) WaitTimeout(time.Duration) bool { return true }
+func (t doneToken) Error() error                   { return t.err }
+
+func (t doneToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+// mqttPublish is a message published through fakeMQTTClient.
+type mqttPublish struct {
+	topic    string
+	payload  string
+	retained bool
+}
+
+// fakeMQTTClient is a connected MQTT client that records what is published
+// and subscribed to.
+type fakeMQTTClient struct {
+	mu         sync.Mutex
+	published  []mqttPublish
+	subscribed map[string]mqtt.MessageHandler
+}
+
+func (c *fakeMQTTClient) IsConnected() bool      { return true }
+func (c *fakeMQTTClient) IsConnectionOpen() bool { return true }
+func (c *fakeMQTTClient) Connect() mqtt.Token    { return doneToken{} }
+func (c *fakeMQTTClient) Disconnect(uint)        {}
+
+func (c *fakeMQTTClient) Publish(topic string, qos byte, retained bool, payload any) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var data string
+	switch p := payload.(type) {
+	case string:
+		data = p
+	case []byte:
+		data = string(p)
+	}
+	c.published = append(c.published, mqttPublish{topic: topic, payload: data, retained: retained})
+	return doneToken{}
+}
+
+func (c *fakeMQTTClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscribed == nil {
+		c.subscribed = make(map[string]mqtt.MessageHandler)
+	}
+	c.subscribed[topic] = callback
+	return doneToken{}
+}
+
+func (c *fakeMQTTClient) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	for topic, qos := range filters {
+		c.Subscribe(topic, qos, callback)
+	}
+	return doneToken{}
+}
+
+func (c *fakeMQTTClient) Unsubscribe(...string) mqtt.Token        { return doneToken{} }
+func (c *fakeMQTTClient) AddRoute(string, mqtt.MessageHandler)    {}
+func (c *fakeMQTTClient) OptionsReader() mqtt.ClientOptionsReader { return mqtt.ClientOptionsReader{} }
+
+// messages returns what was published to topic, in order.
+func (c *fakeMQTTClient) messages(topic string) []mqttPublish {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var found []mqttPublish
+	for _, p := range c.published {
+		if p.topic == topic {
+			found = append(found, p)
+		}
+	}
+	return found
+}
+
+// mqttMessage is a received MQTT message.
+type mqttMessage struct {
+	topic   string
+	payload string
+}
+
+func (m mqttMessage) Duplicate() bool   { return false }
+func (m mqttMessage) Qos() byte         { return 0 }
+func (m mqttMessage) Retained() bool    { return false }
+func (m mqttMessage) Topic() string     { return m.topic }
+func (m mqttMessage) MessageID() uint16 { return 0 }
+func (m mqttMessage) Payload() []byte   { return []byte(m.payload) }
+func (m mqttMessage) Ack()              {}
+
+// newFakeMQTTListener returns a listener connected through a fake client.
+func newFakeMQTTListener(config MQTTConfig, devices *device.Manager) (*MQTTListener, *fakeMQTTClient) {
+	client := &fakeMQTTClient{}
+	l := NewMQTTListener(config, devices)
+	l.client = client
+	return l, client
+}
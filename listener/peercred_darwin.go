@@ -0,0 +1,34 @@
+//go:build darwin
+
+package listener
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// getPeerCredentials reads LOCAL_PEERCRED from a Unix socket connection. The
+// PID is not reported.
+func getPeerCredentials(conn *net.UnixConn) (peerCredentials, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return peerCredentials{}, err
+	}
+
+	var cred *unix.Xucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	}); err != nil {
+		return peerCredentials{}, err
+	}
+	if credErr != nil {
+		return peerCredentials{}, credErr
+	}
+	gid := -1
+	if cred.Ngroups > 0 {
+		gid = int(cred.Groups[0])
+	}
+	return peerCredentials{UID: int(cred.Uid), GID: gid}, nil
+}
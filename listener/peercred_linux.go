@@ -0,0 +1,29 @@
+//go:build linux
+
+package listener
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// getPeerCredentials reads SO_PEERCRED from a Unix socket connection.
+func getPeerCredentials(conn *net.UnixConn) (peerCredentials, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return peerCredentials{}, err
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return peerCredentials{}, err
+	}
+	if credErr != nil {
+		return peerCredentials{}, credErr
+	}
+	return peerCredentials{UID: int(cred.Uid), GID: int(cred.Gid), PID: int(cred.Pid)}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package listener
+
+import (
+	"errors"
+	"net"
+)
+
+// getPeerCredentials is not supported on this platform, so every client of
+// the Unix socket is rejected.
+func getPeerCredentials(conn *net.UnixConn) (peerCredentials, error) {
+	return peerCredentials{}, errors.New("peer credentials are not supported on this platform")
+}
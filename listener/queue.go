@@ -0,0 +1,301 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/p3ddd/HomeGuard/device"
+)
+
+// Request priorities. Queued requests of a higher priority are processed
+// first, so manual requests overtake scheduled ones. The zero value is
+// PriorityNormal.
+const (
+	PriorityLow    = -1 // Scheduled requests
+	PriorityNormal = 0
+)
+
+// Queue defaults.
+const (
+	DefaultWorkers   = 4
+	DefaultQueueSize = 100
+)
+
+var (
+	// ErrQueueFull is returned by TryEnqueue when the queue has no room.
+	ErrQueueFull = errors.New("request queue full")
+	// ErrQueueClosed is returned once the queue is closed.
+	ErrQueueClosed = errors.New("request queue closed")
+)
+
+// Queue holds the requests of all listeners until a worker processes them.
+// Requests are taken by priority, then in arrival order, but never while
+// another request for the same device is being processed, so one slow
+// device only holds up its own requests. A request for a device name and
+// one for its MAC address are for the same device.
+//
+// Listeners choose how to handle a full queue: Enqueue waits for room,
+// TryEnqueue drops the request.
+type Queue struct {
+	mu       sync.Mutex
+	size     int
+	workers  int
+	devices  *device.Manager
+	pending  []queuedRequest // Sorted by priority, then arrival
+	active   map[string]bool // Devices being processed
+	closed   bool
+	changed  chan struct{} // Closed and replaced when pending or active change
+	enqueued uint64
+	done     uint64
+	dropped  uint64
+	waited   uint64
+}
+
+type queuedRequest struct {
+	WakeUpRequest
+	key  string
+	time time.Time
+}
+
+// QueueStats is a snapshot of the queue.
+type QueueStats struct {
+	Workers   int           // Number of workers
+	Size      int           // Maximum number of queued requests
+	Queued    int           // Requests waiting for a worker
+	Scheduled int           // Queued requests of PriorityLow
+	Active    int           // Requests being processed
+	OldestAge time.Duration // How long the oldest queued request has waited
+	Enqueued  uint64        // Requests accepted since start
+	Processed uint64        // Requests processed since start
+	Dropped   uint64        // Requests TryEnqueue rejected as the queue was full
+	Waited    uint64        // Requests Enqueue had to wait for room for
+}
+
+// NewQueue creates a queue holding up to size requests, processed by the
+// given number of workers once Run is called. Values below one use the
+// defaults. devices resolves device names to MAC addresses; it may be nil,
+// in which case requests are serialized by name or MAC address as given.
+func NewQueue(size, workers int, devices *device.Manager) *Queue {
+	if size < 1 {
+		size = DefaultQueueSize
+	}
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+	return &Queue{
+		size:    size,
+		workers: workers,
+		devices: devices,
+		active:  make(map[string]bool),
+		changed: make(chan struct{}),
+	}
+}
+
+// Enqueue adds req to the queue, waiting for room until ctx is done.
+func (q *Queue) Enqueue(ctx context.Context, req WakeUpRequest) error {
+	key := q.requestKey(req)
+	waited := false
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return ErrQueueClosed
+		}
+		if len(q.pending) < q.size {
+			if waited {
+				q.waited++
+			}
+			q.push(req, key)
+			q.mu.Unlock()
+			return nil
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		waited = true
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// TryEnqueue adds req to the queue, or returns ErrQueueFull without waiting
+// if it has no room.
+func (q *Queue) TryEnqueue(req WakeUpRequest) error {
+	key := q.requestKey(req)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if len(q.pending) >= q.size {
+		q.dropped++
+		return ErrQueueFull
+	}
+	q.push(req, key)
+	return nil
+}
+
+// push inserts req after the queued requests of the same or a higher
+// priority. The caller must hold q.mu.
+func (q *Queue) push(req WakeUpRequest, key string) {
+	i := len(q.pending)
+	for i > 0 && q.pending[i-1].Priority < req.Priority {
+		i--
+	}
+	q.pending = slices.Insert(q.pending, i, queuedRequest{WakeUpRequest: req, key: key, time: time.Now()})
+	q.enqueued++
+	q.notify()
+}
+
+// notify wakes up everyone waiting for a change. The caller must hold q.mu.
+func (q *Queue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// requestKey identifies the device of a request for serialization: its MAC
+// address, or its name if that is unknown.
+func (q *Queue) requestKey(req WakeUpRequest) string {
+	mac := req.Mac
+	if req.DeviceName != "" {
+		if q.devices == nil {
+			return "device:" + req.DeviceName
+		}
+		dev, err := q.devices.GetDevice(req.DeviceName)
+		if err != nil || dev.Mac == "" {
+			return "device:" + req.DeviceName
+		}
+		mac = dev.Mac
+	}
+	if hw, err := net.ParseMAC(mac); err == nil {
+		return "mac:" + hw.String()
+	}
+	return "mac:" + mac
+}
+
+// Run processes queued requests with the queue's workers until the queue is
+// closed and empty, or ctx is done. In the latter case the queue is closed
+// and the requests still queued are discarded, their Done called with
+// ErrQueueClosed.
+func (q *Queue) Run(ctx context.Context, process func(WakeUpRequest)) {
+	var wg sync.WaitGroup
+	for range q.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				req, err := q.next(ctx)
+				if err != nil {
+					return
+				}
+				process(req.WakeUpRequest)
+				q.finish(req.key)
+			}
+		}()
+	}
+	wg.Wait()
+	q.discard()
+}
+
+// discard closes the queue and drops the requests still queued.
+func (q *Queue) discard() {
+	q.mu.Lock()
+	discarded := q.pending
+	q.pending = nil
+	q.closed = true
+	q.notify()
+	q.mu.Unlock()
+
+	for _, req := range discarded {
+		if req.Done != nil {
+			req.Done(ErrQueueClosed)
+		}
+	}
+}
+
+// next takes the first queued request whose device is not being processed,
+// waiting until there is one. It takes none once ctx is done.
+func (q *Queue) next(ctx context.Context) (queuedRequest, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return queuedRequest{}, err
+		}
+		q.mu.Lock()
+		i := slices.IndexFunc(q.pending, func(r queuedRequest) bool {
+			return !q.active[r.key]
+		})
+		if i >= 0 {
+			req := q.pending[i]
+			q.pending = slices.Delete(q.pending, i, i+1)
+			q.active[req.key] = true
+			q.notify()
+			q.mu.Unlock()
+			return req, nil
+		}
+		if q.closed && len(q.pending) == 0 {
+			q.mu.Unlock()
+			return queuedRequest{}, ErrQueueClosed
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return queuedRequest{}, ctx.Err()
+		}
+	}
+}
+
+// finish marks the device of a processed request as idle.
+func (q *Queue) finish(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.active, key)
+	q.done++
+	q.notify()
+}
+
+// Close stops the queue from accepting requests. Run returns once the
+// workers finished the requests already queued, unless its context is done
+// first.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		q.notify()
+	}
+}
+
+// Stats returns a snapshot of the queue.
+func (q *Queue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := QueueStats{
+		Workers:   q.workers,
+		Size:      q.size,
+		Queued:    len(q.pending),
+		Active:    len(q.active),
+		Enqueued:  q.enqueued,
+		Processed: q.done,
+		Dropped:   q.dropped,
+		Waited:    q.waited,
+	}
+	for _, r := range q.pending {
+		if r.Priority == PriorityLow {
+			stats.Scheduled++
+		}
+		if age := time.Since(r.time); age > stats.OldestAge {
+			stats.OldestAge = age
+		}
+	}
+	return stats
+}
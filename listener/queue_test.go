@@ -0,0 +1,226 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueueRequestKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolved bool
+		req      WakeUpRequest
+		want     string
+	}{
+		{name: "name", req: WakeUpRequest{DeviceName: "desktop"}, want: "device:desktop"},
+		{name: "MAC", req: WakeUpRequest{Mac: "00-11-22-33-44-55"}, want: "mac:00:11:22:33:44:55"},
+		{name: "invalid MAC", req: WakeUpRequest{Mac: "nope"}, want: "mac:nope"},
+		{name: "resolved name", resolved: true, req: WakeUpRequest{DeviceName: "desktop"}, want: "mac:00:11:22:33:44:55"},
+		{name: "resolved MAC", resolved: true, req: WakeUpRequest{Mac: "00:11:22:33:44:55"}, want: "mac:00:11:22:33:44:55"},
+		{name: "unknown name", resolved: true, req: WakeUpRequest{DeviceName: "laptop"}, want: "device:laptop"},
+	}
+	devices := newTestDevices(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue(0, 0, nil)
+			if tt.resolved {
+				q = NewQueue(0, 0, devices)
+			}
+			if got := q.requestKey(tt.req); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueuePriority(t *testing.T) {
+	q := NewQueue(10, 1, nil)
+	requests := []WakeUpRequest{
+		{DeviceName: "scheduled-1", Priority: PriorityLow},
+		{DeviceName: "manual-1"},
+		{DeviceName: "scheduled-2", Priority: PriorityLow},
+		{DeviceName: "manual-2", Priority: PriorityNormal},
+		{DeviceName: "urgent", Priority: 1},
+	}
+	for _, req := range requests {
+		if err := q.TryEnqueue(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := q.Stats(); stats.Queued != 5 || stats.Scheduled != 2 {
+		t.Errorf("stats = %+v, want 5 queued, 2 scheduled", stats)
+	}
+	q.Close()
+
+	var got []string
+	q.Run(t.Context(), func(req WakeUpRequest) {
+		got = append(got, req.DeviceName)
+	})
+	want := []string{"urgent", "manual-1", "manual-2", "scheduled-1", "scheduled-2"}
+	if !slices.Equal(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+	if stats := q.Stats(); stats.Queued != 0 || stats.Processed != 5 {
+		t.Errorf("stats = %+v, want none queued, 5 processed", stats)
+	}
+}
+
+func TestQueueSerializesDevice(t *testing.T) {
+	q := NewQueue(10, 3, newTestDevices(t))
+	requests := []WakeUpRequest{
+		{Type: "first", DeviceName: "desktop"},
+		{Type: "same MAC", Mac: "00-11-22-33-44-55"},
+		{Type: "other device", DeviceName: "nas"},
+	}
+	for _, req := range requests {
+		if err := q.TryEnqueue(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	started := make(chan string)
+	release := make(chan struct{})
+	ran := make(chan struct{})
+	go func() {
+		defer close(ran)
+		q.Run(t.Context(), func(req WakeUpRequest) {
+			started <- req.Type
+			if req.Type == "first" {
+				<-release
+			}
+		})
+	}()
+
+	// The other device is processed while the first request blocks, the
+	// request for the same MAC only after it
+	var got []string
+	for range 2 {
+		got = append(got, <-started)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, []string{"first", "other device"}) {
+		t.Fatalf("started %v, want the first request and the other device", got)
+	}
+	select {
+	case typ := <-started:
+		t.Fatalf("%s started while the device was busy", typ)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if typ := <-started; typ != "same MAC" {
+		t.Errorf("started %s, want the request for the same MAC", typ)
+	}
+
+	q.Close()
+	<-ran
+}
+
+func TestQueueFull(t *testing.T) {
+	q := NewQueue(1, 1, nil)
+	if err := q.TryEnqueue(WakeUpRequest{DeviceName: "desktop"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.TryEnqueue(WakeUpRequest{DeviceName: "nas"}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("TryEnqueue error = %v, want %v", err, ErrQueueFull)
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.Enqueue(ctx, WakeUpRequest{DeviceName: "nas"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Enqueue error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// A waiting Enqueue gets the room a worker makes
+	errs := make(chan error, 1)
+	go func() { errs <- q.Enqueue(t.Context(), WakeUpRequest{DeviceName: "nas"}) }()
+	time.Sleep(10 * time.Millisecond)
+	var mu sync.Mutex
+	var got []string
+	ran := make(chan struct{})
+	go func() {
+		defer close(ran)
+		q.Run(t.Context(), func(req WakeUpRequest) {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, req.DeviceName)
+		})
+	}()
+	if err := <-errs; err != nil {
+		t.Fatalf("Enqueue error = %v", err)
+	}
+	q.Close()
+	<-ran
+
+	if !slices.Equal(got, []string{"desktop", "nas"}) {
+		t.Errorf("processed %v, want desktop and nas", got)
+	}
+	stats := q.Stats()
+	if stats.Dropped != 1 || stats.Waited != 1 || stats.Enqueued != 2 {
+		t.Errorf("stats = %+v, want 1 dropped, 1 waited, 2 enqueued", stats)
+	}
+}
+
+func TestQueueClose(t *testing.T) {
+	q := NewQueue(1, 1, nil)
+	if err := q.TryEnqueue(WakeUpRequest{DeviceName: "desktop"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close wakes up Enqueue waiting for room
+	errs := make(chan error, 1)
+	go func() { errs <- q.Enqueue(t.Context(), WakeUpRequest{DeviceName: "nas"}) }()
+	time.Sleep(10 * time.Millisecond)
+	q.Close()
+	if err := <-errs; !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("Enqueue error = %v, want %v", err, ErrQueueClosed)
+	}
+	if err := q.TryEnqueue(WakeUpRequest{DeviceName: "nas"}); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("TryEnqueue error = %v, want %v", err, ErrQueueClosed)
+	}
+
+	// The request queued before is still processed
+	var got []string
+	q.Run(t.Context(), func(req WakeUpRequest) {
+		got = append(got, req.DeviceName)
+	})
+	if !slices.Equal(got, []string{"desktop"}) {
+		t.Errorf("processed %v, want desktop", got)
+	}
+}
+
+func TestQueueRunCanceled(t *testing.T) {
+	q := NewQueue(10, 1, nil)
+	var mu sync.Mutex
+	outcomes := make(map[string]error)
+	for _, name := range []string{"desktop", "nas"} {
+		err := q.TryEnqueue(WakeUpRequest{DeviceName: name, Done: func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			outcomes[name] = err
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	q.Run(ctx, func(req WakeUpRequest) {
+		t.Errorf("processed %s after the context was done", req.DeviceName)
+	})
+
+	for _, name := range []string{"desktop", "nas"} {
+		if err := outcomes[name]; !errors.Is(err, ErrQueueClosed) {
+			t.Errorf("%s: Done error = %v, want %v", name, err, ErrQueueClosed)
+		}
+	}
+	if err := q.TryEnqueue(WakeUpRequest{DeviceName: "desktop"}); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("TryEnqueue error = %v, want %v", err, ErrQueueClosed)
+	}
+	if stats := q.Stats(); stats.Queued != 0 {
+		t.Errorf("%d requests still queued", stats.Queued)
+	}
+}
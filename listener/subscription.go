@@ -0,0 +1,120 @@
+package listener
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Payload formats supported by MQTTSubscription.Format.
+const (
+	FormatJSON  = "json"  // MQTTPayload JSON object (default)
+	FormatText  = "text"  // Plain "on"/"off" style commands
+	FormatBemfa = "bemfa" // Bemfa cloud switch: "on", "off" or "on#..."
+)
+
+// bemfaStateSuffix is appended to a Bemfa topic to update the state shown in
+// the Bemfa app without pushing the message back to subscribers.
+const bemfaStateSuffix = "/up"
+
+// bemfaTopicType matches the device type suffix of Bemfa topics, e.g. the
+// "006" (switch) in "desktop006".
+var bemfaTopicType = regexp.MustCompile(`\d{3}$`)
+
+// MQTTSubscription describes a topic to subscribe to and how to read its messages.
+type MQTTSubscription struct {
+	// Topic may contain MQTT wildcards. The first "+" segment is taken as
+	// the device name, e.g. "homeguard/+/wake".
+	Topic  string `yaml:"topic"`
+	Format string `yaml:"format"` // "json" (default), "text" or "bemfa"
+	QoS    *byte  `yaml:"qos"`    // Default: the broker's QoS
+	Device string `yaml:"device"` // Device for topics without a "+" segment
+
+	// ShutdownOnOff makes a Bemfa "off" shut the device down. Otherwise
+	// "off" only updates the switch state.
+	ShutdownOnOff bool `yaml:"shutdown_on_off"`
+}
+
+// deviceFromTopic returns the topic segment matching the first "+" of the
+// subscription, or the configured device if the subscription has none. Bemfa
+// topics without either map to the topic name minus its type suffix.
+func (s MQTTSubscription) deviceFromTopic(topic string) string {
+	filter := strings.Split(s.Topic, "/")
+	segments := strings.Split(topic, "/")
+	for i, part := range filter {
+		if part == "+" && i < len(segments) {
+			return segments[i]
+		}
+	}
+	if s.Device == "" && s.Format == FormatBemfa {
+		return bemfaTopicType.ReplaceAllString(topic, "")
+	}
+	return s.Device
+}
+
+// stateTopic returns the topic the device state is published to after each
+// attempt, or "" if the format has none.
+func (s MQTTSubscription) stateTopic(topic string) string {
+	if s.Format == FormatBemfa {
+		return topic + bemfaStateSuffix
+	}
+	return ""
+}
+
+// Validate checks the subscription's format.
+func (s MQTTSubscription) Validate() error {
+	if s.Topic == "" {
+		return fmt.Errorf("subscription topic cannot be empty")
+	}
+	switch s.Format {
+	case "", FormatJSON, FormatText, FormatBemfa:
+		return nil
+	}
+	return fmt.Errorf("unknown payload format for topic %s: %q", s.Topic, s.Format)
+}
+
+// parse decodes a message received on topic into an MQTTPayload. The device
+// taken from the topic applies when the payload does not name one.
+func (s MQTTSubscription) parse(topic string, data []byte) (MQTTPayload, error) {
+	var payload MQTTPayload
+
+	switch s.Format {
+	case FormatText:
+		action, err := parseTextCommand(string(data))
+		if err != nil {
+			return payload, err
+		}
+		payload.Action = action
+	case FormatBemfa:
+		// Extended commands such as "on#50" carry parameters we ignore
+		command, _, _ := strings.Cut(string(data), "#")
+		switch strings.TrimSpace(command) {
+		case "on":
+			payload.Action = ActionWake
+		case "off":
+			payload.Action = ActionShutdown
+		default:
+			return payload, fmt.Errorf("unknown bemfa command: %q", data)
+		}
+	default:
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return payload, fmt.Errorf("invalid JSON: %w", err)
+		}
+	}
+
+	if payload.Device == "" && payload.Mac == "" {
+		payload.Device = s.deviceFromTopic(topic)
+	}
+	return payload, nil
+}
+
+func parseTextCommand(text string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "on", "wake", "press", "1", "true":
+		return ActionWake, nil
+	case "off", "shutdown", "0", "false":
+		return ActionShutdown, nil
+	}
+	return "", fmt.Errorf("unknown command: %q", text)
+}
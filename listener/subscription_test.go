@@ -0,0 +1,297 @@
+package listener
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSubscriptionDeviceFromTopic(t *testing.T) {
+	tests := []struct {
+		name  string
+		sub   MQTTSubscription
+		topic string
+		want  string
+	}{
+		{name: "plus segment", sub: MQTTSubscription{Topic: "homeguard/+/wake"}, topic: "homeguard/desktop/wake", want: "desktop"},
+		{name: "first plus segment", sub: MQTTSubscription{Topic: "home/+/+/set"}, topic: "home/office/pc/set", want: "office"},
+		{name: "plus before multi-level", sub: MQTTSubscription{Topic: "lan/+/#"}, topic: "lan/nas/power/set", want: "nas"},
+		{name: "configured device", sub: MQTTSubscription{Topic: "office/pc/power", Device: "desktop"}, topic: "office/pc/power", want: "desktop"},
+		{name: "plus wins over the device", sub: MQTTSubscription{Topic: "homeguard/+/wake", Device: "desktop"}, topic: "homeguard/nas/wake", want: "nas"},
+		{name: "multi-level only", sub: MQTTSubscription{Topic: "homeguard/#"}, topic: "homeguard/desktop/wake"},
+		{name: "no device", sub: MQTTSubscription{Topic: "homeguard/wakeup"}, topic: "homeguard/wakeup"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sub.deviceFromTopic(tt.topic); got != tt.want {
+				t.Errorf("deviceFromTopic(%q) = %q, want %q", tt.topic, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		sub     MQTTSubscription
+		topic   string
+		data    string
+		want    MQTTPayload
+		wantErr string
+	}{
+		{
+			name:  "JSON",
+			sub:   MQTTSubscription{Topic: "homeguard/wakeup", Format: FormatJSON},
+			topic: "homeguard/wakeup",
+			data:  `{"device": "desktop", "action": "shutdown", "correlation_id": "1"}`,
+			want:  MQTTPayload{Device: "desktop", Action: ActionShutdown, CorrelationID: "1"},
+		},
+		{
+			name:  "JSON device from the topic",
+			sub:   MQTTSubscription{Topic: "homeguard/+/set", Format: FormatJSON},
+			topic: "homeguard/nas/set",
+			data:  `{"action": "wake"}`,
+			want:  MQTTPayload{Device: "nas", Action: ActionWake},
+		},
+		{
+			name:  "JSON device wins over the topic",
+			sub:   MQTTSubscription{Topic: "homeguard/+/set", Format: FormatJSON},
+			topic: "homeguard/nas/set",
+			data:  `{"device": "desktop"}`,
+			want:  MQTTPayload{Device: "desktop"},
+		},
+		{
+			name:  "JSON MAC keeps the device empty",
+			sub:   MQTTSubscription{Topic: "homeguard/+/set", Format: FormatJSON},
+			topic: "homeguard/nas/set",
+			data:  `{"mac": "00:11:22:33:44:55", "broadcast": "192.168.1.255"}`,
+			want:  MQTTPayload{Mac: "00:11:22:33:44:55", Broadcast: "192.168.1.255"},
+		},
+		{
+			name:    "invalid JSON",
+			sub:     MQTTSubscription{Topic: "homeguard/wakeup", Format: FormatJSON},
+			topic:   "homeguard/wakeup",
+			data:    "on",
+			wantErr: "invalid JSON",
+		},
+		{
+			name:  "text on",
+			sub:   MQTTSubscription{Topic: "homeguard/+/power", Format: FormatText},
+			topic: "homeguard/desktop/power",
+			data:  " ON\n",
+			want:  MQTTPayload{Device: "desktop", Action: ActionWake},
+		},
+		{
+			name:  "text press",
+			sub:   MQTTSubscription{Topic: "office/button", Format: FormatText, Device: "desktop"},
+			topic: "office/button",
+			data:  "press",
+			want:  MQTTPayload{Device: "desktop", Action: ActionWake},
+		},
+		{
+			name:  "text off",
+			sub:   MQTTSubscription{Topic: "homeguard/+/power", Format: FormatText},
+			topic: "homeguard/nas/power",
+			data:  "0",
+			want:  MQTTPayload{Device: "nas", Action: ActionShutdown},
+		},
+		{
+			name:    "unknown text",
+			sub:     MQTTSubscription{Topic: "homeguard/+/power", Format: FormatText},
+			topic:   "homeguard/nas/power",
+			data:    "toggle",
+			wantErr: `unknown command: "toggle"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.sub.parse(tt.topic, []byte(tt.data))
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			case err != nil:
+				return
+			}
+			if got != tt.want {
+				t.Errorf("payload = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionValidate(t *testing.T) {
+	tests := []struct {
+		sub     MQTTSubscription
+		wantErr string
+	}{
+		{sub: MQTTSubscription{Topic: "homeguard/wakeup"}},
+		{sub: MQTTSubscription{Topic: "homeguard/+/power", Format: FormatText}},
+		{sub: MQTTSubscription{Topic: "desktop006", Format: FormatBemfa}},
+		{sub: MQTTSubscription{Format: FormatJSON}, wantErr: "subscription topic cannot be empty"},
+		{sub: MQTTSubscription{Topic: "homeguard/wakeup", Format: "xml"}, wantErr: `unknown payload format for topic homeguard/wakeup: "xml"`},
+	}
+	for _, tt := range tests {
+		err := tt.sub.Validate()
+		switch {
+		case err != nil && tt.wantErr == "":
+			t.Errorf("%+v: %v", tt.sub, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%+v: error = %v, want one containing %q", tt.sub, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMQTTSubscriptionDefaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		config MQTTConfig
+		want   []MQTTSubscription
+	}{
+		{name: "default topic", want: []MQTTSubscription{{Topic: "homeguard/wakeup", Format: FormatJSON}}},
+		{name: "topic", config: MQTTConfig{Topic: "lan/wake"}, want: []MQTTSubscription{{Topic: "lan/wake", Format: FormatJSON}}},
+		{
+			name: "subscriptions replace the topic",
+			config: MQTTConfig{Topic: "lan/wake", Subscriptions: []MQTTSubscription{
+				{Topic: "homeguard/+/wake"},
+				{Topic: "desktop006", Format: FormatBemfa},
+			}},
+			want: []MQTTSubscription{
+				{Topic: "homeguard/+/wake", Format: FormatJSON},
+				{Topic: "desktop006", Format: FormatBemfa},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configured := slices.Clone(tt.config.Subscriptions)
+			l := NewMQTTListener(tt.config, nil)
+			if !slices.EqualFunc(l.config.Subscriptions, tt.want, func(a, b MQTTSubscription) bool {
+				return a.Topic == b.Topic && a.Format == b.Format
+			}) {
+				t.Errorf("subscriptions = %+v, want %+v", l.config.Subscriptions, tt.want)
+			}
+			if !slices.Equal(tt.config.Subscriptions, configured) {
+				t.Error("the configured subscriptions were changed")
+			}
+		})
+	}
+}
+
+func TestMQTTSubscriptionRouting(t *testing.T) {
+	config := MQTTConfig{Subscriptions: []MQTTSubscription{
+		{Topic: "homeguard/+/wake", Format: FormatText},
+		{Topic: "office/pc/power", Format: FormatText, Device: "desktop"},
+	}}
+	l, _ := newFakeMQTTListener(config, nil)
+	queue := NewQueue(10, 1, nil)
+	messages := []struct {
+		sub int
+		mqttMessage
+	}{
+		{0, mqttMessage{topic: "homeguard/nas/wake", payload: "on"}},
+		{1, mqttMessage{topic: "office/pc/power", payload: "off"}},
+		{0, mqttMessage{topic: "homeguard/laptop/wake", payload: "bogus"}},
+	}
+	for _, m := range messages {
+		l.handleMessage(t.Context(), l.config.Subscriptions[m.sub], m.mqttMessage, queue)
+	}
+	if got, want := queued(t, queue), []string{"shutdown desktop", "wake nas"}; !slices.Equal(got, want) {
+		t.Errorf("queued = %q, want %q", got, want)
+	}
+}
+
+func TestBemfaParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		sub     MQTTSubscription
+		topic   string
+		data    string
+		want    MQTTPayload
+		wantErr string
+	}{
+		{name: "on", sub: MQTTSubscription{Topic: "desktop006", Format: FormatBemfa}, topic: "desktop006", data: "on", want: MQTTPayload{Device: "desktop", Action: ActionWake}},
+		{name: "off", sub: MQTTSubscription{Topic: "desktop006", Format: FormatBemfa}, topic: "desktop006", data: " off\n", want: MQTTPayload{Device: "desktop", Action: ActionShutdown}},
+		{name: "extended command", sub: MQTTSubscription{Topic: "desktop006", Format: FormatBemfa}, topic: "desktop006", data: "on#50#1", want: MQTTPayload{Device: "desktop", Action: ActionWake}},
+		{name: "no type suffix", sub: MQTTSubscription{Topic: "nas", Format: FormatBemfa}, topic: "nas", data: "on", want: MQTTPayload{Device: "nas", Action: ActionWake}},
+		{name: "configured device", sub: MQTTSubscription{Topic: "pc001", Format: FormatBemfa, Device: "desktop"}, topic: "pc001", data: "on", want: MQTTPayload{Device: "desktop", Action: ActionWake}},
+		{name: "unknown command", sub: MQTTSubscription{Topic: "desktop006", Format: FormatBemfa}, topic: "desktop006", data: "toggle", wantErr: `unknown bemfa command: "toggle"`},
+		{name: "JSON is not a command", sub: MQTTSubscription{Topic: "desktop006", Format: FormatBemfa}, topic: "desktop006", data: `{"action": "wake"}`, wantErr: "unknown bemfa command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.sub.parse(tt.topic, []byte(tt.data))
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			case err != nil:
+				return
+			}
+			if got != tt.want {
+				t.Errorf("payload = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionStateTopic(t *testing.T) {
+	for format, want := range map[string]string{
+		FormatBemfa: "desktop006/up",
+		FormatJSON:  "",
+		FormatText:  "",
+	} {
+		sub := MQTTSubscription{Topic: "desktop006", Format: format}
+		if got := sub.stateTopic("desktop006"); got != want {
+			t.Errorf("stateTopic with format %q = %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestBemfaSwitchState(t *testing.T) {
+	tests := []struct {
+		name          string
+		shutdownOnOff bool
+		payload       string
+		err           error // Outcome of processing
+		wantQueued    []string
+		wantState     string
+	}{
+		{name: "on", payload: "on", wantQueued: []string{"wake desktop"}, wantState: "on"},
+		{name: "failed on", payload: "on", err: errors.New("no route to host"), wantQueued: []string{"wake desktop"}, wantState: "off"},
+		{name: "off ignored", payload: "off", wantState: "off"},
+		{name: "off shuts down", shutdownOnOff: true, payload: "off", wantQueued: []string{"shutdown desktop"}, wantState: "off"},
+		{name: "failed off", shutdownOnOff: true, payload: "off", err: errors.New("connection refused"), wantQueued: []string{"shutdown desktop"}, wantState: "on"},
+		{name: "invalid", payload: "toggle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := MQTTSubscription{Topic: "desktop006", Format: FormatBemfa, ShutdownOnOff: tt.shutdownOnOff}
+			l, client := newFakeMQTTListener(MQTTConfig{Subscriptions: []MQTTSubscription{sub}}, nil)
+			queue := NewQueue(10, 1, nil)
+			l.handleMessage(t.Context(), sub, mqttMessage{topic: "desktop006", payload: tt.payload}, queue)
+
+			var got []string
+			for _, req := range processQueued(t, queue, tt.err) {
+				got = append(got, req.Action+" "+req.DeviceName)
+			}
+			if !slices.Equal(got, tt.wantQueued) {
+				t.Errorf("queued = %q, want %q", got, tt.wantQueued)
+			}
+
+			states := client.messages("desktop006/up")
+			switch {
+			case tt.wantState == "" && len(states) != 0:
+				t.Errorf("published %+v, want no state", states)
+			case tt.wantState != "" && (len(states) != 1 || states[0].payload != tt.wantState || states[0].retained):
+				t.Errorf("published %+v, want the state %q", states, tt.wantState)
+			}
+			if pushed := client.messages("desktop006"); len(pushed) != 0 {
+				t.Errorf("published %+v to the command topic", pushed)
+			}
+		})
+	}
+}
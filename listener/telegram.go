@@ -0,0 +1,420 @@
+package listener
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	neturl "net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/p3ddd/HomeGuard/device"
+)
+
+var _ Listener = (*TelegramListener)(nil)
+
+// DefaultTelegramAPIURL is the public Telegram Bot API endpoint.
+const DefaultTelegramAPIURL = "https://api.telegram.org"
+
+// Callback data of inline wake buttons. Telegram limits callback data to 64
+// bytes, so longer device names are sent as a hash.
+const (
+	telegramCallbackWake     = "wake:" // Followed by the device name
+	telegramCallbackWakeHash = "wake#" // Followed by deviceHash of the name
+	telegramMaxCallbackData  = 64      // Bytes
+)
+
+// TelegramConfig holds the configuration for Telegram listener.
+type TelegramConfig struct {
+	Token        string        `yaml:"token"`         // Bot token from @BotFather
+	APIURL       string        `yaml:"api_url"`       // Default: DefaultTelegramAPIURL
+	AllowedChats []int64       `yaml:"allowed_chats"` // Chats allowed to use the bot; empty allows none
+	PollTimeout  time.Duration `yaml:"poll_timeout"`  // Long-polling timeout. Default: 30s
+}
+
+// Telegram Bot API types, limited to the fields HomeGuard uses.
+type (
+	telegramResponse struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+
+	telegramUpdate struct {
+		UpdateID      int64                  `json:"update_id"`
+		Message       *telegramMessage       `json:"message"`
+		CallbackQuery *telegramCallbackQuery `json:"callback_query"`
+	}
+
+	telegramMessage struct {
+		MessageID int64        `json:"message_id"`
+		Chat      telegramChat `json:"chat"`
+		Text      string       `json:"text"`
+	}
+
+	telegramChat struct {
+		ID int64 `json:"id"`
+	}
+
+	telegramCallbackQuery struct {
+		ID      string           `json:"id"`
+		Message *telegramMessage `json:"message"`
+		Data    string           `json:"data"`
+	}
+
+	telegramInlineButton struct {
+		Text         string `json:"text"`
+		CallbackData string `json:"callback_data"`
+	}
+
+	telegramInlineKeyboard struct {
+		InlineKeyboard [][]telegramInlineButton `json:"inline_keyboard"`
+	}
+)
+
+type TelegramListener struct {
+	config  TelegramConfig
+	devices *device.Manager
+	client  *http.Client
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+}
+
+// NewTelegramListener creates a Telegram bot listener. devices may be nil, in
+// which case only the device names given to /wake can be used.
+func NewTelegramListener(config TelegramConfig, devices *device.Manager) *TelegramListener {
+	if config.APIURL == "" {
+		config.APIURL = DefaultTelegramAPIURL
+	}
+	if config.PollTimeout <= 0 {
+		config.PollTimeout = 30 * time.Second
+	}
+	return &TelegramListener{
+		config:  config,
+		devices: devices,
+		client:  &http.Client{Timeout: config.PollTimeout + 10*time.Second},
+	}
+}
+
+func (l *TelegramListener) Name() string {
+	return "TELEGRAM"
+}
+
+func (l *TelegramListener) logger() *slog.Logger {
+	return slog.With("type", l.Name())
+}
+
+func (l *TelegramListener) Start(ctx context.Context, queue *Queue) error {
+	if l.config.Token == "" {
+		return fmt.Errorf("telegram bot token cannot be empty")
+	}
+	if len(l.config.AllowedChats) == 0 {
+		l.logger().Warn("No allowed chats configured, all commands will be rejected")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	l.mu.Lock()
+	l.cancel = cancel
+	l.mu.Unlock()
+	l.logger().Info("Starting Telegram listener", "api", l.config.APIURL)
+
+	var offset int64
+	backoff := time.Second
+	for {
+		var updates []telegramUpdate
+		err := l.call(ctx, "getUpdates", map[string]any{
+			"offset":          offset,
+			"timeout":         int(l.config.PollTimeout / time.Second),
+			"allowed_updates": []string{"message", "callback_query"},
+		}, &updates)
+		if ctx.Err() != nil {
+			l.logger().Info("Telegram listener context canceled")
+			return nil
+		}
+		if err != nil {
+			l.logger().Error("Failed to get updates", "error", err, "backoff", backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil
+			}
+			backoff = min(backoff*2, time.Minute)
+			continue
+		}
+		backoff = time.Second
+
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			l.handleUpdate(ctx, update, queue)
+		}
+	}
+}
+
+func (l *TelegramListener) handleUpdate(ctx context.Context, update telegramUpdate, queue *Queue) {
+	switch {
+	case update.Message != nil:
+		msg := update.Message
+		// Other chats get no reply, so the bot does not reveal itself
+		if !l.authorized(msg.Chat.ID) {
+			l.logger().Warn("Ignored message from unauthorized chat", "chat", msg.Chat.ID)
+			return
+		}
+		l.handleCommand(ctx, msg.Chat.ID, msg.Text, queue)
+
+	case update.CallbackQuery != nil:
+		query := update.CallbackQuery
+		if query.Message == nil {
+			return
+		}
+		chatID := query.Message.Chat.ID
+		if !l.authorized(chatID) {
+			l.logger().Warn("Ignored callback from unauthorized chat", "chat", chatID)
+			return
+		}
+		name, ok := strings.CutPrefix(query.Data, telegramCallbackWake)
+		if hash, hashed := strings.CutPrefix(query.Data, telegramCallbackWakeHash); hashed {
+			name, ok = l.deviceByHash(hash)
+			if !ok {
+				l.answerCallback(ctx, query.ID, "Device not found")
+				return
+			}
+		}
+		if ok {
+			l.answerCallback(ctx, query.ID, "Waking "+name+"…")
+			l.wake(ctx, chatID, name, queue)
+		}
+	}
+}
+
+func (l *TelegramListener) handleCommand(ctx context.Context, chatID int64, text string, queue *Queue) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return
+	}
+	// Commands may be addressed as /wake@MyBot in groups
+	command, _, _ := strings.Cut(fields[0], "@")
+	args := fields[1:]
+
+	switch command {
+	case "/wake":
+		if len(args) != 1 {
+			l.reply(ctx, chatID, "Usage: /wake <device>", nil)
+			return
+		}
+		l.wake(ctx, chatID, args[0], queue)
+	case "/devices":
+		l.sendDevices(ctx, chatID)
+	case "/status":
+		l.sendStatus(ctx, chatID, args)
+	case "/start", "/help":
+		l.reply(ctx, chatID, "HomeGuard commands:\n/wake <device> – wake a device\n/devices – list devices\n/status [device] – show online status", nil)
+	default:
+		l.reply(ctx, chatID, "Unknown command. Try /help", nil)
+	}
+}
+
+func (l *TelegramListener) wake(ctx context.Context, chatID int64, name string, queue *Queue) {
+	request := WakeUpRequest{
+		Type:       l.Name(),
+		DeviceName: name,
+		Done: func(err error) {
+			text := fmt.Sprintf("✅ Wake-up packet sent to %s", name)
+			if err != nil {
+				text = fmt.Sprintf("❌ Failed to wake %s: %v", name, err)
+			}
+			// Do not hold up the request processor with the API call
+			go l.reply(context.Background(), chatID, text, nil)
+		},
+	}
+
+	if err := queue.TryEnqueue(request); err != nil {
+		l.logger().Warn("Dropping wakeup request", "error", err, "device", name, "chat", chatID)
+		l.reply(ctx, chatID, "⚠️ Server busy, please try again", nil)
+		return
+	}
+	l.logger().Info("Received wakeup request", "device", name, "chat", chatID)
+}
+
+func (l *TelegramListener) sendDevices(ctx context.Context, chatID int64) {
+	names := l.deviceNames()
+	if len(names) == 0 {
+		l.reply(ctx, chatID, "No devices configured.", nil)
+		return
+	}
+
+	keyboard := telegramInlineKeyboard{}
+	for _, name := range names {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []telegramInlineButton{
+			{Text: "⚡ " + name, CallbackData: wakeCallbackData(name)},
+		})
+	}
+	l.reply(ctx, chatID, "Tap a device to wake it:", keyboard)
+}
+
+func (l *TelegramListener) sendStatus(ctx context.Context, chatID int64, names []string) {
+	if l.devices == nil {
+		l.reply(ctx, chatID, "No device configuration loaded.", nil)
+		return
+	}
+	if len(names) == 0 {
+		names = l.deviceNames()
+	}
+
+	var b strings.Builder
+	for _, name := range names {
+		dev, err := l.devices.GetDevice(name)
+		if err != nil {
+			fmt.Fprintf(&b, "❓ %s: not found\n", name)
+			continue
+		}
+		if dev.Agent == nil {
+			fmt.Fprintf(&b, "⚪ %s: unknown (no agent)\n", name)
+			continue
+		}
+		status, _ := l.devices.GetStatus(name)
+		if status.Online {
+			fmt.Fprintf(&b, "🟢 %s: online", name)
+			if status.IP != "" {
+				fmt.Fprintf(&b, " (%s)", status.IP)
+			}
+			b.WriteString("\n")
+		} else {
+			fmt.Fprintf(&b, "🔴 %s: offline\n", name)
+		}
+	}
+	if b.Len() == 0 {
+		b.WriteString("No devices configured.")
+	}
+	l.reply(ctx, chatID, b.String(), nil)
+}
+
+func (l *TelegramListener) deviceNames() []string {
+	if l.devices == nil {
+		return nil
+	}
+	var names []string
+	for _, dev := range l.devices.ListDevices() {
+		names = append(names, dev.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// deviceByHash returns the device whose name has the given deviceHash.
+func (l *TelegramListener) deviceByHash(hash string) (string, bool) {
+	for _, name := range l.deviceNames() {
+		if deviceHash(name) == hash {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// wakeCallbackData returns the callback data of the wake button of a device.
+func wakeCallbackData(name string) string {
+	if len(telegramCallbackWake)+len(name) <= telegramMaxCallbackData {
+		return telegramCallbackWake + name
+	}
+	return telegramCallbackWakeHash + deviceHash(name)
+}
+
+// deviceHash identifies a device name too long for callback data.
+func deviceHash(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (l *TelegramListener) authorized(chatID int64) bool {
+	return slices.Contains(l.config.AllowedChats, chatID)
+}
+
+func (l *TelegramListener) reply(ctx context.Context, chatID int64, text string, markup any) {
+	params := map[string]any{
+		"chat_id": chatID,
+		"text":    text,
+	}
+	if markup != nil {
+		params["reply_markup"] = markup
+	}
+	if err := l.call(ctx, "sendMessage", params, nil); err != nil {
+		l.logger().Error("Failed to send message", "chat", chatID, "error", err)
+	}
+}
+
+func (l *TelegramListener) answerCallback(ctx context.Context, queryID, text string) {
+	params := map[string]any{
+		"callback_query_id": queryID,
+		"text":              text,
+	}
+	if err := l.call(ctx, "answerCallbackQuery", params, nil); err != nil {
+		l.logger().Error("Failed to answer callback query", "error", err)
+	}
+}
+
+// call invokes a Bot API method and decodes its result into result, if non-nil.
+func (l *TelegramListener) call(ctx context.Context, method string, params any, result any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := strings.TrimSuffix(l.config.APIURL, "/") + "/bot" + l.config.Token + "/" + method
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		// The URL contains the bot token; report only the method
+		return fmt.Errorf("%s request failed: %w", method, errorWithoutURL(err))
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var response telegramResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("failed to decode %s response (status %d): %w", method, resp.StatusCode, err)
+	}
+	if !response.OK {
+		return fmt.Errorf("%s failed: %s", method, response.Description)
+	}
+	if result != nil {
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			return fmt.Errorf("failed to decode %s result: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// Stop implements Listener.
+func (l *TelegramListener) Stop() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.cancel != nil {
+		l.cancel()
+		l.cancel = nil
+		l.logger().Info("Telegram listener stopped")
+	}
+	return nil
+}
+
+// errorWithoutURL strips the request URL from HTTP client errors.
+func errorWithoutURL(err error) error {
+	var urlErr *neturl.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
@@ -0,0 +1,252 @@
+package listener
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/p3ddd/HomeGuard/device"
+)
+
+const (
+	telegramToken = "123456:test-token"
+	allowedChat   = 1001
+	otherChat     = 2002
+)
+
+// longDeviceName does not fit into callback data.
+var longDeviceName = "workstation-" + strings.Repeat("x", 60)
+
+// botCall is a Bot API method call received by the stand-in.
+type botCall struct {
+	method string
+	params map[string]any
+}
+
+// botAPI is a stand-in Telegram Bot API. The first getUpdates returns the
+// given updates; the next blocks until the listener stops.
+type botAPI struct {
+	*httptest.Server
+	mu      sync.Mutex
+	calls   []botCall // Other than getUpdates
+	offsets []float64 // Offset of each getUpdates
+	polled  chan struct{}
+}
+
+func startBotAPI(t *testing.T, updates []telegramUpdate) *botAPI {
+	t.Helper()
+	api := &botAPI{polled: make(chan struct{})}
+	var once sync.Once
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, ok := strings.CutPrefix(r.URL.Path, "/bot"+telegramToken+"/")
+		if !ok {
+			http.Error(w, `{"ok":false,"description":"Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		var params map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&params)
+
+		if method == "getUpdates" {
+			api.mu.Lock()
+			api.offsets = append(api.offsets, params["offset"].(float64))
+			first := len(api.offsets) == 1
+			api.mu.Unlock()
+			if !first {
+				once.Do(func() { close(api.polled) })
+				<-r.Context().Done()
+				return
+			}
+			result, _ := json.Marshal(updates)
+			_ = json.NewEncoder(w).Encode(telegramResponse{OK: true, Result: result})
+			return
+		}
+
+		api.mu.Lock()
+		api.calls = append(api.calls, botCall{method: method, params: params})
+		api.mu.Unlock()
+		_, _ = w.Write([]byte(`{"ok":true,"result":true}`))
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func newTestDevices(t *testing.T) *device.Manager {
+	t.Helper()
+	config := "devices:\n" +
+		"  - name: desktop\n    mac: \"00:11:22:33:44:55\"\n" +
+		"  - name: nas\n    mac: \"00:11:22:33:44:66\"\n" +
+		"  - name: " + longDeviceName + "\n    mac: \"00:11:22:33:44:77\"\n"
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	devices, err := device.NewManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return devices
+}
+
+func message(chat int64, text string) telegramUpdate {
+	return telegramUpdate{Message: &telegramMessage{Chat: telegramChat{ID: chat}, Text: text}}
+}
+
+func callback(chat int64, data string) telegramUpdate {
+	return telegramUpdate{CallbackQuery: &telegramCallbackQuery{
+		ID:      "query",
+		Message: &telegramMessage{Chat: telegramChat{ID: chat}},
+		Data:    data,
+	}}
+}
+
+func TestTelegramListener(t *testing.T) {
+	tests := []struct {
+		name       string
+		updates    []telegramUpdate
+		wantQueued []string
+		wantCalls  []string // method: text of each call
+	}{
+		{
+			name:       "wake",
+			updates:    []telegramUpdate{message(allowedChat, "/wake desktop")},
+			wantQueued: []string{"desktop"},
+		},
+		{
+			name:       "wake addressed to the bot",
+			updates:    []telegramUpdate{message(allowedChat, "/wake@HomeGuardBot nas")},
+			wantQueued: []string{"nas"},
+		},
+		{
+			name:      "wake without a device",
+			updates:   []telegramUpdate{message(allowedChat, "/wake")},
+			wantCalls: []string{"sendMessage: Usage: /wake <device>"},
+		},
+		{
+			name:      "devices",
+			updates:   []telegramUpdate{message(allowedChat, "/devices")},
+			wantCalls: []string{"sendMessage: Tap a device to wake it:"},
+		},
+		{
+			name:       "button",
+			updates:    []telegramUpdate{callback(allowedChat, "wake:nas")},
+			wantQueued: []string{"nas"},
+			wantCalls:  []string{"answerCallbackQuery: Waking nas…"},
+		},
+		{
+			name:       "button of a long device name",
+			updates:    []telegramUpdate{callback(allowedChat, wakeCallbackData(longDeviceName))},
+			wantQueued: []string{longDeviceName},
+			wantCalls:  []string{"answerCallbackQuery: Waking " + longDeviceName + "…"},
+		},
+		{
+			name:      "button of an unknown hash",
+			updates:   []telegramUpdate{callback(allowedChat, telegramCallbackWakeHash+"0123")},
+			wantCalls: []string{"answerCallbackQuery: Device not found"},
+		},
+		{
+			name: "unauthorized chat is ignored",
+			updates: []telegramUpdate{
+				message(otherChat, "/wake desktop"),
+				message(otherChat, "/devices"),
+				callback(otherChat, "wake:desktop"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.updates {
+				tt.updates[i].UpdateID = int64(100 + i)
+			}
+			api := startBotAPI(t, tt.updates)
+			l := NewTelegramListener(TelegramConfig{
+				Token:        telegramToken,
+				APIURL:       api.URL,
+				AllowedChats: []int64{allowedChat},
+			}, newTestDevices(t))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			queue := NewQueue(0, 0, nil)
+			errs := make(chan error, 1)
+			go func() { errs <- l.Start(ctx, queue) }()
+			select {
+			case <-api.polled:
+			case <-time.After(5 * time.Second):
+				t.Fatal("listener did not poll again")
+			}
+			cancel()
+			if err := <-errs; err != nil {
+				t.Fatal(err)
+			}
+
+			// The next poll confirms the updates
+			if want := []float64{0, float64(100 + len(tt.updates))}; !slices.Equal(api.offsets[:2], want) {
+				t.Errorf("offsets = %v, want %v", api.offsets, want)
+			}
+			var queued []string
+			for _, r := range queue.pending {
+				queued = append(queued, r.DeviceName)
+			}
+			if !slices.Equal(queued, tt.wantQueued) {
+				t.Errorf("queued = %v, want %v", queued, tt.wantQueued)
+			}
+			var calls []string
+			for _, c := range api.calls {
+				calls = append(calls, c.method+": "+c.params["text"].(string))
+				if chat, ok := c.params["chat_id"]; ok && chat != float64(allowedChat) {
+					t.Errorf("%s sent to chat %v", c.method, chat)
+				}
+			}
+			if !slices.Equal(calls, tt.wantCalls) {
+				t.Errorf("calls = %q, want %q", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestTelegramDevicesKeyboard(t *testing.T) {
+	api := startBotAPI(t, []telegramUpdate{message(allowedChat, "/devices")})
+	l := NewTelegramListener(TelegramConfig{
+		Token:        telegramToken,
+		APIURL:       api.URL,
+		AllowedChats: []int64{allowedChat},
+	}, newTestDevices(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() { errs <- l.Start(ctx, NewQueue(0, 0, nil)) }()
+	<-api.polled
+	cancel()
+	<-errs
+
+	if len(api.calls) != 1 {
+		t.Fatalf("calls = %+v, want one", api.calls)
+	}
+	data, _ := json.Marshal(api.calls[0].params["reply_markup"])
+	var keyboard telegramInlineKeyboard
+	if err := json.Unmarshal(data, &keyboard); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, row := range keyboard.InlineKeyboard {
+		for _, button := range row {
+			if len(button.CallbackData) > telegramMaxCallbackData {
+				t.Errorf("callback data of %q is %d bytes", button.Text, len(button.CallbackData))
+			}
+			got = append(got, button.CallbackData)
+		}
+	}
+	want := []string{"wake:desktop", "wake:nas", telegramCallbackWakeHash + deviceHash(longDeviceName)}
+	if !slices.Equal(got, want) {
+		t.Errorf("callback data = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,56 @@
+package listener
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// MQTTTLSConfig holds the TLS settings for ssl:// and wss:// brokers.
+type MQTTTLSConfig struct {
+	CAFile             string `yaml:"ca_file"`              // PEM CA bundle used to verify the broker (default: system roots)
+	CertFile           string `yaml:"cert_file"`            // PEM client certificate
+	KeyFile            string `yaml:"key_file"`             // PEM client private key
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // Do not verify the broker certificate
+	ServerName         string `yaml:"server_name"`          // SNI and verification name (default: broker host)
+}
+
+// Load builds a tls.Config from c. It returns nil if no option is set, in
+// which case the client's defaults apply.
+func (c MQTTTLSConfig) Load() (*tls.Config, error) {
+	if c == (MQTTTLSConfig{}) {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify, //nolint:gosec // Explicitly requested by configuration
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if c.CAFile != "" {
+		data, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in CA file: %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, fmt.Errorf("client certificate requires both cert_file and key_file")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
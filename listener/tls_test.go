@@ -0,0 +1,439 @@
+package listener
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMQTTTLSConfigLoad(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	caFile := ca.writeCert(t, dir, "ca.pem")
+	certFile, keyFile := ca.issue(t, dir, "homeguard", false)
+	notPEM := filepath.Join(dir, "not-pem.txt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.pem")
+
+	tests := []struct {
+		name      string
+		config    MQTTTLSConfig
+		wantNil   bool
+		wantRoots bool
+		wantCerts int
+		wantErr   string
+	}{
+		{
+			name:    "nothing set",
+			wantNil: true,
+		},
+		{
+			name:   "insecure skip verify",
+			config: MQTTTLSConfig{InsecureSkipVerify: true},
+		},
+		{
+			name:      "CA file",
+			config:    MQTTTLSConfig{CAFile: caFile},
+			wantRoots: true,
+		},
+		{
+			name:      "client certificate",
+			config:    MQTTTLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile},
+			wantRoots: true,
+			wantCerts: 1,
+		},
+		{
+			name:    "missing CA file",
+			config:  MQTTTLSConfig{CAFile: missing},
+			wantErr: "failed to read CA file",
+		},
+		{
+			name:    "CA file without certificates",
+			config:  MQTTTLSConfig{CAFile: notPEM},
+			wantErr: "no certificates found in CA file",
+		},
+		{
+			name:    "certificate without key",
+			config:  MQTTTLSConfig{CertFile: certFile},
+			wantErr: "requires both cert_file and key_file",
+		},
+		{
+			name:    "missing certificate file",
+			config:  MQTTTLSConfig{CertFile: missing, KeyFile: keyFile},
+			wantErr: "failed to load client certificate",
+		},
+		{
+			name:    "key not matching the certificate",
+			config:  MQTTTLSConfig{CertFile: certFile, KeyFile: notPEM},
+			wantErr: "failed to load client certificate",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := tt.config.Load()
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if (config == nil) != tt.wantNil {
+				t.Fatalf("config = %v, want nil %v", config, tt.wantNil)
+			}
+			if config == nil {
+				return
+			}
+			if config.InsecureSkipVerify != tt.config.InsecureSkipVerify {
+				t.Errorf("InsecureSkipVerify = %v, want %v", config.InsecureSkipVerify, tt.config.InsecureSkipVerify)
+			}
+			if (config.RootCAs != nil) != tt.wantRoots {
+				t.Errorf("RootCAs set = %v, want %v", config.RootCAs != nil, tt.wantRoots)
+			}
+			if len(config.Certificates) != tt.wantCerts {
+				t.Errorf("%d client certificates, want %d", len(config.Certificates), tt.wantCerts)
+			}
+		})
+	}
+}
+
+func TestMQTTListenerTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	caFile := ca.writeCert(t, dir, "ca.pem")
+	certFile, keyFile := ca.issue(t, dir, "homeguard", false)
+	serverCert, serverKey := ca.issue(t, dir, "127.0.0.1", true)
+
+	tests := []struct {
+		name       string
+		config     MQTTTLSConfig
+		wantClient string // Common name of the client certificate the broker saw
+		wantErr    string // Handshake error on the broker side
+	}{
+		{
+			name:       "client certificate",
+			config:     MQTTTLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile},
+			wantClient: "homeguard",
+		},
+		{
+			name:   "CA only",
+			config: MQTTTLSConfig{CAFile: caFile},
+		},
+		{
+			name:   "insecure skip verify",
+			config: MQTTTLSConfig{InsecureSkipVerify: true},
+		},
+		{
+			name:    "broker not trusted",
+			config:  MQTTTLSConfig{ServerName: "127.0.0.1"}, // System roots
+			wantErr: "bad certificate",
+		},
+		{
+			name:    "server name mismatch",
+			config:  MQTTTLSConfig{CAFile: caFile, ServerName: "broker.example"},
+			wantErr: "bad certificate",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := startTLSBroker(t, serverCert, serverKey, caFile)
+			l := NewMQTTListener(MQTTConfig{Broker: "ssl://" + broker.addr, TLS: tt.config}, nil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			errs := make(chan error, 1)
+			go func() { errs <- l.Start(ctx, NewQueue(0, 0, nil)) }()
+
+			select {
+			case c := <-broker.conns:
+				if tt.wantErr == "" && c.err != nil {
+					t.Errorf("handshake failed: %v", c.err)
+				}
+				if tt.wantErr != "" && (c.err == nil || !strings.Contains(c.err.Error(), tt.wantErr)) {
+					t.Errorf("handshake error = %v, want %q", c.err, tt.wantErr)
+				}
+				if c.client != tt.wantClient {
+					t.Errorf("client certificate = %q, want %q", c.client, tt.wantClient)
+				}
+			case <-time.After(5 * time.Second):
+				t.Error("listener did not connect")
+			}
+			if tt.wantErr == "" {
+				select {
+				case <-broker.subscribed:
+				case <-time.After(5 * time.Second):
+					t.Error("listener did not subscribe")
+				}
+			}
+
+			cancel()
+			select {
+			case err := <-errs:
+				if err != nil {
+					t.Errorf("Start returned %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Start did not return after cancel")
+			}
+		})
+	}
+}
+
+func TestMQTTListenerTLSBadPath(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	caFile := ca.writeCert(t, dir, "ca.pem")
+	serverCert, serverKey := ca.issue(t, dir, "127.0.0.1", true)
+	broker := startTLSBroker(t, serverCert, serverKey, caFile)
+
+	l := NewMQTTListener(MQTTConfig{
+		Broker: "ssl://" + broker.addr,
+		TLS: MQTTTLSConfig{
+			CAFile:   caFile,
+			CertFile: filepath.Join(dir, "missing.pem"),
+			KeyFile:  filepath.Join(dir, "missing.key"),
+		},
+	}, nil)
+
+	errs := make(chan error, 1)
+	go func() { errs <- l.Start(t.Context(), NewQueue(0, 0, nil)) }()
+	select {
+	case err := <-errs:
+		if err == nil || !strings.Contains(err.Error(), "failed to load MQTT TLS config") {
+			t.Fatalf("error = %v, want one containing %q", err, "failed to load MQTT TLS config")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not fail")
+	}
+	select {
+	case <-broker.conns:
+		t.Error("listener connected to the broker")
+	default:
+	}
+}
+
+// testCA issues certificates for the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "HomeGuard test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) writeCert(t *testing.T, dir, name string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	writePEM(t, path, "CERTIFICATE", ca.cert.Raw)
+	return path
+}
+
+// issue writes a certificate for name and its key, and returns their paths.
+// A server certificate is valid for name as an IP address.
+func (ca *testCA) issue(t *testing.T, dir, name string, server bool) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.ParseIP(name)}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, name+".pem")
+	keyFile = filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tlsBroker is a stand-in MQTT broker behind a tls.Listener. It accepts any
+// CONNECT and SUBSCRIBE, and reports the outcome of every TLS handshake.
+type tlsBroker struct {
+	addr       string
+	conns      chan brokerConn
+	subscribed chan struct{} // Receives a value for every SUBSCRIBE
+}
+
+type brokerConn struct {
+	client string // Common name of the client certificate, if any
+	err    error  // Handshake error
+}
+
+func startTLSBroker(t *testing.T, certFile, keyFile, caFile string) *tlsBroker {
+	t.Helper()
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(caPEM)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	b := &tlsBroker{
+		addr:       ln.Addr().String(),
+		conns:      make(chan brokerConn, 16),
+		subscribed: make(chan struct{}, 16),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn.(*tls.Conn))
+		}
+	}()
+	return b
+}
+
+func (b *tlsBroker) serve(conn *tls.Conn) {
+	defer func() { _ = conn.Close() }()
+	if err := conn.Handshake(); err != nil {
+		b.conns <- brokerConn{err: err}
+		return
+	}
+	var c brokerConn
+	if peers := conn.ConnectionState().PeerCertificates; len(peers) > 0 {
+		c.client = peers[0].Subject.CommonName
+	}
+	b.conns <- c
+
+	r := bufio.NewReader(conn)
+	for {
+		header, body, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		var reply []byte
+		switch header >> 4 {
+		case 1: // CONNECT
+			reply = []byte{0x20, 2, 0, 0}
+		case 8: // SUBSCRIBE, granted QoS 0 for every topic
+			b.subscribed <- struct{}{}
+			reply = append([]byte{0x90, byte(len(body))}, body[:2]...)
+			for rest := body[2:]; len(rest) >= 2; {
+				n := int(binary.BigEndian.Uint16(rest))
+				rest = rest[min(2+n+1, len(rest)):]
+				reply = append(reply, 0)
+			}
+			reply[1] = byte(len(reply) - 2)
+		case 10: // UNSUBSCRIBE
+			reply = []byte{0xb0, 2, body[0], body[1]}
+		case 12: // PINGREQ
+			reply = []byte{0xd0, 0}
+		case 14: // DISCONNECT
+			return
+		}
+		if reply != nil {
+			if _, err := conn.Write(reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readPacket reads one MQTT control packet.
+func readPacket(r *bufio.Reader) (header byte, body []byte, err error) {
+	header, err = r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, shift := 0, 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+		if shift > 21 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+	}
+	body = make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return header, body, err
+}
@@ -0,0 +1,289 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/p3ddd/HomeGuard/device"
+	"github.com/p3ddd/HomeGuard/discover"
+	"github.com/p3ddd/HomeGuard/event"
+)
+
+var _ Listener = (*UnixSocketListener)(nil)
+
+// DefaultUnixSocketPath is used when UnixSocketConfig.Path is empty.
+const DefaultUnixSocketPath = "/run/homeguard.sock"
+
+// UnixSocketConfig holds the configuration for Unix socket listener.
+type UnixSocketConfig struct {
+	Path  string `yaml:"path"`  // Default: /run/homeguard.sock
+	Mode  string `yaml:"mode"`  // Octal file mode, default "0660"
+	Owner string `yaml:"owner"` // User name or UID owning the socket
+	Group string `yaml:"group"` // Group name or GID owning the socket; its members are allowed
+
+	// Root and the user running HomeGuard are always allowed. Other peers
+	// need a listed UID, or a listed GID as primary or supplementary group.
+	AllowedUIDs []int `yaml:"allowed_uids"`
+	AllowedGIDs []int `yaml:"allowed_gids"`
+}
+
+// UnixSocketListener serves the HTTP API on a Unix domain socket and
+// authorizes clients by their peer credentials.
+type UnixSocketListener struct {
+	config UnixSocketConfig
+	api    *HTTPListener
+	server *http.Server
+	mu     sync.Mutex
+
+	allowedUIDs []int
+	allowedGIDs []int
+
+	// groupIDs returns the supplementary groups of a user
+	groupIDs func(uid int) ([]int, error)
+}
+
+// peerCredentials are the credentials of the process on the other end of a
+// Unix socket connection.
+type peerCredentials struct {
+	UID int
+	GID int
+	PID int
+}
+
+type peerCredentialsKey struct{}
+
+// NewUnixSocketListener creates a Unix socket listener. devices may be nil,
+// as with NewHTTPListener.
+func NewUnixSocketListener(config UnixSocketConfig, devices *device.Manager) *UnixSocketListener {
+	if config.Path == "" {
+		config.Path = DefaultUnixSocketPath
+	}
+	if config.Mode == "" {
+		config.Mode = "0660"
+	}
+	return &UnixSocketListener{
+		config: config,
+		api: &HTTPListener{
+			name:    "UNIX",
+			devices: devices,
+		},
+		groupIDs: lookupGroupIDs,
+	}
+}
+
+// SetEvents sets the bus streamed at /api/v1/events. It must be called
+// before Start.
+func (l *UnixSocketListener) SetEvents(bus *event.Bus) {
+	l.api.SetEvents(bus)
+}
+
+// SetConfigValidator sets the function behind /api/v1/config/validate. It
+// must be called before Start.
+func (l *UnixSocketListener) SetConfigValidator(fn func(format string, data []byte) error) {
+	l.api.SetConfigValidator(fn)
+}
+
+// SetDiscovery sets the lease and vendor files used by /api/v1/discover. It
+// must be called before Start.
+func (l *UnixSocketListener) SetDiscovery(config discover.Config) {
+	l.api.SetDiscovery(config)
+}
+
+func (l *UnixSocketListener) Name() string {
+	return "UNIX"
+}
+
+func (l *UnixSocketListener) logger() *slog.Logger {
+	return slog.With("type", l.Name(), "path", l.config.Path)
+}
+
+func (l *UnixSocketListener) Start(ctx context.Context, queue *Queue) error {
+	mode, err := strconv.ParseUint(l.config.Mode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid socket mode: %s", l.config.Mode)
+	}
+	uid, err := lookupID(l.config.Owner, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		return fmt.Errorf("invalid socket owner: %w", err)
+	}
+	gid, err := lookupID(l.config.Group, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+	if err != nil {
+		return fmt.Errorf("invalid socket group: %w", err)
+	}
+
+	l.allowedUIDs = append([]int{0, os.Getuid()}, l.config.AllowedUIDs...)
+	l.allowedGIDs = slices.Clone(l.config.AllowedGIDs)
+	if gid >= 0 {
+		l.allowedGIDs = append(l.allowedGIDs, gid)
+	}
+
+	// Remove a stale socket left behind by an unclean exit, but not one
+	// another server still answers on
+	if info, err := os.Lstat(l.config.Path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		if conn, err := net.DialTimeout("unix", l.config.Path, time.Second); err == nil {
+			_ = conn.Close()
+			return fmt.Errorf("socket %s is in use by another process", l.config.Path)
+		}
+		if err := os.Remove(l.config.Path); err != nil {
+			return fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	ln, err := net.Listen("unix", l.config.Path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on unix socket: %w", err)
+	}
+	if err := os.Chmod(l.config.Path, fs.FileMode(mode)); err != nil {
+		_ = ln.Close()
+		return fmt.Errorf("failed to set socket mode: %w", err)
+	}
+	if uid >= 0 || gid >= 0 {
+		if err := os.Chown(l.config.Path, uid, gid); err != nil {
+			_ = ln.Close()
+			return fmt.Errorf("failed to set socket owner: %w", err)
+		}
+	}
+
+	l.mu.Lock()
+	l.server = &http.Server{
+		Handler: l.authorize(l.api.handler(ctx, queue)),
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			conn, ok := c.(*net.UnixConn)
+			if !ok {
+				return ctx
+			}
+			cred, err := getPeerCredentials(conn)
+			if err != nil {
+				l.logger().Warn("Failed to read peer credentials", "error", err)
+				return ctx
+			}
+			return context.WithValue(ctx, peerCredentialsKey{}, cred)
+		},
+	}
+	l.mu.Unlock()
+
+	l.logger().Info("Starting Unix socket listener", "mode", l.config.Mode)
+
+	// Handle graceful shutdown
+	go func() {
+		<-ctx.Done()
+		l.logger().Info("Shutting down Unix socket listener")
+		if err := l.server.Shutdown(context.Background()); err != nil {
+			l.logger().Error("Failed to shutdown Unix socket server", "error", err)
+		}
+	}()
+
+	if err := l.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// authorize rejects clients whose peer credentials are unknown or not allowed.
+func (l *UnixSocketListener) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cred, ok := r.Context().Value(peerCredentialsKey{}).(peerCredentials)
+		if !ok || !l.allowed(cred) {
+			l.logger().Warn("Rejected unauthorized peer", "uid", cred.UID, "gid", cred.GID, "pid", cred.PID)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowed reports whether a peer has an allowed UID, primary GID or
+// supplementary group.
+func (l *UnixSocketListener) allowed(cred peerCredentials) bool {
+	if slices.Contains(l.allowedUIDs, cred.UID) || slices.Contains(l.allowedGIDs, cred.GID) {
+		return true
+	}
+	if len(l.allowedGIDs) == 0 {
+		return false
+	}
+	groups, err := l.groupIDs(cred.UID)
+	if err != nil {
+		l.logger().Warn("Failed to look up peer groups", "uid", cred.UID, "error", err)
+		return false
+	}
+	return slices.ContainsFunc(groups, func(gid int) bool {
+		return slices.Contains(l.allowedGIDs, gid)
+	})
+}
+
+// lookupGroupIDs returns the groups a user is a member of.
+func lookupGroupIDs(uid int) ([]int, error) {
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return nil, err
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	gids := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if gid, err := strconv.Atoi(id); err == nil {
+			gids = append(gids, gid)
+		}
+	}
+	return gids, nil
+}
+
+// lookupID resolves a user or group given by name or numeric ID. An empty
+// value yields -1, which leaves the ownership unchanged.
+func lookupID(value string, lookup func(name string) (string, error)) (int, error) {
+	if value == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(value); err == nil {
+		return id, nil
+	}
+	id, err := lookup(value)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
+
+// Stop implements Listener.
+func (l *UnixSocketListener) Stop() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.server != nil {
+		if err := l.server.Shutdown(context.Background()); err != nil {
+			return err
+		}
+		if err := os.Remove(l.config.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			l.logger().Error("Failed to remove socket", "error", err)
+		}
+		l.logger().Info("Unix socket listener stopped")
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnixSocketAuthorize(t *testing.T) {
+	groups := map[int][]int{
+		1000: {1000, 27, 100}, // Supplementary groups include sudo (27)
+		1001: {1001},
+	}
+	lookup := func(uid int) ([]int, error) {
+		if g, ok := groups[uid]; ok {
+			return g, nil
+		}
+		return nil, errors.New("unknown user")
+	}
+
+	tests := []struct {
+		name        string
+		cred        *peerCredentials
+		allowedGIDs []int
+		want        int
+	}{
+		{name: "root", cred: &peerCredentials{UID: 0, GID: 0}, want: http.StatusOK},
+		{name: "same user", cred: &peerCredentials{UID: os.Getuid(), GID: 5000}, want: http.StatusOK},
+		{name: "allowed UID", cred: &peerCredentials{UID: 1002, GID: 1002}, want: http.StatusOK},
+		{name: "primary group", cred: &peerCredentials{UID: 1001, GID: 27}, allowedGIDs: []int{27}, want: http.StatusOK},
+		{name: "supplementary group", cred: &peerCredentials{UID: 1000, GID: 1000}, allowedGIDs: []int{27}, want: http.StatusOK},
+		{name: "not a member", cred: &peerCredentials{UID: 1001, GID: 1001}, allowedGIDs: []int{27}, want: http.StatusForbidden},
+		{name: "unknown user", cred: &peerCredentials{UID: 4242, GID: 4242}, allowedGIDs: []int{27}, want: http.StatusForbidden},
+		{name: "no allowed groups", cred: &peerCredentials{UID: 1000, GID: 1000}, want: http.StatusForbidden},
+		{name: "no credentials", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewUnixSocketListener(UnixSocketConfig{}, nil)
+			l.groupIDs = lookup
+			l.allowedUIDs = []int{0, os.Getuid(), 1002}
+			l.allowedGIDs = tt.allowedGIDs
+
+			handler := l.authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/devices", nil)
+			if tt.cred != nil {
+				r = r.WithContext(context.WithValue(r.Context(), peerCredentialsKey{}, *tt.cred))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+// startUnixListener starts a listener on path and waits until it serves.
+func startUnixListener(t *testing.T, path string) (stop func() error) {
+	t.Helper()
+	l := NewUnixSocketListener(UnixSocketConfig{Path: path}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- l.Start(ctx, NewQueue(0, 0, nil)) }()
+
+	client := unixClient(path)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		resp, err := client.Get("http://unix/api/v1/openapi.json")
+		if err == nil {
+			_ = resp.Body.Close()
+			break
+		}
+		select {
+		case err := <-errs:
+			cancel()
+			t.Fatalf("Start failed: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatalf("listener did not serve: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return func() error {
+		cancel()
+		return <-errs
+	}
+}
+
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func TestUnixSocketListenerServes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hg.sock")
+	stop := startUnixListener(t, path)
+
+	// The test process is the user running the listener
+	resp, err := unixClient(path).Get("http://unix/api/v1/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o660 {
+		t.Errorf("socket mode = %o, want 660", mode)
+	}
+
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnixSocketListenerInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hg.sock")
+	stop := startUnixListener(t, path)
+	defer func() { _ = stop() }()
+
+	second := NewUnixSocketListener(UnixSocketConfig{Path: path}, nil)
+	err := second.Start(t.Context(), NewQueue(0, 0, nil))
+	if err == nil || !strings.Contains(err.Error(), "in use by another process") {
+		t.Fatalf("error = %v, want one containing %q", err, "in use by another process")
+	}
+
+	// The first listener still serves on its socket
+	resp, err := unixClient(path).Get("http://unix/api/v1/openapi.json")
+	if err != nil {
+		t.Fatalf("first listener lost its socket: %v", err)
+	}
+	_ = resp.Body.Close()
+}
+
+func TestUnixSocketListenerStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hg.sock")
+
+	// A socket file nobody listens on, as left by a crash
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.SetUnlinkOnClose(false)
+	_ = ln.Close()
+	if _, err := os.Lstat(path); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := startUnixListener(t, path)
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLookupGroupIDs(t *testing.T) {
+	gids, err := lookupGroupIDs(os.Getuid())
+	if err != nil {
+		t.Skipf("user database unavailable: %v", err)
+	}
+	if !slices.Contains(gids, os.Getgid()) {
+		t.Errorf("groups %v do not include the primary group %d", gids, os.Getgid())
+	}
+}
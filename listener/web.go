@@ -0,0 +1,20 @@
+package listener
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// webHandler serves the embedded web UI. The UI itself is public; the API
+// calls it makes carry the user's token.
+func webHandler() http.Handler {
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServerFS(files)
+}
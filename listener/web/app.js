@@ -0,0 +1,179 @@
+"use strict";
+
+const tokenKey = "homeguard-token";
+const $ = (id) => document.getElementById(id);
+
+let events = null;
+
+function token() {
+  return localStorage.getItem(tokenKey) || "";
+}
+
+async function api(method, path) {
+  const headers = {};
+  if (token()) {
+    headers.Authorization = "Bearer " + token();
+  }
+  const resp = await fetch(path, { method, headers });
+  if (resp.status === 401) {
+    showLogin();
+    throw new Error("Unauthorized");
+  }
+  const body = await resp.json().catch(() => null);
+  if (!resp.ok) {
+    throw new Error(body?.error?.message || resp.statusText);
+  }
+  return body;
+}
+
+function toast(message) {
+  const el = $("toast");
+  el.textContent = message;
+  el.hidden = false;
+  clearTimeout(toast.timer);
+  toast.timer = setTimeout(() => { el.hidden = true; }, 3000);
+}
+
+function element(tag, className, text) {
+  const el = document.createElement(tag);
+  if (className) el.className = className;
+  if (text !== undefined) el.textContent = text;
+  return el;
+}
+
+function card(title, subtitle, subtitleClass, buttonLabel, onClick, dot) {
+  const li = element("li");
+  if (dot) li.append(dot);
+
+  const info = element("div", "info");
+  info.append(element("div", "name", title));
+  if (subtitle) info.append(element("div", subtitleClass, subtitle));
+  li.append(info);
+
+  const button = element("button", "", buttonLabel);
+  button.addEventListener("click", async () => {
+    button.disabled = true;
+    try {
+      await onClick();
+    } catch (err) {
+      toast(err.message);
+    } finally {
+      button.disabled = false;
+    }
+  });
+  li.append(button);
+  return li;
+}
+
+async function loadDevices() {
+  const devices = await api("GET", "/api/v1/devices");
+  const list = $("devices");
+  list.replaceChildren();
+  for (const dev of devices) {
+    const dot = element("span", "dot");
+    if (dev.has_agent) {
+      dot.classList.add(dev.online ? "online" : "offline");
+      dot.title = dev.online ? "Online" : "Offline";
+    } else {
+      dot.title = "Status unknown";
+    }
+    list.append(card(dev.name, dev.description, "description", "Wake", async () => {
+      await api("POST", "/api/v1/devices/" + encodeURIComponent(dev.name) + "/wake");
+      toast("Waking " + dev.name);
+    }, dot));
+  }
+  if (devices.length === 0) {
+    list.append(element("li", "", "No devices configured."));
+  }
+}
+
+async function loadGroups() {
+  const groups = await api("GET", "/api/v1/groups");
+  const list = $("groups");
+  list.replaceChildren();
+  for (const group of groups) {
+    list.append(card(group.name, group.devices.join(", "), "members", "Wake all", async () => {
+      await api("POST", "/api/v1/groups/" + encodeURIComponent(group.name) + "/wake");
+      toast("Waking group " + group.name);
+    }));
+  }
+  $("groups-section").hidden = groups.length === 0;
+}
+
+function historyItem(e) {
+  const li = element("li");
+  const failed = e.type.endsWith(".failed");
+  const action = e.type.startsWith("shutdown.") ? "Shutdown" : "Wake";
+  let text = action + " " + (e.device || e.mac) + (failed ? " failed" : "");
+  if (e.source) text += " (" + e.source + ")";
+  const label = element("span", failed ? "failed" : "", text);
+  if (e.error) label.title = e.error;
+  const time = element("time", "", new Date(e.time).toLocaleString());
+  time.dateTime = e.time;
+  li.append(label, time);
+  return li;
+}
+
+async function loadHistory() {
+  const history = await api("GET", "/api/v1/history");
+  const list = $("history");
+  list.replaceChildren(...history.slice(0, 20).map(historyItem));
+  if (history.length === 0) {
+    list.append(element("li", "", "Nothing yet."));
+  }
+}
+
+function subscribe() {
+  if (events) events.close();
+  let url = "/api/v1/events";
+  if (token()) url += "?access_token=" + encodeURIComponent(token());
+  events = new EventSource(url);
+
+  const refreshHistory = () => loadHistory().catch(() => {});
+  const refreshDevices = () => loadDevices().catch(() => {});
+  for (const type of ["wake.sent", "wake.failed", "shutdown.sent", "shutdown.failed"]) {
+    events.addEventListener(type, refreshHistory);
+  }
+  for (const type of ["device.online", "device.offline"]) {
+    events.addEventListener(type, refreshDevices);
+  }
+  events.addEventListener("config.reloaded", () => {
+    refreshDevices();
+    loadGroups().catch(() => {});
+  });
+}
+
+function showLogin() {
+  if (events) events.close();
+  $("app").hidden = true;
+  $("logout").hidden = true;
+  $("login").hidden = false;
+  $("token").focus();
+}
+
+async function start() {
+  try {
+    await Promise.all([loadDevices(), loadGroups(), loadHistory()]);
+  } catch (err) {
+    if (err.message !== "Unauthorized") toast(err.message);
+    return;
+  }
+  $("login").hidden = true;
+  $("app").hidden = false;
+  $("logout").hidden = !token();
+  subscribe();
+}
+
+$("login").addEventListener("submit", (e) => {
+  e.preventDefault();
+  localStorage.setItem(tokenKey, $("token").value);
+  $("token").value = "";
+  start();
+});
+
+$("logout").addEventListener("click", () => {
+  localStorage.removeItem(tokenKey);
+  showLogin();
+});
+
+start();
@@ -0,0 +1,68 @@
+package listener
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebUI(t *testing.T) {
+	tests := []struct {
+		path        string
+		wantStatus  int
+		contentType string
+	}{
+		{path: "/", wantStatus: http.StatusOK, contentType: "text/html"},
+		{path: "/app.js", wantStatus: http.StatusOK, contentType: "text/javascript"},
+		{path: "/style.css", wantStatus: http.StatusOK, contentType: "text/css"},
+		{path: "/missing.html", wantStatus: http.StatusNotFound},
+	}
+	l := NewHTTPListener(":0", nil)
+	l.SetTokens([]string{"secret"})
+	handler := l.handler(t.Context(), NewQueue(0, 0, nil))
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			// The UI itself is served without a token
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.contentType) {
+				t.Errorf("content type = %q, want %s", ct, tt.contentType)
+			}
+		})
+	}
+}
+
+// TestWebUIRequests checks that the API calls of app.js are served and
+// authorized the way the UI sends its token.
+func TestWebUIRequests(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/api/v1/devices"},
+		{http.MethodPost, "/api/v1/devices/desktop/wake"},
+		{http.MethodGet, "/api/v1/groups"},
+		{http.MethodPost, "/api/v1/groups/office/wake"},
+		{http.MethodGet, "/api/v1/history"},
+	}
+	devices := newAPIDevices(t)
+	l := NewHTTPListener(":0", devices)
+	l.SetTokens([]string{"secret"})
+	handler := l.handler(t.Context(), NewQueue(10, 1, devices))
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.Header.Set("Accept", "*/*") // What fetch sends
+			r.Header.Set("Authorization", "Bearer secret")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != http.StatusOK && w.Code != http.StatusAccepted {
+				t.Errorf("status = %d: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
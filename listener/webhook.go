@@ -0,0 +1,398 @@
+package listener
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Webhook signature schemes.
+const (
+	SignatureNone       = ""            // Shared secret sent as is
+	SignatureHMACSHA256 = "hmac-sha256" // Hex HMAC-SHA256 of the body, optionally prefixed with "sha256="
+)
+
+// Defaults for webhook routes.
+const (
+	DefaultWebhookType            = "WEBHOOK"
+	DefaultWebhookSecretHeader    = "X-Webhook-Secret"
+	DefaultWebhookSignatureHeader = "X-Hub-Signature-256"
+
+	maxWebhookBody = 1 << 20
+)
+
+// WebhookConfig configures a route served at /hooks/{id}.
+//
+// Device, Mac, Broadcast and Action are either JSONPath expressions such as
+// "$.repository.name" or text/template strings such as "{{ .value1 }}",
+// evaluated against the request body. JSON bodies are decoded as is; form
+// bodies and query parameters become a flat object. A string without
+// template actions is used literally.
+type WebhookConfig struct {
+	ID   string `yaml:"id"`
+	Type string `yaml:"type"` // Request type reported in logs, default "WEBHOOK"
+
+	// Secret authenticates requests. Without a signature scheme it must be
+	// sent in the secret header, as a bearer token or as the "token" query
+	// parameter. With "hmac-sha256" it is the HMAC key.
+	Secret          string `yaml:"secret"`
+	Signature       string `yaml:"signature"`        // "" or "hmac-sha256"
+	SecretHeader    string `yaml:"secret_header"`    // Default: X-Webhook-Secret
+	SignatureHeader string `yaml:"signature_header"` // Default: X-Hub-Signature-256
+
+	Device    string `yaml:"device"`
+	Mac       string `yaml:"mac"`
+	Broadcast string `yaml:"broadcast"`
+	Action    string `yaml:"action"` // Evaluates to "wake" (default) or "shutdown"
+}
+
+// Validate checks that the route's ID, signature scheme and expressions are
+// valid.
+func (c WebhookConfig) Validate() error {
+	_, err := newWebhook(c)
+	return err
+}
+
+// webhook is a compiled webhook route.
+type webhook struct {
+	config    WebhookConfig
+	device    *webhookExpr
+	mac       *webhookExpr
+	broadcast *webhookExpr
+	action    *webhookExpr
+}
+
+// webhookExpr extracts a string from a decoded request body.
+type webhookExpr struct {
+	path []any // JSONPath steps: string keys and int indexes
+	tmpl *template.Template
+}
+
+func newWebhook(config WebhookConfig) (*webhook, error) {
+	if config.ID == "" || strings.Contains(config.ID, "/") {
+		return nil, fmt.Errorf("invalid webhook id: %q", config.ID)
+	}
+	if config.Type == "" {
+		config.Type = DefaultWebhookType
+	}
+	if config.SecretHeader == "" {
+		config.SecretHeader = DefaultWebhookSecretHeader
+	}
+	if config.SignatureHeader == "" {
+		config.SignatureHeader = DefaultWebhookSignatureHeader
+	}
+	switch config.Signature {
+	case SignatureNone:
+	case SignatureHMACSHA256:
+		if config.Secret == "" {
+			return nil, fmt.Errorf("webhook %s: %s requires a secret", config.ID, config.Signature)
+		}
+	default:
+		return nil, fmt.Errorf("webhook %s: unknown signature scheme: %s", config.ID, config.Signature)
+	}
+	if config.Device == "" && config.Mac == "" {
+		return nil, fmt.Errorf("webhook %s: device or mac expression is required", config.ID)
+	}
+
+	hook := &webhook{config: config}
+	for _, field := range []struct {
+		name string
+		expr string
+		dst  **webhookExpr
+	}{
+		{"device", config.Device, &hook.device},
+		{"mac", config.Mac, &hook.mac},
+		{"broadcast", config.Broadcast, &hook.broadcast},
+		{"action", config.Action, &hook.action},
+	} {
+		expr, err := parseWebhookExpr(field.name, field.expr)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: invalid %s expression: %w", config.ID, field.name, err)
+		}
+		*field.dst = expr
+	}
+	return hook, nil
+}
+
+// parseWebhookExpr compiles a JSONPath or template expression. An empty
+// expression yields nil.
+func parseWebhookExpr(name, expr string) (*webhookExpr, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(expr, "$") {
+		path, err := parseJSONPath(expr)
+		if err != nil {
+			return nil, err
+		}
+		return &webhookExpr{path: path}, nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &webhookExpr{tmpl: tmpl}, nil
+}
+
+// parseJSONPath parses the JSONPath subset $.key, $['key'] and $[0].
+func parseJSONPath(expr string) ([]any, error) {
+	var path []any
+	rest := strings.TrimPrefix(expr, "$")
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty key in %s", expr)
+			}
+			path = append(path, rest[:end])
+			rest = rest[end:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated bracket in %s", expr)
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				path = append(path, inner[1:len(inner)-1])
+				continue
+			}
+			index, err := strconv.Atoi(inner)
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid index %q in %s", inner, expr)
+			}
+			path = append(path, index)
+		default:
+			return nil, fmt.Errorf("unexpected %q in %s", rest[0], expr)
+		}
+	}
+	return path, nil
+}
+
+// eval returns the expression value for body. A nil expression yields "".
+func (e *webhookExpr) eval(body any) (string, error) {
+	if e == nil {
+		return "", nil
+	}
+	if e.tmpl != nil {
+		var buf bytes.Buffer
+		if err := e.tmpl.Execute(&buf, body); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(buf.String()), nil
+	}
+
+	value := body
+	for _, step := range e.path {
+		switch step := step.(type) {
+		case string:
+			object, ok := value.(map[string]any)
+			if !ok {
+				return "", fmt.Errorf("cannot look up %q in %T", step, value)
+			}
+			if value, ok = object[step]; !ok {
+				return "", fmt.Errorf("key not found: %s", step)
+			}
+		case int:
+			array, ok := value.([]any)
+			if !ok || step >= len(array) {
+				return "", fmt.Errorf("index out of range: %d", step)
+			}
+			value = array[step]
+		}
+	}
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case json.Number, bool:
+		return fmt.Sprint(value), nil
+	default:
+		return "", fmt.Errorf("value is not a scalar: %T", value)
+	}
+}
+
+// authenticate checks the shared secret or signature of a request.
+func (h *webhook) authenticate(r *http.Request, body []byte) bool {
+	secret := []byte(h.config.Secret)
+	if len(secret) == 0 {
+		return true
+	}
+
+	if h.config.Signature == SignatureHMACSHA256 {
+		signature := r.Header.Get(h.config.SignatureHeader)
+		signature = strings.TrimPrefix(signature, "sha256=")
+		got, err := hex.DecodeString(signature)
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+		return hmac.Equal(got, mac.Sum(nil))
+	}
+
+	given := r.Header.Get(h.config.SecretHeader)
+	if given == "" {
+		given, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if given == "" {
+		given = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(given), secret) == 1
+}
+
+// request builds a wake-up request from the request body.
+func (h *webhook) request(r *http.Request, body []byte) (WakeUpRequest, error) {
+	data, err := decodeWebhookBody(r, body)
+	if err != nil {
+		return WakeUpRequest{}, err
+	}
+
+	request := WakeUpRequest{Type: h.config.Type}
+	for _, field := range []struct {
+		name string
+		expr *webhookExpr
+		dst  *string
+	}{
+		{"device", h.device, &request.DeviceName},
+		{"mac", h.mac, &request.Mac},
+		{"broadcast", h.broadcast, &request.Broadcast},
+		{"action", h.action, &request.Action},
+	} {
+		if *field.dst, err = field.expr.eval(data); err != nil {
+			return WakeUpRequest{}, fmt.Errorf("failed to evaluate %s: %w", field.name, err)
+		}
+	}
+
+	switch request.Action {
+	case "", ActionWake:
+	case ActionShutdown:
+		if request.DeviceName == "" {
+			return WakeUpRequest{}, errors.New("shutdown requires a device name")
+		}
+	default:
+		return WakeUpRequest{}, fmt.Errorf("unknown action: %s", request.Action)
+	}
+	if request.DeviceName == "" && (request.Mac == "" || request.Broadcast == "") {
+		return WakeUpRequest{}, errors.New("must provide either device name or both mac and broadcast")
+	}
+	return request, nil
+}
+
+// decodeWebhookBody decodes a JSON body, or else merges form values and
+// query parameters into a flat object.
+func decodeWebhookBody(r *http.Request, body []byte) (any, error) {
+	trimmed := bytes.TrimSpace(body)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if isJSON(mediaType) ||
+		(len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[')) {
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.UseNumber()
+		var data any
+		if err := decoder.Decode(&data); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return data, nil
+	}
+
+	values := r.URL.Query()
+	if mediaType == "application/x-www-form-urlencoded" {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid form: %w", err)
+		}
+		for key, value := range form {
+			values[key] = value
+		}
+	}
+	data := make(map[string]any, len(values))
+	for key := range values {
+		data[key] = values.Get(key)
+	}
+	return data, nil
+}
+
+// AddWebhook registers a webhook route at /hooks/{id}. It must be called
+// before Start.
+func (l *HTTPListener) AddWebhook(config WebhookConfig) error {
+	hook, err := newWebhook(config)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.webhooks == nil {
+		l.webhooks = make(map[string]*webhook)
+	}
+	if _, ok := l.webhooks[hook.config.ID]; ok {
+		return fmt.Errorf("duplicate webhook id: %s", hook.config.ID)
+	}
+	l.webhooks[hook.config.ID] = hook
+	if hook.config.Secret == "" {
+		l.logger().Warn("Webhook has no secret, anyone can trigger it", "id", hook.config.ID)
+	}
+	return nil
+}
+
+func (l *HTTPListener) handleWebhook(w http.ResponseWriter, r *http.Request, queue *Queue) {
+	id := r.PathValue("id")
+	l.mu.Lock()
+	hook := l.webhooks[id]
+	l.mu.Unlock()
+	if hook == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if !hook.authenticate(r, body) {
+		l.logger().Warn("Rejected webhook with invalid secret", "id", id, "remote", r.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	request, err := hook.request(r, body)
+	if err != nil {
+		l.logger().Error("Invalid webhook request", "id", id, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := queue.Enqueue(r.Context(), request); err != nil {
+		l.logger().Warn("Failed to queue webhook request", "id", id, "error", err)
+		http.Error(w, "Server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	l.logger().Info("Received webhook request",
+		"id", id,
+		"action", request.Action,
+		"device", request.DeviceName,
+		"mac", request.Mac,
+		"broadcast", request.Broadcast)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("Request received"))
+}
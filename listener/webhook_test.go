@@ -0,0 +1,176 @@
+package listener
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    []any
+		wantErr string
+	}{
+		{expr: "$", want: nil},
+		{expr: "$.device", want: []any{"device"}},
+		{expr: "$.repository.name", want: []any{"repository", "name"}},
+		{expr: "$['device name']", want: []any{"device name"}},
+		{expr: `$["a.b"].c`, want: []any{"a.b", "c"}},
+		{expr: "$.devices[0]", want: []any{"devices", 0}},
+		{expr: "$[2][10].mac", want: []any{2, 10, "mac"}},
+		{expr: "$..device", wantErr: "empty key"},
+		{expr: "$.", wantErr: "empty key"},
+		{expr: "$.a[0", wantErr: "unterminated bracket"},
+		{expr: "$.a[-1]", wantErr: "invalid index"},
+		{expr: "$.a[*]", wantErr: "invalid index"},
+		{expr: "$['a]", wantErr: "invalid index"},
+		{expr: "$device", wantErr: "unexpected 'd'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := parseJSONPath(tt.expr)
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("path = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookExprEval(t *testing.T) {
+	const body = `{
+		"device": "desktop",
+		"port": 9,
+		"enabled": true,
+		"missing": null,
+		"repository": {"name": "nas", "tags": ["a", "b"]},
+		"devices": [{"mac": "00:11:22:33:44:55"}],
+		"device name": "laptop"
+	}`
+
+	tests := []struct {
+		name    string
+		expr    string
+		want    string
+		wantErr string
+	}{
+		{name: "key", expr: "$.device", want: "desktop"},
+		{name: "nested key", expr: "$.repository.name", want: "nas"},
+		{name: "quoted key", expr: "$['device name']", want: "laptop"},
+		{name: "index", expr: "$.repository.tags[1]", want: "b"},
+		{name: "index then key", expr: "$.devices[0].mac", want: "00:11:22:33:44:55"},
+		{name: "number", expr: "$.port", want: "9"},
+		{name: "bool", expr: "$.enabled", want: "true"},
+		{name: "null", expr: "$.missing", want: ""},
+		{name: "missing key", expr: "$.host", wantErr: "key not found: host"},
+		{name: "index out of range", expr: "$.devices[1]", wantErr: "index out of range"},
+		{name: "index into an object", expr: "$.repository[0]", wantErr: "index out of range"},
+		{name: "key of a string", expr: "$.device.name", wantErr: "cannot look up"},
+		{name: "object", expr: "$.repository", wantErr: "not a scalar"},
+		{name: "whole body", expr: "$", wantErr: "not a scalar"},
+		{name: "template", expr: "{{ .repository.name }}", want: "nas"},
+		{name: "template missing key", expr: "{{ .host }}", wantErr: "map has no entry"},
+		{name: "literal", expr: "desktop", want: "desktop"},
+	}
+	data, err := decodeWebhookBody(httptest.NewRequest("POST", "/hooks/test", nil), []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := parseWebhookExpr("device", tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := expr.eval(data)
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("value = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeWebhookBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		want        any
+	}{
+		{
+			name:        "form and query",
+			target:      "/hooks/test?device=desktop&action=wake",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "action=shutdown",
+			want:        map[string]any{"device": "desktop", "action": "shutdown"},
+		},
+		{
+			name:   "query only",
+			target: "/hooks/test?value1=nas",
+			want:   map[string]any{"value1": "nas"},
+		},
+		{
+			name:   "JSON without a content type",
+			target: "/hooks/test?device=ignored",
+			body:   ` {"device": "desktop"}`,
+			want:   map[string]any{"device": "desktop"},
+		},
+		{
+			name:        "JSON content type with parameters",
+			target:      "/hooks/test?device=ignored",
+			contentType: "Application/JSON; charset=utf-8",
+			body:        `"desktop"`,
+			want:        "desktop",
+		},
+		{
+			name:        "+json content type",
+			target:      "/hooks/test",
+			contentType: "application/vnd.github+json",
+			body:        `["desktop", "nas"]`,
+			want:        []any{"desktop", "nas"},
+		},
+		{
+			name:        "form content type with parameters",
+			target:      "/hooks/test",
+			contentType: "Application/X-WWW-Form-Urlencoded; charset=UTF-8",
+			body:        "device=nas",
+			want:        map[string]any{"device": "nas"},
+		},
+		{
+			name:        "text is not a form",
+			target:      "/hooks/test?device=desktop",
+			contentType: "text/plain",
+			body:        "device=nas",
+			want:        map[string]any{"device": "desktop"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			got, err := decodeWebhookBody(r, []byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("body = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,33 +2,88 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/p3ddd/HomeGuard/device"
+	"github.com/p3ddd/HomeGuard/discover"
+	"github.com/p3ddd/HomeGuard/event"
 	"github.com/p3ddd/HomeGuard/listener"
+	"github.com/p3ddd/HomeGuard/power"
 	"github.com/p3ddd/HomeGuard/wol"
 )
 
 var (
-	configPath   = flag.String("config", "config.yaml", "Path to configuration file")
-	httpAddr     = flag.String("http", ":7092", "HTTP listener address")
-	mqttBroker   = flag.String("mqtt-broker", "", "MQTT broker URL (e.g., tcp://localhost:1883)")
-	mqttTopic    = flag.String("mqtt-topic", "homeguard/wakeup", "MQTT topic to subscribe to")
-	mqttClientID = flag.String("mqtt-client-id", "", "MQTT client ID (default: auto-generated)")
-	mqttUsername = flag.String("mqtt-username", "", "MQTT username")
-	mqttPassword = flag.String("mqtt-password", "", "MQTT password")
-	mqttQoS      = flag.Uint("mqtt-qos", 1, "MQTT QoS level (0, 1, or 2)")
-	logLevel     = flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+	configPath     = flag.String("config", "config.yaml", "Path to configuration file")
+	httpAddr       = flag.String("http", ":7092", "HTTP listener address (empty to disable)")
+	apiTokens      = flag.String("api-tokens", "", "Comma-separated bearer tokens required by the HTTP API and web UI (empty for open access)")
+	unixSocket     = flag.String("unix-socket", "", "Unix socket path for the local HTTP API (empty to disable)")
+	mqttBroker     = flag.String("mqtt-broker", "", "MQTT broker URL (e.g., tcp://localhost:1883)")
+	mqttTopic      = flag.String("mqtt-topic", "homeguard/wakeup", "MQTT topic to subscribe to")
+	mqttClientID   = flag.String("mqtt-client-id", "", "MQTT client ID (default: auto-generated)")
+	mqttUsername   = flag.String("mqtt-username", "", "MQTT username")
+	mqttPassword   = flag.String("mqtt-password", "", "MQTT password")
+	mqttQoS        = flag.Uint("mqtt-qos", 1, "MQTT QoS level (0, 1, or 2)")
+	mqttCAFile     = flag.String("mqtt-ca-file", "", "CA bundle for verifying a TLS broker (default: system roots)")
+	mqttCertFile   = flag.String("mqtt-cert-file", "", "Client certificate for a TLS broker")
+	mqttKeyFile    = flag.String("mqtt-key-file", "", "Client private key for a TLS broker")
+	mqttInsecure   = flag.Bool("mqtt-insecure-skip-verify", false, "Do not verify the TLS broker certificate")
+	mqttSNI        = flag.String("mqtt-server-name", "", "TLS server name (SNI) of the broker (default: broker host)")
+	mqttResponse   = flag.String("mqtt-response-topic", "", "MQTT topic for JSON acknowledgements (empty to disable)")
+	mqttState      = flag.Bool("mqtt-state", false, "Publish the outcome of each attempt to <base>/<device>/state")
+	mqttHA         = flag.Bool("mqtt-discovery", false, "Publish Home Assistant MQTT discovery configs")
+	mqttHAPrefix   = flag.String("mqtt-discovery-prefix", "homeassistant", "Home Assistant discovery prefix")
+	mqttBase       = flag.String("mqtt-base-topic", "homeguard", "Base topic for command, state and availability topics")
+	gotifyURL      = flag.String("gotify-url", "", "Gotify server URL (e.g., https://gotify.example.com)")
+	gotifyToken    = flag.String("gotify-token", "", "Gotify client token")
+	gotifyPattern  = flag.String("gotify-pattern", listener.DefaultGotifyPattern, "Regexp matched against Gotify message title and body; captures the device name")
+	telegramToken  = flag.String("telegram-token", "", "Telegram bot token")
+	telegramAPIURL = flag.String("telegram-api-url", listener.DefaultTelegramAPIURL, "Telegram Bot API base URL")
+	telegramChats  = flag.String("telegram-allowed-chats", "", "Comma-separated Telegram chat IDs allowed to use the bot")
+	logLevel       = flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+	workers        = flag.Int("workers", listener.DefaultWorkers, "Number of requests processed at once")
+	queueSize      = flag.Int("queue-size", listener.DefaultQueueSize, "Number of requests queued for a worker")
+	schemaFlag     = flag.Bool("print-schema", false, "Print the JSON Schema of the configuration file and exit")
+	checkFlag      = flag.Bool("check-config", false, "Validate the configuration file and exit, non-zero on errors")
+
+	// mqttSubscriptions, webhooks, the Unix socket permissions and the
+	// discovery files can only be set in the config file
+	mqttSubscriptions []listener.MQTTSubscription
+	webhooks          []listener.WebhookConfig
+	unixSocketConfig  listener.UnixSocketConfig
+	discoveryConfig   discover.Config
 )
 
 func main() {
 	flag.Parse()
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	if *schemaFlag {
+		if err := printSchema(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *checkFlag {
+		if err := checkConfig(*configPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: OK\n", *configPath)
+		return
+	}
+
+	// Settings from the config file apply unless overridden on the command line
+	extraBrokers, configErr := applyConfigFile(*configPath)
 
 	// Setup logging
 	var level slog.Level
@@ -52,6 +107,10 @@ func main() {
 
 	slog.Info("Starting HomeGuard WOL Service")
 
+	if configErr != nil {
+		slog.Error("Failed to load server configuration", "error", configErr, "path", *configPath)
+	}
+
 	// Load device configuration
 	deviceManager, err := device.NewManager(*configPath)
 	if err != nil {
@@ -74,60 +133,183 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Create channel for wakeup requests
-	requestChan := make(chan listener.WakeUpRequest, 100)
+	// Queue for wakeup and shutdown requests
+	queue := listener.NewQueue(*queueSize, *workers, deviceManager)
+
+	// Event bus for the live event stream
+	events := event.NewBus(event.DefaultReplaySize)
 
-	// Start request processor
 	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		processRequests(ctx, requestChan, deviceManager)
-	}()
 
 	// Start listeners
 	listeners := make([]listener.Listener, 0)
 
-	// HTTP Listener
-	httpListener := listener.NewHTTPListener(*httpAddr)
-	listeners = append(listeners, httpListener)
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := httpListener.Start(ctx, requestChan); err != nil {
-			slog.Error("HTTP listener error", "error", err)
+	// HTTP Listener (unless disabled)
+	if *httpAddr != "" {
+		httpListener := listener.NewHTTPListener(*httpAddr, deviceManager)
+		httpListener.SetEvents(events)
+		httpListener.SetTokens(apiTokensFromFlags())
+		httpListener.SetConfigValidator(validateConfig)
+		httpListener.SetDiscovery(discoveryConfig)
+		for _, hook := range webhooks {
+			if err := httpListener.AddWebhook(hook); err != nil {
+				slog.Error("Failed to add webhook", "id", hook.ID, "error", err)
+			}
 		}
-	}()
+		listeners = append(listeners, httpListener)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := httpListener.Start(ctx, queue); err != nil {
+				slog.Error("HTTP listener error", "error", err)
+			}
+		}()
+	} else {
+		slog.Info("HTTP listener disabled")
+	}
 
-	// MQTT Listener (if configured)
+	// Unix socket listener (if configured)
+	if *unixSocket != "" {
+		config := unixSocketConfig
+		config.Path = *unixSocket
+		unixListener := listener.NewUnixSocketListener(config, deviceManager)
+		unixListener.SetEvents(events)
+		unixListener.SetConfigValidator(validateConfig)
+		unixListener.SetDiscovery(discoveryConfig)
+		listeners = append(listeners, unixListener)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := unixListener.Start(ctx, queue); err != nil {
+				slog.Error("Unix socket listener error", "error", err)
+			}
+		}()
+	}
+
+	// MQTT Listeners (if configured)
+	var mqttConfigs []listener.MQTTConfig
 	if *mqttBroker != "" {
-		mqttConfig := listener.MQTTConfig{
-			Broker:   *mqttBroker,
-			ClientID: *mqttClientID,
-			Topic:    *mqttTopic,
-			QoS:      byte(*mqttQoS),
-			Username: *mqttUsername,
-			Password: *mqttPassword,
-		}
-		mqttListener := listener.NewMQTTListener(mqttConfig)
+		mqttConfigs = append(mqttConfigs, mqttConfigFromFlags())
+	}
+	mqttConfigs = append(mqttConfigs, extraBrokers...)
+	for _, mqttConfig := range mqttConfigs {
+		mqttListener := listener.NewMQTTListener(mqttConfig, deviceManager)
+		mqttListener.SetEvents(events)
 		listeners = append(listeners, mqttListener)
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			if err := mqttListener.Start(ctx, requestChan); err != nil {
-				slog.Error("MQTT listener error", "error", err)
+			if err := mqttListener.Start(ctx, queue); err != nil {
+				slog.Error("MQTT listener error", "broker", mqttConfig.Broker, "error", err)
 			}
 		}()
-	} else {
+	}
+	if len(mqttConfigs) == 0 {
 		slog.Info("MQTT listener not configured (use -mqtt-broker flag to enable)")
 	}
 
+	// Gotify Listener (if configured)
+	if *gotifyURL != "" {
+		gotifyListener, err := listener.NewGotifyListener(gotifyConfigFromFlags())
+		if err != nil {
+			slog.Error("Failed to create Gotify listener", "error", err)
+		} else {
+			listeners = append(listeners, gotifyListener)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := gotifyListener.Start(ctx, queue); err != nil {
+					slog.Error("Gotify listener error", "error", err)
+				}
+			}()
+		}
+	}
+
+	// Telegram Listener (if configured)
+	if *telegramToken != "" {
+		telegramConfig, err := telegramConfigFromFlags()
+		if err != nil {
+			slog.Error("Failed to create Telegram listener", "error", err)
+		} else {
+			telegramListener := listener.NewTelegramListener(telegramConfig, deviceManager)
+			listeners = append(listeners, telegramListener)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := telegramListener.Start(ctx, queue); err != nil {
+					slog.Error("Telegram listener error", "error", err)
+				}
+			}()
+		}
+	}
+
+	// Start request processor. It has its own context, so that it finishes
+	// the queued requests after the listeners stopped.
+	processCtx, stopProcessing := context.WithCancel(context.Background())
+	defer stopProcessing()
+	processed := make(chan struct{})
+	go func() {
+		defer close(processed)
+		processRequests(processCtx, queue, deviceManager, listeners, events)
+	}()
+
+	// Publish online status changes reported by agents, run schedules, pick
+	// up changes of the device sources and track device addresses
+	if deviceManager != nil {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			watchOnlineStatus(ctx, deviceManager, events)
+		}()
+		go func() {
+			defer wg.Done()
+			runSchedules(ctx, deviceManager, queue)
+		}()
+		go func() {
+			defer wg.Done()
+			deviceManager.WatchSources(ctx, func(err error) {
+				if err != nil {
+					slog.Error("Failed to reload device sources", "error", err)
+					return
+				}
+				count := len(deviceManager.ListDevices())
+				slog.Info("Device source changed, reloaded device configuration", "count", count)
+				events.Publish(event.Event{
+					Type: event.ConfigReloaded,
+					Data: map[string]string{"devices": strconv.Itoa(count)},
+				})
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			trackAddresses(ctx, deviceManager, events)
+		}()
+	}
+
 	slog.Info("HomeGuard WOL Service is running. Press Ctrl+C to stop.")
 
-	// Wait for interrupt signal
+	// Wait for interrupt signal, reloading the configuration on SIGHUP
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if deviceManager == nil {
+			slog.Warn("Reload requested but no device configuration loaded")
+			continue
+		}
+		if err := deviceManager.Reload(); err != nil {
+			slog.Error("Failed to reload device configuration", "error", err, "path", *configPath)
+			continue
+		}
+		count := len(deviceManager.ListDevices())
+		slog.Info("Reloaded device configuration", "count", count)
+		events.Publish(event.Event{
+			Type: event.ConfigReloaded,
+			Data: map[string]string{"devices": strconv.Itoa(count)},
+		})
+	}
 
 	slog.Info("Shutdown signal received, stopping services...")
 
@@ -141,13 +323,14 @@ func main() {
 		}
 	}
 
-	// Close request channel
-	close(requestChan)
+	// Stop accepting requests, then finish the queued ones
+	queue.Close()
 
 	// Wait for all goroutines to finish with timeout
 	done := make(chan struct{})
 	go func() {
 		wg.Wait()
+		<-processed
 		close(done)
 	}()
 
@@ -155,28 +338,180 @@ func main() {
 	case <-done:
 		slog.Info("All services stopped gracefully")
 	case <-time.After(5 * time.Second):
+		// Discard the requests still queued, failing them
+		stopProcessing()
+		select {
+		case <-processed:
+		case <-time.After(time.Second):
+		}
 		slog.Warn("Shutdown timeout exceeded, forcing exit")
 	}
 }
 
-func processRequests(ctx context.Context, requestChan <-chan listener.WakeUpRequest, deviceManager *device.Manager) {
-	slog.Info("Request processor started")
+// processRequests processes queued requests with the workers of the queue
+// until it is closed and empty, or ctx is done.
+func processRequests(ctx context.Context, queue *listener.Queue, deviceManager *device.Manager, listeners []listener.Listener, events *event.Bus) {
+	slog.Info("Request processor started", "workers", queue.Stats().Workers)
+	queue.Run(ctx, func(req listener.WakeUpRequest) {
+		requested, sent, failed := event.WakeRequested, event.WakeSent, event.WakeFailed
+		if req.Action == listener.ActionShutdown {
+			requested, sent, failed = event.ShutdownRequested, event.ShutdownSent, event.ShutdownFailed
+		}
+		e := event.Event{
+			Type:   requested,
+			Device: req.DeviceName,
+			Mac:    req.Mac,
+			Source: req.Type,
+		}
+		events.Publish(e)
+
+		var err error
+		if req.Action == listener.ActionShutdown {
+			err = handleShutdownRequest(ctx, req, deviceManager)
+		} else {
+			err = handleWakeUpRequest(req, deviceManager)
+		}
+
+		e.Type = sent
+		if err != nil {
+			e.Type, e.Error = failed, err.Error()
+		}
+		events.Publish(e)
+
+		if req.Done != nil {
+			req.Done(err)
+		}
+		for _, l := range listeners {
+			if r, ok := l.(listener.Reporter); ok {
+				r.Report(req, err)
+			}
+		}
+	})
+	slog.Info("Request processor stopped")
+}
+
+// watchOnlineStatus publishes device online/offline events. Heartbeats
+// expire lazily, so the status is polled.
+func watchOnlineStatus(ctx context.Context, deviceManager *device.Manager, events *event.Bus) {
+	online := make(map[string]bool)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
 	for {
+		for _, dev := range deviceManager.ListDevices() {
+			if dev.Agent == nil {
+				continue
+			}
+			status, err := deviceManager.GetStatus(dev.Name)
+			if err != nil {
+				continue
+			}
+			// Devices start out offline, so that is not reported as a change
+			was := online[dev.Name]
+			online[dev.Name] = status.Online
+			if was == status.Online {
+				continue
+			}
+			e := event.Event{Type: event.DeviceOffline, Device: dev.Name}
+			if status.Online {
+				e.Type = event.DeviceOnline
+				e.Data = make(map[string]string)
+				if status.IP != "" {
+					e.Data["ip"] = status.IP
+				}
+				if status.Hostname != "" {
+					e.Data["hostname"] = status.Hostname
+				}
+			}
+			events.Publish(e)
+		}
+
 		select {
-		case req, ok := <-requestChan:
-			if !ok {
-				slog.Info("Request channel closed, stopping processor")
-				return
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// trackAddresses updates the MAC and IP addresses of the devices from the
+// neighbour table, and publishes their changes.
+func trackAddresses(ctx context.Context, deviceManager *device.Manager, events *event.Bus) {
+	err := discover.Track(ctx, discoveryConfig, func(observations []discover.Observation) {
+		sightings := make([]device.Sighting, 0, len(observations))
+		for _, o := range observations {
+			sightings = append(sightings, device.Sighting{IP: o.IP, Mac: o.Mac, Broadcast: o.Broadcast})
+		}
+		for _, c := range deviceManager.ObserveAddresses(ctx, sightings) {
+			slog.Info("Device address changed",
+				"device", c.Device,
+				"ip", c.IP,
+				"mac", c.Mac,
+				"previous_ip", c.PreviousIP,
+				"previous_mac", c.PreviousMac)
+			data := map[string]string{"ip": c.IP}
+			if c.PreviousIP != "" {
+				data["previous_ip"] = c.PreviousIP
+			}
+			if c.PreviousMac != "" {
+				data["previous_mac"] = c.PreviousMac
 			}
-			handleWakeUpRequest(req, deviceManager)
+			events.Publish(event.Event{Type: event.DeviceMoved, Device: c.Device, Mac: c.Mac, Data: data})
+		}
+	})
+	if err != nil {
+		slog.Warn("Device address tracking unavailable", "error", err)
+	}
+}
+
+// runSchedules queues the requests of due schedules, checking once a minute.
+// They have a low priority, so manual requests go first.
+func runSchedules(ctx context.Context, deviceManager *device.Manager, queue *listener.Queue) {
+	last := time.Now()
+	for {
+		timer := time.NewTimer(time.Until(time.Now().Truncate(time.Minute).Add(time.Minute)))
+		select {
+		case <-timer.C:
 		case <-ctx.Done():
-			slog.Info("Request processor context canceled")
+			timer.Stop()
 			return
 		}
+
+		now := time.Now()
+		for _, s := range deviceManager.ListSchedules() {
+			if s.Next(last).After(now) {
+				continue
+			}
+
+			names := []string{s.Device}
+			if s.Group != "" {
+				members, err := deviceManager.GetGroup(s.Group)
+				if err != nil {
+					slog.Error("Failed to run schedule", "schedule", s.Name, "error", err)
+					continue
+				}
+				names = names[:0]
+				for _, dev := range members {
+					names = append(names, dev.Name)
+				}
+			}
+
+			action := listener.ActionWake
+			if s.Action == listener.ActionShutdown {
+				action = listener.ActionShutdown
+			}
+			slog.Info("Running schedule", "schedule", s.Name, "action", action, "devices", len(names))
+			for _, name := range names {
+				request := listener.WakeUpRequest{Type: "SCHEDULE", DeviceName: name, Action: action, Priority: listener.PriorityLow}
+				if err := queue.Enqueue(ctx, request); err != nil {
+					return
+				}
+			}
+		}
+		last = now
 	}
 }
 
-func handleWakeUpRequest(req listener.WakeUpRequest, deviceManager *device.Manager) {
+func handleWakeUpRequest(req listener.WakeUpRequest, deviceManager *device.Manager) error {
 	var mac, broadcast string
 
 	// If device name is provided, look it up
@@ -185,7 +520,7 @@ func handleWakeUpRequest(req listener.WakeUpRequest, deviceManager *device.Manag
 			slog.Error("Device name provided but no device configuration loaded",
 				"device", req.DeviceName,
 				"type", req.Type)
-			return
+			return errors.New("no device configuration loaded")
 		}
 
 		dev, err := deviceManager.GetDevice(req.DeviceName)
@@ -194,9 +529,16 @@ func handleWakeUpRequest(req listener.WakeUpRequest, deviceManager *device.Manag
 				"device", req.DeviceName,
 				"error", err,
 				"type", req.Type)
-			return
+			return err
 		}
 
+		if dev.Mac == "" {
+			slog.Error("MAC address of device not learned yet",
+				"device", req.DeviceName,
+				"host", dev.Host,
+				"type", req.Type)
+			return fmt.Errorf("MAC address of device %s is not known yet", req.DeviceName)
+		}
 		mac = dev.Mac
 		broadcast = dev.Broadcast
 		slog.Info("Resolved device name to MAC address",
@@ -221,7 +563,7 @@ func handleWakeUpRequest(req listener.WakeUpRequest, deviceManager *device.Manag
 			"broadcast", broadcast,
 			"error", err,
 			"type", req.Type)
-		return
+		return fmt.Errorf("failed to send WOL packet: %w", err)
 	}
 
 	slog.Info("Successfully sent WOL packet",
@@ -229,4 +571,45 @@ func handleWakeUpRequest(req listener.WakeUpRequest, deviceManager *device.Manag
 		"broadcast", broadcast,
 		"device", req.DeviceName,
 		"type", req.Type)
+	return nil
+}
+
+func handleShutdownRequest(ctx context.Context, req listener.WakeUpRequest, deviceManager *device.Manager) error {
+	if deviceManager == nil {
+		slog.Error("Shutdown requested but no device configuration loaded",
+			"device", req.DeviceName,
+			"type", req.Type)
+		return errors.New("no device configuration loaded")
+	}
+
+	dev, err := deviceManager.GetDevice(req.DeviceName)
+	if err != nil {
+		slog.Error("Failed to get device information",
+			"device", req.DeviceName,
+			"error", err,
+			"type", req.Type)
+		return err
+	}
+
+	if dev.Shutdown == nil {
+		slog.Error("Device has no shutdown action configured",
+			"device", req.DeviceName,
+			"type", req.Type)
+		return fmt.Errorf("device has no shutdown action configured: %s", req.DeviceName)
+	}
+
+	if err := power.Shutdown(ctx, *dev.Shutdown); err != nil {
+		slog.Error("Failed to shut down device",
+			"device", req.DeviceName,
+			"method", dev.Shutdown.Type,
+			"error", err,
+			"type", req.Type)
+		return fmt.Errorf("failed to shut down device: %w", err)
+	}
+
+	slog.Info("Successfully sent shutdown command",
+		"device", req.DeviceName,
+		"method", dev.Shutdown.Type,
+		"type", req.Type)
+	return nil
 }
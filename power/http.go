@@ -0,0 +1,36 @@
+package power
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+func shutdownHTTP(ctx context.Context, cfg Config) error {
+	method := cfg.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, cfg.URL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("shutdown endpoint returned error (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package power
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Action types supported by Config.Type.
+const (
+	TypeSSH  = "ssh"
+	TypeHTTP = "http"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// Config describes how to power off a remote machine.
+type Config struct {
+	Type    string        `yaml:"type"`              // "ssh" or "http"
+	Timeout time.Duration `yaml:"timeout,omitempty"` // Default: 10s
+
+	// SSH options
+	Host            string `yaml:"host,omitempty"`
+	Port            int    `yaml:"port,omitempty"` // Default: 22
+	User            string `yaml:"user,omitempty"`
+	KeyFile         string `yaml:"key_file,omitempty"`
+	KnownHostsFile  string `yaml:"known_hosts,omitempty"`
+	InsecureHostKey bool   `yaml:"insecure_host_key,omitempty"`
+	Command         string `yaml:"command,omitempty"` // Default: "sudo shutdown -h now"
+
+	// HTTP options
+	URL    string `yaml:"url,omitempty"`
+	Method string `yaml:"method,omitempty"` // Default: POST
+	Token  string `yaml:"token,omitempty"`  // Sent as "Authorization: Bearer <token>"
+}
+
+// Validate checks that the required fields for the configured type are set.
+func (c Config) Validate() error {
+	switch c.Type {
+	case TypeSSH:
+		if c.Host == "" {
+			return fmt.Errorf("ssh shutdown requires host")
+		}
+		if c.User == "" {
+			return fmt.Errorf("ssh shutdown requires user")
+		}
+		if c.KeyFile == "" {
+			return fmt.Errorf("ssh shutdown requires key_file")
+		}
+		if c.KnownHostsFile == "" && !c.InsecureHostKey {
+			return fmt.Errorf("ssh shutdown requires known_hosts or insecure_host_key")
+		}
+	case TypeHTTP:
+		if c.URL == "" {
+			return fmt.Errorf("http shutdown requires url")
+		}
+	default:
+		return fmt.Errorf("unknown shutdown type: %q", c.Type)
+	}
+	return nil
+}
+
+// Shutdown powers off the machine described by cfg.
+func Shutdown(ctx context.Context, cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch cfg.Type {
+	case TypeSSH:
+		return shutdownSSH(ctx, cfg)
+	default:
+		return shutdownHTTP(ctx, cfg)
+	}
+}
@@ -0,0 +1,367 @@
+package power
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestShutdownHTTP(t *testing.T) {
+	type request struct {
+		method, path, auth string
+	}
+	tests := []struct {
+		name    string
+		config  Config
+		status  int
+		delay   time.Duration
+		want    request
+		wantErr string
+	}{
+		{
+			name:   "default method",
+			config: Config{Type: TypeHTTP},
+			status: http.StatusOK,
+			want:   request{method: http.MethodPost, path: "/shutdown"},
+		},
+		{
+			name:   "method and token",
+			config: Config{Type: TypeHTTP, Method: http.MethodPut, Token: "secret"},
+			status: http.StatusNoContent,
+			want:   request{method: http.MethodPut, path: "/shutdown", auth: "Bearer secret"},
+		},
+		{
+			name:    "error status",
+			config:  Config{Type: TypeHTTP},
+			status:  http.StatusForbidden,
+			want:    request{method: http.MethodPost, path: "/shutdown"},
+			wantErr: "status 403",
+		},
+		{
+			name:    "timeout",
+			config:  Config{Type: TypeHTTP, Timeout: 50 * time.Millisecond},
+			status:  http.StatusOK,
+			delay:   time.Second,
+			want:    request{method: http.MethodPost, path: "/shutdown"},
+			wantErr: "context deadline exceeded",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan request, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received <- request{method: r.Method, path: r.URL.Path, auth: r.Header.Get("Authorization")}
+				select {
+				case <-time.After(tt.delay):
+				case <-r.Context().Done():
+					return
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte("denied"))
+			}))
+			defer server.Close()
+
+			tt.config.URL = server.URL + "/shutdown"
+			err := Shutdown(context.Background(), tt.config)
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if got := <-received; got != tt.want {
+				t.Errorf("request = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShutdownSSH(t *testing.T) {
+	clientKey, clientSigner := newKey(t)
+	_, otherSigner := newKey(t)
+	keyFile := writePrivateKey(t, clientKey)
+
+	authorized := ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "admin" && bytes.Equal(key.Marshal(), clientSigner.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	passwordOnly := ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	otherKeyOnly := ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), otherSigner.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+
+	tests := []struct {
+		name        string
+		server      ssh.ServerConfig
+		exit        func(ch ssh.Channel) // Ends the command
+		command     string
+		timeout     time.Duration
+		knownHosts  bool
+		wantCommand string
+		wantErr     string
+	}{
+		{
+			name:        "default command",
+			server:      authorized,
+			exit:        exitStatus(0),
+			wantCommand: defaultSSHCommand,
+		},
+		{
+			name:        "configured command",
+			server:      authorized,
+			exit:        exitStatus(0),
+			command:     "systemctl poweroff",
+			wantCommand: "systemctl poweroff",
+		},
+		{
+			name:        "known host",
+			server:      authorized,
+			exit:        exitStatus(0),
+			knownHosts:  true,
+			wantCommand: defaultSSHCommand,
+		},
+		{
+			name:        "connection dropped by the shutdown",
+			server:      authorized,
+			exit:        func(ch ssh.Channel) { _ = ch.Close() },
+			wantCommand: defaultSSHCommand,
+		},
+		{
+			name:        "non-zero exit",
+			server:      authorized,
+			exit:        exitStatus(1),
+			wantCommand: defaultSSHCommand,
+			wantErr:     "ssh command failed",
+		},
+		{
+			name:    "server only accepts passwords",
+			server:  passwordOnly,
+			wantErr: "ssh handshake failed",
+		},
+		{
+			name:    "key not authorized",
+			server:  otherKeyOnly,
+			wantErr: "ssh handshake failed",
+		},
+		{
+			name:        "timeout",
+			server:      authorized,
+			exit:        nil, // Never answers
+			timeout:     100 * time.Millisecond,
+			wantCommand: defaultSSHCommand,
+			wantErr:     "timed out",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startSSHServer(t, tt.server, tt.exit)
+			host, port, _ := net.SplitHostPort(server.addr)
+			portNumber, _ := strconv.Atoi(port)
+			config := Config{
+				Type:            TypeSSH,
+				Host:            host,
+				Port:            portNumber,
+				User:            "admin",
+				KeyFile:         keyFile,
+				InsecureHostKey: !tt.knownHosts,
+				Command:         tt.command,
+				Timeout:         tt.timeout,
+			}
+			if tt.knownHosts {
+				config.KnownHostsFile = writeKnownHosts(t, server.addr, server.hostKey)
+			}
+
+			err := Shutdown(context.Background(), config)
+			switch {
+			case err != nil && tt.wantErr == "":
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if got := server.command(); got != tt.wantCommand {
+				t.Errorf("command = %q, want %q", got, tt.wantCommand)
+			}
+		})
+	}
+}
+
+func TestShutdownSSHUnknownHostKey(t *testing.T) {
+	clientKey, _ := newKey(t)
+	_, otherHost := newKey(t)
+	server := startSSHServer(t, ssh.ServerConfig{NoClientAuth: true}, exitStatus(0))
+	host, port, _ := net.SplitHostPort(server.addr)
+	portNumber, _ := strconv.Atoi(port)
+
+	err := Shutdown(context.Background(), Config{
+		Type:           TypeSSH,
+		Host:           host,
+		Port:           portNumber,
+		User:           "admin",
+		KeyFile:        writePrivateKey(t, clientKey),
+		KnownHostsFile: writeKnownHosts(t, server.addr, otherHost.PublicKey()),
+	})
+	if err == nil || !strings.Contains(err.Error(), "ssh handshake failed") {
+		t.Fatalf("error = %v, want one containing %q", err, "ssh handshake failed")
+	}
+	if got := server.command(); got != "" {
+		t.Errorf("command %q was run on a host with an unknown key", got)
+	}
+}
+
+// sshServer is an in-process SSH server that records the command it is
+// asked to run.
+type sshServer struct {
+	addr     string
+	hostKey  ssh.PublicKey
+	commands chan string
+}
+
+func (s *sshServer) command() string {
+	select {
+	case cmd := <-s.commands:
+		return cmd
+	case <-time.After(100 * time.Millisecond):
+		return ""
+	}
+}
+
+// startSSHServer serves config on a local port. exit ends each command; a
+// nil exit leaves it running until the test ends.
+func startSSHServer(t *testing.T, config ssh.ServerConfig, exit func(ssh.Channel)) *sshServer {
+	t.Helper()
+	_, hostSigner := newKey(t)
+	config.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		_ = ln.Close()
+	})
+
+	s := &sshServer{addr: ln.Addr().String(), hostKey: hostSigner.PublicKey(), commands: make(chan string, 1)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, &config, exit, done)
+		}
+	}()
+	return s
+}
+
+func (s *sshServer) serve(conn net.Conn, config *ssh.ServerConfig, exit func(ssh.Channel), done <-chan struct{}) {
+	defer func() { _ = conn.Close() }()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		ch, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		for req := range requests {
+			if req.Type != "exec" {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			s.commands <- payload.Command
+			if exit == nil {
+				<-done
+				return
+			}
+			exit(ch)
+			break
+		}
+	}
+}
+
+// exitStatus ends a command with the given status.
+func exitStatus(status uint32) func(ssh.Channel) {
+	return func(ch ssh.Channel) {
+		_, _ = ch.Write([]byte("output\n"))
+		_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		_ = ch.Close()
+	}
+}
+
+func newKey(t *testing.T) (ed25519.PrivateKey, ssh.Signer) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, signer
+}
+
+func writePrivateKey(t *testing.T, key ed25519.PrivateKey) string {
+	t.Helper()
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeKnownHosts(t *testing.T, addr string, key ssh.PublicKey) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, key) + "\n"
+	if err := os.WriteFile(path, []byte(line), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,92 @@
+package power
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const defaultSSHCommand = "sudo shutdown -h now"
+
+func shutdownSSH(ctx context.Context, cfg Config) error {
+	key, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read ssh key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to parse ssh key: %w", err)
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if cfg.KnownHostsFile != "" {
+		hostKeyCallback, err = knownhosts.New(cfg.KnownHostsFile)
+		if err != nil {
+			return fmt.Errorf("failed to load known_hosts: %w", err)
+		}
+	}
+
+	port := cfg.Port
+	if port == 0 {
+		port = 22
+	}
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// Abort the handshake and command if the context expires
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            cfg.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		return fmt.Errorf("ssh handshake failed: %w", err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer func() { _ = client.Close() }()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open ssh session: %w", err)
+	}
+	defer func() { _ = session.Close() }()
+
+	command := cfg.Command
+	if command == "" {
+		command = defaultSSHCommand
+	}
+
+	output, err := session.CombinedOutput(command)
+	if err != nil {
+		// An expired context closes the connection, which looks like the
+		// shutdown below, so it is checked first
+		if ctx.Err() != nil {
+			return fmt.Errorf("ssh command timed out: %w", ctx.Err())
+		}
+		// The machine going down usually drops the connection before the
+		// command reports an exit status.
+		var missing *ssh.ExitMissingError
+		if errors.As(err, &missing) || errors.Is(err, io.EOF) {
+			return nil
+		}
+		return fmt.Errorf("ssh command failed: %w (output: %s)", err, output)
+	}
+
+	return nil
+}